@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/intel"
+	"github.com/kad/WindowsBrowserGuard/pkg/ledger"
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+	"github.com/kad/WindowsBrowserGuard/pkg/policyfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+	"github.com/kad/WindowsBrowserGuard/pkg/trust"
+)
+
+// policyFileOptions select the Firefox policies.json files a command reads.
+type policyFileOptions struct {
+	paths []string
+}
+
+// addFlags registers the policies.json flag on fs.
+func (o *policyFileOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&o.paths, "policy-file", nil,
+		"Firefox policies.json file to scan; repeatable (default: FirefoxPolicyFiles from config, else the standard install paths)")
+}
+
+// resolve returns the policies.json files to read for src: --policy-file,
+// else FirefoxPolicyFiles of cfg, else the standard install paths. These
+// files belong to the running system, so an offline src only gets them
+// when they are named explicitly.
+func (o *policyFileOptions) resolve(cfg *fileConfig, src scanSource) []string {
+	switch {
+	case len(o.paths) > 0:
+		return o.paths
+	case src != scanSource{}:
+		return nil
+	case len(cfg.FirefoxPolicyFiles) > 0:
+		return cfg.FirefoxPolicyFiles
+	}
+	return policyfile.DefaultPaths()
+}
+
+// engineOptions are the flags of the commands that plan remediation: scan,
+// watch, plan and apply.
+type engineOptions struct {
+	policyFiles   policyFileOptions
+	intelFeed     string
+	mode          string
+	quarantineDir string
+}
+
+// addFlags registers the engine flags on fs.
+func (o *engineOptions) addFlags(fs *pflag.FlagSet) {
+	o.policyFiles.addFlags(fs)
+	fs.StringVar(&o.intelFeed, "intel-feed", "",
+		"JSON or CSV feed of known malicious extension IDs to block pre-emptively (default: IntelFeed from config)")
+	fs.StringVar(&o.mode, "mode", "",
+		"Action mode for detectors without their own entry in ActionModes: observe, block, remove, enforce or quarantine (default: ActionModes.Default from config, else enforce)")
+	fs.StringVar(&o.quarantineDir, "quarantine-dir", "",
+		"Directory receiving backups of policies deleted in quarantine mode (default: QuarantineDir from config, else quarantine next to executable)")
+}
+
+// newEngine returns the engine planning the remediation of src, configured
+// by cfg and the flags; flags override config values.
+func (o *engineOptions) newEngine(cfg *fileConfig, src scanSource) (*monitor.Engine, error) {
+	e := monitor.NewEngine()
+	action, err := extsettings.ParseAction(cfg.ExtensionSettingsAction)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	e.ExtensionSettingsAction = action
+	if e.Exemptions, err = exemptions.New(cfg.Exemptions); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	urlRuleList := cfg.UpdateURLRules
+	if urlRuleList == nil {
+		urlRuleList = trust.Defaults()
+	}
+	if e.UpdateURLRules, err = trust.New(urlRuleList); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if e.HijackActions, err = hijack.ParseActions(cfg.HijackPolicies); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	modeNames := make(map[string]string, len(cfg.ActionModes)+1)
+	for name, mode := range cfg.ActionModes {
+		modeNames[name] = mode
+	}
+	if o.mode != "" {
+		for name := range modeNames {
+			if strings.EqualFold(name, monitor.DetectorDefault) {
+				delete(modeNames, name)
+			}
+		}
+		modeNames[monitor.DetectorDefault] = o.mode
+	}
+	if e.Modes, err = monitor.ParseActionModes(modeNames); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	e.Quarantine.Dir = o.quarantineDir
+	if e.Quarantine.Dir == "" {
+		e.Quarantine.Dir = cfg.QuarantineDir
+	}
+	if e.Quarantine.Dir == "" {
+		e.Quarantine.Dir = defaultQuarantineDir()
+	}
+
+	feedPath := o.intelFeed
+	if feedPath == "" {
+		feedPath = cfg.IntelFeed
+	}
+	if feedPath != "" {
+		if e.Intel, err = intel.Open(feedPath); err != nil {
+			return nil, fmt.Errorf("intel feed: %w", err)
+		}
+	}
+
+	e.PolicyFiles = o.policyFiles.resolve(cfg, src)
+	return e, nil
+}
+
+// ledgerOptions select the ownership ledger of the commands that write, or
+// plan writing, blocklist entries: watch, plan and apply.
+type ledgerOptions struct {
+	path string
+}
+
+// addFlags registers the ledger flag on fs.
+func (o *ledgerOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.path, "ledger", "",
+		"Ownership ledger of guard-written blocklist entries, restored when tampered with (default: LedgerPath from config, else ledger.json next to executable)")
+}
+
+// resolve returns the ledger path: --ledger, else LedgerPath of cfg, else
+// ledger.json next to the executable.
+func (o *ledgerOptions) resolve(cfg *fileConfig) string {
+	switch {
+	case o.path != "":
+		return o.path
+	case cfg.LedgerPath != "":
+		return cfg.LedgerPath
+	}
+	return defaultLedgerPath()
+}
+
+// openLedger opens the ownership ledger at path for the enforcement passes
+// of e. Without it tamper protection is disabled.
+func openLedger(ctx context.Context, e *monitor.Engine, path string) {
+	owned, err := ledger.Open(path)
+	if err != nil {
+		telemetry.Printf(ctx, "⚠️  Could not open ownership ledger %s: %v (tamper protection disabled)\n", path, err)
+		telemetry.RecordError(ctx, err)
+		return
+	}
+	e.Ledger = owned
+	telemetry.Printf(ctx, "🔐 Ownership ledger %s: %d guard-written entr(ies)\n", path, owned.Len())
+}
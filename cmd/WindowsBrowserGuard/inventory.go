@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/inventory"
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+func newInventoryCmd() *cobra.Command {
+	var (
+		src         scanSource
+		policyFiles policyFileOptions
+		output      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inventory",
+		Short: "List every extension-related policy by browser and extension ID without evaluating it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := inventory.ParseFormat(output)
+			if err != nil {
+				return err
+			}
+			return runInventory(cmd.Context(), src, policyFiles.resolve(fileCfg, src), format)
+		},
+	}
+
+	src.addFlags(cmd)
+	policyFiles.addFlags(cmd.Flags())
+	cmd.Flags().StringVar(&output, "output", string(inventory.Table),
+		"Output format: table, json or markdown")
+	return cmd
+}
+
+// runInventory writes every extension-related policy of the selected policy
+// source, and of the policies.json files at policyFiles, to stdout in
+// format. Nothing is evaluated or changed.
+func runInventory(ctx context.Context, src scanSource, policyFiles []string, format inventory.Format) error {
+	ctx, span := telemetry.StartSpan(ctx, "main.inventory",
+		attribute.String("from-reg", src.fromReg),
+		attribute.String("hive", src.hive),
+		attribute.String("format", string(format)),
+	)
+	defer span.End()
+
+	_, state, err := loadScanSource(ctx, src)
+	if err != nil {
+		telemetry.Println(ctx, "Error loading policy state:", err)
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+
+	inv := monitor.BuildInventory(ctx, state)
+	monitor.AddPolicyFilesToInventory(inv, policyFiles)
+	span.SetAttributes(attribute.Int("entries", inv.Len()))
+
+	if err := inv.Write(os.Stdout, format); err != nil {
+		return fmt.Errorf("writing %s inventory: %w", format, err)
+	}
+	return nil
+}
@@ -3,26 +3,46 @@
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.opentelemetry.io/otel/attribute"
-	"golang.org/x/sys/windows"
 
 	"github.com/kad/WindowsBrowserGuard/pkg/admin"
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
 	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
 	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/snapshot"
 	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+	"github.com/kad/WindowsBrowserGuard/pkg/trust"
 )
 
-var extensionIndex *registry.ExtensionPathIndex
 var metrics registry.PerfMetrics
 
+// telemetryShutdownTimeout bounds how long exporting the remaining telemetry
+// may delay exiting.
+const telemetryShutdownTimeout = 10 * time.Second
+
+// Exit codes.
+const (
+	exitOK          = 0   // success, a clean scan or a watch stopped by SIGINT or SIGTERM
+	exitDetections  = 1   // scan found policies the guard acts on
+	exitError       = 2   // the command failed
+	exitInterrupted = 130 // a second signal forced an immediate exit
+)
+
+// policiesKeyPath is the HKLM subtree the guard captures and enforces.
+const policiesKeyPath = `SOFTWARE\Policies`
+
 // fileConfig holds values loaded from config.json; CLI flags override these.
 type fileConfig struct {
 	OTLPEndpoint string `json:"OTLPEndpoint"`
@@ -30,6 +50,59 @@ type fileConfig struct {
 	LogPath      string `json:"LogPath"`
 	DryRun       bool   `json:"DryRun"`
 	Quiet        bool   `json:"Quiet"`
+	SnapshotPath string `json:"SnapshotPath"`
+	LedgerPath   string `json:"LedgerPath"`
+	// Browsers adds custom browsers (e.g. Chromium forks) to the built-in table.
+	Browsers []browsers.Descriptor `json:"Browsers"`
+	// ExtensionSettingsAction is "block" (default) or "remove" and selects
+	// what happens to install entries in the JSON ExtensionSettings policy.
+	ExtensionSettingsAction string `json:"ExtensionSettingsAction"`
+	// FirefoxPolicyFiles overrides the Firefox policies.json paths to scan.
+	FirefoxPolicyFiles []string `json:"FirefoxPolicyFiles"`
+	// Exemptions lists approved extensions that are never removed.
+	Exemptions []exemptions.Rule `json:"Exemptions"`
+	// UpdateURLRules allow or block forcelist entries by update URL. When
+	// the key is missing trust.Defaults apply; an empty list sets no rules.
+	UpdateURLRules []trust.Rule `json:"UpdateURLRules"`
+	// HijackPolicies maps hijack policy names to report, remove or reset.
+	HijackPolicies map[string]string `json:"HijackPolicies"`
+	// IntelFeed is a JSON or CSV feed of known malicious extension IDs.
+	IntelFeed string `json:"IntelFeed"`
+	// ActionModes maps detector names (or "Default") to observe, block,
+	// remove, enforce or quarantine.
+	ActionModes map[string]string `json:"ActionModes"`
+	// QuarantineDir receives the backups taken in quarantine mode.
+	QuarantineDir string `json:"QuarantineDir"`
+	// DebounceQuiet and DebounceMaxLatency are durations (e.g. "500ms",
+	// "5s") controlling how bursts of change notifications are coalesced.
+	DebounceQuiet      string `json:"DebounceQuiet"`
+	DebounceMaxLatency string `json:"DebounceMaxLatency"`
+	// ReconcileInterval is how often the enforcement passes are re-run
+	// against a fresh capture while watching ("0" disables them).
+	ReconcileInterval string `json:"ReconcileInterval"`
+	// PollInterval is how often state is captured when change notifications
+	// fail.
+	PollInterval string `json:"PollInterval"`
+}
+
+// defaultLedgerPath returns ledger.json next to the running executable,
+// mirroring where config.json is looked up.
+func defaultLedgerPath() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "ledger.json"
+	}
+	return filepath.Join(filepath.Dir(exe), "ledger.json")
+}
+
+// defaultQuarantineDir returns a quarantine directory next to the running
+// executable, mirroring where config.json is looked up.
+func defaultQuarantineDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "quarantine"
+	}
+	return filepath.Join(filepath.Dir(exe), "quarantine")
 }
 
 // loadFileConfig reads config from path. If path is empty it looks for
@@ -56,63 +129,169 @@ func loadFileConfig(cfgPath string) (*fileConfig, error) {
 	return &cfg, nil
 }
 
+// applyFileConfig applies the settings of cfg that are shared by every
+// command.
+func applyFileConfig(cfg *fileConfig) error {
+	for _, d := range cfg.Browsers {
+		if err := browsers.Register(d); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	return nil
+}
+
+// fileCfg is the loaded config.json, set before any command runs.
+var fileCfg *fileConfig
+
+// telemetryOptions are the logging and telemetry settings shared by every
+// command.
+type telemetryOptions struct {
+	quiet       bool
+	logFile     string
+	traceFile   string
+	otlpURL     string
+	otlpHeaders string
+}
+
+// addFlags registers the telemetry flags on fs.
+func (o *telemetryOptions) addFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.quiet, "quiet", false, "Suppress stdout logging (send logs to OTLP pipeline only)")
+	fs.StringVar(&o.logFile, "log-file", "", "Path to log file; output is appended (always active, independent of --quiet)")
+	fs.StringVar(&o.traceFile, "trace-file", "", "Output file for OpenTelemetry traces (use 'stdout' for console)")
+	fs.StringVar(&o.otlpURL, "otlp-endpoint", "",
+		"OTLP endpoint URL — scheme sets protocol and TLS:\n"+
+			"  grpc://host[:4317]   gRPC, no TLS\n"+
+			"  grpcs://host[:443]   gRPC, TLS\n"+
+			"  http://host[:4318]   HTTP, no TLS\n"+
+			"  https://host[:443]   HTTP, TLS")
+	fs.StringVar(&o.otlpHeaders, "otlp-headers", "",
+		"OTLP headers as comma-separated key=value pairs (e.g. 'Authorization=Bearer token')")
+}
+
+// applyConfig fills the options whose flags were not set on cmd from cfg.
+func (o *telemetryOptions) applyConfig(cmd *cobra.Command, cfg *fileConfig) {
+	// CLI flags override config file values.
+	if !cmd.Flags().Changed("otlp-endpoint") && cfg.OTLPEndpoint != "" {
+		o.otlpURL = cfg.OTLPEndpoint
+	}
+	if !cmd.Flags().Changed("otlp-headers") && cfg.OTLPHeaders != "" {
+		o.otlpHeaders = cfg.OTLPHeaders
+	}
+	if !cmd.Flags().Changed("log-file") && cfg.LogPath != "" {
+		o.logFile = cfg.LogPath
+	}
+	if !cmd.Flags().Changed("quiet") && cfg.Quiet {
+		o.quiet = true
+	}
+	// A machine-readable report owns stdout.
+	if output := cmd.Flags().Lookup("output"); output != nil && output.Changed {
+		o.quiet = true
+	}
+}
+
 func main() {
 	var (
-		configFile  string
-		dryRun      bool
-		quiet       bool
-		logFilePath string
-		traceFile   string
-		otlpURL     string
-		otlpHeaders string
+		configFile        string
+		telemetryOpts     telemetryOptions
+		shutdownTelemetry = func() {}
 	)
 
 	rootCmd := &cobra.Command{
-		Use:          "WindowsBrowserGuard",
-		Short:        "Monitor and block forced browser extension policies via Windows Registry",
-		SilenceUsage: true,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// Load config file; CLI flags override config file values.
-			fileCfg, err := loadFileConfig(configFile)
+		Use:   "WindowsBrowserGuard",
+		Short: "Monitor and block forced browser extension policies via Windows Registry",
+		Long: "Monitor and block forced browser extension policies via Windows Registry.\n\n" +
+			"Without a subcommand the guard runs as watch does.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			fileCfg, err = loadFileConfig(configFile)
 			if err != nil {
 				return err
 			}
-			if !cmd.Flags().Changed("otlp-endpoint") && fileCfg.OTLPEndpoint != "" {
-				otlpURL = fileCfg.OTLPEndpoint
-			}
-			if !cmd.Flags().Changed("otlp-headers") && fileCfg.OTLPHeaders != "" {
-				otlpHeaders = fileCfg.OTLPHeaders
-			}
-			if !cmd.Flags().Changed("log-file") && fileCfg.LogPath != "" {
-				logFilePath = fileCfg.LogPath
-			}
-			if !cmd.Flags().Changed("dry-run") && fileCfg.DryRun {
-				dryRun = true
+			if err := applyFileConfig(fileCfg); err != nil {
+				return err
 			}
-			if !cmd.Flags().Changed("quiet") && fileCfg.Quiet {
-				quiet = true
+			telemetryOpts.applyConfig(cmd, fileCfg)
+			shutdown, err := setupTelemetry(cmd.Context(), telemetryOpts)
+			if err != nil {
+				return err
 			}
-			return runApp(dryRun, quiet, logFilePath, traceFile, otlpURL, otlpHeaders)
+			shutdownTelemetry = shutdown
+			return nil
 		},
 	}
 
-	f := rootCmd.Flags()
-	f.StringVar(&configFile, "config", "", "Path to config JSON file (default: config.json next to executable)")
-	f.BoolVar(&dryRun, "dry-run", false, "Read-only mode: detect and log planned operations without making changes")
-	f.BoolVar(&quiet, "quiet", false, "Suppress stdout logging (send logs to OTLP pipeline only)")
-	f.StringVar(&logFilePath, "log-file", "", "Path to log file; output is appended (always active, independent of --quiet)")
-	f.StringVar(&traceFile, "trace-file", "", "Output file for OpenTelemetry traces (use 'stdout' for console)")
-	f.StringVar(&otlpURL, "otlp-endpoint", "",
-		"OTLP endpoint URL — scheme sets protocol and TLS:\n"+
-			"  grpc://host[:4317]   gRPC, no TLS\n"+
-			"  grpcs://host[:443]   gRPC, TLS\n"+
-			"  http://host[:4318]   HTTP, no TLS\n"+
-			"  https://host[:443]   HTTP, TLS")
-	f.StringVar(&otlpHeaders, "otlp-headers", "",
-		"OTLP headers as comma-separated key=value pairs (e.g. 'Authorization=Bearer token')")
+	pf := rootCmd.PersistentFlags()
+	pf.StringVar(&configFile, "config", "", "Path to config JSON file (default: config.json next to executable)")
+	telemetryOpts.addFlags(pf)
+
+	// The root command keeps running the guard for existing service installs.
+	var watch watchOptions
+	watch.addFlags(rootCmd.Flags())
+	rootCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return watch.run(cmd)
+	}
+
+	rootCmd.AddCommand(newScanCmd(), newWatchCmd(), newPlanCmd(), newApplyCmd(), newInventoryCmd(), newSnapshotCmd())
+
+	ctx, stop := signalContext(context.Background())
+	err := rootCmd.ExecuteContext(ctx)
+	shutdownTelemetry()
+	stop()
+	os.Exit(exitCode(err))
+}
+
+// exitStatus is returned by a command that completed but must exit with a
+// status other than exitOK, e.g. a scan that found detections. It is not
+// reported as an error.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// exitCode returns the process exit code for the error a command returned,
+// reporting real errors on stderr.
+func exitCode(err error) int {
+	var status exitStatus
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &status):
+		return int(status)
+	}
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	return exitError
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM,
+// with the signal as its cause, so commands can shut down gracefully. A
+// second signal exits immediately with exitInterrupted. stop releases the
+// signal handler.
+func signalContext(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			cancel(fmt.Errorf("received %s", sig))
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "Received %s again, exiting immediately\n", sig)
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel(nil)
 	}
 }
 
@@ -127,59 +306,71 @@ func parseHeaders(raw string) map[string]string {
 	return headers
 }
 
-func runApp(dryRun, quiet bool, logFilePath, traceFile, rawOTLPEndpoint, otlpHeaders string) error {
+// setupTelemetry configures logging and initialises tracing for a command.
+// The returned function exports what is left and shuts tracing down; it is
+// a no-op when no exporter is configured.
+func setupTelemetry(ctx context.Context, opts telemetryOptions) (func(), error) {
 	// Apply stdout suppression before any logging
-	if quiet {
+	if opts.quiet {
 		telemetry.SetSuppressStdout(true)
 	}
 	// Open log file if specified — always active regardless of --quiet or OTLP
-	if logFilePath != "" {
-		if err := telemetry.SetLogFile(logFilePath); err != nil {
-			return err
+	if opts.logFile != "" {
+		if err := telemetry.SetLogFile(opts.logFile); err != nil {
+			return nil, err
 		}
 	}
 	// Parse OTLP endpoint URL → host:port, protocol, TLS setting
-	otlpHost, otlpProtocol, otlpInsecure, err := telemetry.ParseOTLPEndpoint(rawOTLPEndpoint)
+	otlpHost, otlpProtocol, otlpInsecure, err := telemetry.ParseOTLPEndpoint(opts.otlpURL)
 	if err != nil {
-		return fmt.Errorf("--otlp-endpoint: %w", err)
+		return nil, fmt.Errorf("--otlp-endpoint: %w", err)
 	}
 
-	ctx := context.Background()
 	cfg := telemetry.Config{
-		TraceOutput:  traceFile,
+		TraceOutput:  opts.traceFile,
 		OTLPEndpoint: otlpHost,
 		OTLPProtocol: otlpProtocol,
 		OTLPInsecure: otlpInsecure,
-		OTLPHeaders:  parseHeaders(otlpHeaders),
+		OTLPHeaders:  parseHeaders(opts.otlpHeaders),
 	}
 
 	shutdown, err := telemetry.InitTracing(cfg)
 	if err != nil {
 		telemetry.Printf(ctx, "Warning: Failed to initialize tracing: %v\n", err)
-	} else if traceFile != "" || otlpHost != "" {
-		if otlpHost != "" {
-			var scheme string
-			switch {
-			case otlpProtocol == "grpc" && otlpInsecure:
-				scheme = "grpc"
-			case otlpProtocol == "grpc":
-				scheme = "grpcs"
-			case otlpInsecure:
-				scheme = "http"
-			default:
-				scheme = "https"
-			}
-			telemetry.Printf(ctx, "📊 Telemetry enabled: %s://%s\n", scheme, otlpHost)
-		} else {
-			telemetry.Printf(ctx, "📊 Tracing enabled: %s\n", traceFile)
+		return func() {}, nil
+	}
+	if opts.traceFile == "" && otlpHost == "" {
+		return func() {}, nil
+	}
+	if otlpHost != "" {
+		var scheme string
+		switch {
+		case otlpProtocol == "grpc" && otlpInsecure:
+			scheme = "grpc"
+		case otlpProtocol == "grpc":
+			scheme = "grpcs"
+		case otlpInsecure:
+			scheme = "http"
+		default:
+			scheme = "https"
 		}
-		defer func() {
-			if err := shutdown(ctx); err != nil {
-				fmt.Printf("Warning: Failed to shutdown tracing: %v\n", err)
-			}
-		}()
+		telemetry.Printf(ctx, "📊 Telemetry enabled: %s://%s\n", scheme, otlpHost)
+	} else {
+		telemetry.Printf(ctx, "📊 Tracing enabled: %s\n", opts.traceFile)
 	}
+	return func() {
+		// ctx may be cancelled by then; export what is left regardless.
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), telemetryShutdownTimeout)
+		defer cancel()
+		if err := shutdown(shutdownCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to shutdown tracing: %v\n", err)
+		}
+	}, nil
+}
 
+// runWatch runs the guard: a startup enforcement pass followed by watching
+// the policies until ctx is cancelled.
+func runWatch(ctx context.Context, e *monitor.Engine, dryRun bool, snapshotPath, ledgerPath string) error {
 	// Start main application span
 	ctx, mainSpan := telemetry.StartSpan(ctx, "main.application",
 		attribute.Bool("dry-run", dryRun),
@@ -194,7 +385,7 @@ func runApp(dryRun, quiet bool, logFilePath, traceFile, rawOTLPEndpoint, otlpHea
 		attribute.Bool("can-write", canWrite),
 	)
 
-	keyPath := `SOFTWARE\Policies`
+	keyPath := policiesKeyPath
 
 	if canWrite {
 		canDelete := admin.CanDeleteRegistryKey(keyPath)
@@ -209,31 +400,23 @@ func runApp(dryRun, quiet bool, logFilePath, traceFile, rawOTLPEndpoint, otlpHea
 		}
 	}
 
-	key, err := syscall.UTF16PtrFromString(keyPath)
+	backend, err := registry.NewLiveBackend()
 	if err != nil {
-		telemetry.Println(ctx, "Error converting key path:", err)
+		telemetry.Println(ctx, "Error opening registry:", err)
 		telemetry.RecordError(ctx, err)
 		return err
 	}
 
-	var hKey windows.Handle
-	// In dry-run mode, only request read permissions
-	var permissions uint32 = windows.KEY_NOTIFY | windows.KEY_READ
-	if canWrite {
-		permissions |= windows.DELETE
-	}
-
-	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, key, 0, permissions, &hKey)
+	err = backend.OpenKey(keyPath)
 	if err != nil {
 		telemetry.Println(ctx, "Error opening registry key:", err)
 		telemetry.RecordError(ctx, err)
 		return err
 	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
 
 	telemetry.Println(ctx, "Capturing initial registry state...")
 	startTime := time.Now()
-	previousState, err := monitor.CaptureRegistryState(ctx, hKey, keyPath)
+	previousState, err := monitor.CaptureRegistryState(ctx, backend, keyPath)
 	if err != nil {
 		telemetry.Println(ctx, "Error capturing initial state:", err)
 		telemetry.RecordError(ctx, err)
@@ -252,28 +435,54 @@ func runApp(dryRun, quiet bool, logFilePath, traceFile, rawOTLPEndpoint, otlpHea
 	telemetry.Printf(ctx, "Initial state: %d subkeys, %d values (captured in %v)\n",
 		len(previousState.Subkeys), len(previousState.Values), scanDuration)
 
+	snapshots, lastSnapshot := openSnapshotRecorder(ctx, snapshotPath, keyPath, !canWrite)
+	if lastSnapshot != nil {
+		if drift := reportOfflineDrift(ctx, lastSnapshot, previousState); len(drift) > 0 {
+			snapshots.record(ctx, snapshot.EventDrift, previousState, drift)
+		}
+	}
+
+	openLedger(ctx, e, ledgerPath)
+
 	telemetry.Println(ctx, "Building extension path index...")
 	indexStart := time.Now()
-	extensionIndex = registry.NewExtensionPathIndex()
-	extensionIndex.BuildFromState(previousState)
+	index := registry.NewExtensionPathIndex()
+	index.BuildFromState(previousState)
 	indexDuration := time.Since(indexStart)
 	metrics.IndexBuildTime = indexDuration
 
 	telemetry.SetAttributes(ctx,
-		attribute.Int("index.extension-count", extensionIndex.GetCount()),
+		attribute.Int("index.extension-count", index.GetCount()),
 		attribute.String("index.build-duration", indexDuration.String()),
 	)
 
 	telemetry.Printf(ctx, "Index built: tracking %d unique extension IDs (in %v)\n",
-		extensionIndex.GetCount(), indexDuration)
+		index.GetCount(), indexDuration)
+
+	if modes := e.Modes; len(modes) > 0 {
+		telemetry.Printf(ctx, "⚙️  Action modes: %s\n", modes)
+		telemetry.SetAttributes(ctx, attribute.String("action-modes", modes.String()))
+	}
 
-	monitor.ProcessExistingPolicies(ctx, keyPath, previousState, canWrite, extensionIndex)
-	// Run the targeted consistency pass first so startup behavior matches the
-	// live path, then follow with the broader allowlist cleanup.
-	monitor.EnforceBlockAllowlistConsistency(ctx, keyPath, previousState, canWrite, monitor.CollectPlannedBlockedIDs(previousState))
-	monitor.CleanupAllowlists(ctx, keyPath, previousState, canWrite)
-	monitor.CleanupExtensionSettings(ctx, keyPath, previousState, canWrite, extensionIndex)
+	sim := e.Plan(ctx, previousState, e.EnforcementPasses()...)
+	// Save what the registry looks like after enforcement, so the guard's own
+	// startup writes are not reported as drift on the next run.
+	enforcedState := e.Enforce(ctx, backend, keyPath, sim, canWrite)
+	snapshots.record(ctx, snapshot.EventStartup, enforcedState, nil)
 
-	monitor.WatchRegistryChanges(ctx, hKey, keyPath, previousState, canWrite, extensionIndex)
+	if managed, ok := backend.(*registry.ManagedBackend); ok {
+		e.WatchFileChanges(ctx, managed, keyPath, enforcedState, canWrite, snapshots.onChange)
+	} else {
+		e.WatchRegistryChanges(ctx, backend, keyPath, enforcedState, canWrite, snapshots.onChange)
+	}
+
+	cause := context.Cause(ctx)
+	if cause == nil {
+		err := errors.New("monitoring stopped unexpectedly")
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+	telemetry.Printf(ctx, "🛑 Shutting down: %v\n", cause)
+	telemetry.AddEvent(ctx, "shutdown", attribute.String("cause", cause.Error()))
 	return nil
 }
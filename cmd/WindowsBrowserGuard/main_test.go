@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+	"github.com/kad/WindowsBrowserGuard/pkg/trust"
+)
+
+// TestExampleConfig checks that config.example.json shows every config key
+// with a value the guard accepts.
+func TestExampleConfig(t *testing.T) {
+	cfg, err := loadFileConfig("../../config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Telemetry export stays off in the example; sample values are shown in
+	// the underscore keys next to it.
+	emptyByDefault := map[string]bool{"OTLPEndpoint": true, "OTLPHeaders": true}
+	v := reflect.ValueOf(*cfg)
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if v.Field(i).IsZero() && field.Type.Kind() != reflect.Bool && !emptyByDefault[field.Name] {
+			t.Errorf("config.example.json has no example of %s", field.Tag.Get("json"))
+		}
+	}
+
+	table := browsers.NewTable(browsers.Builtin...)
+	for _, d := range cfg.Browsers {
+		if err := table.Register(d); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := exemptions.New(cfg.Exemptions); err != nil {
+		t.Error(err)
+	}
+	if _, err := trust.New(cfg.UpdateURLRules); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(cfg.UpdateURLRules, trust.Defaults()) {
+		t.Error("UpdateURLRules differ from the built-in defaults")
+	}
+	if _, err := hijack.ParseActions(cfg.HijackPolicies); err != nil {
+		t.Error(err)
+	}
+	if _, err := monitor.ParseActionModes(cfg.ActionModes); err != nil {
+		t.Error(err)
+	}
+	for _, d := range []string{cfg.DebounceQuiet, cfg.DebounceMaxLatency, cfg.ReconcileInterval, cfg.PollInterval} {
+		if _, err := time.ParseDuration(d); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/regfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+func newPlanCmd() *cobra.Command {
+	var (
+		src                scanSource
+		engineOpts         engineOptions
+		ledgerOpts         ledgerOptions
+		emitReg, emitState string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Print the remediation plan and optionally export it as a .reg file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := engineOpts.newEngine(fileCfg, src)
+			if err != nil {
+				return err
+			}
+			// The ledger belongs to the running system, so an offline source
+			// is only checked against it when it is named explicitly.
+			var ledgerPath string
+			if src == (scanSource{}) || cmd.Flags().Changed("ledger") {
+				ledgerPath = ledgerOpts.resolve(fileCfg)
+			}
+			return runPlan(cmd.Context(), e, src, emitReg, emitState, ledgerPath)
+		},
+	}
+
+	src.addFlags(cmd)
+	f := cmd.Flags()
+	engineOpts.addFlags(f)
+	ledgerOpts.addFlags(f)
+	f.StringVar(&emitReg, "emit-reg", "", "Write the planned remediation to this .reg file for manual review and import")
+	f.StringVar(&emitState, "emit-state", "", "Write the current HKLM\\SOFTWARE\\Policies state to this .reg file (backup)")
+	return cmd
+}
+
+// runPlan prints the remediation plan e builds for src, checked against the
+// ledger at ledgerPath unless it is empty, and writes the .reg files.
+func runPlan(ctx context.Context, e *monitor.Engine, src scanSource, emitReg, emitState, ledgerPath string) error {
+	ctx, span := telemetry.StartSpan(ctx, "main.plan",
+		attribute.String("from-reg", src.fromReg),
+		attribute.String("hive", src.hive),
+		attribute.String("emit-reg", emitReg),
+	)
+	defer span.End()
+
+	backend, _, err := loadScanSource(ctx, src)
+	if err != nil {
+		telemetry.Println(ctx, "Error loading policy state:", err)
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+	if ledgerPath != "" {
+		openLedger(ctx, e, ledgerPath)
+	}
+
+	sim, err := buildPlan(ctx, e, backend)
+	if err != nil {
+		return err
+	}
+
+	if emitState != "" {
+		f := regfile.FromState(sim.Before, policiesKeyPath, "")
+		if err := writeRegFile(ctx, emitState, f); err != nil {
+			return err
+		}
+		telemetry.Printf(ctx, "💾 Current policy state written to %s\n", emitState)
+	}
+
+	monitor.PrintPlan(ctx, sim.Plan)
+
+	if emitReg == "" {
+		return nil
+	}
+	f := regfile.Remediation(sim.Before, sim.After, policiesKeyPath)
+	if err := writeRegFile(ctx, emitReg, f); err != nil {
+		return err
+	}
+	telemetry.Printf(ctx, "📝 Remediation with %d key section(s) written to %s\n", len(f.Keys), emitReg)
+	return nil
+}
+
+func newApplyCmd() *cobra.Command {
+	var (
+		engineOpts engineOptions
+		ledgerOpts ledgerOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Build the remediation plan of the live policies and apply it",
+		Long: "Build the remediation plan exactly as the plan command does, print it and apply its\n" +
+			"actions in order to the live policies, reporting the result of each action.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := engineOpts.newEngine(fileCfg, scanSource{})
+			if err != nil {
+				return err
+			}
+			return runApply(cmd.Context(), e, ledgerOpts.resolve(fileCfg))
+		},
+	}
+
+	engineOpts.addFlags(cmd.Flags())
+	ledgerOpts.addFlags(cmd.Flags())
+	return cmd
+}
+
+// runApply applies the remediation plan e builds for the live policies,
+// recording the entries it writes in the ledger at ledgerPath.
+func runApply(ctx context.Context, e *monitor.Engine, ledgerPath string) error {
+	ctx, span := telemetry.StartSpan(ctx, "main.apply")
+	defer span.End()
+
+	backend, err := registry.NewLiveBackend()
+	if err != nil {
+		telemetry.Println(ctx, "Error opening registry:", err)
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+	if !backend.HasWriteAccess() {
+		err := errors.New("applying the plan needs write access to the policies (run as Administrator)")
+		telemetry.Println(ctx, "❌", err)
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+	openLedger(ctx, e, ledgerPath)
+
+	sim, err := buildPlan(ctx, e, backend)
+	if err != nil {
+		return err
+	}
+	monitor.PrintPlan(ctx, sim.Plan)
+
+	results := e.ApplyPlan(ctx, backend, policiesKeyPath, sim.Plan)
+	monitor.PrintResults(ctx, results)
+	if failed := plan.Count(results, plan.Failed); failed > 0 {
+		return fmt.Errorf("%d of %d action(s) failed", failed, len(results))
+	}
+	return nil
+}
+
+// buildPlan decides the remediation of the policies in backend with the
+// enforcement passes of e. Nothing is written to backend.
+func buildPlan(ctx context.Context, e *monitor.Engine, backend registry.Backend) (*monitor.Simulation, error) {
+	sim, err := e.BuildPlan(ctx, backend, policiesKeyPath, e.EnforcementPasses()...)
+	if err != nil {
+		err = fmt.Errorf("building remediation plan: %w", err)
+		telemetry.Println(ctx, "Error:", err)
+		telemetry.RecordError(ctx, err)
+		return nil, err
+	}
+	return sim, nil
+}
+
+// writeRegFile writes f to path.
+func writeRegFile(ctx context.Context, path string, f *regfile.File) error {
+	if err := regfile.WriteFile(path, f); err != nil {
+		telemetry.Println(ctx, "Error writing .reg file:", err)
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+	return nil
+}
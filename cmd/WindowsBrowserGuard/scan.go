@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/hive"
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+	"github.com/kad/WindowsBrowserGuard/pkg/regfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/report"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// scanSource selects where scan and plan read policies from. The zero value
+// means the live registry.
+type scanSource struct {
+	fromReg string // regedit export (.reg)
+	hive    string // offline SOFTWARE hive file
+}
+
+// String names src for reports: the file read, or "live" for the live
+// policies.
+func (src scanSource) String() string {
+	switch {
+	case src.fromReg != "":
+		return src.fromReg
+	case src.hive != "":
+		return src.hive
+	}
+	return "live"
+}
+
+// addFlags registers the source selection flags on cmd.
+func (src *scanSource) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&src.fromReg, "from-reg", "",
+		"Read a regedit export (.reg) containing HKLM\\SOFTWARE\\Policies instead of the live registry")
+	cmd.Flags().StringVar(&src.hive, "hive", "",
+		"Read an offline SOFTWARE hive file (e.g. from a disk image or shadow copy) instead of the live registry")
+	cmd.MarkFlagsMutuallyExclusive("from-reg", "hive")
+}
+
+func newScanCmd() *cobra.Command {
+	var (
+		src        scanSource
+		engineOpts engineOptions
+		output     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "scan",
+		Short: "Run a one-shot read-only scan and print what the guard would do",
+		Long: "Run a one-shot read-only scan and print what the guard would do.\n\n" +
+			"Exit status: 0 when no policy the guard acts on was found, 1 when some were\n" +
+			"found, 2 on error.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := engineOpts.newEngine(fileCfg, src)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				return runScan(cmd.Context(), e, src)
+			}
+			format, err := report.ParseFormat(output)
+			if err != nil {
+				return err
+			}
+			return runScanReport(cmd.Context(), e, src, format)
+		},
+	}
+
+	src.addFlags(cmd)
+	engineOpts.addFlags(cmd.Flags())
+	cmd.Flags().StringVar(&output, "output", "",
+		"Write a machine-readable report to stdout instead of text: json, jsonl, csv or sarif")
+	return cmd
+}
+
+// loadScanSource returns the backend and captured policy state to scan:
+// a .reg export or hive file when selected, otherwise the live registry.
+func loadScanSource(ctx context.Context, src scanSource) (registry.Backend, *registry.RegState, error) {
+	if src.fromReg != "" {
+		state, backend, err := regfile.LoadState(src.fromReg, policiesKeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		telemetry.Printf(ctx, "📄 Loaded %s: %d subkeys, %d values\n", src.fromReg, len(state.Subkeys), len(state.Values))
+		return backend, state, nil
+	}
+
+	if src.hive != "" {
+		h, err := hive.Open(src.hive, "SOFTWARE")
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.Dirty() {
+			telemetry.Printf(ctx, "⚠️  %s has unreplayed transaction logs; recent changes may be missing\n", src.hive)
+		}
+		state, err := monitor.CaptureRegistryState(ctx, h, policiesKeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		telemetry.Printf(ctx, "🗄️  Loaded hive %s: %d subkeys, %d values\n", src.hive, len(state.Subkeys), len(state.Values))
+		return h, state, nil
+	}
+
+	backend, err := registry.NewLiveBackend()
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := monitor.CaptureRegistryState(ctx, backend, policiesKeyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	telemetry.Printf(ctx, "Captured HKLM\\%s: %d subkeys, %d values\n", policiesKeyPath, len(state.Subkeys), len(state.Values))
+	return backend, state, nil
+}
+
+// runScan plans the remediation of the selected policy source, and of the
+// policies.json files of e, with e and prints the plan. Nothing is
+// ever written, not even to an imported tree. It returns
+// exitStatus(exitDetections) if a policy the guard acts on was found.
+func runScan(ctx context.Context, e *monitor.Engine, src scanSource) error {
+	ctx, span := telemetry.StartSpan(ctx, "main.scan",
+		attribute.String("from-reg", src.fromReg),
+		attribute.String("hive", src.hive),
+	)
+	defer span.End()
+
+	_, state, err := loadScanSource(ctx, src)
+	if err != nil {
+		telemetry.Println(ctx, "Error loading policy state:", err)
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+
+	detections := e.FindDetections(state)
+	monitor.PrintPlan(ctx, scanPlan(ctx, e, state).Plan)
+	detections = append(detections, e.FindPolicyFileDetections(e.PolicyFiles)...)
+
+	n := countDetections(detections)
+	span.SetAttributes(attribute.Int("detections", n))
+	if n == 0 {
+		telemetry.Println(ctx, "✓ Scan clean: no policies to remediate")
+		return nil
+	}
+	telemetry.Printf(ctx, "🚨 Scan found %d polic(ies) to remediate\n", n)
+	return exitStatus(exitDetections)
+}
+
+// scanPlan plans the remediation of state with the enforcement passes of
+// e. The state scan loaded is planned as is, so the passes run once and
+// nothing is captured again.
+func scanPlan(ctx context.Context, e *monitor.Engine, state *registry.RegState) *monitor.Simulation {
+	return e.Plan(ctx, state, e.EnforcementPasses()...)
+}
+
+// countDetections returns the number of detections the guard acts on, i.e.
+// those not allowed by an exemption or update URL rule.
+func countDetections(detections []report.Detection) int {
+	n := 0
+	for _, d := range detections {
+		if d.AllowedBy == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// runScanReport evaluates the selected policy source like runScan, but writes
+// the detected policies and the planned actions to stdout as a report in
+// format. Progress output is kept off stdout; it still reaches the log file
+// and the OTel pipeline. The exit status is that of runScan.
+func runScanReport(ctx context.Context, e *monitor.Engine, src scanSource, format report.Format) error {
+	ctx, span := telemetry.StartSpan(ctx, "main.scanReport",
+		attribute.String("from-reg", src.fromReg),
+		attribute.String("hive", src.hive),
+		attribute.String("format", string(format)),
+	)
+	defer span.End()
+
+	_, state, err := loadScanSource(ctx, src)
+	if err != nil {
+		telemetry.RecordError(ctx, err)
+		return err
+	}
+	sim := scanPlan(ctx, e, state)
+
+	r := report.Report{
+		Source:     src.String(),
+		Detections: append(e.FindDetections(state), e.FindPolicyFileDetections(e.PolicyFiles)...),
+		Actions:    sim.Plan.Results(),
+	}
+	n := countDetections(r.Detections)
+	span.SetAttributes(
+		attribute.Int("detections", n),
+		attribute.Int("actions", len(r.Actions)),
+	)
+	if err := r.Write(os.Stdout, format); err != nil {
+		return fmt.Errorf("writing %s report: %w", format, err)
+	}
+	if n > 0 {
+		return exitStatus(exitDetections)
+	}
+	return nil
+}
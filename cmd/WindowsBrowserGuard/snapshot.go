@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/snapshot"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// defaultSnapshotPath returns snapshot.json next to the running executable,
+// mirroring where config.json is looked up.
+func defaultSnapshotPath() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "snapshot.json"
+	}
+	return filepath.Join(filepath.Dir(exe), "snapshot.json")
+}
+
+// snapshotRecorder keeps the watch daemon's snapshot file up to date.
+type snapshotRecorder struct {
+	path   string
+	snap   *snapshot.Snapshot
+	dryRun bool
+}
+
+// openSnapshotRecorder loads the snapshot at path. previous is the loaded
+// snapshot, or nil when there is none (first run) or it cannot be used.
+func openSnapshotRecorder(ctx context.Context, path, keyPath string, dryRun bool) (rec *snapshotRecorder, previous *snapshot.Snapshot) {
+	rec = &snapshotRecorder{path: path, dryRun: dryRun}
+
+	snap, err := snapshot.Load(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		telemetry.Printf(ctx, "💾 No snapshot at %s yet - drift since last run cannot be reported\n", path)
+	case err != nil:
+		telemetry.Printf(ctx, "⚠️  Ignoring unreadable snapshot: %v\n", err)
+		telemetry.RecordError(ctx, err)
+	case !strings.EqualFold(snap.KeyPath, keyPath):
+		telemetry.Printf(ctx, "⚠️  Ignoring snapshot of HKLM\\%s (watching HKLM\\%s)\n", snap.KeyPath, keyPath)
+	default:
+		rec.snap = snap
+		previous = snap
+	}
+	if rec.snap == nil {
+		rec.snap = snapshot.New(keyPath, registry.NewRegState())
+	}
+	return rec, previous
+}
+
+// record stores state with a journal entry and saves the snapshot file.
+// Failures are logged but never stop the guard.
+func (r *snapshotRecorder) record(ctx context.Context, event string, state *registry.RegState, changes []registry.Change) {
+	r.snap.Record(event, state, changes, r.dryRun)
+	if err := snapshot.Save(r.path, r.snap); err != nil {
+		telemetry.Printf(ctx, "⚠️  Failed to save snapshot: %v\n", err)
+		telemetry.RecordError(ctx, err)
+	}
+}
+
+// onChange is the monitor.ChangeHandler used while watching.
+func (r *snapshotRecorder) onChange(ctx context.Context, state *registry.RegState, changes []registry.Change) {
+	r.record(ctx, snapshot.EventChange, state, changes)
+}
+
+// reportOfflineDrift prints the changes between the saved snapshot and the
+// live state, i.e. everything that happened while the guard was not running.
+func reportOfflineDrift(ctx context.Context, previous *snapshot.Snapshot, live *registry.RegState) []registry.Change {
+	ctx, span := telemetry.StartSpan(ctx, "main.reportOfflineDrift",
+		attribute.String("snapshot.taken", previous.Taken.Format(time.RFC3339)),
+	)
+	defer span.End()
+
+	changes := registry.Diff(previous.State, live)
+	telemetry.SetAttributes(ctx, attribute.Int("drift.changes", len(changes)))
+	if len(changes) == 0 {
+		telemetry.Printf(ctx, "✓ No changes since last snapshot (%s)\n", previous.Taken.Local().Format(time.RFC3339))
+		return nil
+	}
+
+	telemetry.AddEvent(ctx, "offline-drift", attribute.Int("changes", len(changes)))
+	telemetry.Println(ctx, "\n========== CHANGES WHILE NOT WATCHING ==========")
+	telemetry.Println(ctx, "Since:", previous.Taken.Local().Format(time.RFC3339))
+	telemetry.Println(ctx, "================================================")
+	for _, change := range changes {
+		monitor.PrintChange(ctx, change)
+	}
+	telemetry.Printf(ctx, "⚠️  %d change(s) made while we were not watching\n", len(changes))
+	telemetry.Println(ctx, "================================================")
+	return changes
+}
+
+func newSnapshotCmd() *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save, inspect and compare persisted policy snapshots",
+	}
+	cmd.PersistentFlags().StringVar(&file, "file", defaultSnapshotPath(), "Snapshot file")
+
+	cmd.AddCommand(newSnapshotSaveCmd(&file), newSnapshotShowCmd(&file), newSnapshotDiffCmd(&file))
+	return cmd
+}
+
+func newSnapshotSaveCmd(file *string) *cobra.Command {
+	var src scanSource
+
+	cmd := &cobra.Command{
+		Use:   "save",
+		Short: "Capture the policy tree and save it to the snapshot file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			_, state, err := loadScanSource(ctx, src)
+			if err != nil {
+				return err
+			}
+
+			// Keep the journal of an existing snapshot of the same tree.
+			snap, err := snapshot.Load(*file)
+			if err != nil || !strings.EqualFold(snap.KeyPath, policiesKeyPath) {
+				snap = snapshot.New(policiesKeyPath, state)
+			}
+			snap.Record(snapshot.EventManual, state, nil, false)
+			if err := snapshot.Save(*file, snap); err != nil {
+				return err
+			}
+			telemetry.Printf(ctx, "💾 Snapshot saved to %s\n", *file)
+			return nil
+		},
+	}
+	src.addFlags(cmd)
+	return cmd
+}
+
+func newSnapshotShowCmd(file *string) *cobra.Command {
+	var journal int
+	var values bool
+
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print a summary of the snapshot file and its journal",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			snap, err := snapshot.Load(*file)
+			if err != nil {
+				return err
+			}
+
+			telemetry.Printf(ctx, "Snapshot: %s\n", *file)
+			telemetry.Printf(ctx, "Key:      HKLM\\%s\n", snap.KeyPath)
+			telemetry.Printf(ctx, "Taken:    %s\n", snap.Taken.Local().Format(time.RFC3339))
+			telemetry.Printf(ctx, "State:    %d subkeys, %d values\n", len(snap.State.Subkeys), len(snap.State.Values))
+
+			if values {
+				paths := make([]string, 0, len(snap.State.Values))
+				for path := range snap.State.Values {
+					paths = append(paths, path)
+				}
+				sort.Strings(paths)
+				telemetry.Println(ctx, "\nValues:")
+				for _, path := range paths {
+					value := snap.State.Values[path]
+					telemetry.Printf(ctx, "  %s = %s (type: %d)\n", path, value.Data, value.Type)
+				}
+			}
+
+			entries := snap.Journal
+			if journal >= 0 && len(entries) > journal {
+				entries = entries[len(entries)-journal:]
+			}
+			telemetry.Printf(ctx, "\nJournal (%d of %d entries):\n", len(entries), len(snap.Journal))
+			for _, entry := range entries {
+				mode := ""
+				if entry.DryRun {
+					mode = " [dry-run]"
+				}
+				telemetry.Printf(ctx, "  %s  %-7s %d change(s)%s\n",
+					entry.Time.Local().Format(time.RFC3339), entry.Event, len(entry.Changes), mode)
+				for _, change := range entry.Changes {
+					telemetry.Printf(ctx, "      [%s] %s\n", change.Kind, change.Path)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&journal, "journal", 20, "Number of most recent journal entries to print (-1 for all)")
+	cmd.Flags().BoolVar(&values, "values", false, "Also list every captured value")
+	return cmd
+}
+
+func newSnapshotDiffCmd(file *string) *cobra.Command {
+	var src scanSource
+	var against string
+
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the snapshot with the live registry (or another source)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			snap, err := snapshot.Load(*file)
+			if err != nil {
+				return err
+			}
+
+			var current *registry.RegState
+			if against != "" {
+				other, err := snapshot.Load(against)
+				if err != nil {
+					return err
+				}
+				current = other.State
+			} else {
+				_, current, err = loadScanSource(ctx, src)
+				if err != nil {
+					return err
+				}
+			}
+
+			changes := registry.Diff(snap.State, current)
+			for _, change := range changes {
+				monitor.PrintChange(ctx, change)
+			}
+			if len(changes) == 0 {
+				telemetry.Println(ctx, "✓ No differences")
+			} else {
+				telemetry.Printf(ctx, "%d change(s) since %s\n", len(changes), snap.Taken.Local().Format(time.RFC3339))
+			}
+			return nil
+		},
+	}
+	src.addFlags(cmd)
+	cmd.Flags().StringVar(&against, "against", "", "Compare with another snapshot file instead of a registry source")
+	cmd.MarkFlagsMutuallyExclusive("against", "from-reg")
+	cmd.MarkFlagsMutuallyExclusive("against", "hive")
+	return cmd
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/monitor"
+)
+
+// watchOptions are the flags of the watch command.
+type watchOptions struct {
+	dryRun            bool
+	snapshotPath      string
+	debounce          monitor.Debounce
+	reconcileInterval time.Duration
+	pollInterval      time.Duration
+	engine            engineOptions
+	ledger            ledgerOptions
+}
+
+// addFlags registers the watch flags on fs.
+func (o *watchOptions) addFlags(fs *pflag.FlagSet) {
+	o.debounce = monitor.DefaultDebounce
+	o.reconcileInterval = monitor.DefaultReconcileInterval
+	o.pollInterval = monitor.DefaultPollInterval
+
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Read-only mode: detect and log planned operations without making changes")
+	fs.StringVar(&o.snapshotPath, "snapshot", defaultSnapshotPath(),
+		"Snapshot file used to report changes made while the guard was not running")
+	fs.DurationVar(&o.debounce.Quiet, "debounce", o.debounce.Quiet,
+		"Quiet period: a burst of change notifications is processed once none arrived for this long")
+	fs.DurationVar(&o.debounce.MaxLatency, "debounce-max-latency", o.debounce.MaxLatency,
+		"Process a burst of change notifications at most this long after it started, even if it continues (0 = no bound)")
+	fs.DurationVar(&o.reconcileInterval, "reconcile-interval", o.reconcileInterval,
+		"Re-run the enforcement passes against a fresh capture this often while watching (0 = never)")
+	fs.DurationVar(&o.pollInterval, "poll-interval", o.pollInterval,
+		"Capture and diff state this often when change notifications fail")
+	o.engine.addFlags(fs)
+	o.ledger.addFlags(fs)
+}
+
+// run applies config.json to the flags not set on cmd, builds the engine
+// and runs the guard.
+func (o *watchOptions) run(cmd *cobra.Command) error {
+	// CLI flags override config file values.
+	if !cmd.Flags().Changed("dry-run") && fileCfg.DryRun {
+		o.dryRun = true
+	}
+	if !cmd.Flags().Changed("snapshot") && fileCfg.SnapshotPath != "" {
+		o.snapshotPath = fileCfg.SnapshotPath
+	}
+	for _, d := range []struct {
+		flag, name, value string
+		dst               *time.Duration
+	}{
+		{"debounce", "DebounceQuiet", fileCfg.DebounceQuiet, &o.debounce.Quiet},
+		{"debounce-max-latency", "DebounceMaxLatency", fileCfg.DebounceMaxLatency, &o.debounce.MaxLatency},
+		{"reconcile-interval", "ReconcileInterval", fileCfg.ReconcileInterval, &o.reconcileInterval},
+		{"poll-interval", "PollInterval", fileCfg.PollInterval, &o.pollInterval},
+	} {
+		if cmd.Flags().Changed(d.flag) || d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", d.name, err)
+		}
+		*d.dst = v
+	}
+	e, err := o.engine.newEngine(fileCfg, scanSource{})
+	if err != nil {
+		return err
+	}
+	e.Debounce = o.debounce
+	e.ReconcileInterval = o.reconcileInterval
+	e.PollInterval = o.pollInterval
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	return runWatch(cmd.Context(), e, o.dryRun, o.snapshotPath, o.ledger.resolve(fileCfg))
+}
+
+func newWatchCmd() *cobra.Command {
+	var opts watchOptions
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Enforce the policies once, then watch them and remediate every change until stopped",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(cmd)
+		},
+	}
+
+	opts.addFlags(cmd.Flags())
+	return cmd
+}
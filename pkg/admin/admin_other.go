@@ -0,0 +1,40 @@
+//go:build !windows
+
+package admin
+
+import (
+	"fmt"
+	"os"
+)
+
+// IsAdmin reports whether the current process runs as root, the equivalent
+// of Administrator privileges for system-wide policy locations.
+func IsAdmin() bool {
+	return os.Geteuid() == 0
+}
+
+// CanDeleteRegistryKey reports whether policies below keyPath can be
+// deleted. On this platform they live in root-owned managed policy files.
+func CanDeleteRegistryKey(keyPath string) bool {
+	return IsAdmin()
+}
+
+// CheckAdminAndElevate checks admin status and handles dry-run mode.
+// Elevation is not attempted on this platform.
+// Returns true if the process has write permissions, false otherwise
+func CheckAdminAndElevate(dryRun bool) bool {
+	if dryRun {
+		fmt.Println("🔍 DRY-RUN MODE: Running in read-only mode")
+		fmt.Println("   No changes will be made to the managed policy files")
+		fmt.Println("   All write/delete operations will be simulated")
+		return false
+	}
+
+	if !IsAdmin() {
+		fmt.Println("⚠️  WARNING: Not running as root")
+		fmt.Println("Please rerun with sudo to enable policy removal, or use --dry-run.")
+		return false
+	}
+	fmt.Println("✓ Running with root privileges")
+	return true
+}
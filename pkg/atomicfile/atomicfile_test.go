@@ -0,0 +1,73 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "ledger.json")
+
+	// The first write creates the missing directory; the second replaces
+	// the file and its mode.
+	for _, tt := range []struct {
+		data string
+		perm os.FileMode
+	}{
+		{"first", 0o600},
+		{"second", 0o644},
+	} {
+		if err := WriteFile(path, []byte(tt.data), tt.perm); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.data {
+			t.Errorf("contents = %q, want %q", got, tt.data)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != tt.perm {
+			t.Errorf("mode = %v, want %v", info.Mode().Perm(), tt.perm)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the file", len(entries))
+	}
+}
+
+// TestWriteFileFailure replaces a directory, which fails at the rename, and
+// checks that the temporary file is removed.
+func TestWriteFileFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "target")
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "keep"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("data"), 0o644); err == nil {
+		t.Fatal("replacing a directory succeeded")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "target" || !entries[0].IsDir() {
+		t.Errorf("directory holds %v after the failed write, want only the target", entries)
+	}
+}
@@ -0,0 +1,236 @@
+package browsers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+)
+
+// ============================================================================
+// BROWSER TABLE - Declarative descriptors for every supported browser
+// ============================================================================
+
+// Family groups browsers that share a policy schema.
+type Family string
+
+const (
+	Chromium Family = "chromium"
+	Gecko    Family = "gecko"
+)
+
+// Policy names, as they appear as key paths directly below a policy root.
+const (
+	ExtensionInstallForcelist = "ExtensionInstallForcelist"
+	ExtensionInstallBlocklist = "ExtensionInstallBlocklist"
+	ExtensionInstallAllowlist = "ExtensionInstallAllowlist"
+	ExtensionSettings         = "ExtensionSettings"
+	ThirdPartyExtensions      = `3rdparty\extensions`
+	ExtensionsInstall         = `Extensions\Install`
+	ExtensionsLocked          = `Extensions\Locked`
+)
+
+// Store update URLs used by forcelist entries that name no update URL.
+const (
+	ChromeWebStoreUpdateURL = "https://clients2.google.com/service/update2/crx"
+	EdgeAddonsUpdateURL     = "https://edge.microsoft.com/extensionwebstorebase/v1/crx"
+)
+
+// DefaultPolicies returns the policies every browser of family supports.
+func DefaultPolicies(family Family) []string {
+	switch family {
+	case Chromium:
+		return []string{ExtensionInstallForcelist, ExtensionInstallBlocklist, ExtensionInstallAllowlist, ExtensionSettings, ThirdPartyExtensions}
+	case Gecko:
+		return []string{ExtensionSettings, ExtensionsInstall, ExtensionsLocked}
+	}
+	return nil
+}
+
+// Descriptor describes where a browser reads its policies from.
+type Descriptor struct {
+	// ID is the stable lower-case name used as the metric "browser" label.
+	ID string `json:"ID"`
+	// DisplayName is used in log output.
+	DisplayName string `json:"DisplayName"`
+	Family      Family `json:"Family"`
+	// PolicyRoot is the policy key relative to HKLM\SOFTWARE\Policies.
+	PolicyRoot string `json:"PolicyRoot"`
+	// Policies lists the supported policy names; empty means the family default.
+	Policies []string `json:"Policies,omitempty"`
+	// UpdateURL is the store used for forcelist entries without an update
+	// URL; empty means the Chrome Web Store for the Chromium family.
+	UpdateURL string `json:"UpdateURL,omitempty"`
+	// ManagedPolicyPath is where the browser reads JSON policies on Linux:
+	// the managed policy directory of a Chromium browser or the
+	// policies.json file of a Gecko browser. Empty means none.
+	ManagedPolicyPath string `json:"ManagedPolicyPath,omitempty"`
+}
+
+// Supports reports whether d honours policy.
+func (d Descriptor) Supports(policy string) bool {
+	for _, p := range d.Policies {
+		if strings.EqualFold(p, policy) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyPath returns the key path of policy for d, relative to
+// HKLM\SOFTWARE\Policies.
+func (d Descriptor) PolicyPath(policy string) string {
+	return pathutils.BuildPath(d.PolicyRoot, policy)
+}
+
+// Builtin lists the browsers shipped with the guard. Firefox ESR reads the
+// same policy key as Firefox.
+var Builtin = []Descriptor{
+	{ID: "chrome", DisplayName: "Chrome", Family: Chromium, PolicyRoot: `Google\Chrome`,
+		ManagedPolicyPath: "/etc/opt/chrome/policies/managed"},
+	{ID: "chromium", DisplayName: "Chromium", Family: Chromium, PolicyRoot: `Chromium`,
+		ManagedPolicyPath: "/etc/chromium/policies/managed"},
+	{ID: "edge", DisplayName: "Edge", Family: Chromium, PolicyRoot: `Microsoft\Edge`, UpdateURL: EdgeAddonsUpdateURL,
+		ManagedPolicyPath: "/etc/opt/edge/policies/managed"},
+	{ID: "brave", DisplayName: "Brave", Family: Chromium, PolicyRoot: `BraveSoftware\Brave`},
+	{ID: "vivaldi", DisplayName: "Vivaldi", Family: Chromium, PolicyRoot: `Vivaldi`},
+	{ID: "opera", DisplayName: "Opera", Family: Chromium, PolicyRoot: `Opera Software\Opera`},
+	{ID: "yandex", DisplayName: "Yandex", Family: Chromium, PolicyRoot: `YandexBrowser`},
+	{ID: "firefox", DisplayName: "Firefox", Family: Gecko, PolicyRoot: `Mozilla\Firefox`,
+		ManagedPolicyPath: "/usr/lib/firefox/distribution/policies.json"},
+}
+
+// Table is a set of browser descriptors looked up by ID or policy path.
+type Table struct {
+	mu          sync.RWMutex
+	descriptors []Descriptor
+}
+
+// NewTable returns a table holding descriptors. It panics on invalid entries
+// and is meant for static tables such as Builtin.
+func NewTable(descriptors ...Descriptor) *Table {
+	t := &Table{}
+	for _, d := range descriptors {
+		if err := t.Register(d); err != nil {
+			panic(err)
+		}
+	}
+	return t
+}
+
+// Register validates d and adds it to the table. Missing display names,
+// policy lists and update URLs are filled in from the ID and family.
+func (t *Table) Register(d Descriptor) error {
+	d.ID = strings.ToLower(strings.TrimSpace(d.ID))
+	d.PolicyRoot = strings.Trim(d.PolicyRoot, `\`)
+	d.Family = Family(strings.ToLower(string(d.Family)))
+
+	if d.ID == "" {
+		return fmt.Errorf("browser descriptor: missing ID")
+	}
+	if d.PolicyRoot == "" {
+		return fmt.Errorf("browser %q: missing PolicyRoot", d.ID)
+	}
+	if d.Family != Chromium && d.Family != Gecko {
+		return fmt.Errorf("browser %q: unknown family %q (want %q or %q)", d.ID, d.Family, Chromium, Gecko)
+	}
+	if d.DisplayName == "" {
+		d.DisplayName = d.ID
+	}
+	if len(d.Policies) == 0 {
+		d.Policies = DefaultPolicies(d.Family)
+	}
+	if d.UpdateURL == "" && d.Family == Chromium {
+		d.UpdateURL = ChromeWebStoreUpdateURL
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, existing := range t.descriptors {
+		if existing.ID == d.ID {
+			return fmt.Errorf("browser %q: already registered", d.ID)
+		}
+		if strings.EqualFold(existing.PolicyRoot, d.PolicyRoot) {
+			return fmt.Errorf("browser %q: policy root %s already used by %q", d.ID, d.PolicyRoot, existing.ID)
+		}
+	}
+	t.descriptors = append(t.descriptors, d)
+	// Longest roots first so nested roots win in ForPath.
+	sort.SliceStable(t.descriptors, func(i, j int) bool {
+		return len(t.descriptors[i].PolicyRoot) > len(t.descriptors[j].PolicyRoot)
+	})
+	return nil
+}
+
+// All returns every descriptor, ordered by ID.
+func (t *Table) All() []Descriptor {
+	t.mu.RLock()
+	all := append([]Descriptor(nil), t.descriptors...)
+	t.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// Get returns the descriptor with the given ID.
+func (t *Table) Get(id string) (Descriptor, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, d := range t.descriptors {
+		if strings.EqualFold(d.ID, id) {
+			return d, true
+		}
+	}
+	return Descriptor{}, false
+}
+
+// ForPath returns the browser whose policy root contains path (relative to
+// HKLM\SOFTWARE\Policies) and the remainder of path below that root.
+func (t *Table) ForPath(path string) (d Descriptor, rest string, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, d := range t.descriptors {
+		if rest, ok := pathutils.TrimPathPrefix(path, d.PolicyRoot); ok {
+			return d, rest, true
+		}
+	}
+	return Descriptor{}, "", false
+}
+
+// PolicyForPath returns the browser and the supported policy that path lies
+// in, e.g. Chrome and ExtensionInstallForcelist for
+// "Google\Chrome\ExtensionInstallForcelist\1". rest is the part of path below
+// the policy key.
+func (t *Table) PolicyForPath(path string) (d Descriptor, policy, rest string, ok bool) {
+	d, below, ok := t.ForPath(path)
+	if !ok {
+		return Descriptor{}, "", "", false
+	}
+	for _, p := range d.Policies {
+		if rest, ok := pathutils.TrimPathPrefix(below, p); ok {
+			return d, p, rest, true
+		}
+	}
+	return Descriptor{}, "", "", false
+}
+
+// defaultTable holds the built-in browsers plus any registered from config.
+var defaultTable = NewTable(Builtin...)
+
+// Register adds a custom browser (e.g. a Chromium fork) to the default table.
+func Register(d Descriptor) error { return defaultTable.Register(d) }
+
+// All returns every browser in the default table.
+func All() []Descriptor { return defaultTable.All() }
+
+// Get looks up a browser in the default table by ID.
+func Get(id string) (Descriptor, bool) { return defaultTable.Get(id) }
+
+// ForPath looks up the browser owning path in the default table.
+func ForPath(path string) (Descriptor, string, bool) { return defaultTable.ForPath(path) }
+
+// PolicyForPath looks up the browser and policy of path in the default table.
+func PolicyForPath(path string) (Descriptor, string, string, bool) {
+	return defaultTable.PolicyForPath(path)
+}
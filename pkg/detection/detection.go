@@ -2,11 +2,11 @@
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
-	"syscall"
-
-	"golang.org/x/sys/windows"
+	"unicode/utf16"
 
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
 	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
 )
 
@@ -39,54 +39,237 @@ func ExtractExtensionIDFromValue(value string) string {
 	return strings.TrimSpace(value)
 }
 
-// FormatRegValue formats a registry value based on its type
-func FormatRegValue(valueType uint32, data []byte) string {
+// ForcelistEntry is a parsed ExtensionInstallForcelist value.
+type ForcelistEntry struct {
+	ID        string
+	UpdateURL string // as written after the ';', empty if the value names none
+	Scheme    string // lower-case, e.g. "https" or "file"
+	Host      string // lower-case, without port
+	Path      string // URL path, e.g. "/service/update2/crx"
+}
+
+// ParseForcelistEntry splits a forcelist value into the extension ID and the
+// update URL, and breaks the URL down into scheme, host and path. Local paths
+// ("C:\...") and UNC paths ("\\server\share") are reported with the "file"
+// scheme; UNC paths use the server as host.
+func ParseForcelistEntry(value string) ForcelistEntry {
+	entry := ForcelistEntry{ID: ExtractExtensionIDFromValue(value)}
+	if idx := strings.Index(value, ";"); idx >= 0 {
+		entry.UpdateURL = strings.TrimSpace(value[idx+1:])
+	}
+	entry.Scheme, entry.Host, entry.Path = parseUpdateURL(entry.UpdateURL)
+	return entry
+}
+
+// parseUpdateURL returns the scheme, host and path of an update URL.
+func parseUpdateURL(rawURL string) (scheme, host, path string) {
+	switch {
+	case rawURL == "":
+		return "", "", ""
+	case strings.HasPrefix(rawURL, `\\`):
+		server, rest, _ := strings.Cut(strings.TrimPrefix(rawURL, `\\`), `\`)
+		return "file", strings.ToLower(server), `\` + rest
+	case len(rawURL) >= 2 && rawURL[1] == ':' && isASCIILetter(rawURL[0]):
+		return "file", "", rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", ""
+	}
+	return strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Path
+}
+
+func isASCIILetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// Registry value types (winnt.h REG_*). They are defined here instead of being
+// taken from golang.org/x/sys/windows so that detection builds on every OS.
+const (
+	RegNone           uint32 = 0
+	RegSZ             uint32 = 1
+	RegExpandSZ       uint32 = 2
+	RegBinary         uint32 = 3
+	RegDword          uint32 = 4
+	RegDwordBigEndian uint32 = 5
+	RegLink           uint32 = 6
+	RegMultiSZ        uint32 = 7
+	RegQword          uint32 = 11
+)
+
+// DecodeUTF16String decodes little-endian UTF-16 registry data up to the first
+// NUL terminator.
+func DecodeUTF16String(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	u16 := make([]uint16, len(data)/2)
+	for i := 0; i < len(u16); i++ {
+		u16[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+	}
+	for i, c := range u16 {
+		if c == 0 {
+			u16 = u16[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(u16))
+}
+
+// EncodeUTF16String encodes s as NUL-terminated little-endian UTF-16, the
+// on-disk representation of REG_SZ and REG_EXPAND_SZ data.
+func EncodeUTF16String(s string) []byte {
+	u16 := utf16.Encode([]rune(s))
+	data := make([]byte, (len(u16)+1)*2)
+	for i, c := range u16 {
+		data[i*2] = byte(c)
+		data[i*2+1] = byte(c >> 8)
+	}
+	return data
+}
+
+// DecodeUTF16MultiString decodes REG_MULTI_SZ data: NUL-separated UTF-16LE
+// strings terminated by an empty string. Data that is missing the final
+// terminator, as some policy tools write it, is accepted.
+func DecodeUTF16MultiString(data []byte) []string {
+	u16 := make([]uint16, len(data)/2)
+	for i := 0; i < len(u16); i++ {
+		u16[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+	}
+
+	strs := []string{}
+	start := 0
+	for i := 0; i <= len(u16); i++ {
+		if i < len(u16) && u16[i] != 0 {
+			continue
+		}
+		if i == start {
+			break
+		}
+		strs = append(strs, string(utf16.Decode(u16[start:i])))
+		start = i + 1
+	}
+	return strs
+}
+
+// EncodeUTF16MultiString encodes strs as REG_MULTI_SZ data.
+func EncodeUTF16MultiString(strs []string) []byte {
+	var data []byte
+	for _, s := range strs {
+		data = append(data, EncodeUTF16String(s)...)
+	}
+	return append(data, 0, 0)
+}
+
+// DecodeUint32 decodes REG_DWORD (little-endian) or REG_DWORD_BIG_ENDIAN data.
+func DecodeUint32(valueType uint32, data []byte) (uint32, bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
 	switch valueType {
-	case windows.REG_SZ, windows.REG_EXPAND_SZ:
-		if len(data) < 2 {
-			return ""
+	case RegDword:
+		return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24, true
+	case RegDwordBigEndian:
+		return uint32(data[3]) | uint32(data[2])<<8 | uint32(data[1])<<16 | uint32(data[0])<<24, true
+	}
+	return 0, false
+}
+
+// DecodeUint64 decodes REG_QWORD data.
+func DecodeUint64(data []byte) (uint64, bool) {
+	if len(data) < 8 {
+		return 0, false
+	}
+	return uint64(data[0]) | uint64(data[1])<<8 | uint64(data[2])<<16 | uint64(data[3])<<24 |
+		uint64(data[4])<<32 | uint64(data[5])<<40 | uint64(data[6])<<48 | uint64(data[7])<<56, true
+}
+
+// ExpandEnvironmentStrings replaces %NAME% references in REG_EXPAND_SZ data
+// the way ExpandEnvironmentStringsW does: names are resolved through lookup
+// and references to undefined variables are left untouched.
+func ExpandEnvironmentStrings(s string, lookup func(name string) (string, bool)) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(s, '%')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start+1:], '%')
+		if end < 0 {
+			break
 		}
-		u16 := make([]uint16, len(data)/2)
-		for i := 0; i < len(u16); i++ {
-			u16[i] = uint16(data[i*2]) | uint16(data[i*2+1])<<8
+		end += start + 1
+
+		sb.WriteString(s[:start])
+		if value, ok := lookup(s[start+1 : end]); ok && end > start+1 {
+			sb.WriteString(value)
+			s = s[end+1:]
+			continue
 		}
-		return syscall.UTF16ToString(u16)
-	case windows.REG_DWORD:
-		if len(data) >= 4 {
-			return fmt.Sprintf("0x%08x", uint32(data[0])|uint32(data[1])<<8|uint32(data[2])<<16|uint32(data[3])<<24)
+		// Keep the unresolved reference; its closing '%' may open the next one.
+		sb.WriteString(s[start:end])
+		s = s[end:]
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+// FormatRegValue formats a registry value based on its type
+func FormatRegValue(valueType uint32, data []byte) string {
+	switch valueType {
+	case RegSZ, RegExpandSZ:
+		return DecodeUTF16String(data)
+	case RegDword, RegDwordBigEndian:
+		if n, ok := DecodeUint32(valueType, data); ok {
+			return fmt.Sprintf("0x%08x", n)
 		}
-	case windows.REG_QWORD:
-		if len(data) >= 8 {
-			return fmt.Sprintf("0x%016x", uint64(data[0])|uint64(data[1])<<8|uint64(data[2])<<16|uint64(data[3])<<24|
-				uint64(data[4])<<32|uint64(data[5])<<40|uint64(data[6])<<48|uint64(data[7])<<56)
+	case RegQword:
+		if n, ok := DecodeUint64(data); ok {
+			return fmt.Sprintf("0x%016x", n)
 		}
-	case windows.REG_BINARY, windows.REG_MULTI_SZ:
+	case RegMultiSZ:
+		return fmt.Sprintf("%q", DecodeUTF16MultiString(data))
+	case RegBinary, RegNone:
 		return fmt.Sprintf("%d bytes", len(data))
 	}
 	return fmt.Sprintf("Unknown type %d", valueType)
 }
 
-// IsChromeExtensionForcelist checks if a path is a Chrome forcelist path
+// isPolicyPath reports whether path lies inside policy of a browser of the
+// given family, according to the browser table.
+func isPolicyPath(path string, family browsers.Family, policy string) bool {
+	d, p, _, ok := browsers.PolicyForPath(path)
+	return ok && d.Family == family && p == policy
+}
+
+// IsChromiumPolicyKey reports whether path is exactly the key of policy for a
+// Chromium-family browser, e.g. "Microsoft\Edge\ExtensionInstallAllowlist".
+func IsChromiumPolicyKey(path, policy string) bool {
+	d, p, rest, ok := browsers.PolicyForPath(path)
+	return ok && d.Family == browsers.Chromium && p == policy && rest == ""
+}
+
+// IsChromeExtensionForcelist checks if a path is inside the forcelist of any
+// Chromium-family browser (Chrome, Edge, Brave, ...)
 func IsChromeExtensionForcelist(path string) bool {
-	return pathutils.Contains(path, "ExtensionInstallForcelist")
+	return isPolicyPath(path, browsers.Chromium, browsers.ExtensionInstallForcelist)
 }
 
-// IsFirefoxExtensionSettings checks if a path is a Firefox extension settings path
+// IsFirefoxExtensionSettings checks if a path is a Gecko-family extension settings path
 func IsFirefoxExtensionSettings(path string) bool {
-	return pathutils.Contains(path, "Mozilla\\Firefox\\ExtensionSettings") ||
-		pathutils.Contains(path, "Firefox\\ExtensionSettings")
+	return isPolicyPath(path, browsers.Gecko, browsers.ExtensionSettings)
 }
 
-// IsFirefoxExtensionsInstall checks if a path contains Firefox\Extensions\Install
-// as consecutive exact path components (case-insensitive).
+// IsFirefoxExtensionsInstall checks if a path is inside a Gecko-family
+// browser's Extensions\Install policy.
 func IsFirefoxExtensionsInstall(path string) bool {
-	return pathutils.HasPathComponentSequence(path, "Firefox", "Extensions", "Install")
+	return isPolicyPath(path, browsers.Gecko, browsers.ExtensionsInstall)
 }
 
-// IsFirefoxExtensionsLocked checks if a path contains Firefox\Extensions\Locked
-// as consecutive exact path components (case-insensitive).
+// IsFirefoxExtensionsLocked checks if a path is inside a Gecko-family
+// browser's Extensions\Locked policy.
 func IsFirefoxExtensionsLocked(path string) bool {
-	return pathutils.HasPathComponentSequence(path, "Firefox", "Extensions", "Locked")
+	return isPolicyPath(path, browsers.Gecko, browsers.ExtensionsLocked)
 }
 
 // IsFirefoxForcedExtension returns true for any Firefox forced-install policy path
@@ -98,35 +281,54 @@ func IsFirefoxForcedExtension(path string) bool {
 
 // IsEdgeExtensionForcelist checks if a path is an Edge forcelist path
 func IsEdgeExtensionForcelist(path string) bool {
-	return pathutils.Contains(path, "Microsoft\\Edge\\ExtensionInstallForcelist") ||
-		pathutils.Contains(path, "Edge\\ExtensionInstallForcelist")
+	d, p, _, ok := browsers.PolicyForPath(path)
+	return ok && d.ID == "edge" && p == browsers.ExtensionInstallForcelist
 }
 
-// IsChromeExtensionBlocklist checks if a path is a Chrome blocklist path
+// IsChromeExtensionBlocklist checks if a path is inside a Chromium-family blocklist
 func IsChromeExtensionBlocklist(path string) bool {
-	return pathutils.Contains(path, "ExtensionInstallBlocklist")
+	return isPolicyPath(path, browsers.Chromium, browsers.ExtensionInstallBlocklist)
 }
 
-// IsExtensionSettingsPath checks if a path is an ExtensionSettings path
+// IsExtensionSettingsPath checks if a path is inside any browser's ExtensionSettings
 func IsExtensionSettingsPath(path string) bool {
-	return pathutils.Contains(path, "ExtensionSettings")
+	_, p, _, ok := browsers.PolicyForPath(path)
+	return ok && p == browsers.ExtensionSettings
+}
+
+// IsExtensionSettingsValue checks if path is where the JSON form of
+// ExtensionSettings lives: a value stored directly on a browser's policy root
+// (e.g. "Google\Chrome\ExtensionSettings"). The default value of an
+// ExtensionSettings subkey is captured under the same path, so callers should
+// read the named value from the policy root to tell the two apart.
+func IsExtensionSettingsValue(path string) bool {
+	_, p, rest, ok := browsers.PolicyForPath(path)
+	return ok && p == browsers.ExtensionSettings && rest == ""
 }
 
 // Is3rdPartyExtensionsPath checks if a path is a 3rdparty extensions path
 func Is3rdPartyExtensionsPath(path string) bool {
-	return pathutils.Contains(path, "3rdparty\\extensions")
+	return isPolicyPath(path, browsers.Chromium, browsers.ThirdPartyExtensions)
+}
+
+// GetPolicyKeyPath returns the key of policy for the browser owning path,
+// e.g. the blocklist key next to a forcelist. Paths outside the browser
+// table fall back to swapping the policy component in place.
+func GetPolicyKeyPath(path, fromPolicy, toPolicy string) string {
+	if d, _, ok := browsers.ForPath(path); ok {
+		return d.PolicyPath(toPolicy)
+	}
+	return pathutils.ReplacePathComponent(path, fromPolicy, toPolicy)
 }
 
 // GetBlocklistKeyPath converts a forcelist path to a blocklist path
 func GetBlocklistKeyPath(forcelistPath string) string {
-	// Replace "ExtensionInstallForcelist" with "ExtensionInstallBlocklist"
-	return pathutils.ReplacePathComponent(forcelistPath, "ExtensionInstallForcelist", "ExtensionInstallBlocklist")
+	return GetPolicyKeyPath(forcelistPath, browsers.ExtensionInstallForcelist, browsers.ExtensionInstallBlocklist)
 }
 
 // GetAllowlistKeyPath converts a forcelist path to an allowlist path
 func GetAllowlistKeyPath(forcelistPath string) string {
-	// Replace "ExtensionInstallForcelist" with "ExtensionInstallAllowlist"
-	return pathutils.ReplacePathComponent(forcelistPath, "ExtensionInstallForcelist", "ExtensionInstallAllowlist")
+	return GetPolicyKeyPath(forcelistPath, browsers.ExtensionInstallForcelist, browsers.ExtensionInstallAllowlist)
 }
 
 // ExtractFirefoxExtensionID extracts the extension ID from a Firefox extension path
@@ -165,7 +367,14 @@ func GetFirefoxExtensionsKeyPath(valuePath string) string {
 // GetFirefoxBlocklistPath returns the Firefox blocklist path for an extension ID
 func GetFirefoxBlocklistPath(extensionID string) string {
 	// Firefox blocklist path: Mozilla\Firefox\ExtensionSettings\{extension-id}\installation_mode
-	return "Mozilla\\Firefox\\ExtensionSettings\\" + extensionID
+	firefox, _ := browsers.Get("firefox")
+	return GetGeckoBlocklistPath(firefox.PolicyRoot, extensionID)
+}
+
+// GetGeckoBlocklistPath returns the ExtensionSettings key for an extension ID
+// below a Gecko-family policy root.
+func GetGeckoBlocklistPath(policyRoot, extensionID string) string {
+	return pathutils.BuildPath(policyRoot, browsers.ExtensionSettings, extensionID)
 }
 
 // ExtractExtensionIDFromPath extracts extension ID from various path formats
@@ -215,8 +424,8 @@ func IsExtensionPolicy(path string) bool {
 
 // ShouldBlockPath determines if a registry path should be blocked
 func ShouldBlockPath(path string) bool {
-	// Block ExtensionInstallForcelist paths for Chrome and Edge
-	if pathutils.Contains(path, "ExtensionInstallForcelist") {
+	// Block ExtensionInstallForcelist paths for every Chromium-family browser
+	if IsChromeExtensionForcelist(path) {
 		return true
 	}
 
@@ -247,21 +456,42 @@ func ParseForcelistValues(values map[string]string) []string {
 	return extensionIDs
 }
 
-// GetBrowserFromPath determines which browser a path belongs to
+// GetBrowserFromPath returns the display name of the browser a path belongs to
 func GetBrowserFromPath(path string) string {
-	pathLower := strings.ToLower(path)
-
-	if strings.Contains(pathLower, "google\\chrome") || strings.Contains(pathLower, "chrome\\") {
-		return "Chrome"
+	if d, _, ok := browsers.ForPath(path); ok {
+		return d.DisplayName
 	}
-	if strings.Contains(pathLower, "microsoft\\edge") || strings.Contains(pathLower, "edge\\") {
-		return "Edge"
+	return "Unknown"
+}
+
+// GetBrowserIDFromPath returns the browser ID used as the metric label for a path
+func GetBrowserIDFromPath(path string) string {
+	if d, _, ok := browsers.ForPath(path); ok {
+		return d.ID
 	}
-	if strings.Contains(pathLower, "mozilla\\firefox") || strings.Contains(pathLower, "firefox\\") {
-		return "Firefox"
+	return "unknown"
+}
+
+// GetPolicyRootFromPath returns the policy root of the browser a path belongs to
+func GetPolicyRootFromPath(path string) string {
+	if d, _, ok := browsers.ForPath(path); ok {
+		return d.PolicyRoot
 	}
+	return ""
+}
 
-	return "Unknown"
+// IsChromiumExtensionID reports whether extID has the Chromium format: 32
+// letters from a to p.
+func IsChromiumExtensionID(extID string) bool {
+	if len(extID) != 32 {
+		return false
+	}
+	for _, c := range extID {
+		if c < 'a' || c > 'p' {
+			return false
+		}
+	}
+	return true
 }
 
 // ValidateExtensionID checks if an extension ID has a valid format
@@ -273,12 +503,7 @@ func ValidateExtensionID(extID string) bool {
 	// Chrome/Edge extension IDs are typically 32 lowercase letters (a-p)
 	// Example: afdpoidmelmfapkoikmenejmcdpgecfe
 	if len(extID) == 32 {
-		for _, c := range extID {
-			if c < 'a' || c > 'p' {
-				return false
-			}
-		}
-		return true
+		return IsChromiumExtensionID(extID)
 	}
 
 	// Firefox extension IDs can be:
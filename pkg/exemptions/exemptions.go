@@ -0,0 +1,135 @@
+package exemptions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ============================================================================
+// EXEMPTIONS - Organization-approved extensions the guard leaves in place
+// ============================================================================
+
+// SourcePolicyFile is the Source of entries found in a Firefox
+// policies.json file. Registry entries use their policy name as Source,
+// e.g. "ExtensionInstallForcelist" or "ExtensionSettings".
+const SourcePolicyFile = "policies.json"
+
+// Rule exempts matching extension install entries from remediation. ID is
+// required; the other fields are optional and narrow the match. Every field
+// is matched case-insensitively and may use the glob wildcards * and ?.
+type Rule struct {
+	ID        string `json:"ID"`
+	Browser   string `json:"Browser,omitempty"`   // browser ID, e.g. "chrome"
+	UpdateURL string `json:"UpdateURL,omitempty"` // forcelist update URL or install_url
+	Source    string `json:"Source,omitempty"`    // policy name or SourcePolicyFile
+	Comment   string `json:"Comment,omitempty"`
+}
+
+// String describes r for log output.
+func (r Rule) String() string {
+	parts := []string{"id=" + r.ID}
+	if r.Browser != "" {
+		parts = append(parts, "browser="+r.Browser)
+	}
+	if r.UpdateURL != "" {
+		parts = append(parts, "update-url="+r.UpdateURL)
+	}
+	if r.Source != "" {
+		parts = append(parts, "source="+r.Source)
+	}
+	s := strings.Join(parts, " ")
+	if r.Comment != "" {
+		s += " (" + r.Comment + ")"
+	}
+	return s
+}
+
+// Candidate is an extension install entry checked against the rules.
+type Candidate struct {
+	ID        string
+	Browser   string
+	UpdateURL string
+	Source    string
+}
+
+// compiledRule is a Rule with its globs compiled. A nil pattern matches
+// anything.
+type compiledRule struct {
+	rule                           Rule
+	id, browser, updateURL, source *regexp.Regexp
+}
+
+// Set is an ordered set of exemption rules. The zero value and nil exempt
+// nothing.
+type Set struct {
+	rules []compiledRule
+}
+
+// New compiles rules into a Set.
+func New(rules []Rule) (*Set, error) {
+	s := &Set{}
+	for i, r := range rules {
+		if strings.TrimSpace(r.ID) == "" {
+			return nil, fmt.Errorf("exemption %d: missing ID", i+1)
+		}
+		s.rules = append(s.rules, compiledRule{
+			rule:      r,
+			id:        compileGlob(r.ID),
+			browser:   compileGlob(r.Browser),
+			updateURL: compileGlob(r.UpdateURL),
+			source:    compileGlob(r.Source),
+		})
+	}
+	return s, nil
+}
+
+// Len returns the number of rules in s.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Match returns the first rule exempting c.
+func (s *Set) Match(c Candidate) (Rule, bool) {
+	if s == nil {
+		return Rule{}, false
+	}
+	for _, r := range s.rules {
+		if matches(r.id, c.ID) && matches(r.browser, c.Browser) &&
+			matches(r.updateURL, c.UpdateURL) && matches(r.source, c.Source) {
+			return r.rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// compileGlob turns a glob into an anchored, case-insensitive regexp.
+// An empty glob compiles to nil.
+func compileGlob(glob string) *regexp.Regexp {
+	glob = strings.TrimSpace(glob)
+	if glob == "" {
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString("(?is)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// matches reports whether s matches pattern; a nil pattern matches anything.
+func matches(pattern *regexp.Regexp, s string) bool {
+	return pattern == nil || pattern.MatchString(s)
+}
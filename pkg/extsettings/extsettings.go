@@ -0,0 +1,214 @@
+package extsettings
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// EXTENSION SETTINGS - Parsing and rewriting the JSON ExtensionSettings policy
+// ============================================================================
+
+// Installation modes used in ExtensionSettings entries.
+const (
+	ModeAllowed         = "allowed"
+	ModeBlocked         = "blocked"
+	ModeForceInstalled  = "force_installed"
+	ModeNormalInstalled = "normal_installed"
+	ModeRemoved         = "removed"
+)
+
+// DefaultKey is the entry holding the defaults for every extension not
+// listed explicitly.
+const DefaultKey = "*"
+
+// updateURLKeyPrefix marks Chromium entries that apply to every extension
+// served from an update URL rather than to an extension ID.
+const updateURLKeyPrefix = "update_url:"
+
+// IsInstallMode reports whether mode makes the browser install the extension.
+func IsInstallMode(mode string) bool {
+	return mode == ModeForceInstalled || mode == ModeNormalInstalled
+}
+
+// Action is what is done to an entry that installs an extension.
+type Action string
+
+const (
+	// ActionBlock flips the entry to installation_mode "blocked".
+	ActionBlock Action = "block"
+	// ActionRemove deletes the entry.
+	ActionRemove Action = "remove"
+)
+
+// ParseAction parses an Action name; "" selects ActionBlock.
+func ParseAction(s string) (Action, error) {
+	switch Action(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ActionBlock:
+		return ActionBlock, nil
+	case ActionRemove:
+		return ActionRemove, nil
+	}
+	return "", fmt.Errorf("unknown extension settings action %q (want %q or %q)", s, ActionBlock, ActionRemove)
+}
+
+// Entry is the part of an ExtensionSettings entry the guard acts on.
+type Entry struct {
+	// Key is the entry name: an extension ID, a comma-separated list of
+	// Chromium IDs, DefaultKey or "update_url:<url>".
+	Key        string
+	Mode       string
+	UpdateURL  string
+	InstallURL string
+}
+
+// IDs returns the extension IDs the entry applies to. The default entry and
+// update_url: entries have none.
+func (e Entry) IDs() []string {
+	if e.Key == DefaultKey || strings.HasPrefix(e.Key, updateURLKeyPrefix) {
+		return nil
+	}
+	var ids []string
+	for _, id := range strings.Split(e.Key, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Installs reports whether the entry force- or normal-installs extensions.
+func (e Entry) Installs() bool {
+	return IsInstallMode(e.Mode) && len(e.IDs()) > 0
+}
+
+// Settings is a parsed ExtensionSettings policy. Each entry keeps its raw
+// fields so that rewriting the policy preserves settings the guard does not
+// understand (runtime_blocked_hosts, toolbar_pin, ...).
+type Settings map[string]map[string]json.RawMessage
+
+// Parse parses the JSON form of the ExtensionSettings policy: an object
+// mapping entry keys to objects of settings.
+func Parse(data string) (Settings, error) {
+	var s Settings
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("parsing ExtensionSettings JSON: %w", err)
+	}
+	if s == nil {
+		s = make(Settings)
+	}
+	return s, nil
+}
+
+// Entries returns every entry of s, sorted by key.
+func (s Settings) Entries() []Entry {
+	entries := make([]Entry, 0, len(s))
+	for key, fields := range s {
+		entries = append(entries, Entry{
+			Key:        key,
+			Mode:       stringField(fields, "installation_mode"),
+			UpdateURL:  stringField(fields, "update_url"),
+			InstallURL: stringField(fields, "install_url"),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Installed returns the entries that force- or normal-install extensions.
+func (s Settings) Installed() []Entry {
+	var installed []Entry
+	for _, e := range s.Entries() {
+		if e.Installs() {
+			installed = append(installed, e)
+		}
+	}
+	return installed
+}
+
+// Apply applies action to the entry key and reports whether s changed.
+// Blocking also drops the entry's update_url and install_url, which only
+// serve to fetch the extension.
+func (s Settings) Apply(key string, action Action) bool {
+	fields, ok := s[key]
+	if !ok {
+		return false
+	}
+	if action == ActionRemove {
+		delete(s, key)
+		return true
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+		s[key] = fields
+	}
+	blocked, _ := json.Marshal(ModeBlocked)
+	fields["installation_mode"] = blocked
+	delete(fields, "update_url")
+	delete(fields, "install_url")
+	return true
+}
+
+// ApplyIDs applies action to ids, some or all of the extension IDs of the
+// entry key, and reports whether s changed. The other IDs of a
+// comma-separated key keep the entry's settings under a key listing only
+// them, and each blocked ID gets an entry of its own.
+func (s Settings) ApplyIDs(key string, ids []string, action Action) bool {
+	fields, ok := s[key]
+	if !ok {
+		return false
+	}
+	all := Entry{Key: key}.IDs()
+	var rest, matched []string
+	for _, id := range all {
+		if slices.Contains(ids, id) {
+			matched = append(matched, id)
+		} else {
+			rest = append(rest, id)
+		}
+	}
+	switch {
+	case len(matched) == 0:
+		return false
+	case len(rest) == 0:
+		return s.Apply(key, action)
+	}
+	delete(s, key)
+	s[strings.Join(rest, ",")] = fields
+	if action == ActionBlock {
+		for _, id := range matched {
+			if _, ok := s[id]; !ok {
+				s[id] = maps.Clone(fields)
+			}
+			s.Apply(id, ActionBlock)
+		}
+	}
+	return true
+}
+
+// Marshal returns the compact JSON form of s with keys in sorted order.
+func (s Settings) Marshal() (string, error) {
+	data, err := json.Marshal(map[string]map[string]json.RawMessage(s))
+	if err != nil {
+		return "", fmt.Errorf("encoding ExtensionSettings JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// stringField returns the string field name of an entry, or "" if it is
+// missing or not a string.
+func stringField(fields map[string]json.RawMessage, name string) string {
+	raw, ok := fields[name]
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return ""
+	}
+	return s
+}
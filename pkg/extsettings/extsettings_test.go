@@ -0,0 +1,176 @@
+package extsettings
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Extension IDs used by the fixtures.
+const (
+	idA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	idB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	idC = "cccccccccccccccccccccccccccccccc"
+)
+
+// policy is an ExtensionSettings policy mixing install entries with
+// settings the guard does not understand.
+const policy = `{
+  "*": {"installation_mode": "allowed", "blocked_permissions": ["downloads"]},
+  "update_url:https://example.com/update.xml": {"installation_mode": "blocked"},
+  "` + idA + `": {
+    "installation_mode": "force_installed",
+    "update_url": "https://example.com/update.xml",
+    "toolbar_pin": "force_pinned",
+    "runtime_blocked_hosts": ["*://*.example.com"]
+  },
+  "` + idB + `,` + idC + `": {
+    "installation_mode": "normal_installed",
+    "update_url": "https://example.com/update.xml",
+    "minimum_version_required": "1.2"
+  }
+}`
+
+// decode parses the JSON data into generic values, so that two encodings of
+// the same policy compare equal.
+func decode(t *testing.T, data string) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return v
+}
+
+// apply parses policy, runs edit on it and returns the decoded result.
+func apply(t *testing.T, edit func(Settings) bool, wantChanged bool) map[string]any {
+	t.Helper()
+	s, err := Parse(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := edit(s); changed != wantChanged {
+		t.Errorf("changed = %v, want %v", changed, wantChanged)
+	}
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decode(t, data)
+}
+
+func TestRoundTrip(t *testing.T) {
+	got := apply(t, func(Settings) bool { return false }, false)
+	if want := decode(t, policy); !reflect.DeepEqual(got, want) {
+		t.Errorf("Marshal(Parse()) = %v\nwant %v", got, want)
+	}
+}
+
+func TestInstalled(t *testing.T) {
+	s, err := Parse(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Key: idA, Mode: ModeForceInstalled, UpdateURL: "https://example.com/update.xml"},
+		{Key: idB + "," + idC, Mode: ModeNormalInstalled, UpdateURL: "https://example.com/update.xml"},
+	}
+	if got := s.Installed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Installed() = %+v\nwant %+v", got, want)
+	}
+	if got := want[1].IDs(); !reflect.DeepEqual(got, []string{idB, idC}) {
+		t.Errorf("IDs() = %q, want %q", got, []string{idB, idC})
+	}
+}
+
+func TestApply(t *testing.T) {
+	want := decode(t, policy)
+	entry := want[idA].(map[string]any)
+	entry["installation_mode"] = ModeBlocked
+	delete(entry, "update_url")
+	// Settings the guard does not understand stay with the blocked entry.
+	got := apply(t, func(s Settings) bool { return s.Apply(idA, ActionBlock) }, true)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply(block) = %v\nwant %v", got, want)
+	}
+
+	want = decode(t, policy)
+	delete(want, idA)
+	got = apply(t, func(s Settings) bool { return s.Apply(idA, ActionRemove) }, true)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply(remove) = %v\nwant %v", got, want)
+	}
+
+	apply(t, func(s Settings) bool { return s.Apply(idB, ActionRemove) }, false)
+}
+
+func TestApplyIDs(t *testing.T) {
+	const shared = idB + "," + idC
+
+	t.Run("remove one ID", func(t *testing.T) {
+		want := decode(t, policy)
+		want[idC] = want[shared]
+		delete(want, shared)
+		got := apply(t, func(s Settings) bool { return s.ApplyIDs(shared, []string{idB}, ActionRemove) }, true)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ApplyIDs() = %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("block one ID", func(t *testing.T) {
+		want := decode(t, policy)
+		want[idC] = want[shared]
+		delete(want, shared)
+		want[idB] = map[string]any{"installation_mode": ModeBlocked, "minimum_version_required": "1.2"}
+		got := apply(t, func(s Settings) bool { return s.ApplyIDs(shared, []string{idB}, ActionBlock) }, true)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ApplyIDs() = %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("every ID", func(t *testing.T) {
+		want := decode(t, policy)
+		delete(want, shared)
+		got := apply(t, func(s Settings) bool { return s.ApplyIDs(shared, []string{idC, idB}, ActionRemove) }, true)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ApplyIDs() = %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("no listed ID", func(t *testing.T) {
+		got := apply(t, func(s Settings) bool { return s.ApplyIDs(shared, []string{idA}, ActionRemove) }, false)
+		if want := decode(t, policy); !reflect.DeepEqual(got, want) {
+			t.Errorf("ApplyIDs() = %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{`[]`, `{"x": 1}`, `{`} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Parse(%s) succeeded, want an error", data)
+		}
+	}
+	if s, err := Parse(`null`); err != nil || s == nil {
+		t.Errorf("Parse(null) = %v, %v, want empty settings", s, err)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Action
+	}{
+		{"", ActionBlock},
+		{"block", ActionBlock},
+		{" Remove ", ActionRemove},
+	}
+	for _, tt := range tests {
+		if got, err := ParseAction(tt.in); got != tt.want || err != nil {
+			t.Errorf("ParseAction(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseAction("quarantine"); err == nil {
+		t.Error("ParseAction(quarantine) succeeded, want an error")
+	}
+}
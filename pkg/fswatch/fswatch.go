@@ -0,0 +1,60 @@
+package fswatch
+
+import "path/filepath"
+
+// ============================================================================
+// FILESYSTEM WATCH - Change notifications for policy files
+// ============================================================================
+
+// target is a watched file, or directory entries matching pattern.
+type target struct {
+	// path is reported when the target changes, as passed to AddFile or
+	// AddDir.
+	path string
+	// dir is the directory holding the watched entries.
+	dir string
+	// name is the watched file name; empty for a directory target.
+	name string
+	// pattern selects the entries of a directory target.
+	pattern string
+	// armed is the directory currently watched for the target: dir itself
+	// or, while dir does not exist, its nearest existing ancestor.
+	armed string
+}
+
+// newFileTarget watches the file at path, through its directory so atomic
+// replacements (write to a temporary file, rename over path) are seen.
+func newFileTarget(path string) *target {
+	clean := filepath.Clean(path)
+	return &target{path: path, dir: filepath.Dir(clean), name: filepath.Base(clean)}
+}
+
+// newDirTarget watches the entries of dir matching pattern ("" for all).
+func newDirTarget(dir, pattern string) *target {
+	return &target{path: dir, dir: filepath.Clean(dir), pattern: pattern}
+}
+
+// matches reports whether a change to the entry name of t.dir concerns t.
+func (t *target) matches(name string) bool {
+	if t.name != "" {
+		return name == t.name
+	}
+	if t.pattern == "" {
+		return true
+	}
+	ok, _ := filepath.Match(t.pattern, name)
+	return ok
+}
+
+// nearestExisting returns dir or its nearest ancestor that exists, using
+// isDir to probe.
+func nearestExisting(dir string, isDir func(string) bool) string {
+	for !isDir(dir) {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+	return dir
+}
@@ -0,0 +1,320 @@
+//go:build linux
+
+package fswatch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask selects the inotify events of a watched directory: entries
+// created, written, replaced or removed, and the directory itself going away.
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// ancestorMask selects the inotify events of the ancestors of a watched
+// directory: entries renamed or removed, which may move the watched
+// directory away from its path or another directory onto it.
+const ancestorMask = unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// structuralMask selects the entry events that can change which directory a
+// watched path resolves to, when the entry is a directory.
+const structuralMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// watch is an inotify watch of a directory.
+type watch struct {
+	wd   int32
+	mask uint32
+}
+
+// event is one decoded inotify event.
+type event struct {
+	wd   int32
+	mask uint32
+	name string
+}
+
+// Watcher reports changes to files and directory entries through inotify.
+// Every target is watched through its directory, so a file replaced by a
+// rename is seen like one written in place. While a directory does not
+// exist its nearest existing ancestor is watched instead, and the watch is
+// re-armed once the directory is (re)created. An inotify watch follows its
+// directory when it is renamed, so the ancestors of every watched directory
+// are watched as well: when one of them is renamed or removed, the watches
+// below it are dropped and re-armed on what the paths now resolve to. Every
+// event that concerns a target is delivered on Changes; policy tools write
+// files in several steps (temporary file, chmod, rename), so callers
+// debounce them.
+type Watcher struct {
+	fd   int
+	file *os.File
+
+	mu      sync.Mutex
+	targets []*target
+	wds     map[int32]string
+	dirs    map[string]watch
+
+	changes chan []string
+	errs    chan error
+	done    chan struct{}
+}
+
+// New starts an inotify watcher.
+func New() (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	w := &Watcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		wds:     make(map[int32]string),
+		dirs:    make(map[string]watch),
+		changes: make(chan []string),
+		errs:    make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+	go w.read()
+	return w, nil
+}
+
+// AddFile watches the file at path. The file and its directory need not
+// exist yet.
+func (w *Watcher) AddFile(path string) error {
+	return w.add(newFileTarget(path))
+}
+
+// AddDir watches the entries of dir whose names match pattern ("" for all).
+// The directory need not exist yet.
+func (w *Watcher) AddDir(dir, pattern string) error {
+	return w.add(newDirTarget(dir, pattern))
+}
+
+func (w *Watcher) add(t *target) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.targets = append(w.targets, t)
+	if err := w.arm(); err != nil {
+		w.targets = w.targets[:len(w.targets)-1]
+		return err
+	}
+	return nil
+}
+
+// Changes delivers the paths, as passed to AddFile and AddDir, of the
+// targets each event changed.
+func (w *Watcher) Changes() <-chan []string {
+	return w.changes
+}
+
+// Errors delivers the error that stopped the watcher, e.g. a directory that
+// could not be watched again.
+func (w *Watcher) Errors() <-chan error {
+	return w.errs
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+// handle applies ev to the watches and returns the targets it changed.
+// w.mu must be held.
+func (w *Watcher) handle(ev event) ([]*target, error) {
+	if ev.mask&unix.IN_Q_OVERFLOW != 0 {
+		// Events were lost, possibly renames: every target may have
+		// changed and every watch may be stale.
+		w.unwatch("")
+		return w.targets, w.arm()
+	}
+	dir, ok := w.wds[ev.wd]
+	if !ok {
+		return nil, nil
+	}
+
+	var gone map[string]bool
+	switch {
+	case ev.mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0:
+		// The directory is gone, or followed by the watch to another path.
+		gone = w.unwatch(dir)
+	case ev.mask&structuralMask != 0 && ev.mask&unix.IN_ISDIR != 0:
+		// A directory appeared or disappeared at dir/name, possibly on the
+		// path of a target.
+		gone = w.unwatch(filepath.Join(dir, ev.name))
+	default:
+		var changed []*target
+		for _, t := range w.targets {
+			if t.armed == dir && t.armed == t.dir && t.matches(ev.name) {
+				changed = append(changed, t)
+			}
+		}
+		return changed, nil
+	}
+
+	armed := make([]string, len(w.targets))
+	for i, t := range w.targets {
+		armed[i] = t.armed
+	}
+	err := w.arm()
+
+	// A target changed when its directory went away, appeared, or was
+	// replaced by another one, or when one of its entries changed.
+	var changed []*target
+	for i, t := range w.targets {
+		moved := gone[armed[i]] || armed[i] != t.armed
+		switch {
+		case moved && (armed[i] == t.dir || t.armed == t.dir):
+			changed = append(changed, t)
+		case !moved && t.armed == dir && t.armed == t.dir && t.matches(ev.name):
+			changed = append(changed, t)
+		}
+	}
+	return changed, err
+}
+
+// unwatch removes the watches of dir and every directory below it, or of
+// every directory if dir is empty, and returns the directories it removed.
+// w.mu must be held.
+func (w *Watcher) unwatch(dir string) map[string]bool {
+	gone := make(map[string]bool)
+	for path, wa := range w.dirs {
+		if dir != "" && path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue
+		}
+		// A deleted directory's watch is already gone; the error is expected.
+		_, _ = unix.InotifyRmWatch(w.fd, uint32(wa.wd))
+		delete(w.dirs, path)
+		delete(w.wds, wa.wd)
+		gone[path] = true
+	}
+	return gone
+}
+
+// arm points every target at its directory or, while it does not exist, its
+// nearest existing ancestor, and makes the watches match: the armed
+// directories are watched for their entries, their ancestors for renames
+// and removals. Watches no target needs any more are removed. w.mu must be
+// held.
+func (w *Watcher) arm() error {
+	for {
+		need := make(map[string]uint32)
+		for _, t := range w.targets {
+			t.armed = nearestExisting(t.dir, isDir)
+			need[t.armed] |= watchMask
+			for dir := t.armed; ; {
+				parent := filepath.Dir(dir)
+				if parent == dir {
+					break
+				}
+				need[parent] |= ancestorMask
+				dir = parent
+			}
+		}
+
+		for path, wa := range w.dirs {
+			if need[path] == 0 {
+				_, _ = unix.InotifyRmWatch(w.fd, uint32(wa.wd))
+				delete(w.dirs, path)
+				delete(w.wds, wa.wd)
+			}
+		}
+
+		retry := false
+		for _, path := range slices.Sorted(maps.Keys(need)) {
+			mask := need[path]
+			if wa, ok := w.dirs[path]; ok && wa.mask == mask {
+				continue
+			}
+			wd, err := unix.InotifyAddWatch(w.fd, path, mask)
+			if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOTDIR) {
+				// Removed since it was probed: probe again.
+				retry = true
+				break
+			}
+			if errors.Is(err, unix.EACCES) && mask == ancestorMask {
+				// Renames below an unreadable ancestor go unnoticed.
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("watching %s: %w", path, err)
+			}
+			w.wds[int32(wd)] = path
+			w.dirs[path] = watch{wd: int32(wd), mask: mask}
+		}
+		if !retry {
+			return nil
+		}
+	}
+}
+
+// read decodes inotify events and delivers the changes they make until the
+// watcher is closed or fails.
+func (w *Watcher) read() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			w.fail(fmt.Errorf("reading inotify events: %w", err))
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := event{
+				wd:   int32(binary.NativeEndian.Uint32(buf[off:])),
+				mask: binary.NativeEndian.Uint32(buf[off+4:]),
+			}
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			off += unix.SizeofInotifyEvent
+			if nameLen > 0 && off+nameLen <= n {
+				ev.name = strings.TrimRight(string(buf[off:off+nameLen]), "\x00")
+			}
+			off += nameLen
+
+			w.mu.Lock()
+			targets, err := w.handle(ev)
+			w.mu.Unlock()
+			if err != nil {
+				w.fail(err)
+				return
+			}
+			if len(targets) == 0 {
+				continue
+			}
+			paths := make([]string, 0, len(targets))
+			for _, t := range targets {
+				if !slices.Contains(paths, t.path) {
+					paths = append(paths, t.path)
+				}
+			}
+			select {
+			case w.changes <- paths:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// fail reports err on Errors unless the watcher was closed.
+func (w *Watcher) fail(err error) {
+	select {
+	case <-w.done:
+	case w.errs <- err:
+	}
+}
+
+// isDir reports whether path is an existing directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,218 @@
+//go:build linux
+
+package fswatch
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// settle is how long a test waits for events that may or may not arrive.
+const settle = 200 * time.Millisecond
+
+func newWatcher(t *testing.T) *Watcher {
+	t.Helper()
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+// drain returns the paths reported until no event arrived for settle.
+func drain(t *testing.T, w *Watcher) []string {
+	t.Helper()
+	var paths []string
+	for {
+		select {
+		case changed := <-w.Changes():
+			for _, path := range changed {
+				if !slices.Contains(paths, path) {
+					paths = append(paths, path)
+				}
+			}
+		case err := <-w.Errors():
+			t.Fatalf("watcher failed: %v", err)
+		case <-time.After(settle):
+			return paths
+		}
+	}
+}
+
+func mkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherDir(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "a", "b")
+
+	tests := []struct {
+		name string
+		// setup prepares base before the watch is added.
+		setup func(t *testing.T)
+		// change is made once the watch is added and drained.
+		change func(t *testing.T)
+		want   []string
+	}{
+		{
+			name:   "write matching file",
+			setup:  func(t *testing.T) { mkdir(t, dir) },
+			change: func(t *testing.T) { writeFile(t, filepath.Join(dir, "p.json")) },
+			want:   []string{dir},
+		},
+		{
+			name:   "write other file",
+			setup:  func(t *testing.T) { mkdir(t, dir) },
+			change: func(t *testing.T) { writeFile(t, filepath.Join(dir, "p.txt")) },
+		},
+		{
+			name:  "directory created later",
+			setup: func(t *testing.T) {},
+			change: func(t *testing.T) {
+				mkdir(t, dir)
+				writeFile(t, filepath.Join(dir, "p.json"))
+			},
+			want: []string{dir},
+		},
+		{
+			name:   "directory deleted",
+			setup:  func(t *testing.T) { mkdir(t, dir) },
+			change: func(t *testing.T) { removeAll(t, filepath.Join(base, "a")) },
+			want:   []string{dir},
+		},
+		{
+			name:   "ancestor renamed",
+			setup:  func(t *testing.T) { mkdir(t, dir) },
+			change: func(t *testing.T) { rename(t, filepath.Join(base, "a"), filepath.Join(base, "old")) },
+			want:   []string{dir},
+		},
+		{
+			name: "directory renamed onto path",
+			setup: func(t *testing.T) {
+				mkdir(t, filepath.Join(base, "new", "b"))
+			},
+			change: func(t *testing.T) { rename(t, filepath.Join(base, "new"), filepath.Join(base, "a")) },
+			want:   []string{dir},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"a", "old", "new"} {
+				removeAll(t, filepath.Join(base, name))
+			}
+			tt.setup(t)
+			w := newWatcher(t)
+			if err := w.AddDir(dir, "*.json"); err != nil {
+				t.Fatal(err)
+			}
+			drain(t, w)
+
+			tt.change(t)
+			if got := drain(t, w); !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatcherRecreated checks that the watch moves back to the path once
+// the directory is deleted or renamed away and then recreated.
+func TestWatcherRecreated(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		remove func(t *testing.T, base string)
+	}{
+		{"deleted", func(t *testing.T, base string) { removeAll(t, filepath.Join(base, "a")) }},
+		{"ancestor renamed", func(t *testing.T, base string) {
+			rename(t, filepath.Join(base, "a"), filepath.Join(base, "old"))
+		}},
+		{"directory renamed", func(t *testing.T, base string) {
+			rename(t, filepath.Join(base, "a", "b"), filepath.Join(base, "old"))
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dir := filepath.Join(base, "a", "b")
+			mkdir(t, dir)
+			w := newWatcher(t)
+			if err := w.AddDir(dir, "*.json"); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.remove(t, base)
+			drain(t, w)
+
+			// The directory moved away is no longer watched.
+			if moved := filepath.Join(base, "old"); isDir(moved) {
+				_ = filepath.WalkDir(moved, func(path string, d os.DirEntry, err error) error {
+					if err == nil && d.IsDir() {
+						writeFile(t, filepath.Join(path, "stale.json"))
+					}
+					return nil
+				})
+				if got := drain(t, w); len(got) != 0 {
+					t.Errorf("changes after writing below the moved directory = %q, want none", got)
+				}
+			}
+
+			mkdir(t, dir)
+			drain(t, w)
+			writeFile(t, filepath.Join(dir, "q.json"))
+			if got, want := drain(t, w), []string{dir}; !slices.Equal(got, want) {
+				t.Errorf("changes after recreating = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWatcherFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policies.json")
+	w := newWatcher(t)
+	if err := w.AddFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "other.json"))
+	if got := drain(t, w); len(got) != 0 {
+		t.Errorf("changes after writing another file = %q, want none", got)
+	}
+
+	// Replaced atomically, as policy tools do.
+	tmp := filepath.Join(dir, ".policies.json.tmp")
+	writeFile(t, tmp)
+	rename(t, tmp, path)
+	if got, want := drain(t, w), []string{path}; !slices.Equal(got, want) {
+		t.Errorf("changes after replacing = %q, want %q", got, want)
+	}
+}
+
+func removeAll(t *testing.T, path string) {
+	t.Helper()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rename(t *testing.T, from, to string) {
+	t.Helper()
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+
+package fswatch
+
+import "errors"
+
+var errUnsupported = errors.New("filesystem change notifications are only available on Linux")
+
+// Watcher reports changes to files and directory entries. It is only
+// implemented on Linux.
+type Watcher struct{}
+
+// New returns an error: filesystem change notifications are not implemented
+// on this platform.
+func New() (*Watcher, error) {
+	return nil, errUnsupported
+}
+
+// AddFile watches the file at path.
+func (w *Watcher) AddFile(path string) error {
+	return errUnsupported
+}
+
+// AddDir watches the entries of dir whose names match pattern.
+func (w *Watcher) AddDir(dir, pattern string) error {
+	return errUnsupported
+}
+
+// Changes delivers the paths of the targets each event changed.
+func (w *Watcher) Changes() <-chan []string {
+	return nil
+}
+
+// Errors delivers the error that stopped the watcher.
+func (w *Watcher) Errors() <-chan error {
+	return nil
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	return nil
+}
@@ -0,0 +1,191 @@
+package hijack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+)
+
+// ============================================================================
+// HIJACK POLICIES - Non-extension policies set by browser hijackers
+// ============================================================================
+
+// Action is what the guard does with a hijack policy it finds.
+type Action string
+
+const (
+	// Report logs the policy and leaves it in place.
+	Report Action = "report"
+	// Remove deletes the policy value or key.
+	Remove Action = "remove"
+	// Reset overwrites the policy value with the detector's safe value.
+	Reset Action = "reset"
+)
+
+// SafeValue is the value a policy is reset to.
+type SafeValue struct {
+	Type  uint32 // detection.RegSZ or detection.RegDword
+	Text  string
+	Dword uint32
+}
+
+// Raw returns the registry encoding of s.
+func (s SafeValue) Raw() []byte {
+	if s.Type == detection.RegDword {
+		return []byte{byte(s.Dword), byte(s.Dword >> 8), byte(s.Dword >> 16), byte(s.Dword >> 24)}
+	}
+	return detection.EncodeUTF16String(s.Text)
+}
+
+// Detector describes one hijack policy of a browser family.
+type Detector struct {
+	// Policy is the policy name, used as the metric "policy" label.
+	Policy string
+	Family browsers.Family
+	// Path is the policy value, or with Key the policy key, relative to the
+	// browser's policy root.
+	Path string
+	Key  bool
+	// Suspicious reports whether a set value is a hit; nil means any value.
+	// Values equal to Safe are never hits.
+	Suspicious func(valueType uint32, raw []byte) bool
+	// Safe is the value used by Reset; nil means Reset is unsupported.
+	Safe        *SafeValue
+	Description string
+}
+
+// Detectors lists the hijack policies the guard looks for.
+var Detectors = []Detector{
+	{Policy: "HomepageLocation", Family: browsers.Chromium, Path: "HomepageLocation",
+		Safe: &SafeValue{Type: detection.RegSZ, Text: "chrome://newtab"}, Description: "forced home page"},
+	{Policy: "RestoreOnStartupURLs", Family: browsers.Chromium, Path: "RestoreOnStartupURLs", Key: true,
+		Description: "pages forced open on startup"},
+	{Policy: "NewTabPageLocation", Family: browsers.Chromium, Path: "NewTabPageLocation",
+		Description: "forced new tab page"},
+	{Policy: "DefaultSearchProviderSearchURL", Family: browsers.Chromium, Path: "DefaultSearchProviderSearchURL",
+		Description: "forced search engine"},
+	{Policy: "ProxySettings", Family: browsers.Chromium, Path: "ProxySettings",
+		Safe: &SafeValue{Type: detection.RegSZ, Text: `{"ProxyMode":"system"}`}, Description: "forced proxy configuration"},
+	{Policy: "ProxyServer", Family: browsers.Chromium, Path: "ProxyServer",
+		Description: "forced proxy server"},
+	{Policy: "DeveloperToolsAvailability", Family: browsers.Chromium, Path: "DeveloperToolsAvailability",
+		Suspicious: func(valueType uint32, raw []byte) bool {
+			// 2 disables the developer tools, hiding what extensions do.
+			n, ok := detection.DecodeUint32(valueType, raw)
+			return ok && n == 2
+		},
+		Safe: &SafeValue{Type: detection.RegDword, Dword: 1}, Description: "developer tools disabled"},
+	{Policy: "ExtensionInstallSources", Family: browsers.Chromium, Path: "ExtensionInstallSources", Key: true,
+		Description: "extra extension install sites"},
+	{Policy: "ExtensionAllowedTypes", Family: browsers.Chromium, Path: "ExtensionAllowedTypes", Key: true,
+		Description: "restricted extension types"},
+	{Policy: "SearchEngines", Family: browsers.Gecko, Path: "SearchEngines", Key: true,
+		Description: "forced search engines"},
+	{Policy: "Homepage", Family: browsers.Gecko, Path: "Homepage", Key: true,
+		Description: "forced home page"},
+}
+
+// Hit is a hijack policy found in the registry.
+type Hit struct {
+	Detector Detector
+	Browser  browsers.Descriptor
+	// Target is the value or key path to remediate, relative to
+	// HKLM\SOFTWARE\Policies.
+	Target string
+}
+
+// Match returns the hijack policy that the value at valuePath belongs to.
+// The value itself is checked with Hit.Suspicious.
+func Match(valuePath string) (Hit, bool) {
+	d, rest, ok := browsers.ForPath(valuePath)
+	if !ok || rest == "" {
+		return Hit{}, false
+	}
+	for _, det := range Detectors {
+		if det.Family != d.Family {
+			continue
+		}
+		if det.Key {
+			if below, ok := pathutils.TrimPathPrefix(rest, det.Path); ok {
+				// Keep the casing of valuePath so the target matches state keys.
+				target := valuePath
+				if below != "" {
+					target = valuePath[:len(valuePath)-len(below)-1]
+				}
+				return Hit{Detector: det, Browser: d, Target: target}, true
+			}
+		} else if strings.EqualFold(rest, det.Path) {
+			return Hit{Detector: det, Browser: d, Target: valuePath}, true
+		}
+	}
+	return Hit{}, false
+}
+
+// Suspicious reports whether a value of the hit's policy is a hit. Values of
+// key policies always are.
+func (h Hit) Suspicious(valueType uint32, raw []byte) bool {
+	det := h.Detector
+	if det.Key {
+		return true
+	}
+	if det.Safe != nil && det.Safe.Type == valueType &&
+		detection.FormatRegValue(valueType, raw) == detection.FormatRegValue(det.Safe.Type, det.Safe.Raw()) {
+		return false
+	}
+	return det.Suspicious == nil || det.Suspicious(valueType, raw)
+}
+
+// Actions maps policy names to actions. Policies without an entry are
+// reported.
+type Actions map[string]Action
+
+// For returns the action for policy.
+func (a Actions) For(policy string) Action {
+	if action, ok := a[policy]; ok {
+		return action
+	}
+	return Report
+}
+
+// ParseActions validates a policy name -> action map as found in config.json.
+func ParseActions(m map[string]string) (Actions, error) {
+	actions := make(Actions, len(m))
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		det, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("hijack policy %q: unknown policy", name)
+		}
+		action := Action(strings.ToLower(strings.TrimSpace(m[name])))
+		switch action {
+		case Report, Remove:
+		case Reset:
+			if det.Safe == nil {
+				return nil, fmt.Errorf("hijack policy %q: no safe value to reset to (use %q or %q)", name, Report, Remove)
+			}
+		default:
+			return nil, fmt.Errorf("hijack policy %q: unknown action %q (want %q, %q or %q)", name, m[name], Report, Remove, Reset)
+		}
+		actions[det.Policy] = action
+	}
+	return actions, nil
+}
+
+// lookup returns the detector for a policy name, ignoring case.
+func lookup(policy string) (Detector, bool) {
+	for _, det := range Detectors {
+		if strings.EqualFold(det.Policy, policy) {
+			return det, true
+		}
+	}
+	return Detector{}, false
+}
@@ -0,0 +1,416 @@
+package hive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// ============================================================================
+// REGF HIVES - Read-only parser for offline registry hive files
+// ============================================================================
+
+const (
+	baseBlockSize = 4096
+	// bigDataThreshold is the largest value stored in a single cell; larger
+	// values use a "db" big-data record in hives of version 1.4 and later.
+	bigDataThreshold = 16344
+
+	keyCompName   = 0x0020 // nk name is stored as ASCII/Latin-1
+	valueCompName = 0x0001 // vk name is stored as ASCII/Latin-1
+)
+
+// ErrReadOnly is returned by every write operation on a Hive.
+var ErrReadOnly = errors.New("offline hive files are read-only")
+
+// Hive is an offline registry hive file (regf format). It implements
+// registry.Backend read-only, with the hive root mounted at a path relative
+// to HKLM, so the SOFTWARE hive from a disk image can be captured and
+// scanned exactly like the live registry.
+type Hive struct {
+	data         []byte
+	mount        []string
+	minorVersion uint32
+	dirty        bool
+	rootOffset   uint32
+}
+
+// Open reads the hive file at path and mounts its root at mountPoint
+// (relative to HKLM, typically "SOFTWARE").
+func Open(path, mountPoint string) (*Hive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := Parse(data, mountPoint)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Parse validates the base block of an in-memory hive image and mounts its
+// root at mountPoint.
+func Parse(data []byte, mountPoint string) (*Hive, error) {
+	if len(data) < baseBlockSize || string(data[0:4]) != "regf" {
+		return nil, errors.New("not a registry hive (missing regf signature)")
+	}
+	h := &Hive{
+		data:         data,
+		mount:        pathutils.SplitPath(strings.Trim(mountPoint, "\\")),
+		minorVersion: binary.LittleEndian.Uint32(data[0x18:]),
+		// Mismatched sequence numbers mean the hive was not cleanly
+		// written back and its transaction logs were not replayed.
+		dirty:      binary.LittleEndian.Uint32(data[0x04:]) != binary.LittleEndian.Uint32(data[0x08:]),
+		rootOffset: binary.LittleEndian.Uint32(data[0x24:]),
+	}
+	if _, err := h.nk(h.rootOffset); err != nil {
+		return nil, fmt.Errorf("invalid root key: %w", err)
+	}
+	return h, nil
+}
+
+// Dirty reports whether the hive has pending transaction log data. Recent
+// changes may be missing from a dirty hive.
+func (h *Hive) Dirty() bool {
+	return h.dirty
+}
+
+// corrupt builds an error for structural problems in the hive.
+func corrupt(format string, args ...interface{}) error {
+	return fmt.Errorf("corrupt hive: "+format, args...)
+}
+
+// cell returns the payload of the cell at offset (relative to the first hbin).
+func (h *Hive) cell(offset uint32) ([]byte, error) {
+	start := uint64(baseBlockSize) + uint64(offset)
+	if start+4 > uint64(len(h.data)) {
+		return nil, corrupt("cell offset 0x%x out of range", offset)
+	}
+	size := int32(binary.LittleEndian.Uint32(h.data[start:]))
+	if size < 0 {
+		size = -size
+	}
+	end := start + uint64(size)
+	if size < 4 || end > uint64(len(h.data)) {
+		return nil, corrupt("cell at 0x%x has invalid size %d", offset, size)
+	}
+	return h.data[start+4 : end], nil
+}
+
+// nkRecord is a decoded key node.
+type nkRecord struct {
+	name          string
+	subkeyCount   uint32
+	subkeysOffset uint32
+	valueCount    uint32
+	valuesOffset  uint32
+}
+
+func (h *Hive) nk(offset uint32) (*nkRecord, error) {
+	c, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) < 76 || string(c[0:2]) != "nk" {
+		return nil, corrupt("expected key node at 0x%x", offset)
+	}
+	flags := binary.LittleEndian.Uint16(c[2:])
+	nameLen := int(binary.LittleEndian.Uint16(c[72:]))
+	if 76+nameLen > len(c) {
+		return nil, corrupt("key name at 0x%x exceeds cell", offset)
+	}
+	return &nkRecord{
+		name:          decodeName(c[76:76+nameLen], flags&keyCompName != 0),
+		subkeyCount:   binary.LittleEndian.Uint32(c[20:]),
+		subkeysOffset: binary.LittleEndian.Uint32(c[28:]),
+		valueCount:    binary.LittleEndian.Uint32(c[36:]),
+		valuesOffset:  binary.LittleEndian.Uint32(c[40:]),
+	}, nil
+}
+
+// subkeyOffsets appends the key node offsets of a subkey index (lf, lh, li
+// or ri) to offsets. An index of a key lists at most limit subkeys; an ri
+// index may only refer to leaf indexes. Both bound the work on corrupt hives.
+func (h *Hive) subkeyOffsets(listOffset uint32, offsets []uint32, limit int, nested bool) ([]uint32, error) {
+	c, err := h.cell(listOffset)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) < 4 {
+		return nil, corrupt("subkey index at 0x%x too short", listOffset)
+	}
+	sig := string(c[0:2])
+	count := int(binary.LittleEndian.Uint16(c[2:]))
+
+	var stride int
+	switch sig {
+	case "lf", "lh":
+		stride = 8 // offset + name hint/hash
+	case "li":
+		stride = 4
+	case "ri":
+		if nested {
+			return nil, corrupt("nested ri subkey index at 0x%x", listOffset)
+		}
+		stride = 4
+	default:
+		return nil, corrupt("unknown subkey index %q at 0x%x", sig, listOffset)
+	}
+	if 4+count*stride > len(c) {
+		return nil, corrupt("subkey index at 0x%x exceeds cell", listOffset)
+	}
+	if sig != "ri" && len(offsets)+count > limit {
+		return nil, corrupt("subkey index at 0x%x lists more than %d subkeys", listOffset, limit)
+	}
+
+	for i := 0; i < count; i++ {
+		off := binary.LittleEndian.Uint32(c[4+i*stride:])
+		if sig == "ri" {
+			offsets, err = h.subkeyOffsets(off, offsets, limit, true)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		offsets = append(offsets, off)
+	}
+	return offsets, nil
+}
+
+func (h *Hive) subkeys(key *nkRecord) ([]*nkRecord, error) {
+	if key.subkeyCount == 0 || key.subkeysOffset == 0xFFFFFFFF {
+		return nil, nil
+	}
+	offsets, err := h.subkeyOffsets(key.subkeysOffset, nil, int(key.subkeyCount), false)
+	if err != nil {
+		return nil, err
+	}
+	subkeys := make([]*nkRecord, 0, len(offsets))
+	for _, off := range offsets {
+		sub, err := h.nk(off)
+		if err != nil {
+			return nil, err
+		}
+		subkeys = append(subkeys, sub)
+	}
+	return subkeys, nil
+}
+
+func (h *Hive) values(key *nkRecord) ([]registry.RawValue, error) {
+	if key.valueCount == 0 || key.valuesOffset == 0xFFFFFFFF {
+		return nil, nil
+	}
+	list, err := h.cell(key.valuesOffset)
+	if err != nil {
+		return nil, err
+	}
+	if int(key.valueCount)*4 > len(list) {
+		return nil, corrupt("value list at 0x%x exceeds cell", key.valuesOffset)
+	}
+
+	values := make([]registry.RawValue, 0, key.valueCount)
+	for i := 0; i < int(key.valueCount); i++ {
+		value, err := h.vk(binary.LittleEndian.Uint32(list[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (h *Hive) vk(offset uint32) (registry.RawValue, error) {
+	var value registry.RawValue
+
+	c, err := h.cell(offset)
+	if err != nil {
+		return value, err
+	}
+	if len(c) < 20 || string(c[0:2]) != "vk" {
+		return value, corrupt("expected value record at 0x%x", offset)
+	}
+	nameLen := int(binary.LittleEndian.Uint16(c[2:]))
+	dataSize := binary.LittleEndian.Uint32(c[4:])
+	dataOffset := binary.LittleEndian.Uint32(c[8:])
+	value.Type = binary.LittleEndian.Uint32(c[12:])
+	flags := binary.LittleEndian.Uint16(c[16:])
+	if 20+nameLen > len(c) {
+		return value, corrupt("value name at 0x%x exceeds cell", offset)
+	}
+	value.Name = decodeName(c[20:20+nameLen], flags&valueCompName != 0)
+
+	value.Data, err = h.valueData(dataSize, dataOffset)
+	if err != nil {
+		return value, fmt.Errorf("value %q: %w", value.Name, err)
+	}
+	return value, nil
+}
+
+// valueData resolves vk data stored inline, in a single cell, or in a "db"
+// big-data record.
+func (h *Hive) valueData(dataSize, dataOffset uint32) ([]byte, error) {
+	if dataSize&0x80000000 != 0 {
+		// Data of up to four bytes lives in the offset field itself.
+		size := dataSize & 0x7FFFFFFF
+		if size > 4 {
+			return nil, corrupt("inline value data of %d bytes", size)
+		}
+		inline := make([]byte, 4)
+		binary.LittleEndian.PutUint32(inline, dataOffset)
+		return inline[:size], nil
+	}
+	if dataSize == 0 {
+		return []byte{}, nil
+	}
+
+	c, err := h.cell(dataOffset)
+	if err != nil {
+		return nil, err
+	}
+	if dataSize > bigDataThreshold && h.minorVersion >= 4 && len(c) >= 8 && string(c[0:2]) == "db" {
+		return h.bigData(c, dataSize)
+	}
+	if int(dataSize) > len(c) {
+		return nil, corrupt("value data at 0x%x exceeds cell", dataOffset)
+	}
+	return append([]byte(nil), c[:dataSize]...), nil
+}
+
+func (h *Hive) bigData(db []byte, dataSize uint32) ([]byte, error) {
+	segments := int(binary.LittleEndian.Uint16(db[2:]))
+	listOffset := binary.LittleEndian.Uint32(db[4:])
+	list, err := h.cell(listOffset)
+	if err != nil {
+		return nil, err
+	}
+	if segments*4 > len(list) {
+		return nil, corrupt("big data segment list at 0x%x exceeds cell", listOffset)
+	}
+	if uint64(dataSize) > uint64(segments)*bigDataThreshold {
+		return nil, corrupt("big data record of %d segments cannot hold %d bytes", segments, dataSize)
+	}
+
+	data := make([]byte, 0, dataSize)
+	for i := 0; i < segments && uint32(len(data)) < dataSize; i++ {
+		segment, err := h.cell(binary.LittleEndian.Uint32(list[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+		n := len(segment)
+		if n > bigDataThreshold {
+			n = bigDataThreshold
+		}
+		if remaining := int(dataSize) - len(data); n > remaining {
+			n = remaining
+		}
+		data = append(data, segment[:n]...)
+	}
+	if uint32(len(data)) != dataSize {
+		return nil, corrupt("big data record holds %d of %d bytes", len(data), dataSize)
+	}
+	return data, nil
+}
+
+func decodeName(raw []byte, compressed bool) string {
+	if compressed {
+		runes := make([]rune, len(raw))
+		for i, c := range raw {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+	u16 := make([]uint16, len(raw)/2)
+	for i := range u16 {
+		u16[i] = binary.LittleEndian.Uint16(raw[i*2:])
+	}
+	return string(utf16.Decode(u16))
+}
+
+// resolve maps an HKLM-relative path onto the hive. mountRest holds the
+// remaining mount point components when path lies above the hive root.
+func (h *Hive) resolve(path string) (key *nkRecord, mountRest []string, err error) {
+	parts := pathutils.SplitPath(strings.Trim(path, "\\"))
+	for i, m := range h.mount {
+		if i >= len(parts) {
+			return nil, h.mount[i:], nil
+		}
+		if !strings.EqualFold(parts[i], m) {
+			return nil, nil, fmt.Errorf("key %s: %w", path, registry.ErrNotFound)
+		}
+	}
+
+	key, err = h.nk(h.rootOffset)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, part := range parts[len(h.mount):] {
+		subkeys, err := h.subkeys(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		var next *nkRecord
+		for _, sub := range subkeys {
+			if strings.EqualFold(sub.name, part) {
+				next = sub
+				break
+			}
+		}
+		if next == nil {
+			return nil, nil, fmt.Errorf("key %s: %w", path, registry.ErrNotFound)
+		}
+		key = next
+	}
+	return key, nil, nil
+}
+
+func (h *Hive) OpenKey(path string) error {
+	_, _, err := h.resolve(path)
+	return err
+}
+
+func (h *Hive) EnumSubkeys(path string) ([]string, error) {
+	key, mountRest, err := h.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return []string{mountRest[0]}, nil
+	}
+	subkeys, err := h.subkeys(key)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(subkeys))
+	for i, sub := range subkeys {
+		names[i] = sub.name
+	}
+	return names, nil
+}
+
+func (h *Hive) EnumValues(path string) ([]registry.RawValue, error) {
+	key, _, err := h.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+	return h.values(key)
+}
+
+func (h *Hive) CreateKey(path string) error                                     { return ErrReadOnly }
+func (h *Hive) SetValue(path, name string, valueType uint32, data []byte) error { return ErrReadOnly }
+func (h *Hive) DeleteValue(path, name string) error                             { return ErrReadOnly }
+func (h *Hive) DeleteKey(path string) error                                     { return ErrReadOnly }
+
+// HasWriteAccess always reports false: hive files are analysed read-only.
+func (h *Hive) HasWriteAccess() bool {
+	return false
+}
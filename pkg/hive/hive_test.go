@@ -0,0 +1,318 @@
+package hive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// testdata/software.hive is a version 1.5 SOFTWARE hive holding
+//
+//	Microsoft
+//	Policies                          (lh index)
+//	  Google                          (lf index)
+//	    Chrome                        (ri index of an lf and an li)
+//	      ExtensionInstallForcelist
+//	      ExtensionInstallAllowlist
+//	      ExtensionInstallBlocklist
+//	  Mozilla                         (li index)
+//	    Firefox                       (value with a UTF-16 name)
+//
+// Chrome has a 40000 byte binary value stored in a three segment "db"
+// big-data record, an inline dword and a binary value in its own cell.
+const fixture = "software.hive"
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// bigValue is the data of the big-data value in the fixture.
+func bigValue() []byte {
+	data := make([]byte, 40000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestCaptureState(t *testing.T) {
+	h, err := Open(filepath.Join("testdata", fixture), "SOFTWARE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Dirty() {
+		t.Error("clean fixture reported dirty")
+	}
+
+	state, err := registry.CaptureState(h, `SOFTWARE\Policies`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantKeys := []string{
+		`Google`,
+		`Google\Chrome`,
+		`Google\Chrome\ExtensionInstallAllowlist`,
+		`Google\Chrome\ExtensionInstallBlocklist`,
+		`Google\Chrome\ExtensionInstallForcelist`,
+		`Mozilla`,
+		`Mozilla\Firefox`,
+	}
+	var gotKeys []string
+	for key := range state.Subkeys {
+		gotKeys = append(gotKeys, key)
+	}
+	slices.Sort(gotKeys)
+	if !slices.Equal(gotKeys, wantKeys) {
+		t.Errorf("keys = %q, want %q", gotKeys, wantKeys)
+	}
+
+	wantValues := map[string]registry.RawValue{
+		`Google\Chrome\Big`:    {Type: detection.RegBinary, Data: bigValue()},
+		`Google\Chrome\Dword`:  {Type: detection.RegDword, Data: []byte{42, 0, 0, 0}},
+		`Google\Chrome\Binary`: {Type: detection.RegBinary, Data: []byte{0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe}},
+		`Google\Chrome\ExtensionInstallForcelist\1`: {Type: detection.RegSZ,
+			Data: detection.EncodeUTF16String("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa;https://clients2.google.com/service/update2/crx")},
+		`Google\Chrome\ExtensionInstallAllowlist\1`: {Type: detection.RegSZ, Data: detection.EncodeUTF16String("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")},
+		`Google\Chrome\ExtensionInstallBlocklist\1`: {Type: detection.RegSZ, Data: detection.EncodeUTF16String("*")},
+		`Mozilla\Firefox\Grüße 拡張`:                  {Type: detection.RegSZ, Data: detection.EncodeUTF16String("x")},
+	}
+	if len(state.Values) != len(wantValues) {
+		t.Errorf("got %d values, want %d", len(state.Values), len(wantValues))
+	}
+	for path, want := range wantValues {
+		got, ok := state.Values[path]
+		if !ok {
+			t.Errorf("value %s missing", path)
+			continue
+		}
+		if got.Type != want.Type || !bytes.Equal(got.Raw, want.Data) {
+			t.Errorf("value %s = type %d, %d bytes; want type %d, %d bytes", path, got.Type, len(got.Raw), want.Type, len(want.Data))
+		}
+	}
+}
+
+func TestEnumSubkeys(t *testing.T) {
+	h, err := Parse(readFixture(t), "SOFTWARE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		want []string
+	}{
+		{``, []string{"SOFTWARE"}},
+		{`SOFTWARE`, []string{"Microsoft", "Policies"}},
+		{`SOFTWARE\Policies`, []string{"Google", "Mozilla"}},
+		{`SOFTWARE\Policies\Google`, []string{"Chrome"}},
+		{`SOFTWARE\Policies\Google\Chrome`, []string{"ExtensionInstallForcelist", "ExtensionInstallAllowlist", "ExtensionInstallBlocklist"}},
+		{`software\POLICIES\mozilla`, []string{"Firefox"}},
+		{`SOFTWARE\Microsoft`, []string{}},
+	} {
+		got, err := h.EnumSubkeys(tt.path)
+		if err != nil {
+			t.Errorf("EnumSubkeys(%q): %v", tt.path, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("EnumSubkeys(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	for _, path := range []string{`SYSTEM`, `SOFTWARE\Nope`, `SOFTWARE\Policies\Google\Edge`} {
+		if err := h.OpenKey(path); !errors.Is(err, registry.ErrNotFound) {
+			t.Errorf("OpenKey(%q) = %v, want ErrNotFound", path, err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	h, err := Parse(readFixture(t), "SOFTWARE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const path = `SOFTWARE\Policies\Google\Chrome`
+	for name, err := range map[string]error{
+		"CreateKey":   h.CreateKey(path + `\New`),
+		"SetValue":    h.SetValue(path, "x", detection.RegDword, []byte{1, 0, 0, 0}),
+		"DeleteValue": h.DeleteValue(path, "Dword"),
+		"DeleteKey":   h.DeleteKey(path),
+	} {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s = %v, want ErrReadOnly", name, err)
+		}
+	}
+	if h.HasWriteAccess() {
+		t.Error("HasWriteAccess() = true")
+	}
+}
+
+func TestDirty(t *testing.T) {
+	data := readFixture(t)
+	binary.LittleEndian.PutUint32(data[0x08:], binary.LittleEndian.Uint32(data[0x04:])-1)
+	h, err := Parse(data, "SOFTWARE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Dirty() {
+		t.Error("hive with mismatched sequence numbers not reported dirty")
+	}
+}
+
+// findCell returns the offset of the first cell whose payload starts with
+// sig and contains name.
+func findCell(t *testing.T, data []byte, sig, name string) uint32 {
+	t.Helper()
+	for pos := baseBlockSize + 32; pos+6 <= len(data); {
+		size := int(int32(binary.LittleEndian.Uint32(data[pos:])))
+		if size < 0 {
+			size = -size
+		}
+		if size == 0 {
+			break
+		}
+		payload := data[pos+4 : pos+size]
+		if string(payload[0:2]) == sig && bytes.Contains(payload, []byte(name)) {
+			return uint32(pos - baseBlockSize)
+		}
+		pos += size
+	}
+	t.Fatalf("no %s cell containing %q", sig, name)
+	return 0
+}
+
+// payloadAt returns the payload of the fixture cell at offset.
+func payloadAt(data []byte, offset uint32) []byte {
+	return data[baseBlockSize+offset+4:]
+}
+
+// TestCorrupt checks that damaged hives fail with an error from Parse or
+// from capturing their state rather than panicking.
+func TestCorrupt(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		damage func(t *testing.T, data []byte) []byte
+	}{
+		{"bad signature", func(t *testing.T, data []byte) []byte {
+			copy(data, "fger")
+			return data
+		}},
+		{"short base block", func(t *testing.T, data []byte) []byte {
+			return data[:baseBlockSize-1]
+		}},
+		{"root out of range", func(t *testing.T, data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[0x24:], uint32(len(data)))
+			return data
+		}},
+		{"root not a key node", func(t *testing.T, data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[0x24:], findCell(t, data, "vk", "Dword"))
+			return data
+		}},
+		{"zero cell size", func(t *testing.T, data []byte) []byte {
+			off := findCell(t, data, "nk", "Mozilla")
+			binary.LittleEndian.PutUint32(data[baseBlockSize+off:], 0)
+			return data
+		}},
+		{"cell past end", func(t *testing.T, data []byte) []byte {
+			off := findCell(t, data, "nk", "Firefox")
+			binary.LittleEndian.PutUint32(data[baseBlockSize+off:], 0x7FFFFFF0)
+			return data
+		}},
+		{"key name exceeds cell", func(t *testing.T, data []byte) []byte {
+			nk := payloadAt(data, findCell(t, data, "nk", "Google"))
+			binary.LittleEndian.PutUint16(nk[72:], 0x1000)
+			return data
+		}},
+		{"unknown subkey index", func(t *testing.T, data []byte) []byte {
+			ri := payloadAt(data, findCell(t, data, "ri", ""))
+			copy(ri, "rx")
+			return data
+		}},
+		{"nested ri", func(t *testing.T, data []byte) []byte {
+			off := findCell(t, data, "ri", "")
+			binary.LittleEndian.PutUint32(payloadAt(data, off)[4:], off)
+			return data
+		}},
+		{"index lists more subkeys than key", func(t *testing.T, data []byte) []byte {
+			ri := payloadAt(data, findCell(t, data, "ri", ""))
+			copy(ri[4:8], ri[8:12]) // both entries refer to the two-entry li
+			return data
+		}},
+		{"index count exceeds cell", func(t *testing.T, data []byte) []byte {
+			lh := payloadAt(data, findCell(t, data, "lh", ""))
+			binary.LittleEndian.PutUint16(lh[2:], 0xFFFF)
+			return data
+		}},
+		{"value count exceeds list", func(t *testing.T, data []byte) []byte {
+			nk := payloadAt(data, findCell(t, data, "nk", "Chrome"))
+			binary.LittleEndian.PutUint32(nk[36:], 0x10000000)
+			return data
+		}},
+		{"inline data too long", func(t *testing.T, data []byte) []byte {
+			vk := payloadAt(data, findCell(t, data, "vk", "Dword"))
+			binary.LittleEndian.PutUint32(vk[4:], 0x80000008)
+			return data
+		}},
+		{"value data exceeds cell", func(t *testing.T, data []byte) []byte {
+			vk := payloadAt(data, findCell(t, data, "vk", "Binary"))
+			binary.LittleEndian.PutUint32(vk[4:], 0x1000)
+			return data
+		}},
+		{"big data larger than its segments", func(t *testing.T, data []byte) []byte {
+			vk := payloadAt(data, findCell(t, data, "vk", "Big"))
+			binary.LittleEndian.PutUint32(vk[4:], 0x7FFFFFFF)
+			return data
+		}},
+		{"big data segments exceed list", func(t *testing.T, data []byte) []byte {
+			db := payloadAt(data, findCell(t, data, "db", ""))
+			binary.LittleEndian.PutUint16(db[2:], 0xFFFF)
+			return data
+		}},
+		{"big data with too few segments", func(t *testing.T, data []byte) []byte {
+			db := payloadAt(data, findCell(t, data, "db", ""))
+			binary.LittleEndian.PutUint16(db[2:], 2)
+			return data
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := captureDamaged(tt.damage(t, readFixture(t))); err == nil {
+				t.Error("damaged hive read without error")
+			}
+		})
+	}
+}
+
+// TestTruncated cuts the fixture at every 8 bytes of its cells. The root key
+// is the last cell, so every cut must fail.
+func TestTruncated(t *testing.T) {
+	data := readFixture(t)
+	root := baseBlockSize + int(binary.LittleEndian.Uint32(data[0x24:]))
+	end := root - int(int32(binary.LittleEndian.Uint32(data[root:])))
+	for n := 0; n < end; n += 8 {
+		if err := captureDamaged(data[:n]); err == nil {
+			t.Fatalf("hive truncated to %d bytes read without error", n)
+		}
+	}
+}
+
+// captureDamaged parses data and captures the whole mounted hive.
+func captureDamaged(data []byte) error {
+	h, err := Parse(data, "SOFTWARE")
+	if err != nil {
+		return err
+	}
+	_, err = registry.CaptureState(h, "SOFTWARE")
+	return err
+}
@@ -0,0 +1,311 @@
+package intel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// THREAT INTEL - Local feed of known malicious extension IDs
+// ============================================================================
+
+// Severity ranks how dangerous a known-bad extension is.
+type Severity string
+
+const (
+	Low      Severity = "low"
+	Medium   Severity = "medium"
+	High     Severity = "high"
+	Critical Severity = "critical"
+)
+
+// severityRank orders severities; unknown severities rank 0.
+var severityRank = map[Severity]int{Low: 1, Medium: 2, High: 3, Critical: 4}
+
+// ParseSeverity parses a severity name. An empty name means High, since
+// every feed entry is known to be malicious.
+func ParseSeverity(s string) (Severity, error) {
+	sev := Severity(strings.ToLower(strings.TrimSpace(s)))
+	if sev == "" {
+		return High, nil
+	}
+	if _, ok := severityRank[sev]; !ok {
+		return "", fmt.Errorf("unknown severity %q (want %s, %s, %s or %s)", s, Low, Medium, High, Critical)
+	}
+	return sev, nil
+}
+
+// AtLeast reports whether s is as severe as o.
+func (s Severity) AtLeast(o Severity) bool {
+	return severityRank[s] >= severityRank[o]
+}
+
+// Entry is one known-bad extension.
+type Entry struct {
+	ID        string
+	Name      string
+	Severity  Severity
+	Source    string
+	FirstSeen time.Time // zero if unknown
+}
+
+// FirstSeenDate returns the first-seen date as YYYY-MM-DD, or "" if unknown.
+func (e Entry) FirstSeenDate() string {
+	if e.FirstSeen.IsZero() {
+		return ""
+	}
+	return e.FirstSeen.Format(time.DateOnly)
+}
+
+// String describes e for log output.
+func (e Entry) String() string {
+	parts := []string{"severity " + string(e.Severity)}
+	if e.Name != "" {
+		parts = append([]string{e.Name}, parts...)
+	}
+	if e.Source != "" {
+		parts = append(parts, "source "+e.Source)
+	}
+	if date := e.FirstSeenDate(); date != "" {
+		parts = append(parts, "first seen "+date)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// rawEntry is the on-disk form of an entry, shared by the JSON and CSV
+// readers.
+type rawEntry struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Severity  string `json:"severity"`
+	Source    string `json:"source"`
+	FirstSeen string `json:"first_seen"`
+}
+
+// entry validates r.
+func (r rawEntry) entry() (Entry, error) {
+	e := Entry{ID: strings.TrimSpace(r.ID), Name: strings.TrimSpace(r.Name), Source: strings.TrimSpace(r.Source)}
+	if e.ID == "" {
+		return Entry{}, fmt.Errorf("missing id")
+	}
+	sev, err := ParseSeverity(r.Severity)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%s: %w", e.ID, err)
+	}
+	e.Severity = sev
+	if date := strings.TrimSpace(r.FirstSeen); date != "" {
+		t, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, date); err != nil {
+				return Entry{}, fmt.Errorf("%s: first_seen %q: want YYYY-MM-DD or RFC 3339", e.ID, date)
+			}
+		}
+		e.FirstSeen = t
+	}
+	return e, nil
+}
+
+// Parse reads a feed. Files ending in .csv are read as CSV with a header
+// row naming the columns id, name, severity, source and first_seen; anything
+// else is read as JSON, either an array of entries or an object with an
+// "extensions" array.
+func Parse(name string, r io.Reader) ([]Entry, error) {
+	var raws []rawEntry
+	var err error
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		raws, err = parseCSV(r)
+	} else {
+		raws, err = parseJSON(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	entries := make([]Entry, 0, len(raws))
+	for i, raw := range raws {
+		e, err := raw.entry()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: entry %d: %w", name, i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseJSON(r io.Reader) ([]rawEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var raws []rawEntry
+	if err := json.Unmarshal(data, &raws); err == nil {
+		return raws, nil
+	}
+	var doc struct {
+		Extensions []rawEntry `json:"extensions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Extensions, nil
+}
+
+func parseCSV(r io.Reader) ([]rawEntry, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.NewReplacer("-", "_", " ", "_").Replace(name)
+		columns[name] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, fmt.Errorf("header has no id column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var raws []rawEntry
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return raws, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raws = append(raws, rawEntry{
+			ID:        field(record, "id"),
+			Name:      field(record, "name"),
+			Severity:  field(record, "severity"),
+			Source:    field(record, "source"),
+			FirstSeen: field(record, "first_seen"),
+		})
+	}
+}
+
+// Feed is a feed file loaded into memory. It is safe for concurrent use;
+// Reload swaps in the new entries atomically. A nil Feed is empty.
+type Feed struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]Entry // by lower-case ID
+	sum     [sha256.Size]byte
+}
+
+// Open loads the feed at path.
+func Open(path string) (*Feed, error) {
+	f := &Feed{path: path}
+	if _, err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the feed file path.
+func (f *Feed) Path() string {
+	if f == nil {
+		return ""
+	}
+	return f.path
+}
+
+// load reads the file and swaps in its entries if its content changed.
+// The content is compared rather than the modification time and size,
+// which miss a rewrite of the same length within the file system's time
+// resolution.
+func (f *Feed) load() (bool, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(data)
+	f.mu.RLock()
+	unchanged := f.entries != nil && sum == f.sum
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	list, err := Parse(f.path, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+
+	entries := make(map[string]Entry, len(list))
+	for _, e := range list {
+		entries[strings.ToLower(e.ID)] = e
+	}
+	f.mu.Lock()
+	f.entries, f.sum = entries, sum
+	f.mu.Unlock()
+	return true, nil
+}
+
+// Reload re-reads the feed if the content of the file changed since it was
+// last loaded and reports whether it did. On error the previous entries are kept.
+func (f *Feed) Reload() (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	return f.load()
+}
+
+// Lookup returns the entry for extensionID, ignoring case.
+func (f *Feed) Lookup(extensionID string) (Entry, bool) {
+	if f == nil {
+		return Entry{}, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, ok := f.entries[strings.ToLower(strings.TrimSpace(extensionID))]
+	return e, ok
+}
+
+// Len returns the number of entries.
+func (f *Feed) Len() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.entries)
+}
+
+// Entries returns every entry, ordered by ID.
+func (f *Feed) Entries() []Entry {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	list := make([]Entry, 0, len(f.entries))
+	for _, e := range f.entries {
+		list = append(list, e)
+	}
+	f.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
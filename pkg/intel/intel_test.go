@@ -0,0 +1,164 @@
+package intel
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Extension IDs used by the fixtures.
+const (
+	idA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	idB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// date returns midnight UTC of the YYYY-MM-DD date s.
+func date(t *testing.T, s string) time.Time {
+	t.Helper()
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	want := []Entry{
+		{ID: idA, Name: "Evil Helper", Severity: Critical, Source: "vendor", FirstSeen: date(t, "2024-03-01")},
+		{ID: idB, Severity: High},
+	}
+
+	tests := []struct {
+		name string
+		file string
+		feed string
+	}{
+		{
+			name: "CSV",
+			file: "feed.csv",
+			feed: "id,name,severity,source,first_seen\n" +
+				idA + ",Evil Helper,critical,vendor,2024-03-01\n" +
+				idB + ",,,,\n",
+		},
+		{
+			name: "CSV with dashed header and comments",
+			file: "feed.CSV",
+			feed: "# exported by the SOC\n" +
+				"ID, Name, Severity, Source, First-Seen, Notes\n" +
+				"# first entry\n" +
+				idA + ", Evil Helper, Critical, vendor, 2024-03-01, seen in the wild\n" +
+				idB + "\n",
+		},
+		{
+			name: "CSV with spaced header",
+			file: "feed.csv",
+			feed: "first seen,id,severity,name,source\n" +
+				"2024-03-01T00:00:00Z," + idA + ",critical,Evil Helper,vendor\n" +
+				"," + idB + ",,,\n",
+		},
+		{
+			name: "JSON array",
+			file: "feed.json",
+			feed: `[
+  {"id": "` + idA + `", "name": "Evil Helper", "severity": "critical", "source": "vendor", "first_seen": "2024-03-01"},
+  {"id": "` + idB + `"}
+]`,
+		},
+		{
+			name: "JSON object",
+			file: "feed.json",
+			feed: `{"extensions": [
+  {"id": "` + idA + `", "name": "Evil Helper", "severity": "CRITICAL", "source": "vendor", "first_seen": "2024-03-01"},
+  {"id": "` + idB + `", "severity": ""}
+]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.file, strings.NewReader(tt.feed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Parse() = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		feed string
+		want string
+	}{
+		{"bad date", "feed.json", `[{"id": "` + idA + `", "first_seen": "03/01/2024"}]`, `first_seen "03/01/2024"`},
+		{"bad severity", "feed.json", `[{"id": "` + idA + `", "severity": "urgent"}]`, `unknown severity "urgent"`},
+		{"bad CSV severity", "feed.csv", "id,severity\n" + idA + ",urgent\n", `unknown severity "urgent"`},
+		{"missing id", "feed.json", `[{"name": "Evil Helper"}]`, "entry 1: missing id"},
+		{"no id column", "feed.csv", "name,severity\nEvil Helper,high\n", "no id column"},
+		{"not JSON", "feed.json", `id,severity`, "parsing feed.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.file, strings.NewReader(tt.feed))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed.csv")
+	write := func(feed string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(feed), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	write("id,severity\n"+idA+",low\n", modTime)
+	feed, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := feed.Reload(); changed || err != nil {
+		t.Fatalf("Reload() of an unchanged feed = %v, %v, want false, nil", changed, err)
+	}
+
+	// A rewrite of the same size and modification time is still noticed.
+	write("id,severity\n"+idB+",low\n", modTime)
+	if changed, err := feed.Reload(); !changed || err != nil {
+		t.Fatalf("Reload() of a rewritten feed = %v, %v, want true, nil", changed, err)
+	}
+	if _, ok := feed.Lookup(strings.ToUpper(idB)); !ok || feed.Len() != 1 {
+		t.Errorf("reloaded feed lists %v, want only %s", feed.Entries(), idB)
+	}
+
+	write("id,severity\n"+idA+",urgent\n", modTime.Add(time.Minute))
+	if changed, err := feed.Reload(); changed || err == nil {
+		t.Fatalf("Reload() of a broken feed = %v, %v, want false and an error", changed, err)
+	}
+	if _, ok := feed.Lookup(idB); !ok || feed.Len() != 1 {
+		t.Errorf("feed lists %v after a failed reload, want the previous %s", feed.Entries(), idB)
+	}
+}
+
+func TestNilFeed(t *testing.T) {
+	var feed *Feed
+	if changed, err := feed.Reload(); changed || err != nil {
+		t.Errorf("Reload() = %v, %v, want false, nil", changed, err)
+	}
+	if _, ok := feed.Lookup(idA); ok || feed.Len() != 0 || feed.Entries() != nil {
+		t.Error("nil feed is not empty")
+	}
+}
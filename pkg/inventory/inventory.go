@@ -0,0 +1,261 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ============================================================================
+// EXTENSION POLICY INVENTORY - Every extension-related policy, by browser
+// ============================================================================
+
+// Settings of inventory entries for the list policies; ExtensionSettings
+// entries carry their installation_mode.
+const (
+	SettingForceInstalled = "force_installed"
+	SettingBlocked        = "blocked"
+	SettingAllowed        = "allowed"
+	SettingInstall        = "install"
+	SettingLocked         = "locked"
+	SettingConfigured     = "configured"
+)
+
+// Entry is one policy entry naming an extension.
+type Entry struct {
+	// Policy is the policy type, e.g. ExtensionInstallForcelist or
+	// Extensions\Locked.
+	Policy string `json:"policy"`
+	// Setting is what the entry does to the extension: an installation
+	// mode, blocked, allowed, locked, or configured for settings without
+	// one (e.g. 3rdparty extension policy).
+	Setting   string `json:"setting"`
+	UpdateURL string `json:"updateUrl,omitempty"`
+	// Source is the registry path of the entry, relative to
+	// HKLM\SOFTWARE\Policies, or the path of a policies.json file.
+	Source string `json:"source"`
+}
+
+// Blocks reports whether e blocks its extension.
+func (e Entry) Blocks() bool {
+	return e.Setting == SettingBlocked
+}
+
+// Extension groups the entries naming one extension ID. ID is "*" for
+// entries applying to every extension and empty for entries naming no ID,
+// e.g. a Firefox Extensions\Install URL.
+type Extension struct {
+	ID      string  `json:"id"`
+	Entries []Entry `json:"entries"`
+}
+
+// Browser groups the extensions of one browser.
+type Browser struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Extensions []Extension `json:"extensions"`
+}
+
+// Inventory is every extension-related policy found, grouped by browser and
+// extension ID.
+type Inventory struct {
+	Browsers []Browser `json:"browsers"`
+}
+
+// Add records entry for extensionID of the browser with the given ID and
+// display name. An entry already recorded is not added twice.
+func (inv *Inventory) Add(browserID, browserName, extensionID string, entry Entry) {
+	b := inv.browser(browserID, browserName)
+	ext := b.extension(extensionID)
+	for _, e := range ext.Entries {
+		if e == entry {
+			return
+		}
+	}
+	ext.Entries = append(ext.Entries, entry)
+}
+
+func (inv *Inventory) browser(id, name string) *Browser {
+	for i := range inv.Browsers {
+		if inv.Browsers[i].ID == id {
+			return &inv.Browsers[i]
+		}
+	}
+	inv.Browsers = append(inv.Browsers, Browser{ID: id, Name: name})
+	return &inv.Browsers[len(inv.Browsers)-1]
+}
+
+func (b *Browser) extension(id string) *Extension {
+	for i := range b.Extensions {
+		if b.Extensions[i].ID == id {
+			return &b.Extensions[i]
+		}
+	}
+	b.Extensions = append(b.Extensions, Extension{ID: id})
+	return &b.Extensions[len(b.Extensions)-1]
+}
+
+// Sort orders browsers by name, extensions by ID and entries by policy and
+// source.
+func (inv *Inventory) Sort() {
+	sort.Slice(inv.Browsers, func(i, j int) bool { return inv.Browsers[i].Name < inv.Browsers[j].Name })
+	for _, b := range inv.Browsers {
+		sort.Slice(b.Extensions, func(i, j int) bool { return b.Extensions[i].ID < b.Extensions[j].ID })
+		for _, ext := range b.Extensions {
+			sort.Slice(ext.Entries, func(i, j int) bool {
+				if ext.Entries[i].Policy != ext.Entries[j].Policy {
+					return ext.Entries[i].Policy < ext.Entries[j].Policy
+				}
+				return ext.Entries[i].Source < ext.Entries[j].Source
+			})
+		}
+	}
+}
+
+// Len returns the number of entries in inv.
+func (inv *Inventory) Len() int {
+	n := 0
+	for _, b := range inv.Browsers {
+		for _, ext := range b.Extensions {
+			n += len(ext.Entries)
+		}
+	}
+	return n
+}
+
+// BlockedIDs returns the extension IDs blocked by an entry of any browser,
+// mapped to the sources of the blocking entries. Entries for every
+// extension ("*") and entries naming no ID are left out.
+func (inv *Inventory) BlockedIDs() map[string][]string {
+	blocked := make(map[string][]string)
+	for _, b := range inv.Browsers {
+		for _, ext := range b.Extensions {
+			if ext.ID == "" || ext.ID == "*" {
+				continue
+			}
+			for _, e := range ext.Entries {
+				if e.Blocks() {
+					blocked[ext.ID] = append(blocked[ext.ID], e.Source)
+				}
+			}
+		}
+	}
+	return blocked
+}
+
+// Format is an output format of the inventory.
+type Format string
+
+const (
+	// Table writes a plain-text table per browser.
+	Table Format = "table"
+	// JSON writes the inventory as one JSON document.
+	JSON Format = "json"
+	// Markdown writes a section with a table per browser.
+	Markdown Format = "markdown"
+)
+
+// ParseFormat parses a format name (case-insensitive); "md" is accepted for
+// Markdown.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", string(Table):
+		return Table, nil
+	case string(JSON):
+		return JSON, nil
+	case string(Markdown), "md":
+		return Markdown, nil
+	}
+	return "", fmt.Errorf("unknown inventory format %q (want table, json or markdown)", s)
+}
+
+// Write writes inv to w in format f.
+func (inv *Inventory) Write(w io.Writer, f Format) error {
+	switch f {
+	case Table:
+		return inv.writeTable(w)
+	case JSON:
+		return inv.writeJSON(w)
+	case Markdown:
+		return inv.writeMarkdown(w)
+	}
+	return fmt.Errorf("unknown inventory format %q", f)
+}
+
+func (inv *Inventory) writeJSON(w io.Writer) error {
+	out := *inv
+	// An empty inventory is written as [] rather than null.
+	if out.Browsers == nil {
+		out.Browsers = []Browser{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// displayID returns how an extension ID is shown in the table and Markdown.
+func displayID(id string) string {
+	if id == "" {
+		return "(no ID)"
+	}
+	return id
+}
+
+func (inv *Inventory) writeTable(w io.Writer) error {
+	if len(inv.Browsers) == 0 {
+		_, err := fmt.Fprintln(w, "No extension policies found.")
+		return err
+	}
+	for i, b := range inv.Browsers {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s (%s): %d extension(s)\n", b.Name, b.ID, len(b.Extensions))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  EXTENSION\tPOLICY\tSETTING\tUPDATE URL\tSOURCE")
+		for _, ext := range b.Extensions {
+			id := displayID(ext.ID)
+			for _, e := range ext.Entries {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", id, e.Policy, e.Setting, e.UpdateURL, e.Source)
+				id = ""
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownCell escapes s for a Markdown table cell.
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+func (inv *Inventory) writeMarkdown(w io.Writer) error {
+	fmt.Fprintln(w, "# Extension Policy Inventory")
+	if len(inv.Browsers) == 0 {
+		_, err := fmt.Fprintln(w, "\nNo extension policies found.")
+		return err
+	}
+	for _, b := range inv.Browsers {
+		fmt.Fprintf(w, "\n## %s\n\n", markdownCell(b.Name))
+		fmt.Fprintln(w, "| Extension | Policy | Setting | Update URL | Source |")
+		fmt.Fprintln(w, "|-----------|--------|---------|------------|--------|")
+		for _, ext := range b.Extensions {
+			id := "`" + displayID(ext.ID) + "`"
+			for _, e := range ext.Entries {
+				if _, err := fmt.Fprintf(w, "| %s | %s | %s | %s | `%s` |\n",
+					markdownCell(id), markdownCell(e.Policy), markdownCell(e.Setting),
+					markdownCell(e.UpdateURL), markdownCell(e.Source)); err != nil {
+					return err
+				}
+				id = ""
+			}
+		}
+	}
+	return nil
+}
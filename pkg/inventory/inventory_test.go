@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// update rewrites the golden files with the current output.
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Extension IDs used by the fixture.
+const (
+	idA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	idB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// testInventory returns an inventory of Chrome, Edge and Firefox policies,
+// added out of order and with a duplicate entry.
+func testInventory() *Inventory {
+	var inv Inventory
+	inv.Add("firefox", "Mozilla Firefox", "", Entry{Policy: `Extensions\Install`, Setting: SettingInstall, UpdateURL: "https://example.com/x.xpi", Source: `/etc/firefox/policies/policies.json`})
+	inv.Add("firefox", "Mozilla Firefox", "evil@example.com", Entry{Policy: `Extensions\Locked`, Setting: SettingLocked, Source: `Mozilla\Firefox\Extensions\Locked\1`})
+	inv.Add("chrome", "Google Chrome", idB, Entry{Policy: "ExtensionInstallBlocklist", Setting: SettingBlocked, Source: `Google\Chrome\ExtensionInstallBlocklist\1`})
+	inv.Add("chrome", "Google Chrome", idA, Entry{Policy: "ExtensionSettings", Setting: SettingForceInstalled, UpdateURL: "https://example.com/u.xml?a=1|2", Source: `Google\Chrome\ExtensionSettings`})
+	inv.Add("chrome", "Google Chrome", idA, Entry{Policy: "ExtensionInstallForcelist", Setting: SettingForceInstalled, UpdateURL: "https://example.com/u.xml", Source: `Google\Chrome\ExtensionInstallForcelist\1`})
+	inv.Add("chrome", "Google Chrome", idA, Entry{Policy: "ExtensionInstallForcelist", Setting: SettingForceInstalled, UpdateURL: "https://example.com/u.xml", Source: `Google\Chrome\ExtensionInstallForcelist\1`})
+	inv.Add("chrome", "Google Chrome", "*", Entry{Policy: "ExtensionInstallBlocklist", Setting: SettingBlocked, Source: `Google\Chrome\ExtensionInstallBlocklist\2`})
+	inv.Add("edge", "Microsoft Edge", idB, Entry{Policy: "3rdparty", Setting: SettingConfigured, Source: `Microsoft\Edge\3rdparty\extensions\` + idB + `\policy`})
+	inv.Add("edge", "Microsoft Edge", idB, Entry{Policy: "ExtensionInstallBlocklist", Setting: SettingBlocked, Source: `Microsoft\Edge\ExtensionInstallBlocklist\1`})
+	inv.Sort()
+	return &inv
+}
+
+// checkGolden compares got with the golden file testdata/name, or rewrites
+// the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		format Format
+		golden string
+	}{
+		{Table, "inventory.txt"},
+		{JSON, "inventory.json"},
+		{Markdown, "inventory.md"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := testInventory().Write(&out, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{Table, "No extension policies found.\n"},
+		{JSON, "{\n  \"browsers\": []\n}\n"},
+		{Markdown, "# Extension Policy Inventory\n\nNo extension policies found.\n"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var inv Inventory
+			var out bytes.Buffer
+			if err := inv.Write(&out, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("Write() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockedIDs(t *testing.T) {
+	inv := testInventory()
+	if n := inv.Len(); n != 8 {
+		t.Errorf("Len() = %d, want 8 without the duplicate", n)
+	}
+	want := map[string][]string{
+		idB: {`Google\Chrome\ExtensionInstallBlocklist\1`, `Microsoft\Edge\ExtensionInstallBlocklist\1`},
+	}
+	if got := inv.BlockedIDs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("BlockedIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]Format{"": Table, "table": Table, "JSON": JSON, "markdown": Markdown, "md": Markdown}
+	for in, want := range tests {
+		if got, err := ParseFormat(in); got != want || err != nil {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("csv"); err == nil {
+		t.Error("ParseFormat(csv) succeeded, want an error")
+	}
+}
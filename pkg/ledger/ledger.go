@@ -0,0 +1,201 @@
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/atomicfile"
+)
+
+// ============================================================================
+// OWNERSHIP LEDGER - Blocklist entries written by the guard, persisted to disk
+// ============================================================================
+
+// FormatVersion is written to every ledger file and checked on load.
+const FormatVersion = 1
+
+// Kind is the type of an owned entry.
+type Kind string
+
+const (
+	// Blocklist is an extension ID in a Chromium ExtensionInstallBlocklist
+	// key; Path is the blocklist key.
+	Blocklist Kind = "blocklist"
+	// GeckoBlocked is installation_mode=blocked in a Firefox
+	// ExtensionSettings\{id} key; Path is the browser's policy root.
+	GeckoBlocked Kind = "gecko-blocked"
+)
+
+// Entry is one policy entry the guard wrote. Paths are relative to
+// HKLM\SOFTWARE\Policies.
+type Entry struct {
+	Kind        Kind      `json:"kind"`
+	Browser     string    `json:"browser"`
+	Path        string    `json:"path"`
+	ExtensionID string    `json:"extensionId"`
+	Written     time.Time `json:"written"`
+	// Restored counts how often the entry was restored after tampering.
+	Restored     int       `json:"restored,omitempty"`
+	LastRestored time.Time `json:"lastRestored,omitzero"`
+}
+
+// key identifies an entry regardless of path and ID casing.
+func (e Entry) key() string {
+	return string(e.Kind) + "|" + strings.ToLower(e.Path) + "|" + strings.ToLower(e.ExtensionID)
+}
+
+// file is the on-disk format.
+type file struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// Ledger is a ledger file loaded into memory. Every change is saved
+// immediately. It is safe for concurrent use; a nil Ledger owns nothing.
+type Ledger struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// Open loads the ledger at path. A missing file is an empty ledger.
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{path: path, entries: make(map[string]Entry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing ledger %s: %w", path, err)
+	}
+	if f.Version != FormatVersion {
+		return nil, fmt.Errorf("ledger %s: unsupported version %d", path, f.Version)
+	}
+	for _, e := range f.Entries {
+		l.entries[e.key()] = e
+	}
+	return l, nil
+}
+
+// Path returns the ledger file path.
+func (l *Ledger) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Add records that the guard wrote e and saves the ledger. Entries already
+// owned keep their original Written time.
+func (l *Ledger) Add(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.entries[e.key()]; ok {
+		return nil
+	}
+	if e.Written.IsZero() {
+		e.Written = time.Now().UTC()
+	}
+	l.entries[e.key()] = e
+	return l.save()
+}
+
+// Remove drops e from the ledger and saves it, once the guard no longer
+// owns the entry. Entries not in the ledger are ignored.
+func (l *Ledger) Remove(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.entries[e.key()]; !ok {
+		return nil
+	}
+	delete(l.entries, e.key())
+	return l.save()
+}
+
+// MarkRestored counts a restoration of e and saves the ledger.
+func (l *Ledger) MarkRestored(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	owned, ok := l.entries[e.key()]
+	if !ok {
+		return nil
+	}
+	owned.Restored++
+	owned.LastRestored = time.Now().UTC()
+	l.entries[e.key()] = owned
+	return l.save()
+}
+
+// Owns reports whether e is in the ledger.
+func (l *Ledger) Owns(e Entry) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.entries[e.key()]
+	return ok
+}
+
+// Len returns the number of owned entries.
+func (l *Ledger) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// Entries returns every owned entry, ordered by path and extension ID.
+func (l *Ledger) Entries() []Entry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	list := make([]Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		list = append(list, e)
+	}
+	l.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	return list
+}
+
+// save writes the ledger atomically, like snapshot.Save. l.mu must be held.
+func (l *Ledger) save() error {
+	f := file{Version: FormatVersion, Entries: make([]Entry, 0, len(l.entries))}
+	for _, e := range l.entries {
+		f.Entries = append(f.Entries, e)
+	}
+	sort.Slice(f.Entries, func(i, j int) bool { return f.Entries[i].key() < f.Entries[j].key() })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding ledger: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(l.path, data, 0o600); err != nil {
+		return fmt.Errorf("saving ledger: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/ledger"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/policyfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// ============================================================================
+// APPLY - The only writer of the policies
+// ============================================================================
+
+// Enforce applies the plan of sim to b and returns the state after it. The
+// plan is only printed when there is nothing to apply, when canWrite is
+// false, or when b cannot be written; the state is then sim.Before, as
+// nothing changed.
+func (e *Engine) Enforce(ctx context.Context, b registry.Backend, keyPath string, sim *Simulation, canWrite bool) *registry.RegState {
+	if sim.Plan.Len() == 0 {
+		return sim.Before
+	}
+	if !canWrite || !b.HasWriteAccess() {
+		if canWrite {
+			telemetry.Printf(ctx, "  ❌ Insufficient privileges. Run as Administrator.\n")
+		}
+		PrintPlan(ctx, sim.Plan)
+		return sim.Before
+	}
+
+	PrintResults(ctx, e.ApplyPlan(ctx, b, keyPath, sim.Plan))
+	if err := reload(b); err != nil {
+		telemetry.RecordError(ctx, err)
+		return sim.After
+	}
+	state, err := CaptureRegistryState(ctx, b, keyPath)
+	if err != nil {
+		return sim.After
+	}
+	return state
+}
+
+// ApplyPlan makes the changes of p in b in order and returns the outcome of
+// each action. Actions whose change is already made are skipped, so a plan
+// can be applied again after a partial failure. A failed action does not
+// stop the ones after it.
+func (e *Engine) ApplyPlan(ctx context.Context, b registry.Backend, keyPath string, p *plan.Plan) []plan.Result {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.ApplyPlan",
+		attribute.String("key-path", keyPath),
+		attribute.Int("actions", p.Len()),
+	)
+	defer span.End()
+
+	results := p.Results()
+	for i := range results {
+		a := results[i].Action
+		status, err := e.applyAction(ctx, b, keyPath, a)
+		if err != nil {
+			status = plan.Failed
+			results[i].Error = err.Error()
+			telemetry.RecordError(ctx, err)
+		}
+		results[i].Status = status
+		if status == plan.Applied {
+			recordApplied(ctx, a)
+		}
+		telemetry.AddEvent(ctx, "action-applied",
+			attribute.String("kind", string(a.Kind)),
+			attribute.String("path", a.Path),
+			attribute.String("status", string(status)),
+		)
+	}
+
+	span.SetAttributes(
+		attribute.Int("applied", plan.Count(results, plan.Applied)),
+		attribute.Int("skipped", plan.Count(results, plan.Skipped)),
+		attribute.Int("failed", plan.Count(results, plan.Failed)),
+	)
+	return results
+}
+
+// recordApplied records the metrics of the applied action a.
+func recordApplied(ctx context.Context, a plan.Action) {
+	switch a.Kind {
+	case plan.AddBlocklistEntry, plan.SetFirefoxBlocked:
+		telemetry.RecordExtensionBlocked(ctx, a.Browser, a.ExtensionID)
+	}
+	if hit, ok := hijack.Match(a.Path); ok && a.Detector == hit.Detector.Policy {
+		action := hijack.Remove
+		if a.Kind == plan.SetValue {
+			action = hijack.Reset
+		}
+		telemetry.RecordPolicyRemediated(ctx, hit.Browser.ID, hit.Detector.Policy, string(action))
+	}
+}
+
+// applyAction makes the change of a unless it is already made.
+func (e *Engine) applyAction(ctx context.Context, b registry.Backend, keyPath string, a plan.Action) (plan.Status, error) {
+	switch a.Kind {
+	case plan.DeleteKey:
+		return e.applyKeyDeletion(ctx, b, keyPath, a)
+	case plan.DeleteValue:
+		return e.applyValueDeletion(ctx, b, keyPath, a)
+	case plan.SetValue:
+		return e.applyValue(ctx, b, keyPath, a)
+
+	case plan.AddBlocklistEntry:
+		ids, err := readBlocklistIDs(b, keyPath, a.Path)
+		if err != nil {
+			return plan.Failed, err
+		}
+		if slices.Contains(ids, a.ExtensionID) {
+			return plan.Skipped, nil
+		}
+		if err := registry.AddToBlocklist(b, keyPath, a.Path, a.ExtensionID); err != nil {
+			return plan.Failed, err
+		}
+		e.own(ctx, ledger.Entry{Kind: ledger.Blocklist, Browser: a.Browser, Path: a.Path, ExtensionID: a.ExtensionID})
+		return plan.Applied, nil
+
+	case plan.SetFirefoxBlocked:
+		policyRoot := detection.GetPolicyRootFromPath(a.Path)
+		if policyRoot == "" {
+			return plan.Failed, fmt.Errorf("no browser policy root in %s", a.Path)
+		}
+		if geckoBlocked(b, keyPath, policyRoot, a.ExtensionID) {
+			return plan.Skipped, nil
+		}
+		if a.Quarantine && keyExists(b, keyPath, a.Path) {
+			if err := e.quarantineKey(ctx, b, keyPath, a.Path); err != nil {
+				return plan.Failed, fmt.Errorf("quarantine failed, install policy kept: %w", err)
+			}
+		}
+		if err := registry.BlockGeckoExtension(b, keyPath, policyRoot, a.ExtensionID); err != nil {
+			return plan.Failed, err
+		}
+		e.own(ctx, ledger.Entry{Kind: ledger.GeckoBlocked, Browser: a.Browser, Path: policyRoot, ExtensionID: a.ExtensionID})
+		return plan.Applied, nil
+
+	case plan.RemoveAllowlistValue:
+		if !allowlistContains(b, keyPath, a.Path, a.ExtensionID) {
+			return plan.Skipped, nil
+		}
+		if err := registry.RemoveFromAllowlist(b, keyPath, a.Path, a.ExtensionID); err != nil {
+			return plan.Failed, err
+		}
+		return plan.Applied, nil
+
+	case plan.RemoveExtensionSettings:
+		switch {
+		case a.Value != nil:
+			return e.applyValue(ctx, b, keyPath, a)
+		case detection.IsExtensionSettingsValue(a.Path):
+			return e.applyValueDeletion(ctx, b, keyPath, a)
+		default:
+			return e.applyKeyDeletion(ctx, b, keyPath, a)
+		}
+
+	case plan.ReleaseEntry:
+		return e.release(ctx, a)
+	case plan.RewritePolicyFile:
+		return e.rewritePolicyFile(ctx, a)
+	}
+	return plan.Failed, fmt.Errorf("unknown action kind %q", a.Kind)
+}
+
+// applyKeyDeletion deletes the key of a recursively, quarantining it first
+// if a asks for it.
+func (e *Engine) applyKeyDeletion(ctx context.Context, b registry.Backend, keyPath string, a plan.Action) (plan.Status, error) {
+	if !keyExists(b, keyPath, a.Path) {
+		return plan.Skipped, nil
+	}
+	if a.Quarantine {
+		if err := e.quarantineKey(ctx, b, keyPath, a.Path); err != nil {
+			return plan.Failed, fmt.Errorf("quarantine failed, key kept: %w", err)
+		}
+	}
+	if err := registry.DeleteRegistryKeyRecursive(b, keyPath, a.Path); err != nil {
+		return plan.Failed, err
+	}
+	return plan.Applied, nil
+}
+
+// applyValueDeletion deletes the value of a, quarantining it first if a
+// asks for it.
+func (e *Engine) applyValueDeletion(ctx context.Context, b registry.Backend, keyPath string, a plan.Action) (plan.Status, error) {
+	value, ok := readValue(b, keyPath, a.Path)
+	if !ok {
+		return plan.Skipped, nil
+	}
+	if a.Quarantine {
+		if err := e.quarantineValue(ctx, keyPath, a.Path, value); err != nil {
+			return plan.Failed, fmt.Errorf("quarantine failed, value kept: %w", err)
+		}
+	}
+	if err := registry.DeletePolicyValue(b, keyPath, a.Path); err != nil {
+		return plan.Failed, err
+	}
+	return plan.Applied, nil
+}
+
+// applyValue writes the value of a, quarantining the value it replaces if a
+// asks for it.
+func (e *Engine) applyValue(ctx context.Context, b registry.Backend, keyPath string, a plan.Action) (plan.Status, error) {
+	if a.Value == nil {
+		return plan.Failed, fmt.Errorf("no value to write to %s", a.Path)
+	}
+	current, ok := readValue(b, keyPath, a.Path)
+	if ok && current.Equal(*a.Value) {
+		return plan.Skipped, nil
+	}
+	if ok && a.Quarantine {
+		if err := e.quarantineValue(ctx, keyPath, a.Path, current); err != nil {
+			return plan.Failed, fmt.Errorf("quarantine failed, value kept: %w", err)
+		}
+	}
+	if _, err := registry.SetPolicyValue(b, keyPath, a.Path, a.Value.Type, a.Value.Raw); err != nil {
+		return plan.Failed, err
+	}
+	return plan.Applied, nil
+}
+
+// own records the guard-written entry owned in the ownership ledger, or
+// counts its restoration if it was owned already.
+func (e *Engine) own(ctx context.Context, owned ledger.Entry) {
+	var err error
+	if e.Ledger.Owns(owned) {
+		err = e.Ledger.MarkRestored(owned)
+		telemetry.RecordTamperRestored(ctx, owned.Browser, string(owned.Kind))
+	} else {
+		err = e.Ledger.Add(owned)
+	}
+	if err != nil {
+		telemetry.Printf(ctx, "  ⚠️  Could not update ownership ledger %s: %v\n", e.Ledger.Path(), err)
+		telemetry.RecordError(ctx, err)
+	}
+}
+
+// release drops the owned entry of a from the ledger because current policy
+// allows the extension, so the entry is no longer restored.
+func (e *Engine) release(ctx context.Context, a plan.Action) (plan.Status, error) {
+	i := slices.IndexFunc(e.Ledger.Entries(), func(owned ledger.Entry) bool {
+		return strings.EqualFold(ownedKeyPath(owned), a.Path) && owned.ExtensionID == a.ExtensionID
+	})
+	if i < 0 {
+		return plan.Skipped, nil
+	}
+	owned := e.Ledger.Entries()[i]
+	telemetry.Printf(ctx, "  🔓 Releasing guard-written %s entry for %s: %s\n", owned.Kind, owned.ExtensionID, a.Reason)
+	telemetry.AddEvent(ctx, "ownership-released",
+		attribute.String("browser", owned.Browser),
+		attribute.String("kind", string(owned.Kind)),
+		attribute.String("path", a.Path),
+		attribute.String("extension.id", owned.ExtensionID),
+		attribute.String("rule", a.Reason),
+	)
+	if err := e.Ledger.Remove(owned); err != nil {
+		return plan.Failed, fmt.Errorf("updating ownership ledger %s: %w", e.Ledger.Path(), err)
+	}
+	return plan.Applied, nil
+}
+
+// rewritePolicyFile remediates the install policies of the Firefox
+// policies.json file of a with the policies.json action mode, copying the
+// file to quarantine first if a asks for it.
+func (e *Engine) rewritePolicyFile(ctx context.Context, a plan.Action) (plan.Status, error) {
+	f, err := policyfile.Load(a.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return plan.Skipped, nil
+	}
+	if err != nil {
+		return plan.Failed, err
+	}
+	findings, err := f.Findings()
+	if err != nil {
+		return plan.Failed, err
+	}
+	exempt := func(finding policyfile.Finding) bool {
+		_, ok := e.matchPolicyFileExemption(finding)
+		return ok
+	}
+	findings = slices.DeleteFunc(findings, exempt)
+	if len(findings) == 0 {
+		return plan.Skipped, nil
+	}
+	if a.Quarantine {
+		if err := e.quarantineFile(ctx, a.Path); err != nil {
+			return plan.Failed, fmt.Errorf("quarantine failed, %s kept: %w", a.Path, err)
+		}
+	}
+	action := e.settingsAction(e.effectiveMode(DetectorPolicyFile))
+	if _, err := f.Remediate(action, exempt); err != nil {
+		return plan.Failed, err
+	}
+	if err := policyfile.Save(f); err != nil {
+		return plan.Failed, err
+	}
+	for _, finding := range findings {
+		if finding.ExtensionID != "" && action == extsettings.ActionBlock {
+			telemetry.RecordExtensionBlocked(ctx, a.Browser, finding.ExtensionID)
+		}
+	}
+	return plan.Applied, nil
+}
+
+// keyExists reports whether the key at relPath exists.
+func keyExists(b registry.Backend, keyPath, relPath string) bool {
+	return b.OpenKey(pathutils.BuildPath(keyPath, relPath)) == nil
+}
+
+// readValue reads the value at valuePath, whose last component is the value
+// name.
+func readValue(b registry.Backend, keyPath, valuePath string) (registry.RegValue, bool) {
+	parent, ok := pathutils.GetParentPath(valuePath)
+	if !ok {
+		return registry.RegValue{}, false
+	}
+	values, err := registry.ReadKeyValues(b, keyPath, parent)
+	if err != nil {
+		return registry.RegValue{}, false
+	}
+	value, ok := values[pathutils.GetKeyName(valuePath)]
+	return value, ok
+}
+
+// allowlistContains reports whether a value of the allowlist key at
+// allowlistPath lists extensionID.
+func allowlistContains(b registry.Backend, keyPath, allowlistPath, extensionID string) bool {
+	values, err := registry.ReadKeyValues(b, keyPath, allowlistPath)
+	if err != nil {
+		return false
+	}
+	return listsID(values, extensionID)
+}
+
+// readBlocklistIDs returns the extension IDs in the blocklist key at
+// blocklistPath. A missing key has none.
+func readBlocklistIDs(b registry.Backend, keyPath, blocklistPath string) ([]string, error) {
+	values, err := registry.ReadKeyValues(b, keyPath, blocklistPath)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, value := range values {
+		ids = append(ids, registry.ExtensionIDs(value)...)
+	}
+	return ids, nil
+}
+
+// geckoBlocked reports whether extensionID is already blocked through the
+// ExtensionSettings policy below policyRoot.
+func geckoBlocked(b registry.Backend, keyPath, policyRoot, extensionID string) bool {
+	values, err := registry.ReadKeyValues(b, keyPath, detection.GetGeckoBlocklistPath(policyRoot, extensionID))
+	if err != nil {
+		return false
+	}
+	mode, ok := values["installation_mode"]
+	return ok && mode.Unexpanded() == "blocked"
+}
@@ -0,0 +1,157 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// ============================================================================
+// DEBOUNCE - Coalescing bursts of change notifications
+// ============================================================================
+
+// Debounce controls how the watchers coalesce change notifications. A GPO
+// refresh or a policy tool touches many keys or files in a burst; the burst
+// is processed with a single capture and diff once it ends.
+type Debounce struct {
+	// Quiet is how long no notification must arrive before a burst ends.
+	Quiet time.Duration
+	// MaxLatency bounds how long after its first notification a burst is
+	// processed, even while notifications keep arriving. 0 means no bound.
+	MaxLatency time.Duration
+}
+
+// DefaultDebounce is the debounce of the engines NewEngine returns.
+var DefaultDebounce = Debounce{Quiet: 500 * time.Millisecond, MaxLatency: 5 * time.Second}
+
+// Validate reports whether d has usable durations.
+func (d Debounce) Validate() error {
+	if d.Quiet < 0 {
+		return fmt.Errorf("debounce quiet period must not be negative: %s", d.Quiet)
+	}
+	if d.MaxLatency < 0 {
+		return fmt.Errorf("debounce maximum latency must not be negative: %s", d.MaxLatency)
+	}
+	return nil
+}
+
+// coalesce waits for a notification on notify and then for the burst it
+// starts to end, passing every notification to add. The burst ends once d.Quiet
+// passed without a notification, or d.MaxLatency after it started. It
+// returns the number of notifications in the burst or, if a timer of t fired
+// before the first one, 0 and its tick. It returns the first error received
+// on errs or from ctx.
+func coalesce[T any](ctx context.Context, d Debounce, source string, notify <-chan T, errs <-chan error, t timers, add func(T)) (int, tick, error) {
+	quiet := time.NewTimer(d.Quiet)
+	quiet.Stop()
+	defer quiet.Stop()
+	var quietC, deadline <-chan time.Time
+
+	n := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return n, noTick, ctx.Err()
+		case err := <-errs:
+			return n, noTick, err
+		case <-t.reconcile:
+			return n, reconcileTick, nil
+		case <-t.intel:
+			return n, intelTick, nil
+		case v := <-notify:
+			n++
+			// A tick during the burst stays pending for the next call.
+			t = timers{}
+			telemetry.RecordNotificationReceived(ctx, source)
+			if add != nil {
+				add(v)
+			}
+			quiet.Reset(d.Quiet)
+			quietC = quiet.C
+			if n == 1 && d.MaxLatency > 0 {
+				latency := time.NewTimer(d.MaxLatency)
+				defer latency.Stop()
+				deadline = latency.C
+			}
+		case <-quietC:
+			return n, noTick, nil
+		case <-deadline:
+			return n, noTick, nil
+		}
+	}
+}
+
+// processChanges re-captures the state below keyPath after a burst of
+// notifications notifications and handles its differences to previousState
+// with handleChanges. It returns the state to diff the next burst against.
+func (e *Engine) processChanges(ctx context.Context, b registry.Backend, keyPath, source string, notifications int, previousState *registry.RegState, canWrite bool, onChange ChangeHandler, extra ...Pass) *registry.RegState {
+	if notifications > 1 {
+		telemetry.Printf(ctx, "🔀 Coalesced %d change notifications into one capture\n", notifications)
+	}
+	newState, err := CaptureRegistryState(ctx, b, keyPath)
+	telemetry.RecordCapturePerformed(ctx, source)
+	if err != nil {
+		telemetry.Println(ctx, "Error capturing new state:", err)
+		telemetry.RecordError(ctx, err)
+		return previousState
+	}
+	state, changes := e.handleChanges(ctx, b, keyPath, previousState, newState, canWrite, extra...)
+	if onChange != nil {
+		onChange(ctx, state, changes)
+	}
+	telemetry.AddEvent(ctx, "changes-processed",
+		attribute.String("source", source),
+		attribute.Int("notifications", notifications),
+		attribute.Int("changes", len(changes)),
+	)
+	return state
+}
+
+// handleChanges reports the differences between previousState and newState
+// with PrintDiff, plans their remediation with the change passes and extra,
+// and enforces the plan. It returns the state after enforcement and the
+// changes.
+func (e *Engine) handleChanges(ctx context.Context, b registry.Backend, keyPath string, previousState, newState *registry.RegState, canWrite bool, extra ...Pass) (*registry.RegState, []registry.Change) {
+	changes := registry.Diff(previousState, newState)
+	// Extra passes run on a schedule, not on a change; an empty diff is
+	// only worth reporting for a notification.
+	if len(changes) > 0 || len(extra) == 0 {
+		PrintDiff(ctx, previousState, newState, keyPath)
+	}
+	sim := e.Plan(ctx, newState, append(e.changePasses(changes), extra...)...)
+	return e.Enforce(ctx, b, keyPath, sim, canWrite), changes
+}
+
+// changePasses returns the passes remediating changes: the install and
+// hijack policies they add, the blocklist/allowlist conflicts blocking a
+// forcelist creates, and the guard-owned entries they tamper with.
+func (e *Engine) changePasses(changes []registry.Change) []Pass {
+	passes := []Pass{func(ctx context.Context, state *registry.RegState) []plan.Action {
+		return e.RemediateChanges(ctx, changes, state)
+	}}
+	if addsForcelist(changes) && e.effectiveMode(DetectorForcelist).blocks() {
+		// Each comparison remains browser-local (Chrome vs Chrome, Edge vs
+		// Edge).
+		passes = append(passes, e.EnforceBlockAllowlistConsistency)
+	}
+	return append(passes, func(ctx context.Context, state *registry.RegState) []plan.Action {
+		return e.RestoreTamperedEntries(ctx, changes, state)
+	})
+}
+
+// addsForcelist reports whether changes add a Chromium forcelist value.
+func addsForcelist(changes []registry.Change) bool {
+	for _, change := range changes {
+		if change.Kind == registry.ValueAdded && detection.IsChromeExtensionForcelist(change.Path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDebounce ends a burst after 100ms without notifications, or 400ms
+// after it started.
+var testDebounce = Debounce{Quiet: 100 * time.Millisecond, MaxLatency: 400 * time.Millisecond}
+
+// coalesceResult is what coalesce returned, and after how long.
+type coalesceResult struct {
+	n       int
+	tick    tick
+	err     error
+	elapsed time.Duration
+}
+
+// startCoalesce runs coalesce on notify in the background.
+func startCoalesce(ctx context.Context, d Debounce, notify <-chan struct{}, errs <-chan error, t timers) <-chan coalesceResult {
+	done := make(chan coalesceResult, 1)
+	start := time.Now()
+	go func() {
+		var r coalesceResult
+		r.n, r.tick, r.err = coalesce(ctx, d, "test", notify, errs, t, nil)
+		r.elapsed = time.Since(start)
+		done <- r
+	}()
+	return done
+}
+
+func TestCoalesceBurst(t *testing.T) {
+	notify := make(chan struct{})
+	done := startCoalesce(testContext(), testDebounce, notify, nil, timers{})
+
+	for range 5 {
+		notify <- struct{}{}
+	}
+	burstEnd := time.Now()
+
+	r := <-done
+	if r.err != nil || r.tick != noTick {
+		t.Fatalf("coalesce() = %d, %v, %v, want a flush", r.n, r.tick, r.err)
+	}
+	if r.n != 5 {
+		t.Errorf("coalesce() flushed %d notifications, want all 5 of the burst", r.n)
+	}
+	// The last send returns about when coalesce restarts its quiet timer.
+	if quiet := time.Since(burstEnd); quiet < testDebounce.Quiet*9/10 {
+		t.Errorf("coalesce() flushed %v after the burst, want about the quiet period %v", quiet, testDebounce.Quiet)
+	}
+	if r.elapsed >= testDebounce.MaxLatency {
+		t.Errorf("coalesce() flushed after %v, want the quiet period to end the burst before %v", r.elapsed, testDebounce.MaxLatency)
+	}
+}
+
+func TestCoalesceSteadyStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(testContext())
+	defer cancel()
+	notify := make(chan struct{})
+	done := startCoalesce(ctx, testDebounce, notify, nil, timers{})
+
+	// Notifications arrive faster than the quiet period, so only the
+	// maximum latency can end the burst.
+	go func() {
+		ticker := time.NewTicker(testDebounce.Quiet / 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			select {
+			case notify <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil || r.tick != noTick {
+			t.Fatalf("coalesce() = %d, %v, %v, want a flush", r.n, r.tick, r.err)
+		}
+		if r.n < 2 {
+			t.Errorf("coalesce() flushed %d notification(s), want the stream coalesced", r.n)
+		}
+		if r.elapsed < testDebounce.MaxLatency {
+			t.Errorf("coalesce() flushed after %v, want the maximum latency %v", r.elapsed, testDebounce.MaxLatency)
+		}
+	case <-time.After(10 * testDebounce.MaxLatency):
+		t.Fatal("coalesce() did not flush a steady stream at the maximum latency")
+	}
+}
+
+func TestCoalesceTick(t *testing.T) {
+	reconcile := make(chan time.Time, 1)
+	reconcile <- time.Now()
+	r := <-startCoalesce(testContext(), testDebounce, make(chan struct{}), nil, timers{reconcile: reconcile})
+	if r.n != 0 || r.tick != reconcileTick || r.err != nil {
+		t.Errorf("coalesce() = %d, %v, %v, want the reconcile tick", r.n, r.tick, r.err)
+	}
+}
+
+func TestCoalesceError(t *testing.T) {
+	errs := make(chan error, 1)
+	errBroken := errors.New("notifications broken")
+	errs <- errBroken
+	r := <-startCoalesce(testContext(), testDebounce, make(chan struct{}), errs, timers{})
+	if !errors.Is(r.err, errBroken) {
+		t.Errorf("coalesce() error = %v, want %v", r.err, errBroken)
+	}
+}
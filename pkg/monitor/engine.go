@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/intel"
+	"github.com/kad/WindowsBrowserGuard/pkg/ledger"
+	"github.com/kad/WindowsBrowserGuard/pkg/quarantine"
+	"github.com/kad/WindowsBrowserGuard/pkg/trust"
+)
+
+// ============================================================================
+// ENGINE - Configuration of the enforcement passes and the watchers
+// ============================================================================
+
+// Engine holds the configuration the enforcement passes and the watchers
+// run with. The passes and watchers are its methods, so that several
+// engines, e.g. one per test, never share state. The zero value enforces
+// every detector, exempts nothing and has no watch timing; NewEngine sets
+// the default timing.
+type Engine struct {
+	// Modes selects the mode of each detector; nil enforces everywhere.
+	Modes ActionModes
+	// Exemptions lists the organization-approved extensions that are left
+	// in place. nil exempts nothing.
+	Exemptions *exemptions.Set
+	// UpdateURLRules decide forcelist entries by their update URL before
+	// exemptions are consulted. nil decides nothing.
+	UpdateURLRules *trust.Rules
+	// HijackActions selects what is done with each hijack policy; policies
+	// without an entry are only reported.
+	HijackActions hijack.Actions
+	// ExtensionSettingsAction is what the enforcing modes do to install
+	// entries of the ExtensionSettings policy; "" blocks them.
+	ExtensionSettingsAction extsettings.Action
+	// Intel lists known malicious extensions. nil disables the feed.
+	Intel *intel.Feed
+	// Ledger records the blocklist entries the guard wrote. nil disables
+	// ownership tracking and tamper protection.
+	Ledger *ledger.Ledger
+	// Quarantine receives the backups of quarantine mode; an empty Dir
+	// disables quarantine.
+	Quarantine quarantine.Store
+	// PolicyFiles are the Firefox policies.json files checked in addition
+	// to the policy tree.
+	PolicyFiles []string
+
+	// Debounce controls how the watchers coalesce change notifications.
+	Debounce Debounce
+	// ReconcileInterval is how often the watchers re-run the enforcement
+	// passes against a fresh capture; 0 disables reconciliation.
+	ReconcileInterval time.Duration
+	// PollInterval is how often state is captured when change
+	// notifications fail.
+	PollInterval time.Duration
+}
+
+// NewEngine returns an engine that enforces every detector, with the
+// default debounce, reconcile and poll intervals.
+func NewEngine() *Engine {
+	return &Engine{
+		ExtensionSettingsAction: extsettings.ActionBlock,
+		Debounce:                DefaultDebounce,
+		ReconcileInterval:       DefaultReconcileInterval,
+		PollInterval:            DefaultPollInterval,
+	}
+}
+
+// Validate reports whether the watch timing of e is usable.
+func (e *Engine) Validate() error {
+	if err := e.Debounce.Validate(); err != nil {
+		return err
+	}
+	if e.ReconcileInterval < 0 {
+		return fmt.Errorf("reconcile interval must not be negative: %s", e.ReconcileInterval)
+	}
+	if e.PollInterval <= 0 {
+		return fmt.Errorf("poll interval must be positive: %s", e.PollInterval)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"context"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// matchExemption returns the rule exempting extensionID, installed by the
+// policy at path from source, if any.
+func (e *Engine) matchExemption(path, source, extensionID, updateURL string) (exemptions.Rule, bool) {
+	return e.Exemptions.Match(exemptions.Candidate{
+		ID:        extensionID,
+		Browser:   detection.GetBrowserIDFromPath(path),
+		UpdateURL: updateURL,
+		Source:    source,
+	})
+}
+
+// matchFirefoxExemption checks a Firefox ExtensionSettings\{id} subkey
+// entry, identified by the path of its installation_mode value, using the
+// entry's install_url as update URL.
+func (e *Engine) matchFirefoxExemption(state *registry.RegState, modePath, extensionID string) (exemptions.Rule, bool) {
+	var installURL string
+	if entryPath, ok := pathutils.GetParentPath(modePath); ok {
+		installURL = state.Values[pathutils.BuildPath(entryPath, "install_url")].Unexpanded()
+	}
+	return e.matchExemption(modePath, browsers.ExtensionSettings, extensionID, installURL)
+}
+
+// unexemptedIDs returns the extension IDs of the ExtensionSettings install
+// entry found at path that no exemption covers, logging each exemption.
+func (e *Engine) unexemptedIDs(ctx context.Context, path string, entry extsettings.Entry) []string {
+	updateURL := entry.UpdateURL
+	if updateURL == "" {
+		updateURL = entry.InstallURL
+	}
+	var ids []string
+	for _, extensionID := range entry.IDs() {
+		if rule, ok := e.matchExemption(path, browsers.ExtensionSettings, extensionID, updateURL); ok {
+			logExemption(ctx, extensionID, rule)
+			continue
+		}
+		ids = append(ids, extensionID)
+	}
+	return ids
+}
+
+// logExemption reports an entry that is left in place.
+func logExemption(ctx context.Context, extensionID string, rule exemptions.Rule) {
+	telemetry.Printf(ctx, "  ✅ Exempted %s (rule: %s)\n", extensionID, rule)
+	telemetry.AddEvent(ctx, "extension-exempted",
+		attribute.String("extension.id", extensionID),
+		attribute.String("rule", rule.String()),
+	)
+}
+
+// forcelistPlan splits the entries of a forcelist key into the extensions to
+// block and the entries to keep.
+type forcelistPlan struct {
+	blockIDs []string        // sorted, unique, never kept
+	keep     map[string]bool // entries left in place
+}
+
+// planForcelist builds the forcelistPlan of the forcelist key at
+// forcelistKeyPath, logging the update URL rule or exemption deciding each
+// entry.
+func (e *Engine) planForcelist(ctx context.Context, forcelistKeyPath string, values map[string]registry.RegValue) forcelistPlan {
+	forcelist := forcelistPlan{keep: make(map[string]bool)}
+	keptIDs := make(map[string]bool)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		for _, entry := range values[name].Strings() {
+			v := e.decideForcelistEntry(forcelistKeyPath, entry)
+			if v.entry.ID == "" {
+				continue
+			}
+			if !seen[entry] {
+				v.log(ctx)
+				seen[entry] = true
+			}
+			if !v.keep {
+				forcelist.blockIDs = append(forcelist.blockIDs, v.entry.ID)
+				continue
+			}
+			forcelist.keep[entry] = true
+			keptIDs[v.entry.ID] = true
+		}
+	}
+
+	// Blocklisting an ID would also stop its kept entry from installing.
+	forcelist.blockIDs = slices.DeleteFunc(forcelist.blockIDs, func(id string) bool { return keptIDs[id] })
+	slices.Sort(forcelist.blockIDs)
+	forcelist.blockIDs = slices.Compact(forcelist.blockIDs)
+	return forcelist
+}
+
+// removes reports whether entry is removed from the forcelist.
+func (p forcelistPlan) removes(entry string) bool {
+	return !p.keep[entry]
+}
+
+// pruneForcelist plans removing only the entries of the forcelist key at
+// forcelistKeyPath that are not kept. values holds the key's values by name.
+func pruneForcelist(ctx context.Context, forcelistKeyPath string, values map[string]registry.RegValue, forcelist forcelistPlan, mode Mode) []plan.Action {
+	telemetry.Printf(ctx, "  ✂️  Keeping %d allowed entr(ies); removing the others from %s\n", len(forcelist.keep), forcelistKeyPath)
+	deleted, rewritten := registry.PruneForcelistValues(values, forcelist.removes)
+	c := cause{detector: DetectorForcelist, source: forcelistKeyPath, reason: "forcelist installs blocked extensions", quarantine: mode == Quarantine}
+	var actions []plan.Action
+	for _, name := range deleted {
+		actions = append(actions, c.action(plan.DeleteValue, pathutils.BuildPath(forcelistKeyPath, name), ""))
+	}
+	for _, value := range rewritten {
+		actions = append(actions, c.valueAction(plan.SetValue, pathutils.BuildPath(forcelistKeyPath, value.Name), value))
+	}
+	return actions
+}
@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// parseExtensionSettingsValue parses the JSON held by an ExtensionSettings
+// value. REG_MULTI_SZ values hold the JSON split across lines.
+func parseExtensionSettingsValue(value registry.RegValue) (extsettings.Settings, bool) {
+	if !value.IsString() && value.Type != detection.RegMultiSZ {
+		return nil, false
+	}
+	settings, err := extsettings.Parse(strings.Join(value.Strings(), "\n"))
+	if err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+// RemediateExtensionSettingsJSON plans the remediation of the JSON form of
+// ExtensionSettings at valuePath in state. For Chromium browsers every
+// extension it force- or normal-installs is blocklisted like a forcelist
+// entry; for every browser the install entry is blocked or removed
+// according to the ExtensionSettings action mode and the engine's
+// ExtensionSettingsAction.
+func (e *Engine) RemediateExtensionSettingsJSON(ctx context.Context, valuePath string, state *registry.RegState, index *registry.ExtensionPathIndex) []plan.Action {
+	d, _, ok := browsers.ForPath(valuePath)
+	if !ok {
+		return nil
+	}
+	value, ok := state.Values[valuePath]
+	if !ok {
+		return nil
+	}
+	settings, ok := parseExtensionSettingsValue(value)
+	if !ok {
+		return nil
+	}
+	// Exempted IDs stay installed, even when they share an entry with
+	// others.
+	var installed []extsettings.Entry
+	var targets [][]string
+	for _, entry := range settings.Installed() {
+		if ids := e.unexemptedIDs(ctx, valuePath, entry); len(ids) > 0 {
+			installed = append(installed, entry)
+			targets = append(targets, ids)
+		}
+	}
+	if len(installed) == 0 {
+		return nil
+	}
+
+	ctx, span := telemetry.StartSpan(ctx, "monitor.RemediateExtensionSettingsJSON",
+		attribute.String("path", valuePath),
+		attribute.String("browser", d.ID),
+		attribute.Int("entries", len(installed)),
+	)
+	defer span.End()
+
+	telemetry.Printf(ctx, "\n[%s EXTENSIONSETTINGS JSON POLICY]\n", strings.ToUpper(d.DisplayName))
+	telemetry.Printf(ctx, "  Path: %s\n", valuePath)
+	telemetry.Printf(ctx, "  ⚠️  DETECTED %s ExtensionSettings JSON with %d install entr(ies) - PROCESSING...\n", d.DisplayName, len(installed))
+	mode := e.resolveMode(ctx, DetectorExtensionSettings)
+	action := e.settingsAction(mode)
+
+	blocklistKeyPath := d.PolicyPath(browsers.ExtensionInstallBlocklist)
+	allowlistKeyPath := d.PolicyPath(browsers.ExtensionInstallAllowlist)
+	installedBy := cause{detector: DetectorExtensionSettings, source: valuePath, reason: "installed by ExtensionSettings"}
+
+	var actions []plan.Action
+	for i, entry := range installed {
+		for _, extensionID := range targets[i] {
+			telemetry.Printf(ctx, "  🔍 Extension ID: %s (%s)\n", extensionID, entry.Mode)
+			e.recordExtensionDetected(ctx, d.ID, extensionID)
+
+			// Gecko browsers have no separate blocklist; the rewritten entry
+			// blocks the extension, as SetFirefoxBlocked does for subkeys.
+			if d.Family != browsers.Chromium {
+				continue
+			}
+			if mode.blocks() {
+				actions = append(actions, blockChromium(ctx, state, blocklistKeyPath, allowlistKeyPath, extensionID, installedBy)...)
+			}
+			if mode.removes() {
+				actions = append(actions, settingsRemovals(index, extensionID, installedBy)...)
+			}
+		}
+		settings.ApplyIDs(entry.Key, targets[i], action)
+	}
+
+	telemetry.Printf(ctx, "  📝 Rewriting ExtensionSettings (%s install entries)\n", action)
+	c := installedBy
+	c.quarantine = mode == Quarantine
+	rewrite := c.action(plan.RemoveExtensionSettings, valuePath, "")
+	if len(settings) > 0 {
+		newValue, err := registry.EncodeExtensionSettings(value, settings)
+		if err != nil {
+			telemetry.Printf(ctx, "  ❌ Failed to rewrite ExtensionSettings: %v\n", err)
+			telemetry.RecordError(ctx, err)
+			return actions
+		}
+		newValue.Name = valuePath
+		rewrite.Value = &newValue
+	}
+	return append(actions, rewrite)
+}
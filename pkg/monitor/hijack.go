@@ -0,0 +1,108 @@
+package monitor
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// matchHijackValue returns the hijack policy that the value at valuePath is
+// a hit for.
+func matchHijackValue(valuePath string, value registry.RegValue) (hijack.Hit, bool) {
+	hit, ok := hijack.Match(valuePath)
+	if !ok || !hit.Suspicious(value.Type, value.Raw) {
+		return hijack.Hit{}, false
+	}
+	return hit, true
+}
+
+// FindHijackPolicies returns the hijack policies set in state, one hit per
+// policy value or key, ordered by target path.
+func FindHijackPolicies(state *registry.RegState) []hijack.Hit {
+	seen := make(map[string]bool)
+	var hits []hijack.Hit
+	for _, valuePath := range slices.Sorted(maps.Keys(state.Values)) {
+		hit, ok := matchHijackValue(valuePath, state.Values[valuePath])
+		if !ok || seen[strings.ToLower(hit.Target)] {
+			continue
+		}
+		seen[strings.ToLower(hit.Target)] = true
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Target < hits[j].Target })
+	return hits
+}
+
+// remediateHijackPolicy reports hit and plans its configured action:
+// removing the policy value or key, or resetting the value to the
+// detector's safe value.
+func (e *Engine) remediateHijackPolicy(ctx context.Context, hit hijack.Hit, state *registry.RegState) []plan.Action {
+	det := hit.Detector
+	action := e.HijackActions.For(det.Policy)
+
+	ctx, span := telemetry.StartSpan(ctx, "monitor.RemediateHijackPolicy",
+		attribute.String("path", hit.Target),
+		attribute.String("browser", hit.Browser.ID),
+		attribute.String("policy", det.Policy),
+		attribute.String("action", string(action)),
+	)
+	defer span.End()
+
+	telemetry.Printf(ctx, "\n[%s HIJACK POLICY DETECTED]\n", strings.ToUpper(hit.Browser.DisplayName))
+	telemetry.Printf(ctx, "Path: %s\n", hit.Target)
+	if value, ok := state.Values[hit.Target]; ok {
+		telemetry.Printf(ctx, "Value: %s\n", value.Data)
+	}
+	telemetry.Printf(ctx, "  ⚠️  %s: %s (action: %s)\n", det.Policy, det.Description, action)
+	telemetry.RecordPolicyDetected(ctx, hit.Browser.ID, det.Policy)
+
+	hijacked := cause{detector: det.Policy, reason: det.Policy + ": " + det.Description}
+	switch {
+	case action == hijack.Report:
+		return nil
+	case action == hijack.Reset && det.Safe != nil:
+		safe := registry.NewRegValue(hit.Target, det.Safe.Type, det.Safe.Raw())
+		return []plan.Action{hijacked.valueAction(plan.SetValue, hit.Target, safe)}
+	case det.Key:
+		telemetry.Printf(ctx, "🗑️  Deleting %s %s key: %s\n", hit.Browser.DisplayName, det.Policy, hit.Target)
+		return []plan.Action{hijacked.action(plan.DeleteKey, hit.Target, "")}
+	default:
+		return []plan.Action{hijacked.action(plan.DeleteValue, hit.Target, "")}
+	}
+}
+
+// ProcessHijackPolicies reports every hijack policy in state (home page,
+// startup pages, new tab page, search provider, proxy, developer tools, extension install
+// sources and types, Firefox SearchEngines and Homepage) and plans their
+// remediation.
+func (e *Engine) ProcessHijackPolicies(ctx context.Context, state *registry.RegState) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.ProcessHijackPolicies")
+	defer span.End()
+
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Println(ctx, "Checking for browser hijack policies...")
+	telemetry.Println(ctx, "========================================")
+
+	var actions []plan.Action
+	hits := FindHijackPolicies(state)
+	for _, hit := range hits {
+		actions = append(actions, e.remediateHijackPolicy(ctx, hit, state)...)
+	}
+	span.SetAttributes(attribute.Int("hits", len(hits)))
+
+	if len(hits) == 0 {
+		telemetry.Println(ctx, "✓ No browser hijack policies found")
+	}
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+	return actions
+}
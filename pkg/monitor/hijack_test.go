@@ -0,0 +1,184 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// hijackFixture sets a home page, a new tab page and a search provider in
+// Chrome and Edge, and the Firefox home page and search engines. The Edge
+// home page holds the safe value and its developer tools are enabled, so
+// neither is a hit.
+const hijackFixture = regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome]
+"HomepageLocation"="https://evil.example.com"
+"NewTabPageLocation"="https://evil.example.com/ntp"
+"DefaultSearchProviderSearchURL"="https://evil.example.com/search?q={searchTerms}"
+"DeveloperToolsAvailability"=dword:00000002
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge]
+"HomepageLocation"="chrome://newtab"
+"NewTabPageLocation"="https://evil.example.com/ntp"
+"DefaultSearchProviderSearchURL"="https://evil.example.com/search?q={searchTerms}"
+"DeveloperToolsAvailability"=dword:00000001
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Mozilla\Firefox\Homepage]
+"URL"="https://evil.example.com"
+"Locked"=dword:00000001
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Mozilla\Firefox\SearchEngines]
+"Default"="Evil"
+`
+
+// Paths of the hijack policies of hijackFixture.
+const (
+	chromeHomepage  = `Google\Chrome\HomepageLocation`
+	chromeNewTab    = `Google\Chrome\NewTabPageLocation`
+	chromeSearch    = `Google\Chrome\DefaultSearchProviderSearchURL`
+	chromeDevTools  = `Google\Chrome\DeveloperToolsAvailability`
+	edgeHomepage    = `Microsoft\Edge\HomepageLocation`
+	edgeNewTab      = `Microsoft\Edge\NewTabPageLocation`
+	edgeSearch      = `Microsoft\Edge\DefaultSearchProviderSearchURL`
+	edgeDevTools    = `Microsoft\Edge\DeveloperToolsAvailability`
+	firefoxHomepage = `Mozilla\Firefox\Homepage`
+	firefoxSearch   = `Mozilla\Firefox\SearchEngines`
+)
+
+func TestFindHijackPolicies(t *testing.T) {
+	var got []string
+	for _, hit := range FindHijackPolicies(captureState(t, newTree(t, hijackFixture))) {
+		got = append(got, hit.Detector.Policy+" "+hit.Target)
+	}
+	// The Edge home page and developer tools hold allowed values.
+	want := []string{
+		"DefaultSearchProviderSearchURL " + chromeSearch,
+		"DeveloperToolsAvailability " + chromeDevTools,
+		"HomepageLocation " + chromeHomepage,
+		"NewTabPageLocation " + chromeNewTab,
+		"DefaultSearchProviderSearchURL " + edgeSearch,
+		"NewTabPageLocation " + edgeNewTab,
+		"Homepage " + firefoxHomepage,
+		"SearchEngines " + firefoxSearch,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("FindHijackPolicies() = %q\nwant %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("hit %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestProcessHijackPolicies(t *testing.T) {
+	// absent marks a value or key the policies must no longer hold.
+	const absent = "<absent>"
+	// unchanged is what every policy of hijackFixture holds before.
+	unchanged := map[string]string{
+		chromeHomepage:             "https://evil.example.com",
+		chromeNewTab:               "https://evil.example.com/ntp",
+		chromeSearch:               "https://evil.example.com/search?q={searchTerms}",
+		chromeDevTools:             "0x00000002",
+		edgeHomepage:               "chrome://newtab",
+		edgeNewTab:                 "https://evil.example.com/ntp",
+		edgeSearch:                 "https://evil.example.com/search?q={searchTerms}",
+		edgeDevTools:               "0x00000001",
+		firefoxHomepage + `\URL`:   "https://evil.example.com",
+		firefoxSearch + `\Default`: "Evil",
+	}
+
+	tests := []struct {
+		name    string
+		actions hijack.Actions
+		want    map[string]string // changes to unchanged
+		deleted []string          // keys deleted with their values
+	}{
+		{
+			name: "report by default",
+		},
+		{
+			name: "remove",
+			actions: hijack.Actions{
+				"HomepageLocation":               hijack.Remove,
+				"NewTabPageLocation":             hijack.Remove,
+				"DefaultSearchProviderSearchURL": hijack.Remove,
+				"DeveloperToolsAvailability":     hijack.Remove,
+				"Homepage":                       hijack.Remove,
+				"SearchEngines":                  hijack.Remove,
+			},
+			// The allowed Edge values stay.
+			want: map[string]string{
+				chromeHomepage:             absent,
+				chromeNewTab:               absent,
+				chromeSearch:               absent,
+				chromeDevTools:             absent,
+				edgeNewTab:                 absent,
+				edgeSearch:                 absent,
+				firefoxHomepage + `\URL`:   absent,
+				firefoxSearch + `\Default`: absent,
+			},
+			deleted: []string{firefoxHomepage, firefoxSearch},
+		},
+		{
+			name: "reset",
+			actions: hijack.Actions{
+				"HomepageLocation":           hijack.Reset,
+				"DeveloperToolsAvailability": hijack.Reset,
+				"SearchEngines":              hijack.Remove,
+			},
+			want: map[string]string{
+				chromeHomepage:             "chrome://newtab",
+				chromeDevTools:             "0x00000001",
+				firefoxSearch + `\Default`: absent,
+			},
+			deleted: []string{firefoxSearch},
+		},
+	}
+	for _, tt := range tests {
+		for _, dryRun := range []bool{false, true} {
+			name := tt.name
+			if dryRun {
+				name += " dry run"
+			}
+			t.Run(name, func(t *testing.T) {
+				e := NewEngine()
+				e.HijackActions = tt.actions
+				b := newTree(t, hijackFixture)
+				state := enforce(t, e, b, dryRun, e.ProcessHijackPolicies)
+
+				captured := captureState(t, b)
+				for path, value := range unchanged {
+					if want, ok := tt.want[path]; ok && !dryRun {
+						value = want
+					}
+					checkPolicyValue(t, captured, path, value, absent)
+					checkPolicyValue(t, state, path, value, absent)
+				}
+				for _, key := range []string{firefoxHomepage, firefoxSearch} {
+					wantKey := dryRun || !slices.Contains(tt.deleted, key)
+					if captured.Subkeys[key] != wantKey {
+						t.Errorf("key %s exists = %v, want %v", key, captured.Subkeys[key], wantKey)
+					}
+				}
+			})
+		}
+	}
+}
+
+// checkPolicyValue compares the value at path in state with want, or with
+// its absence if want is absent.
+func checkPolicyValue(t *testing.T, state *registry.RegState, path, want, absent string) {
+	t.Helper()
+	value, ok := state.Values[path]
+	switch {
+	case want == absent && ok:
+		t.Errorf("%s = %q, want it removed", path, value.Data)
+	case want != absent && !ok:
+		t.Errorf("%s removed, want %q", path, want)
+	case want != absent && value.Data != want:
+		t.Errorf("%s = %q, want %q", path, value.Data, want)
+	}
+}
@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/intel"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// intelReloadInterval is how often the watchers check the feed file for
+// changes.
+const intelReloadInterval = 30 * time.Second
+
+// intelAttributes returns the metadata of a feed entry as telemetry
+// attributes.
+func intelAttributes(entry intel.Entry) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("intel.name", entry.Name),
+		attribute.String("intel.severity", string(entry.Severity)),
+		attribute.String("intel.source", entry.Source),
+		attribute.String("intel.first_seen", entry.FirstSeenDate()),
+	}
+}
+
+// recordExtensionDetected records the detection of extensionID in a policy
+// of browserID. Extensions listed in the intel feed are logged at warning or,
+// from high severity, error level, and their metric carries the feed's
+// severity and source.
+func (e *Engine) recordExtensionDetected(ctx context.Context, browserID, extensionID string) {
+	entry, known := e.Intel.Lookup(extensionID)
+	if !known {
+		telemetry.RecordExtensionDetected(ctx, browserID, extensionID)
+		return
+	}
+
+	attrs := append([]attribute.KeyValue{
+		attribute.String("browser", browserID),
+		attribute.String("extension.id", extensionID),
+	}, intelAttributes(entry)...)
+	msg := fmt.Sprintf("Known malicious extension %s (%s)", extensionID, entry)
+	telemetry.Printf(ctx, "  🚨 %s\n", msg)
+	telemetry.AddEvent(ctx, "known-malicious-extension", attrs...)
+	if entry.Severity.AtLeast(intel.High) {
+		telemetry.LogError(ctx, msg, nil, attrs...)
+	} else {
+		telemetry.LogWarn(ctx, msg, attrs...)
+	}
+	telemetry.RecordExtensionDetected(ctx, browserID, extensionID,
+		attribute.Bool("known_malicious", true),
+		attribute.String("severity", string(entry.Severity)),
+		attribute.String("intel_source", entry.Source),
+	)
+}
+
+// intelIDsFor returns the feed IDs that apply to browsers of family: IDs in
+// the Chromium format for Chromium browsers, every other ID for Gecko.
+func (e *Engine) intelIDsFor(family browsers.Family) []string {
+	var ids []string
+	for _, entry := range e.Intel.Entries() {
+		if detection.IsChromiumExtensionID(entry.ID) == (family == browsers.Chromium) {
+			ids = append(ids, entry.ID)
+		}
+	}
+	return ids
+}
+
+// EnforceIntelBlocklist plans blocking every feed ID in every browser,
+// whether or not a policy installs it: Chromium-format IDs are added to each
+// Chromium browser's ExtensionInstallBlocklist, other IDs are blocked
+// through each Gecko browser's ExtensionSettings. IDs state shows blocked
+// already are left out. The ThreatIntel action mode only matters as far as
+// it blocks.
+func (e *Engine) EnforceIntelBlocklist(ctx context.Context, state *registry.RegState) []plan.Action {
+	if e.Intel.Len() == 0 {
+		return nil
+	}
+	ctx, span := telemetry.StartSpan(ctx, "monitor.EnforceIntelBlocklist",
+		attribute.String("feed", e.Intel.Path()),
+		attribute.Int("feed.entries", e.Intel.Len()),
+	)
+	defer span.End()
+
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Printf(ctx, "Blocking %d known malicious extension(s) from %s...\n", e.Intel.Len(), e.Intel.Path())
+	mode := e.resolveMode(ctx, DetectorThreatIntel)
+	if !mode.blocks() {
+		telemetry.Printf(ctx, "⏭️  Nothing to remove in %s mode - skipping threat-intel blocklist\n", mode)
+		telemetry.Println(ctx, "========================================")
+		return nil
+	}
+	telemetry.Println(ctx, "========================================")
+
+	var actions []plan.Action
+	for _, d := range browsers.All() {
+		for _, id := range e.intelIDsFor(d.Family) {
+			entry, _ := e.Intel.Lookup(id)
+			c := cause{detector: DetectorThreatIntel, source: e.Intel.Path(), reason: fmt.Sprintf("known malicious extension (%s)", entry)}
+			switch d.Family {
+			case browsers.Chromium:
+				blocklistPath := d.PolicyPath(browsers.ExtensionInstallBlocklist)
+				if listsID(keyValues(state, blocklistPath), id) {
+					continue
+				}
+				telemetry.Printf(ctx, "📝 Adding %s (%s) to %s blocklist: %s\n", id, entry, d.DisplayName, blocklistPath)
+				actions = append(actions, c.action(plan.AddBlocklistEntry, blocklistPath, id))
+			case browsers.Gecko:
+				if detection.SanitizeExtensionID(id) == "" || geckoBlockedIn(state, d.PolicyRoot, id) {
+					continue
+				}
+				telemetry.Printf(ctx, "🔒 Blocking %s (%s) in %s\n", id, entry, d.DisplayName)
+				actions = append(actions, c.action(plan.SetFirefoxBlocked, detection.GetGeckoBlocklistPath(d.PolicyRoot, id), id))
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("actions", len(actions)))
+
+	if len(actions) == 0 {
+		telemetry.Println(ctx, "✓ Every known malicious extension is already blocked")
+	}
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+	return actions
+}
+
+// geckoBlockedIn reports whether state shows extensionID blocked through the
+// ExtensionSettings policy below policyRoot.
+func geckoBlockedIn(state *registry.RegState, policyRoot, extensionID string) bool {
+	mode, ok := keyValues(state, detection.GetGeckoBlocklistPath(policyRoot, extensionID))["installation_mode"]
+	return ok && mode.Unexpanded() == "blocked"
+}
+
+// reloadIntelFeed re-reads the intel feed if its file changed and processes
+// the policies like a burst of notifications, with EnforceIntelBlocklist as
+// an extra pass, so that the IDs it now lists are blocked and changes made
+// meanwhile are remediated. It returns the state to diff the next burst
+// against.
+func (e *Engine) reloadIntelFeed(ctx context.Context, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) *registry.RegState {
+	changed, err := e.Intel.Reload()
+	if err != nil {
+		telemetry.Printf(ctx, "⚠️  Could not reload intel feed %s: %v\n", e.Intel.Path(), err)
+		telemetry.RecordError(ctx, err)
+		return previousState
+	}
+	if !changed {
+		return previousState
+	}
+	telemetry.Printf(ctx, "🔄 Reloaded intel feed %s: %d known malicious extension(s)\n", e.Intel.Path(), e.Intel.Len())
+	telemetry.AddEvent(ctx, "intel-feed-reloaded", attribute.Int("entries", e.Intel.Len()))
+
+	if err := reload(b); err != nil {
+		telemetry.Println(ctx, "Error reloading policies:", err)
+		telemetry.RecordError(ctx, err)
+		return previousState
+	}
+	return e.processChanges(ctx, b, keyPath, "intel-feed", 1, previousState, canWrite, onChange, e.EnforceIntelBlocklist)
+}
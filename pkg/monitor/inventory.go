@@ -0,0 +1,214 @@
+package monitor
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/inventory"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/policyfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// listSettings maps the Chromium list policies to the setting of their
+// entries.
+var listSettings = map[string]string{
+	browsers.ExtensionInstallForcelist: inventory.SettingForceInstalled,
+	browsers.ExtensionInstallBlocklist: inventory.SettingBlocked,
+	browsers.ExtensionInstallAllowlist: inventory.SettingAllowed,
+}
+
+// BuildInventory returns every extension-related policy below keyPath:
+// Chromium forcelists, blocklists and allowlists, ExtensionSettings in its
+// JSON and subkey forms, 3rdparty extension policy and Firefox
+// Extensions\Install and Extensions\Locked, all read from state. The
+// inventory is sorted.
+func BuildInventory(ctx context.Context, state *registry.RegState) *inventory.Inventory {
+	_, span := telemetry.StartSpan(ctx, "monitor.BuildInventory")
+	defer span.End()
+
+	inv := &inventory.Inventory{}
+
+	for subkeyPath := range state.Subkeys {
+		d, policy, rest, ok := browsers.PolicyForPath(subkeyPath)
+		setting, isList := listSettings[policy]
+		if !ok || !isList || rest != "" || d.Family != browsers.Chromium {
+			continue
+		}
+		for name, value := range keyValues(state, subkeyPath) {
+			for _, entry := range value.Strings() {
+				parsed := detection.ParseForcelistEntry(entry)
+				if parsed.ID == "" {
+					continue
+				}
+				inv.Add(d.ID, d.DisplayName, parsed.ID, inventory.Entry{
+					Policy:    policy,
+					Setting:   setting,
+					UpdateURL: parsed.UpdateURL,
+					Source:    pathutils.BuildPath(subkeyPath, name),
+				})
+			}
+		}
+	}
+
+	// ExtensionSettings\{id} subkeys, one entry per extension key.
+	type settingsKey struct {
+		browserID, browserName string
+		id, source             string
+	}
+	settingsKeys := make(map[settingsKey]*inventory.Entry)
+
+	for valuePath, value := range state.Values {
+		d, policy, rest, ok := browsers.PolicyForPath(valuePath)
+		if !ok {
+			continue
+		}
+		switch {
+		case policy == browsers.ExtensionSettings && rest == "":
+			settings, ok := parseExtensionSettingsValue(value)
+			if !ok {
+				continue
+			}
+			addSettingsEntries(inv, d, browsers.ExtensionSettings, valuePath, settings)
+
+		case policy == browsers.ExtensionSettings:
+			id, field, _ := strings.Cut(rest, `\`)
+			key := settingsKey{d.ID, d.DisplayName, id, pathutils.BuildPath(d.PolicyPath(browsers.ExtensionSettings), id)}
+			entry, seen := settingsKeys[key]
+			if !seen {
+				entry = &inventory.Entry{Policy: browsers.ExtensionSettings, Setting: inventory.SettingConfigured, Source: key.source}
+				settingsKeys[key] = entry
+			}
+			switch {
+			case strings.EqualFold(field, "installation_mode"):
+				entry.Setting = value.Unexpanded()
+			case strings.EqualFold(field, "update_url"):
+				entry.UpdateURL = value.Unexpanded()
+			case strings.EqualFold(field, "install_url") && entry.UpdateURL == "":
+				entry.UpdateURL = value.Unexpanded()
+			}
+
+		case policy == browsers.ExtensionsInstall && d.Family == browsers.Gecko:
+			inv.Add(d.ID, d.DisplayName, "", inventory.Entry{
+				Policy:    browsers.ExtensionsInstall,
+				Setting:   inventory.SettingInstall,
+				UpdateURL: value.Unexpanded(),
+				Source:    valuePath,
+			})
+
+		case policy == browsers.ExtensionsLocked && d.Family == browsers.Gecko:
+			inv.Add(d.ID, d.DisplayName, detection.SanitizeExtensionID(value.Unexpanded()), inventory.Entry{
+				Policy:  browsers.ExtensionsLocked,
+				Setting: inventory.SettingLocked,
+				Source:  valuePath,
+			})
+		}
+	}
+	for key, entry := range settingsKeys {
+		inv.Add(key.browserID, key.browserName, key.id, *entry)
+	}
+
+	extensionIndex := newExtensionIndex(state)
+	for _, id := range extensionIndex.IDs() {
+		paths := extensionIndex.GetPaths(id)
+		slices.Sort(paths)
+		var keys []string
+		for _, path := range paths {
+			// The policy key below an extension key is part of it.
+			if parent, ok := pathutils.GetParentPath(path); ok && containsFold(keys, parent) {
+				continue
+			}
+			keys = append(keys, path)
+			d, _, ok := browsers.ForPath(path)
+			if !ok {
+				continue
+			}
+			inv.Add(d.ID, d.DisplayName, id, inventory.Entry{
+				Policy:  browsers.ThirdPartyExtensions,
+				Setting: inventory.SettingConfigured,
+				Source:  path,
+			})
+		}
+	}
+
+	inv.Sort()
+	span.SetAttributes(attribute.Int("entries", inv.Len()))
+	return inv
+}
+
+// AddPolicyFilesToInventory adds the extension policies of the Firefox
+// policies.json files at paths to inv and sorts it. Missing and unreadable
+// files are skipped.
+func AddPolicyFilesToInventory(inv *inventory.Inventory, paths []string) {
+	firefox, _ := browsers.Get("firefox")
+	for _, path := range paths {
+		f, err := policyfile.Load(path)
+		if err != nil {
+			continue
+		}
+		if settings, err := f.ExtensionSettings(); err == nil {
+			addSettingsEntries(inv, firefox, policyfile.PolicyExtensionSettings, path, settings)
+		}
+		for _, url := range f.ExtensionsInstall() {
+			inv.Add(firefox.ID, firefox.DisplayName, "", inventory.Entry{
+				Policy:    policyfile.PolicyExtensionsInstall,
+				Setting:   inventory.SettingInstall,
+				UpdateURL: url,
+				Source:    path,
+			})
+		}
+		for _, id := range f.ExtensionsLocked() {
+			inv.Add(firefox.ID, firefox.DisplayName, detection.SanitizeExtensionID(id), inventory.Entry{
+				Policy:  policyfile.PolicyExtensionsLocked,
+				Setting: inventory.SettingLocked,
+				Source:  path,
+			})
+		}
+	}
+	inv.Sort()
+}
+
+// addSettingsEntries adds every entry of the ExtensionSettings policy
+// settings found at source. Entries naming no extension ID (the default
+// entry and update_url: entries) are added under their key.
+func addSettingsEntries(inv *inventory.Inventory, d browsers.Descriptor, policy, source string, settings extsettings.Settings) {
+	for _, entry := range settings.Entries() {
+		setting := entry.Mode
+		if setting == "" {
+			setting = inventory.SettingConfigured
+		}
+		updateURL := entry.UpdateURL
+		if updateURL == "" {
+			updateURL = entry.InstallURL
+		}
+		ids := entry.IDs()
+		if len(ids) == 0 {
+			ids = []string{entry.Key}
+		}
+		for _, id := range ids {
+			inv.Add(d.ID, d.DisplayName, id, inventory.Entry{
+				Policy:    policy,
+				Setting:   setting,
+				UpdateURL: updateURL,
+				Source:    source,
+			})
+		}
+	}
+}
+
+// containsFold reports whether paths contains path, ignoring case.
+func containsFold(paths []string, path string) bool {
+	for _, p := range paths {
+		if strings.EqualFold(p, path) {
+			return true
+		}
+	}
+	return false
+}
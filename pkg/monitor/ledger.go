@@ -0,0 +1,202 @@
+package monitor
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/ledger"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// ownedKeyPath returns the key holding e.
+func ownedKeyPath(e ledger.Entry) string {
+	if e.Kind == ledger.GeckoBlocked {
+		return detection.GetGeckoBlocklistPath(e.Path, e.ExtensionID)
+	}
+	return e.Path
+}
+
+// ownedEntryIntact reports whether e is in place in state.
+func ownedEntryIntact(state *registry.RegState, e ledger.Entry) bool {
+	if e.Kind == ledger.GeckoBlocked {
+		return geckoBlockedIn(state, e.Path, e.ExtensionID)
+	}
+	return listsID(keyValues(state, e.Path), e.ExtensionID)
+}
+
+// allowedNow returns why current policy no longer blocks the owned entry
+// owned, if it does not: a forcelist entry for the ID in state that an update
+// URL rule or exemption keeps, or an exemption of the ID itself. IDs in the
+// threat-intel feed stay blocked.
+func (e *Engine) allowedNow(state *registry.RegState, owned ledger.Entry) (string, bool) {
+	if _, known := e.Intel.Lookup(owned.ExtensionID); known && e.effectiveMode(DetectorThreatIntel).blocks() {
+		return "", false
+	}
+	if owned.Kind == ledger.GeckoBlocked {
+		rule, exempt := e.matchExemption(ownedKeyPath(owned), browsers.ExtensionSettings, owned.ExtensionID, "")
+		return "exemption " + rule.String(), exempt
+	}
+
+	if d, _, ok := browsers.ForPath(owned.Path); ok {
+		forcelistPath := d.PolicyPath(browsers.ExtensionInstallForcelist)
+		for _, valuePath := range slices.Sorted(maps.Keys(state.Values)) {
+			if parent, ok := pathutils.GetParentPath(valuePath); !ok || !strings.EqualFold(parent, forcelistPath) {
+				continue
+			}
+			for _, entry := range state.Values[valuePath].Strings() {
+				v := e.decideForcelistEntry(forcelistPath, entry)
+				if !strings.EqualFold(v.entry.ID, owned.ExtensionID) || !v.keep {
+					continue
+				}
+				if v.trusted {
+					return "update URL rule " + v.trustRule.String(), true
+				}
+				return "exemption " + v.exemption.String(), true
+			}
+		}
+	}
+	// Without a forcelist entry there is no update URL to go by, so only
+	// exemptions that accept any update URL apply.
+	rule, exempt := e.matchExemption(owned.Path, browsers.ExtensionInstallForcelist, owned.ExtensionID, "")
+	return "exemption " + rule.String(), exempt
+}
+
+// touches reports whether change removed or modified something in the key
+// holding e, or removed one of its ancestors.
+func touches(change registry.Change, e ledger.Entry) bool {
+	owned := ownedKeyPath(e)
+	switch change.Kind {
+	case registry.ValueRemoved, registry.ValueChanged:
+		_, ok := pathutils.TrimPathPrefix(change.Path, owned)
+		return ok
+	case registry.SubkeyRemoved:
+		_, ok := pathutils.TrimPathPrefix(owned, change.Path)
+		return ok
+	}
+	return false
+}
+
+// suspect is an owned entry that may have been tampered with, and what
+// made it suspect.
+type suspect struct {
+	entry ledger.Entry
+	cause string
+}
+
+// restoreOwnedEntries plans restoring the suspects that state shows are
+// no longer in place, reporting each one as a "tamper" event. Suspects that
+// current policy allows (see allowedNow) are released from the ledger
+// instead. It returns the actions and the number of tampered entries.
+func (e *Engine) restoreOwnedEntries(ctx context.Context, suspects []suspect, state *registry.RegState) ([]plan.Action, int) {
+	mode := e.resolveMode(ctx, DetectorTamper)
+	var actions []plan.Action
+	tampered := 0
+	for _, s := range suspects {
+		owned, why := s.entry, s.cause
+		if allowed, ok := e.allowedNow(state, owned); ok {
+			c := cause{detector: DetectorTamper, reason: "allowed by " + allowed}
+			actions = append(actions, c.action(plan.ReleaseEntry, ownedKeyPath(owned), owned.ExtensionID))
+			continue
+		}
+		if ownedEntryIntact(state, owned) {
+			continue
+		}
+		tampered++
+		attrs := []attribute.KeyValue{
+			attribute.String("browser", owned.Browser),
+			attribute.String("kind", string(owned.Kind)),
+			attribute.String("path", ownedKeyPath(owned)),
+			attribute.String("extension.id", owned.ExtensionID),
+			attribute.String("cause", why),
+		}
+
+		msg := "Guard-written blocklist entry for " + owned.ExtensionID + " was removed or modified"
+		telemetry.Printf(ctx, "\n[TAMPER DETECTED]\n")
+		telemetry.Printf(ctx, "Path: %s\n", ownedKeyPath(owned))
+		telemetry.Printf(ctx, "  ⚠️  %s (%s)\n", msg, why)
+		telemetry.LogWarn(ctx, msg, attrs...)
+		telemetry.AddEvent(ctx, "tamper", append(attrs, attribute.Bool("restore", mode.blocks()))...)
+
+		if !mode.blocks() {
+			continue
+		}
+		telemetry.Printf(ctx, "  🔁 Restoring %s entry for %s\n", owned.Kind, owned.ExtensionID)
+		c := cause{detector: DetectorTamper, reason: "guard-written entry was tampered with (" + why + ")"}
+		if owned.Kind == ledger.GeckoBlocked {
+			actions = append(actions, c.action(plan.SetFirefoxBlocked, ownedKeyPath(owned), owned.ExtensionID))
+		} else {
+			actions = append(actions, c.action(plan.AddBlocklistEntry, owned.Path, owned.ExtensionID))
+		}
+	}
+	return actions, tampered
+}
+
+// RestoreTamperedEntries plans restoring the guard-owned entries that
+// changes removed or modified, e.g. blocklist values deleted by an attacker
+// or a GPO refresh. state is the state after changes.
+func (e *Engine) RestoreTamperedEntries(ctx context.Context, changes []registry.Change, state *registry.RegState) []plan.Action {
+	if e.Ledger.Len() == 0 {
+		return nil
+	}
+	var suspects []suspect
+	for _, owned := range e.Ledger.Entries() {
+		for _, change := range changes {
+			if touches(change, owned) {
+				suspects = append(suspects, suspect{entry: owned, cause: change.Kind.String() + " " + change.Path})
+				break
+			}
+		}
+	}
+	if len(suspects) == 0 {
+		return nil
+	}
+
+	ctx, span := telemetry.StartSpan(ctx, "monitor.RestoreTamperedEntries",
+		attribute.Int("suspects", len(suspects)),
+	)
+	defer span.End()
+
+	actions, tampered := e.restoreOwnedEntries(ctx, suspects, state)
+	span.SetAttributes(attribute.Int("tampered", tampered))
+	return actions
+}
+
+// VerifyOwnedEntries checks every guard-owned entry against state and plans
+// restoring the missing ones, catching tampering that happened while the
+// guard was not running or that change notifications missed.
+func (e *Engine) VerifyOwnedEntries(ctx context.Context, state *registry.RegState) []plan.Action {
+	if e.Ledger.Len() == 0 {
+		return nil
+	}
+	ctx, span := telemetry.StartSpan(ctx, "monitor.VerifyOwnedEntries",
+		attribute.String("ledger", e.Ledger.Path()),
+		attribute.Int("owned", e.Ledger.Len()),
+	)
+	defer span.End()
+
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Printf(ctx, "Verifying %d guard-written blocklist entr(ies)...\n", e.Ledger.Len())
+	telemetry.Println(ctx, "========================================")
+
+	var suspects []suspect
+	for _, owned := range e.Ledger.Entries() {
+		suspects = append(suspects, suspect{entry: owned, cause: "no longer in place"})
+	}
+	actions, tampered := e.restoreOwnedEntries(ctx, suspects, state)
+	span.SetAttributes(attribute.Int("tampered", tampered))
+	if tampered == 0 {
+		telemetry.Println(ctx, "✓ Every guard-written entry is in place")
+	}
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+	return actions
+}
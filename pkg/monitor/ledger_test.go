@@ -0,0 +1,133 @@
+package monitor
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/ledger"
+	"github.com/kad/WindowsBrowserGuard/pkg/trust"
+)
+
+const firefoxRoot = `Mozilla\Firefox`
+
+// TestVerifyOwnedEntries checks that owned entries removed from the tree
+// are restored unless current policy allows the extension, in which case
+// they are dropped from the ledger.
+func TestVerifyOwnedEntries(t *testing.T) {
+	blocklistEntry := func(id string) ledger.Entry {
+		return ledger.Entry{Kind: ledger.Blocklist, Browser: "chrome", Path: chromeBlocklist, ExtensionID: id}
+	}
+	geckoEntry := ledger.Entry{Kind: ledger.GeckoBlocked, Browser: "firefox", Path: firefoxRoot, ExtensionID: "x@example.com"}
+	geckoKey := firefoxRoot + `\ExtensionSettings\x@example.com`
+	emptyTree := regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies]
+`
+
+	for _, tt := range []struct {
+		name       string
+		reg        string
+		rules      []exemptions.Rule
+		trustRules []trust.Rule
+		owned      []ledger.Entry
+		want       listed
+		wantGecko  bool
+		wantOwned  []string
+	}{
+		{
+			name:      "tampered entries restored",
+			reg:       emptyTree,
+			owned:     []ledger.Entry{blocklistEntry(idA), geckoEntry},
+			want:      listed{chromeBlocklist: {idA}},
+			wantGecko: true,
+			wantOwned: []string{idA, "x@example.com"},
+		},
+		{
+			name:      "exempted IDs released",
+			reg:       emptyTree,
+			rules:     []exemptions.Rule{{ID: idA}, {ID: "x@example.com", Browser: "firefox"}},
+			owned:     []ledger.Entry{blocklistEntry(idA), blocklistEntry(idB), geckoEntry},
+			want:      listed{chromeBlocklist: {idB}},
+			wantOwned: []string{idB},
+		},
+		{
+			name: "exemption of the forcelist update URL releases",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\` + chromeForcelist + `]
+"1"="` + idA + `;https://approved.example/update.xml"
+`,
+			rules:     []exemptions.Rule{{ID: idA, UpdateURL: "https://approved.example/*"}},
+			owned:     []ledger.Entry{blocklistEntry(idA)},
+			want:      listed{chromeBlocklist: nil},
+			wantOwned: []string{},
+		},
+		{
+			name: "exemption of another update URL keeps the block",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\` + chromeForcelist + `]
+"1"="` + idA + `;https://evil.example/update.xml"
+`,
+			rules:     []exemptions.Rule{{ID: idA, UpdateURL: "https://approved.example/*"}},
+			owned:     []ledger.Entry{blocklistEntry(idA)},
+			want:      listed{chromeBlocklist: {idA}},
+			wantOwned: []string{idA},
+		},
+		{
+			name: "allowed update URL releases",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\` + chromeForcelist + `]
+"1"="` + idA + `;https://clients2.google.com/service/update2/crx"
+"2"="` + idB + `;https://evil.example/update.xml"
+`,
+			trustRules: []trust.Rule{{Action: trust.Allow, Host: "clients2.google.com"}},
+			owned:      []ledger.Entry{blocklistEntry(idA), blocklistEntry(idB)},
+			want:       listed{chromeBlocklist: {idB}},
+			wantOwned:  []string{idB},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := configure(t, nil, tt.rules)
+			rules, err := trust.New(tt.trustRules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.UpdateURLRules = rules
+
+			l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, owned := range tt.owned {
+				if err := l.Add(owned); err != nil {
+					t.Fatal(err)
+				}
+			}
+			e.Ledger = l
+
+			b := newTree(t, tt.reg)
+			state := enforce(t, e, b, false, e.VerifyOwnedEntries)
+
+			tt.want.check(t, b, state)
+			if got := keyExists(b, policiesKeyPath, geckoKey); got != tt.wantGecko {
+				t.Errorf("%s exists = %v, want %v", geckoKey, got, tt.wantGecko)
+			}
+
+			// The ledger file holds the same entries as memory.
+			reopened, err := ledger.Open(l.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, owner := range []*ledger.Ledger{l, reopened} {
+				got := []string{}
+				for _, owned := range owner.Entries() {
+					got = append(got, owned.ExtensionID)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, tt.wantOwned) {
+					t.Errorf("owned entries = %q, want %q", got, tt.wantOwned)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// ============================================================================
+// ACTION MODES - What each detector is allowed to change
+// ============================================================================
+
+// Mode is what the guard does about the install policies one detector finds.
+type Mode string
+
+const (
+	// Observe reports findings and the operations that would fix them
+	// without changing anything.
+	Observe Mode = "observe"
+	// Block blocks the extension but leaves the install policy in place.
+	Block Mode = "block"
+	// Remove deletes the install policy without blocking the extension.
+	Remove Mode = "remove"
+	// Enforce blocks the extension and deletes the install policy.
+	Enforce Mode = "enforce"
+	// Quarantine backs up the install policy, then enforces. Nothing is
+	// deleted if the backup fails.
+	Quarantine Mode = "quarantine"
+)
+
+// Detector names accepted in ActionModes. Default applies to every detector
+// without an entry of its own.
+const (
+	DetectorDefault           = "Default"
+	DetectorForcelist         = browsers.ExtensionInstallForcelist
+	DetectorExtensionSettings = browsers.ExtensionSettings
+	DetectorFirefoxExtensions = "Extensions"
+	DetectorPolicyFile        = exemptions.SourcePolicyFile
+	DetectorThreatIntel       = "ThreatIntel"
+	DetectorCleanup           = "Cleanup"
+	DetectorTamper            = "Tamper"
+)
+
+var detectorNames = []string{
+	DetectorDefault,
+	DetectorForcelist,
+	DetectorExtensionSettings,
+	DetectorFirefoxExtensions,
+	DetectorPolicyFile,
+	DetectorThreatIntel,
+	DetectorCleanup,
+	DetectorTamper,
+}
+
+// ParseMode parses a mode name; "" selects Enforce.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return Enforce, nil
+	case Observe, Block, Remove, Enforce, Quarantine:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown mode %q (want %q, %q, %q, %q or %q)", s, Observe, Block, Remove, Enforce, Quarantine)
+}
+
+// blocks reports whether m blocks extensions.
+func (m Mode) blocks() bool {
+	return m == Block || m == Enforce || m == Quarantine
+}
+
+// removes reports whether m deletes install policies.
+func (m Mode) removes() bool {
+	return m == Remove || m == Enforce || m == Quarantine
+}
+
+// ActionModes maps detector names to modes.
+type ActionModes map[string]Mode
+
+// For returns the mode of detector, falling back to Default and then to
+// Enforce.
+func (a ActionModes) For(detector string) Mode {
+	if mode, ok := a[detector]; ok {
+		return mode
+	}
+	if mode, ok := a[DetectorDefault]; ok {
+		return mode
+	}
+	return Enforce
+}
+
+// String lists the modes, Default first, for log output.
+func (a ActionModes) String() string {
+	parts := []string{DetectorDefault + "=" + string(a.For(DetectorDefault))}
+	names := make([]string, 0, len(a))
+	for name := range a {
+		if name != DetectorDefault {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+"="+string(a[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseActionModes validates a detector name -> mode map as found in
+// config.json.
+func ParseActionModes(m map[string]string) (ActionModes, error) {
+	modes := make(ActionModes, len(m))
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		detector, ok := lookupDetector(name)
+		if !ok {
+			return nil, fmt.Errorf("action mode %q: unknown detector (want one of %s)", name, strings.Join(detectorNames, ", "))
+		}
+		mode, err := ParseMode(m[name])
+		if err != nil {
+			return nil, fmt.Errorf("action mode %q: %w", name, err)
+		}
+		modes[detector] = mode
+	}
+	return modes, nil
+}
+
+// lookupDetector returns the canonical spelling of a detector name.
+func lookupDetector(name string) (string, bool) {
+	for _, detector := range detectorNames {
+		if strings.EqualFold(detector, strings.TrimSpace(name)) {
+			return detector, true
+		}
+	}
+	return "", false
+}
+
+// settingsAction returns what mode m does to ExtensionSettings install
+// entries: Block flips them to "blocked", Remove deletes them and the
+// enforcing modes use e.ExtensionSettingsAction.
+func (e *Engine) settingsAction(m Mode) extsettings.Action {
+	switch m {
+	case Block:
+		return extsettings.ActionBlock
+	case Remove:
+		return extsettings.ActionRemove
+	}
+	if e.ExtensionSettingsAction == "" {
+		return extsettings.ActionBlock
+	}
+	return e.ExtensionSettingsAction
+}
+
+// logMode reports the mode a detector runs in when it is not Enforce.
+func logMode(ctx context.Context, detector string, mode Mode) {
+	if mode == Enforce {
+		return
+	}
+	telemetry.Printf(ctx, "  ⚙️  %s mode: %s\n", detector, mode)
+	telemetry.SetAttributes(ctx, attribute.String("mode", string(mode)))
+}
+
+// effectiveMode returns the mode whose operations detector plans. Observe
+// plans like Enforce; its actions are reported instead of applied (see
+// observes).
+func (e *Engine) effectiveMode(detector string) Mode {
+	if mode := e.Modes.For(detector); mode != Observe {
+		return mode
+	}
+	return Enforce
+}
+
+// resolveMode logs the mode of detector and returns its effective mode.
+func (e *Engine) resolveMode(ctx context.Context, detector string) Mode {
+	logMode(ctx, detector, e.Modes.For(detector))
+	return e.effectiveMode(detector)
+}
+
+// observes reports whether the actions planned by detector are only
+// reported: detector runs in Observe mode. Hijack policies have actions of
+// their own and are never observed.
+func (e *Engine) observes(detector string) bool {
+	name, ok := lookupDetector(detector)
+	return ok && name == detector && e.Modes.For(detector) == Observe
+}
+
+// ============================================================================
+// QUARANTINE - Backups taken before quarantine mode deletes a policy
+// ============================================================================
+
+var errNoQuarantineDir = errors.New("no quarantine directory configured")
+
+// recordQuarantined reports a backup written to file.
+func recordQuarantined(ctx context.Context, what, file string) {
+	telemetry.Printf(ctx, "  📦 Quarantined %s to %s\n", what, file)
+	telemetry.AddEvent(ctx, "policy-quarantined",
+		attribute.String("path", what),
+		attribute.String("backup", file),
+	)
+}
+
+// quarantineKey backs up the key at relPath before it is deleted.
+func (e *Engine) quarantineKey(ctx context.Context, b registry.Backend, keyPath, relPath string) error {
+	if e.Quarantine.Dir == "" {
+		return errNoQuarantineDir
+	}
+	file, err := e.Quarantine.SaveKey(b, keyPath, relPath)
+	if err != nil {
+		return err
+	}
+	recordQuarantined(ctx, relPath, file)
+	return nil
+}
+
+// quarantineValue backs up the value at valuePath before it is rewritten.
+func (e *Engine) quarantineValue(ctx context.Context, keyPath, valuePath string, value registry.RegValue) error {
+	if e.Quarantine.Dir == "" {
+		return errNoQuarantineDir
+	}
+	file, err := e.Quarantine.SaveValue(keyPath, valuePath, value)
+	if err != nil {
+		return err
+	}
+	recordQuarantined(ctx, valuePath, file)
+	return nil
+}
+
+// quarantineFile backs up the file at path before it is rewritten.
+func (e *Engine) quarantineFile(ctx context.Context, path string) error {
+	if e.Quarantine.Dir == "" {
+		return errNoQuarantineDir
+	}
+	file, err := e.Quarantine.SaveFile(path)
+	if err != nil {
+		return err
+	}
+	recordQuarantined(ctx, path, file)
+	return nil
+}
@@ -2,36 +2,32 @@
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
-	"golang.org/x/sys/windows"
 
-	"github.com/kad/WindowsBrowserGuard/pkg/admin"
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
 	"github.com/kad/WindowsBrowserGuard/pkg/detection"
 	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
 	"github.com/kad/WindowsBrowserGuard/pkg/registry"
 	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
 )
 
 // CaptureRegistryState captures the current state of a registry key and all its subkeys
-func CaptureRegistryState(ctx context.Context, hKey windows.Handle, keyPath string) (*registry.RegState, error) {
+func CaptureRegistryState(ctx context.Context, b registry.Backend, keyPath string) (*registry.RegState, error) {
 	startTime := time.Now()
 	ctx, span := telemetry.StartSpan(ctx, "monitor.CaptureRegistryState",
 		attribute.String("key-path", keyPath),
 	)
 	defer span.End()
 
-	state := &registry.RegState{
-		Subkeys: make(map[string]bool),
-		Values:  make(map[string]registry.RegValue),
-	}
-
-	err := registry.CaptureKeyRecursive(hKey, "", state, 0)
+	state, err := registry.CaptureState(b, keyPath)
 	duration := time.Since(startTime)
+	reportLoadWarnings(ctx, b)
 
 	if err != nil {
 		telemetry.RecordError(ctx, err)
@@ -53,11 +49,29 @@ func CaptureRegistryState(ctx context.Context, hKey windows.Handle, keyPath stri
 	return state, nil
 }
 
-// PrintDiff compares two registry states and prints the differences
-func PrintDiff(ctx context.Context, oldState, newState *registry.RegState, keyPath string, canWrite bool, extensionIndex *registry.ExtensionPathIndex) {
+// reportLoadWarnings logs the policy files and policies a managed backend
+// skipped while loading them.
+func reportLoadWarnings(ctx context.Context, b registry.Backend) {
+	managed, ok := b.(*registry.ManagedBackend)
+	if !ok {
+		return
+	}
+	for _, w := range managed.Warnings() {
+		telemetry.Printf(ctx, "  ⚠️  %s\n", w)
+		telemetry.LogWarn(ctx, w)
+	}
+}
+
+// ChangeHandler is called after each processed change notification with the
+// state the guard now considers current and the changes that led to it.
+type ChangeHandler func(ctx context.Context, state *registry.RegState, changes []registry.Change)
+
+// PrintDiff compares two registry states and prints the differences. It
+// returns the changes it found; their remediation is planned by the
+// watchers (see processChanges).
+func PrintDiff(ctx context.Context, oldState, newState *registry.RegState, keyPath string) []registry.Change {
 	ctx, span := telemetry.StartSpan(ctx, "monitor.PrintDiff",
 		attribute.String("key-path", keyPath),
-		attribute.Bool("can-write", canWrite),
 	)
 	defer span.End()
 	telemetry.Println(ctx, "\n========== CHANGES DETECTED ==========")
@@ -65,328 +79,363 @@ func PrintDiff(ctx context.Context, oldState, newState *registry.RegState, keyPa
 	telemetry.Println(ctx, "Key:", keyPath)
 	telemetry.Println(ctx, "======================================")
 
-	hasChanges := false
-
-	for name := range newState.Subkeys {
-		if !oldState.Subkeys[name] {
-			telemetry.Printf(ctx, "[SUBKEY ADDED] %s\n", name)
-			hasChanges = true
-		}
-	}
-	for name := range oldState.Subkeys {
-		if !newState.Subkeys[name] {
-			telemetry.Printf(ctx, "[SUBKEY REMOVED] %s\n", name)
-			hasChanges = true
-		}
-	}
-
-	for name, newVal := range newState.Values {
-		oldVal, exists := oldState.Values[name]
-		if !exists {
-			telemetry.Printf(ctx, "[VALUE ADDED] %s = %s (type: %d)\n", name, newVal.Data, newVal.Type)
-			hasChanges = true
-
-			if detection.IsChromeExtensionForcelist(name) {
-				telemetry.Printf(ctx, "  ⚠️  DETECTED Chrome ExtensionInstallForcelist VALUE - PROCESSING...\n")
-
-				if !admin.IsAdmin() {
-					telemetry.Printf(ctx, "  ❌ Insufficient privileges. Run as Administrator.\n")
-				} else {
-					forcelistKeyPath, hasParent := pathutils.GetParentPath(name)
-
-					if hasParent {
-						allValues, err := registry.ReadKeyValues(keyPath, forcelistKeyPath)
-						if err != nil {
-							telemetry.Printf(ctx, "  ⚠️  Could not read forcelist values: %v\n", err)
-						} else {
-							telemetry.Printf(ctx, "  📋 Processing all extension IDs in forcelist...\n")
-
-							blocklistKeyPath := detection.GetBlocklistKeyPath(forcelistKeyPath)
-							allowlistKeyPath := detection.GetAllowlistKeyPath(forcelistKeyPath)
-
-							blocklistConfirmed := false
-							plannedBlockedIDs := make(PlannedBlockedIDs)
-							for _, valueData := range allValues {
-								extensionID := detection.ExtractExtensionIDFromValue(valueData)
-								if extensionID != "" {
-									telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", extensionID)
-									trackPlannedBlockedID(plannedBlockedIDs, blocklistKeyPath, extensionID)
-
-									telemetry.Printf(ctx, "  📝 Adding to blocklist: %s\n", blocklistKeyPath)
-									err := registry.AddToBlocklist(keyPath, blocklistKeyPath, extensionID, !canWrite)
-									if err != nil {
-										telemetry.Printf(ctx, "  ⚠️  Failed to add to blocklist: %v\n", err)
-									} else if canWrite {
-										blocklistConfirmed = true
-									}
-
-									telemetry.Printf(ctx, "  🔍 Checking allowlist: %s\n", allowlistKeyPath)
-									err = registry.RemoveFromAllowlist(keyPath, allowlistKeyPath, extensionID, !canWrite)
-									if err != nil {
-										telemetry.Printf(ctx, "  ⚠️  Failed to remove from allowlist: %v\n", err)
-									}
-
-									registry.RemoveExtensionSettingsForID(keyPath, extensionID, !canWrite, newState, extensionIndex)
-								}
-							}
-
-							telemetry.Printf(ctx, "  🗑️  Deleting forcelist key: %s\n", forcelistKeyPath)
-							err = registry.DeleteRegistryKeyRecursive(keyPath, forcelistKeyPath, !canWrite)
-							if err != nil {
-								telemetry.Printf(ctx, "  ❌ Failed to delete key: %v\n", err)
-							} else {
-								telemetry.Printf(ctx, "  ✓ Successfully deleted forcelist key\n")
-								delete(newState.Subkeys, forcelistKeyPath)
-								for valName := range newState.Values {
-									if len(valName) > len(forcelistKeyPath) &&
-										valName[:len(forcelistKeyPath)] == forcelistKeyPath {
-										delete(newState.Values, valName)
-									}
-								}
-							}
-
-							if blocklistConfirmed {
-								// Keep newState in sync with the registry only after confirming
-								// the blocklist key exists following a successful write.
-								newState.Subkeys[blocklistKeyPath] = true
-							}
-
-							// Post-process: verify blocklist/allowlist consistency
-							// across all known allowlists in newState. Each comparison
-							// remains browser-local (Chrome vs Chrome, Edge vs Edge).
-							EnforceBlockAllowlistConsistency(ctx, keyPath, newState, canWrite, plannedBlockedIDs)
-						}
-					}
-				}
+	changes := registry.Diff(oldState, newState)
+	for _, change := range changes {
+		PrintChange(ctx, change)
+	}
+
+	if len(changes) == 0 {
+		telemetry.Println(ctx, "(No actual changes detected - likely a metadata update)")
+	}
+
+	telemetry.Println(ctx, "======================================")
+	telemetry.Println(ctx)
+	return changes
+}
+
+// PrintChange prints a single change in the watch log format.
+func PrintChange(ctx context.Context, change registry.Change) {
+	switch change.Kind {
+	case registry.SubkeyAdded, registry.SubkeyRemoved, registry.ValueRemoved:
+		telemetry.Printf(ctx, "[%s] %s\n", change.Kind, change.Path)
+	case registry.ValueAdded:
+		telemetry.Printf(ctx, "[%s] %s = %s (type: %d)\n", change.Kind, change.Path, change.New.Data, change.New.Type)
+	case registry.ValueChanged:
+		telemetry.Printf(ctx, "[%s] %s\n", change.Kind, change.Path)
+		telemetry.Printf(ctx, "  Old: %s (type: %d)\n", change.Old.Data, change.Old.Type)
+		telemetry.Printf(ctx, "  New: %s (type: %d)\n", change.New.Data, change.New.Type)
+		if change.Old.Type == detection.RegMultiSZ && change.New.Type == detection.RegMultiSZ {
+			printMultiStringDiff(ctx, change.Old.Strings(), change.New.Strings())
+		}
+	}
+}
+
+// printMultiStringDiff prints the entries added to and removed from a
+// REG_MULTI_SZ value.
+func printMultiStringDiff(ctx context.Context, oldStrs, newStrs []string) {
+	for _, s := range oldStrs {
+		if !slices.Contains(newStrs, s) {
+			telemetry.Printf(ctx, "  - %s\n", s)
+		}
+	}
+	for _, s := range newStrs {
+		if !slices.Contains(oldStrs, s) {
+			telemetry.Printf(ctx, "  + %s\n", s)
+		}
+	}
+}
+
+// RemediateChanges plans the remediation of the ValueAdded entries of
+// changes: Chromium forcelist values and Firefox install policies are
+// blocked and their keys deleted. Added or changed ExtensionSettings JSON
+// values are handled by RemediateExtensionSettingsJSON, added or changed
+// hijack policies like ProcessHijackPolicies handles them. state is the
+// state after changes.
+func (e *Engine) RemediateChanges(ctx context.Context, changes []registry.Change, state *registry.RegState) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.RemediateChanges",
+		attribute.Int("changes-count", len(changes)),
+	)
+	defer span.End()
+
+	var actions []plan.Action
+	// Forcelist keys that keep exempted entries are not deleted, so their
+	// remaining added values must not trigger a second pass.
+	processedForcelists := make(map[string]bool)
+	// Several values of one hijack policy key are reported once.
+	processedHijacks := make(map[string]bool)
+	index := newExtensionIndex(state)
+
+	for _, change := range changes {
+		if change.Kind != registry.ValueAdded && change.Kind != registry.ValueChanged {
+			continue
+		}
+		// An earlier change in the list may already be handled by a planned
+		// key deletion, e.g. a sibling value of the same Extensions key.
+		if _, exists := state.Values[change.Path]; !exists || removedBy(actions, change.Path) {
+			continue
+		}
+		name, newVal := change.Path, change.New
+
+		if hit, ok := matchHijackValue(name, newVal); ok {
+			if !processedHijacks[hit.Target] {
+				processedHijacks[hit.Target] = true
+				actions = append(actions, e.remediateHijackPolicy(ctx, hit, state)...)
 			}
+			continue
+		}
 
-			if detection.IsFirefoxExtensionSettings(name) && pathutils.Contains(name, "installation_mode") {
-				if newVal.Data == "force_installed" || newVal.Data == "normal_installed" {
-					telemetry.Printf(ctx, "  ⚠️  DETECTED Firefox extension install policy - PROCESSING...\n")
-
-					if !admin.IsAdmin() {
-						telemetry.Printf(ctx, "  ❌ Insufficient privileges. Run as Administrator.\n")
-					} else {
-						extensionID := detection.ExtractFirefoxExtensionID(name)
-						if extensionID != "" {
-							telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", extensionID)
-
-							telemetry.Printf(ctx, "  📝 Blocking Firefox extension\n")
-							err := registry.BlockFirefoxExtension(keyPath, extensionID, !canWrite)
-							if err != nil {
-								telemetry.Printf(ctx, "  ⚠️  Failed to block extension: %v\n", err)
-							}
-
-							extensionKeyPath, hasParent := pathutils.GetParentPath(name)
-
-							if hasParent {
-								telemetry.Printf(ctx, "  🗑️  Deleting install policy: %s\n", extensionKeyPath)
-								err = registry.DeleteRegistryKeyRecursive(keyPath, extensionKeyPath, !canWrite)
-								if err != nil {
-									telemetry.Printf(ctx, "  ❌ Failed to delete key: %v\n", err)
-								} else {
-									telemetry.Printf(ctx, "  ✓ Successfully deleted install policy\n")
-									delete(newState.Subkeys, extensionKeyPath)
-									registry.RemoveSubtreeFromState(newState, extensionKeyPath)
-								}
-							}
-						}
-					}
-				}
+		// The JSON form of ExtensionSettings is one value, so editing it is
+		// as dangerous as adding it.
+		if detection.IsExtensionSettingsValue(name) {
+			actions = append(actions, e.RemediateExtensionSettingsJSON(ctx, name, state, index)...)
+			continue
+		}
+		if change.Kind != registry.ValueAdded {
+			continue
+		}
+
+		if detection.IsChromeExtensionForcelist(name) {
+			telemetry.Printf(ctx, "  ⚠️  DETECTED %s ExtensionInstallForcelist VALUE - PROCESSING...\n", detection.GetBrowserFromPath(name))
+			if forcelistKeyPath, hasParent := pathutils.GetParentPath(name); hasParent && !processedForcelists[forcelistKeyPath] {
+				processedForcelists[forcelistKeyPath] = true
+				mode := e.resolveMode(ctx, DetectorForcelist)
+				actions = append(actions, e.remediateForcelist(ctx, forcelistKeyPath, state, mode, index)...)
 			}
+		}
 
-			// Firefox Extensions\Install and Extensions\Locked (legacy GP format)
-			if detection.IsFirefoxExtensionsInstall(name) || detection.IsFirefoxExtensionsLocked(name) {
-				telemetry.Printf(ctx, "  ⚠️  DETECTED Firefox Extensions policy (%s) - PROCESSING...\n", name)
-
-				if !admin.IsAdmin() {
-					telemetry.Printf(ctx, "  ❌ Insufficient privileges. Run as Administrator.\n")
-				} else {
-					// Extensions\Locked value data is the extension ID — block it before removing the key
-					if detection.IsFirefoxExtensionsLocked(name) {
-						extID := detection.SanitizeExtensionID(newVal.Data)
-						if extID != "" {
-							telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", extID)
-							telemetry.Printf(ctx, "  📝 Blocking Firefox extension\n")
-							if err := registry.BlockFirefoxExtension(keyPath, extID, !canWrite); err != nil {
-								telemetry.Printf(ctx, "  ⚠️  Failed to block extension: %v\n", err)
-							}
-						} else {
-							telemetry.Printf(ctx, "  ⚠️  Skipping block for %s: invalid extension ID in value data %q\n", name, newVal.Data)
-						}
-					}
-
-					keyToDelete := detection.GetFirefoxExtensionsKeyPath(name)
-					if keyToDelete != "" {
-						telemetry.Printf(ctx, "  🗑️  Deleting Firefox Extensions policy key: %s\n", keyToDelete)
-						err := registry.DeleteRegistryKeyRecursive(keyPath, keyToDelete, !canWrite)
-						if err != nil {
-							telemetry.Printf(ctx, "  ❌ Failed to delete key: %v\n", err)
-						} else {
-							telemetry.Printf(ctx, "  ✓ Successfully deleted Firefox Extensions policy\n")
-							delete(newState.Subkeys, keyToDelete)
-							registry.RemoveSubtreeFromState(newState, keyToDelete)
-						}
-					}
-				}
+		if isFirefoxInstallMode(name, newVal) {
+			telemetry.Printf(ctx, "  ⚠️  DETECTED Firefox extension install policy - PROCESSING...\n")
+			extensionID := detection.ExtractFirefoxExtensionID(name)
+			if rule, ok := e.matchFirefoxExemption(state, name, extensionID); ok {
+				logExemption(ctx, extensionID, rule)
+			} else if extensionID != "" {
+				telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", extensionID)
+				mode := e.resolveMode(ctx, DetectorExtensionSettings)
+				actions = append(actions, remediateFirefoxSettingsEntry(ctx, name, extensionID, mode)...)
 			}
-		} else if oldVal.Data != newVal.Data || oldVal.Type != newVal.Type {
-			telemetry.Printf(ctx, "[VALUE CHANGED] %s\n", name)
-			telemetry.Printf(ctx, "  Old: %s (type: %d)\n", oldVal.Data, oldVal.Type)
-			telemetry.Printf(ctx, "  New: %s (type: %d)\n", newVal.Data, newVal.Type)
-			hasChanges = true
+		}
+
+		// Firefox Extensions\Install and Extensions\Locked (legacy GP format)
+		if detection.IsFirefoxExtensionsInstall(name) || detection.IsFirefoxExtensionsLocked(name) {
+			telemetry.Printf(ctx, "  ⚠️  DETECTED Firefox Extensions policy (%s) - PROCESSING...\n", name)
+			mode := e.resolveMode(ctx, DetectorFirefoxExtensions)
+			actions = append(actions, remediateFirefoxExtensionsPolicy(ctx, name, newVal, state, mode)...)
+		}
+	}
+	span.SetAttributes(attribute.Int("actions", len(actions)))
+	return actions
+}
+
+// isFirefoxInstallMode reports whether the value at valuePath is the
+// installation_mode of a Firefox ExtensionSettings\{id} subkey that force-
+// or normal-installs the extension.
+func isFirefoxInstallMode(valuePath string, value registry.RegValue) bool {
+	if !detection.IsFirefoxExtensionSettings(valuePath) || !pathutils.Contains(valuePath, "installation_mode") {
+		return false
+	}
+	installMode := value.Unexpanded()
+	return installMode == "force_installed" || installMode == "normal_installed"
+}
+
+// removedBy reports whether one of actions deletes the key holding path.
+func removedBy(actions []plan.Action, path string) bool {
+	for _, a := range actions {
+		if a.Kind != plan.DeleteKey {
+			continue
+		}
+		if _, below := pathutils.TrimPathPrefix(path, a.Path); below {
+			return true
 		}
 	}
+	return false
+}
+
+// newExtensionIndex returns the index of the 3rdparty extension policy keys
+// in state.
+func newExtensionIndex(state *registry.RegState) *registry.ExtensionPathIndex {
+	index := registry.NewExtensionPathIndex()
+	index.BuildFromState(state)
+	return index
+}
+
+// remediateForcelist plans the remediation of the Chromium forcelist key at
+// forcelistKeyPath: the extensions it installs that no exemption or update
+// URL rule keeps are blocked, and the key is pruned to its kept entries or,
+// without any, deleted, as mode allows.
+func (e *Engine) remediateForcelist(ctx context.Context, forcelistKeyPath string, state *registry.RegState, mode Mode, index *registry.ExtensionPathIndex) []plan.Action {
+	values := keyValues(state, forcelistKeyPath)
+	telemetry.Printf(ctx, "  📋 Processing all extension IDs in forcelist...\n")
+	forcelist := e.planForcelist(ctx, forcelistKeyPath, values)
+
+	blocklistKeyPath := detection.GetBlocklistKeyPath(forcelistKeyPath)
+	allowlistKeyPath := detection.GetAllowlistKeyPath(forcelistKeyPath)
+	forced := cause{detector: DetectorForcelist, source: forcelistKeyPath, reason: "force-installed by ExtensionInstallForcelist"}
 
-	for name := range oldState.Values {
-		if _, exists := newState.Values[name]; !exists {
-			telemetry.Printf(ctx, "[VALUE REMOVED] %s\n", name)
-			hasChanges = true
+	var actions []plan.Action
+	for _, extensionID := range forcelist.blockIDs {
+		telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", extensionID)
+		if mode.blocks() {
+			actions = append(actions, blockChromium(ctx, state, blocklistKeyPath, allowlistKeyPath, extensionID, forced)...)
+		}
+		if mode.removes() {
+			actions = append(actions, settingsRemovals(index, extensionID, forced)...)
 		}
 	}
 
-	if !hasChanges {
-		telemetry.Println(ctx, "(No actual changes detected - likely a metadata update)")
+	switch {
+	case !mode.removes():
+		telemetry.Printf(ctx, "  📌 Leaving %s forcelist key in place (%s mode): %s\n", detection.GetBrowserFromPath(forcelistKeyPath), mode, forcelistKeyPath)
+	case len(forcelist.keep) > 0:
+		actions = append(actions, pruneForcelist(ctx, forcelistKeyPath, values, forcelist, mode)...)
+	default:
+		telemetry.Printf(ctx, "  🗑️  Deleting %s forcelist key: %s\n", detection.GetBrowserFromPath(forcelistKeyPath), forcelistKeyPath)
+		c := cause{detector: DetectorForcelist, reason: "forcelist installs blocked extensions", quarantine: mode == Quarantine}
+		actions = append(actions, c.action(plan.DeleteKey, forcelistKeyPath, ""))
 	}
+	return actions
+}
 
-	telemetry.Println(ctx, "======================================")
-	fmt.Println()
+// blockChromium plans blocking extensionID in the Chromium blocklist key at
+// blocklistPath and removing it from the allowlist key at allowlistPath.
+// What state shows done already is left out.
+func blockChromium(ctx context.Context, state *registry.RegState, blocklistPath, allowlistPath, extensionID string, c cause) []plan.Action {
+	var actions []plan.Action
+	browser := detection.GetBrowserFromPath(blocklistPath)
+	if !listsID(keyValues(state, blocklistPath), extensionID) {
+		telemetry.Printf(ctx, "  📝 Adding to %s blocklist: %s\n", browser, blocklistPath)
+		actions = append(actions, c.action(plan.AddBlocklistEntry, blocklistPath, extensionID))
+	}
+	if listsID(keyValues(state, allowlistPath), extensionID) {
+		telemetry.Printf(ctx, "  🗑️  Removing from %s allowlist: %s\n", browser, allowlistPath)
+		actions = append(actions, c.action(plan.RemoveAllowlistValue, allowlistPath, extensionID))
+	}
+	return actions
 }
 
-// ProcessExistingPolicies scans for and processes existing extension install policies
-func ProcessExistingPolicies(ctx context.Context, keyPath string, state *registry.RegState, canWrite bool, extensionIndex *registry.ExtensionPathIndex) {
-	ctx, span := telemetry.StartSpan(ctx, "monitor.ProcessExistingPolicies",
-		attribute.String("key-path", keyPath),
-		attribute.Bool("can-write", canWrite),
-	)
-	defer span.End()
+// settingsRemovals plans deleting the 3rdparty extension policy keys of
+// extensionID found by index. Keys below another deleted key go with it.
+func settingsRemovals(index *registry.ExtensionPathIndex, extensionID string, c cause) []plan.Action {
+	paths := index.GetPaths(extensionID)
+	slices.Sort(paths)
+	var actions []plan.Action
+	var removed []string
+	for _, path := range paths {
+		if slices.ContainsFunc(removed, func(parent string) bool {
+			_, below := pathutils.TrimPathPrefix(path, parent)
+			return below
+		}) {
+			continue
+		}
+		removed = append(removed, path)
+		actions = append(actions, c.action(plan.RemoveExtensionSettings, path, extensionID))
+	}
+	return actions
+}
 
-	if !canWrite {
-		telemetry.Println(ctx, "\n========================================")
-		telemetry.Println(ctx, "Checking for existing extension policies...")
-		telemetry.Println(ctx, "(DRY-RUN MODE - showing planned operations)")
-		telemetry.Println(ctx, "========================================")
-	} else if !admin.IsAdmin() {
-		telemetry.Println(ctx, "\n⚠️  Not running as Administrator - skipping existing policy processing")
-		return
-	} else {
-		telemetry.Println(ctx, "\n========================================")
-		telemetry.Println(ctx, "Checking for existing extension policies...")
-		telemetry.Println(ctx, "========================================")
+// remediateFirefoxSettingsEntry plans the remediation of the Firefox
+// ExtensionSettings\{id} subkey whose installation_mode value is at
+// modePath. Blocking rewrites the entry itself to "blocked", so the key is
+// only deleted when mode removes without blocking.
+func remediateFirefoxSettingsEntry(ctx context.Context, modePath, extensionID string, mode Mode) []plan.Action {
+	entryKeyPath, hasParent := pathutils.GetParentPath(modePath)
+	if !hasParent {
+		return nil
+	}
+	c := cause{detector: DetectorExtensionSettings, source: modePath, reason: "installed by Firefox ExtensionSettings", quarantine: mode == Quarantine}
+	if mode.blocks() {
+		telemetry.Printf(ctx, "  📝 Blocking Firefox extension\n")
+		return []plan.Action{c.action(plan.SetFirefoxBlocked, entryKeyPath, extensionID)}
+	}
+	telemetry.Printf(ctx, "  🗑️  Deleting Firefox install policy: %s\n", entryKeyPath)
+	return []plan.Action{c.action(plan.DeleteKey, entryKeyPath, "")}
+}
+
+// remediateFirefoxExtensionsPolicy plans the remediation of a value of the
+// legacy Firefox Extensions\Install or Extensions\Locked key at valuePath:
+// locked IDs are blocked and the key is deleted, as mode allows.
+func remediateFirefoxExtensionsPolicy(ctx context.Context, valuePath string, value registry.RegValue, state *registry.RegState, mode Mode) []plan.Action {
+	var actions []plan.Action
+	// Extensions\Locked value data is the extension ID — block it before removing the key
+	if mode.blocks() && detection.IsFirefoxExtensionsLocked(valuePath) {
+		extID := detection.SanitizeExtensionID(value.Unexpanded())
+		policyRoot := detection.GetPolicyRootFromPath(valuePath)
+		switch {
+		case extID == "":
+			telemetry.Printf(ctx, "  ⚠️  Skipping block for %s: invalid extension ID in value data %q\n", valuePath, value.Data)
+		case !geckoBlockedIn(state, policyRoot, extID):
+			telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", extID)
+			telemetry.Printf(ctx, "  📝 Blocking Firefox extension\n")
+			c := cause{detector: DetectorFirefoxExtensions, source: valuePath, reason: "locked by Firefox Extensions\\Locked"}
+			actions = append(actions, c.action(plan.SetFirefoxBlocked, detection.GetGeckoBlocklistPath(policyRoot, extID), extID))
+		}
+	}
+
+	keyToDelete := detection.GetFirefoxExtensionsKeyPath(valuePath)
+	if keyToDelete == "" {
+		return actions
+	}
+	if !mode.removes() {
+		telemetry.Printf(ctx, "  📌 Leaving Firefox Extensions policy key in place (%s mode): %s\n", mode, keyToDelete)
+		return actions
 	}
+	telemetry.Printf(ctx, "  🗑️  Deleting Firefox Extensions policy key: %s\n", keyToDelete)
+	c := cause{detector: DetectorFirefoxExtensions, source: valuePath, reason: "installs extensions through the Firefox Extensions policy", quarantine: mode == Quarantine}
+	return append(actions, c.action(plan.DeleteKey, keyToDelete, ""))
+}
+
+// ProcessExistingPolicies plans the remediation of the extension install
+// policies in state: Chromium forcelists, the JSON form of
+// ExtensionSettings, force- or normal-installed Firefox ExtensionSettings
+// entries and the Firefox Extensions\Install and Extensions\Locked keys.
+func (e *Engine) ProcessExistingPolicies(ctx context.Context, state *registry.RegState) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.ProcessExistingPolicies")
+	defer span.End()
 
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Println(ctx, "Checking for existing extension policies...")
+	telemetry.Println(ctx, "========================================")
+
+	var actions []plan.Action
 	hasExistingPolicies := false
+	// Forcelist keys that keep exempted entries are not deleted, so their
+	// remaining values must not trigger a second pass.
+	processedForcelists := make(map[string]bool)
+	index := newExtensionIndex(state)
+
+	// Sorted so that the plan lists the actions in the same order every
+	// time. Values whose key is already planned for deletion are skipped.
+	for _, valuePath := range slices.Sorted(maps.Keys(state.Values)) {
+		if removedBy(actions, valuePath) {
+			continue
+		}
+		value := state.Values[valuePath]
+		if detection.IsExtensionSettingsValue(valuePath) {
+			if found := e.RemediateExtensionSettingsJSON(ctx, valuePath, state, index); len(found) > 0 {
+				hasExistingPolicies = true
+				actions = append(actions, found...)
+			}
+		}
 
-	for valuePath, value := range state.Values {
 		if detection.IsChromeExtensionForcelist(valuePath) {
 			hasExistingPolicies = true
 			telemetry.Printf(ctx, "\n[EXISTING CHROME POLICY DETECTED]\n")
 			telemetry.Printf(ctx, "Path: %s\n", valuePath)
 			telemetry.Printf(ctx, "Value: %s\n", value.Data)
 
-			extensionID := detection.ExtractExtensionIDFromValue(value.Data)
-			if extensionID != "" {
-				telemetry.Printf(ctx, "🔍 Extension ID: %s\n", extensionID)
+			valueIDs := registry.ExtensionIDs(value)
+			if len(valueIDs) > 0 {
+				browser := detection.GetBrowserIDFromPath(valuePath)
 
-				// Determine browser from path
-				browser := "chrome"
-				if strings.Contains(strings.ToLower(valuePath), "edge") {
-					browser = "edge"
-				}
+				for _, extensionID := range valueIDs {
+					telemetry.Printf(ctx, "🔍 Extension ID: %s\n", extensionID)
 
-				// Record metrics
-				telemetry.RecordExtensionDetected(ctx, browser, extensionID)
+					// Record metrics
+					e.recordExtensionDetected(ctx, browser, extensionID)
+				}
 
 				forcelistKeyPath, hasParent := pathutils.GetParentPath(valuePath)
 
-				if hasParent {
-					allValues, err := registry.ReadKeyValues(keyPath, forcelistKeyPath)
-					if err != nil {
-						telemetry.Printf(ctx, "⚠️  Could not read forcelist values: %v\n", err)
-					} else {
-						telemetry.Printf(ctx, "📋 Processing all extension IDs in forcelist...\n")
-
-						blocklistKeyPath := detection.GetBlocklistKeyPath(forcelistKeyPath)
-						allowlistKeyPath := detection.GetAllowlistKeyPath(forcelistKeyPath)
-
-						for _, valueData := range allValues {
-							extensionID := detection.ExtractExtensionIDFromValue(valueData)
-							if extensionID != "" {
-								telemetry.Printf(ctx, "🔍 Extension ID: %s\n", extensionID)
-
-								// Determine browser and record metrics
-								browser := "chrome"
-								if strings.Contains(strings.ToLower(forcelistKeyPath), "edge") {
-									browser = "edge"
-								}
-								telemetry.RecordExtensionBlocked(ctx, browser, extensionID)
-
-								telemetry.Printf(ctx, "📝 Adding to Chrome blocklist: %s\n", blocklistKeyPath)
-								err := registry.AddToBlocklist(keyPath, blocklistKeyPath, extensionID, !canWrite)
-								if err != nil {
-									telemetry.Printf(ctx, "⚠️  Failed to add to blocklist: %v\n", err)
-								}
-
-								telemetry.Printf(ctx, "🔍 Checking Chrome allowlist: %s\n", allowlistKeyPath)
-								err = registry.RemoveFromAllowlist(keyPath, allowlistKeyPath, extensionID, !canWrite)
-								if err != nil {
-									telemetry.Printf(ctx, "⚠️  Failed to remove from allowlist: %v\n", err)
-								}
-
-								registry.RemoveExtensionSettingsForID(keyPath, extensionID, !canWrite, state, extensionIndex)
-							}
-						}
-					}
-
-					telemetry.Printf(ctx, "🗑️  Deleting Chrome forcelist key: %s\n", forcelistKeyPath)
-					err = registry.DeleteRegistryKeyRecursive(keyPath, forcelistKeyPath, !canWrite)
-					if err != nil {
-						telemetry.Printf(ctx, "❌ Failed to delete key: %v\n", err)
-					} else {
-						telemetry.Printf(ctx, "✓ Successfully removed forcelist key\n")
-						delete(state.Subkeys, forcelistKeyPath)
-						for valName := range state.Values {
-							if len(valName) > len(forcelistKeyPath) &&
-								valName[:len(forcelistKeyPath)] == forcelistKeyPath {
-								delete(state.Values, valName)
-							}
-						}
-					}
+				if hasParent && !processedForcelists[forcelistKeyPath] {
+					processedForcelists[forcelistKeyPath] = true
+					mode := e.resolveMode(ctx, DetectorForcelist)
+					actions = append(actions, e.remediateForcelist(ctx, forcelistKeyPath, state, mode, index)...)
 				}
 			}
 		}
 
-		if detection.IsFirefoxExtensionSettings(valuePath) && pathutils.Contains(valuePath, "installation_mode") {
-			if value.Data == "force_installed" || value.Data == "normal_installed" {
-				hasExistingPolicies = true
-				telemetry.Printf(ctx, "\n[EXISTING FIREFOX POLICY DETECTED]\n")
-				telemetry.Printf(ctx, "Path: %s\n", valuePath)
-				telemetry.Printf(ctx, "Value: %s\n", value.Data)
-
-				extensionID := detection.ExtractFirefoxExtensionID(valuePath)
-				if extensionID != "" {
-					telemetry.Printf(ctx, "🔍 Extension ID: %s\n", extensionID)
+		if isFirefoxInstallMode(valuePath, value) {
+			hasExistingPolicies = true
+			telemetry.Printf(ctx, "\n[EXISTING FIREFOX POLICY DETECTED]\n")
+			telemetry.Printf(ctx, "Path: %s\n", valuePath)
+			telemetry.Printf(ctx, "Value: %s\n", value.Data)
 
-					telemetry.Printf(ctx, "📝 Blocking Firefox extension\n")
-					err := registry.BlockFirefoxExtension(keyPath, extensionID, !canWrite)
-					if err != nil {
-						telemetry.Printf(ctx, "⚠️  Failed to block extension: %v\n", err)
-					}
-
-					extensionKeyPath, hasParent := pathutils.GetParentPath(valuePath)
-
-					if hasParent {
-						telemetry.Printf(ctx, "🗑️  Deleting Firefox install policy: %s\n", extensionKeyPath)
-						err = registry.DeleteRegistryKeyRecursive(keyPath, extensionKeyPath, !canWrite)
-						if err != nil {
-							telemetry.Printf(ctx, "❌ Failed to delete key: %v\n", err)
-						} else {
-							telemetry.Printf(ctx, "✓ Successfully removed install policy\n")
-							delete(state.Subkeys, extensionKeyPath)
-							registry.RemoveSubtreeFromState(state, extensionKeyPath)
-						}
-					}
-				}
+			extensionID := detection.ExtractFirefoxExtensionID(valuePath)
+			if rule, ok := e.matchFirefoxExemption(state, valuePath, extensionID); ok {
+				logExemption(ctx, extensionID, rule)
+			} else if extensionID != "" {
+				telemetry.Printf(ctx, "🔍 Extension ID: %s\n", extensionID)
+				mode := e.resolveMode(ctx, DetectorExtensionSettings)
+				actions = append(actions, remediateFirefoxSettingsEntry(ctx, valuePath, extensionID, mode)...)
 			}
 		}
 
@@ -397,143 +446,68 @@ func ProcessExistingPolicies(ctx context.Context, keyPath string, state *registr
 			telemetry.Printf(ctx, "Path: %s\n", valuePath)
 			telemetry.Printf(ctx, "Value: %s\n", value.Data)
 
-			// Extensions\Locked value data is the extension ID — block it before removing the key
-			if detection.IsFirefoxExtensionsLocked(valuePath) {
-				extID := detection.SanitizeExtensionID(value.Data)
-				if extID != "" {
-					telemetry.Printf(ctx, "🔍 Extension ID: %s\n", extID)
-					telemetry.Printf(ctx, "📝 Blocking Firefox extension\n")
-					if err := registry.BlockFirefoxExtension(keyPath, extID, !canWrite); err != nil {
-						telemetry.Printf(ctx, "⚠️  Failed to block extension: %v\n", err)
-					}
-				} else {
-					telemetry.Printf(ctx, "⚠️  Skipping block for %s: invalid extension ID in value data %q\n", valuePath, value.Data)
-				}
-			}
-
-			keyToDelete := detection.GetFirefoxExtensionsKeyPath(valuePath)
-			if keyToDelete != "" {
-				telemetry.Printf(ctx, "🗑️  Deleting Firefox Extensions policy key: %s\n", keyToDelete)
-				err := registry.DeleteRegistryKeyRecursive(keyPath, keyToDelete, !canWrite)
-				if err != nil {
-					telemetry.Printf(ctx, "❌ Failed to delete key: %v\n", err)
-				} else {
-					telemetry.Printf(ctx, "✓ Successfully removed Firefox Extensions policy\n")
-					delete(state.Subkeys, keyToDelete)
-					registry.RemoveSubtreeFromState(state, keyToDelete)
-				}
-			}
+			mode := e.resolveMode(ctx, DetectorFirefoxExtensions)
+			actions = append(actions, remediateFirefoxExtensionsPolicy(ctx, valuePath, value, state, mode)...)
 		}
 	}
 
 	if !hasExistingPolicies {
 		telemetry.Println(ctx, "✓ No existing extension install policies found")
 	}
+	span.SetAttributes(attribute.Int("actions", len(actions)))
 
 	telemetry.Println(ctx, "========================================")
-	fmt.Println()
+	telemetry.Println(ctx)
+	return actions
 }
 
-// CleanupAllowlists removes ExtensionInstallAllowlist keys
-func CleanupAllowlists(ctx context.Context, keyPath string, state *registry.RegState, canWrite bool) {
-	ctx, span := telemetry.StartSpan(ctx, "monitor.CleanupAllowlists",
-		attribute.String("key-path", keyPath),
-		attribute.Bool("can-write", canWrite),
-	)
+// CleanupAllowlists plans deleting the ExtensionInstallAllowlist keys in
+// state. It only runs when the Cleanup action mode removes.
+func (e *Engine) CleanupAllowlists(ctx context.Context, state *registry.RegState) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.CleanupAllowlists")
 	defer span.End()
 
-	if !canWrite {
-		telemetry.Println(ctx, "(DRY-RUN MODE - showing planned operations)")
-	} else if !admin.IsAdmin() {
-		telemetry.Println(ctx, "\n⚠️  Not running as Administrator - skipping allowlist cleanup")
-		return
+	mode := e.resolveMode(ctx, DetectorCleanup)
+	if !mode.removes() {
+		telemetry.Printf(ctx, "\n⏭️  Skipping allowlist cleanup (%s mode: %s)\n", DetectorCleanup, mode)
+		return nil
 	}
 
 	telemetry.Println(ctx, "Checking for ExtensionInstallAllowlist keys...")
 
-	allowlistsFound := false
-	allowlistKeys := make(map[string]bool)
-
+	var allowlistKeys []string
 	for subkeyPath := range state.Subkeys {
-		if pathutils.Contains(subkeyPath, "ExtensionInstallAllowlist") {
-			allowlistsFound = true
-			allowlistKeys[subkeyPath] = true
+		if detection.IsChromiumPolicyKey(subkeyPath, browsers.ExtensionInstallAllowlist) {
+			allowlistKeys = append(allowlistKeys, subkeyPath)
 		}
 	}
-
-	if !allowlistsFound {
+	if len(allowlistKeys) == 0 {
 		telemetry.Println(ctx, "✓ No ExtensionInstallAllowlist keys found")
-		return
+		return nil
 	}
+	slices.Sort(allowlistKeys)
 
-	for allowlistPath := range allowlistKeys {
+	var actions []plan.Action
+	c := cause{detector: DetectorCleanup, reason: "allowlists are removed by cleanup", quarantine: mode == Quarantine}
+	for _, allowlistPath := range allowlistKeys {
 		telemetry.Printf(ctx, "\n[REMOVING ALLOWLIST]\n")
 		telemetry.Printf(ctx, "Path: %s\n", allowlistPath)
 
-		values, err := registry.ReadKeyValues(keyPath, allowlistPath)
-		if err == nil && len(values) > 0 {
+		if values := keyValues(state, allowlistPath); len(values) > 0 {
 			telemetry.Printf(ctx, "Found %d extension(s) in allowlist:\n", len(values))
-			for valueName, valueData := range values {
-				extensionID := detection.ExtractExtensionIDFromValue(valueData)
-				if extensionID != "" {
+			for _, valueName := range slices.Sorted(maps.Keys(values)) {
+				for _, extensionID := range registry.ExtensionIDs(values[valueName]) {
 					telemetry.Printf(ctx, "  - %s: %s\n", valueName, extensionID)
 				}
 			}
 		}
 
 		telemetry.Printf(ctx, "🗑️  Deleting allowlist key: %s\n", allowlistPath)
-		err = registry.DeleteRegistryKeyRecursive(keyPath, allowlistPath, !canWrite)
-		if err != nil {
-			telemetry.Printf(ctx, "❌ Failed to delete allowlist: %v\n", err)
-		} else {
-			telemetry.Printf(ctx, "✓ Successfully deleted allowlist\n")
-			delete(state.Subkeys, allowlistPath)
-			for valName := range state.Values {
-				if len(valName) > len(allowlistPath) &&
-					valName[:len(allowlistPath)] == allowlistPath {
-					delete(state.Values, valName)
-				}
-			}
-		}
+		actions = append(actions, c.action(plan.DeleteKey, allowlistPath, ""))
 	}
 
-	fmt.Println()
-}
-
-// PlannedBlockedIDs maps a blocklist key path to extension IDs that are
-// expected to be added during the current enforcement pass but may not yet
-// exist in the live registry (for example during dry-run).
-type PlannedBlockedIDs map[string]map[string]bool
-
-func trackPlannedBlockedID(planned PlannedBlockedIDs, blocklistPath, extensionID string) {
-	if planned == nil || blocklistPath == "" || extensionID == "" {
-		return
-	}
-	if planned[blocklistPath] == nil {
-		planned[blocklistPath] = make(map[string]bool)
-	}
-	planned[blocklistPath][extensionID] = true
-}
-
-// CollectPlannedBlockedIDs scans Chromium forcelists in the captured state and
-// returns the blocklist entries that would be created from them.
-func CollectPlannedBlockedIDs(state *registry.RegState) PlannedBlockedIDs {
-	planned := make(PlannedBlockedIDs)
-	for valuePath, value := range state.Values {
-		if !detection.IsChromeExtensionForcelist(valuePath) {
-			continue
-		}
-		parentPath, ok := pathutils.GetParentPath(valuePath)
-		if !ok {
-			continue
-		}
-		extensionID := detection.ExtractExtensionIDFromValue(value.Data)
-		if extensionID == "" {
-			continue
-		}
-		trackPlannedBlockedID(planned, detection.GetBlocklistKeyPath(parentPath), extensionID)
-	}
-	return planned
+	telemetry.Println(ctx)
+	return actions
 }
 
 // EnforceBlockAllowlistConsistency ensures that no extension ID present in a
@@ -544,238 +518,133 @@ func CollectPlannedBlockedIDs(state *registry.RegState) PlannedBlockedIDs {
 //
 // For every ExtensionInstallAllowlist key found in state the function derives
 // the corresponding ExtensionInstallBlocklist path (same browser, same base
-// path) and reads it live from the registry. During dry-run it can also merge
-// planned blocklist additions so the reported allowlist removals reflect the
-// writes that would happen. Any allowlist value whose extension ID is present
-// in that browser's blocklist is removed; if the allowlist key is empty
-// afterwards it is deleted entirely.
-func EnforceBlockAllowlistConsistency(ctx context.Context, keyPath string, state *registry.RegState, canWrite bool, plannedBlockedIDs PlannedBlockedIDs) {
-	ctx, span := telemetry.StartSpan(ctx, "monitor.EnforceBlockAllowlistConsistency",
-		attribute.String("key-path", keyPath),
-		attribute.Bool("can-write", canWrite),
-	)
+// path) and reads it from state, which holds the blocklist entries planned by
+// the passes before it. Every blocked extension ID present in the allowlist
+// is removed from it; if no allowlist value is left the key is deleted
+// entirely. It only runs when the Cleanup action mode blocks.
+func (e *Engine) EnforceBlockAllowlistConsistency(ctx context.Context, state *registry.RegState) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.EnforceBlockAllowlistConsistency")
 	defer span.End()
 
-	if !canWrite {
-		telemetry.Println(ctx, "\n========================================")
-		telemetry.Println(ctx, "Enforcing blocklist/allowlist consistency...")
-		telemetry.Println(ctx, "(DRY-RUN MODE - showing planned operations)")
-		telemetry.Println(ctx, "========================================")
-	} else if !admin.IsAdmin() {
-		telemetry.Println(ctx, "\n⚠️  Not running as Administrator - skipping blocklist/allowlist consistency check")
-		return
-	} else {
-		telemetry.Println(ctx, "\n========================================")
-		telemetry.Println(ctx, "Enforcing blocklist/allowlist consistency...")
-		telemetry.Println(ctx, "========================================")
+	mode := e.resolveMode(ctx, DetectorCleanup)
+	if !mode.blocks() {
+		telemetry.Printf(ctx, "\n⏭️  Skipping blocklist/allowlist consistency check (%s mode: %s)\n", DetectorCleanup, mode)
+		return nil
 	}
 
-	detectedConflicts := 0
-	resolvedConflicts := 0
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Println(ctx, "Enforcing blocklist/allowlist consistency...")
+	telemetry.Println(ctx, "========================================")
 
-	for subkeyPath := range state.Subkeys {
-		if !pathutils.Contains(subkeyPath, "ExtensionInstallAllowlist") {
+	var actions []plan.Action
+	conflicts := 0
+
+	for _, subkeyPath := range slices.Sorted(maps.Keys(state.Subkeys)) {
+		if !detection.IsChromiumPolicyKey(subkeyPath, browsers.ExtensionInstallAllowlist) {
 			continue
 		}
 
-		// Derive the same-browser blocklist path. The path component replacement
-		// preserves the full browser prefix (e.g. "Microsoft\Edge\"), so Chrome
-		// blocklist is never compared against Edge allowlist and vice-versa.
-		blocklistPath := pathutils.ReplacePathComponent(
-			subkeyPath, "ExtensionInstallAllowlist", "ExtensionInstallBlocklist",
-		)
+		// Derive the same-browser blocklist path from the browser table, so a
+		// Chrome blocklist is never compared against an Edge allowlist and
+		// vice-versa.
+		blocklistPath := detection.GetPolicyKeyPath(subkeyPath,
+			browsers.ExtensionInstallAllowlist, browsers.ExtensionInstallBlocklist)
 
 		browser := detection.GetBrowserFromPath(subkeyPath)
 		telemetry.Printf(ctx, "\n[%s] Checking allowlist: %s\n", browser, subkeyPath)
 		telemetry.Printf(ctx, "[%s] Against blocklist:  %s\n", browser, blocklistPath)
 
-		// Read the blocklist live, then merge any planned dry-run additions.
-		blocklistValues, err := registry.ReadKeyValues(keyPath, blocklistPath)
-		if err != nil {
-			if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
-				blocklistValues = map[string]string{}
-			} else {
-				telemetry.Printf(ctx, "  ❌ Failed to read %s blocklist at HKLM\\%s\\%s: %v\n", browser, keyPath, blocklistPath, err)
-				telemetry.RecordError(ctx, err)
-				continue
-			}
-		}
-		if blocklistValues == nil {
-			blocklistValues = map[string]string{}
-		}
-
-		blockedIDs := make(map[string]bool, len(blocklistValues))
-		for _, v := range blocklistValues {
-			if id := detection.ExtractExtensionIDFromValue(v); id != "" {
+		blockedIDs := make(map[string]bool)
+		for _, v := range keyValues(state, blocklistPath) {
+			for _, id := range registry.ExtensionIDs(v) {
 				blockedIDs[id] = true
 			}
 		}
-		if !canWrite {
-			for plannedID := range plannedBlockedIDs[blocklistPath] {
-				blockedIDs[plannedID] = true
-			}
-		}
 		if len(blockedIDs) == 0 {
 			telemetry.Printf(ctx, "  ℹ️  Blocklist is empty or absent - nothing to enforce\n")
 			continue
 		}
 		telemetry.Printf(ctx, "  📋 %d blocked ID(s) in %s blocklist\n", len(blockedIDs), browser)
 
-		// Read allowlist values live for accurate comparison.
-		allowlistValues, err := registry.ReadKeyValues(keyPath, subkeyPath)
-		if err != nil {
-			if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
-				telemetry.Printf(ctx, "  ✓ Allowlist is empty or absent - no conflicts possible\n")
-				continue
-			}
-			telemetry.Printf(ctx, "  ❌ Failed to read %s allowlist at HKLM\\%s\\%s: %v\n", browser, keyPath, subkeyPath, err)
-			telemetry.RecordError(ctx, err)
-			continue
-		}
+		allowlistValues := keyValues(state, subkeyPath)
 		if len(allowlistValues) == 0 {
 			telemetry.Printf(ctx, "  ✓ Allowlist is empty - no conflicts possible\n")
 			continue
 		}
 
-		conflicts := 0
-		initialAllowlistCount := len(allowlistValues)
-		conflictingValueNames := make([]string, 0, len(allowlistValues))
-		conflictingIDs := make([]string, 0, len(allowlistValues))
-		for valueName, valueData := range allowlistValues {
-			extID := detection.ExtractExtensionIDFromValue(valueData)
-			if extID == "" || !blockedIDs[extID] {
-				continue
-			}
-			conflicts++
-			detectedConflicts++
-			conflictingValueNames = append(conflictingValueNames, valueName)
-			conflictingIDs = append(conflictingIDs, extID)
-			telemetry.Printf(ctx, "  ⚠️  Conflict: %s is blocked but present in %s allowlist\n", extID, browser)
-		}
-
-		if conflicts == 0 {
-			telemetry.Printf(ctx, "  ✓ No conflicts in %s allowlist\n", browser)
-			continue
-		}
-
-		deletedValueNames, err := registry.RemoveAllowlistValueNames(keyPath, subkeyPath, conflictingValueNames, !canWrite)
-		if err != nil {
-			telemetry.Printf(ctx, "  ❌ Failed to remove conflicting allowlist entries: %v\n", err)
-			continue
-		}
-
-		deletedNames := make(map[string]bool, len(deletedValueNames))
-		for _, valueName := range deletedValueNames {
-			deletedNames[valueName] = true
-		}
-		if canWrite {
-			for _, valueName := range deletedValueNames {
-				delete(state.Values, pathutils.BuildPath(subkeyPath, valueName))
-			}
-		}
-		for i, valueName := range conflictingValueNames {
-			if !deletedNames[valueName] {
-				continue
+		conflicting := 0
+		var conflictingIDs []string
+		for _, valueName := range slices.Sorted(maps.Keys(allowlistValues)) {
+			blocked := false
+			for _, id := range registry.ExtensionIDs(allowlistValues[valueName]) {
+				if !blockedIDs[id] {
+					continue
+				}
+				blocked = true
+				if !slices.Contains(conflictingIDs, id) {
+					conflictingIDs = append(conflictingIDs, id)
+					telemetry.Printf(ctx, "  ⚠️  Conflict: %s is blocked but present in %s allowlist\n", id, browser)
+				}
 			}
-			resolvedConflicts++
-			if canWrite {
-				telemetry.Printf(ctx, "  ✓ Removed %s from %s allowlist\n", conflictingIDs[i], browser)
-			} else {
-				telemetry.Printf(ctx, "  ✓ Would remove %s from %s allowlist (dry run)\n", conflictingIDs[i], browser)
+			if blocked {
+				conflicting++
 			}
 		}
-
-		if !canWrite {
-			if conflicts == initialAllowlistCount {
-				telemetry.Printf(ctx, "  ✓ Would delete empty %s allowlist key (dry run)\n", browser)
-			}
+		if len(conflictingIDs) == 0 {
+			telemetry.Printf(ctx, "  ✓ No conflicts in %s allowlist\n", browser)
 			continue
 		}
+		conflicts += len(conflictingIDs)
 
-		// Delete the key if it is now empty to leave no orphan keys behind.
-		remaining, err := registry.ReadKeyValues(keyPath, subkeyPath)
-		if err != nil {
-			telemetry.Printf(ctx, "  ❌ Failed to confirm whether %s allowlist is empty at HKLM\\%s\\%s: %v\n", browser, keyPath, subkeyPath, err)
-			telemetry.RecordError(ctx, err)
-			continue
+		c := cause{detector: DetectorCleanup, source: blocklistPath, reason: "blocked extension is also allowlisted"}
+		for _, id := range conflictingIDs {
+			actions = append(actions, c.action(plan.RemoveAllowlistValue, subkeyPath, id))
 		}
-		if len(remaining) == 0 {
+		// Delete the key once it is empty to leave no orphan keys behind.
+		if conflicting == len(allowlistValues) {
 			telemetry.Printf(ctx, "  🗑️  Allowlist empty after conflict removal, deleting: %s\n", subkeyPath)
-			if err := registry.DeleteRegistryKeyRecursive(keyPath, subkeyPath, !canWrite); err != nil {
-				telemetry.Printf(ctx, "  ❌ Failed to delete empty allowlist key: %v\n", err)
-			} else {
-				telemetry.Printf(ctx, "  ✓ Deleted empty %s allowlist key\n", browser)
-				registry.RemoveSubtreeFromState(state, subkeyPath)
-				delete(state.Subkeys, subkeyPath)
-			}
+			c.reason = "allowlist is empty after conflict removal"
+			actions = append(actions, c.action(plan.DeleteKey, subkeyPath, ""))
 		}
 	}
 
-	if detectedConflicts == 0 {
+	if conflicts == 0 {
 		telemetry.Println(ctx, "✓ No blocklist/allowlist conflicts found")
 	} else {
-		if canWrite {
-			telemetry.Printf(ctx, "✓ Resolved %d of %d blocklist/allowlist conflict(s)\n", resolvedConflicts, detectedConflicts)
-		} else {
-			telemetry.Printf(ctx, "✓ Would resolve %d of %d blocklist/allowlist conflict(s) (dry run)\n", resolvedConflicts, detectedConflicts)
-		}
+		telemetry.Printf(ctx, "✓ Planned the removal of %d blocklist/allowlist conflict(s)\n", conflicts)
 	}
+	span.SetAttributes(attribute.Int("conflicts", conflicts))
 	telemetry.Println(ctx, "========================================")
 	telemetry.Println(ctx, "")
+	return actions
 }
 
-// GetBlockedExtensionIDs scans the registry state for all blocked extension IDs
-func GetBlockedExtensionIDs(ctx context.Context, keyPath string, state *registry.RegState) map[string]bool {
-	blockedIDs := make(map[string]bool)
-
+// GetBlockedExtensionIDs returns the extension IDs blocked by a blocklist or
+// an ExtensionSettings policy of any browser in state, as found by
+// BuildInventory.
+func GetBlockedExtensionIDs(ctx context.Context, state *registry.RegState) map[string]bool {
 	telemetry.Println(ctx, "  📋 Scanning for blocked extension IDs...")
 
-	for subkeyPath := range state.Subkeys {
-		if pathutils.Contains(subkeyPath, "ExtensionInstallBlocklist") {
-			telemetry.Printf(ctx, "  🔍 Found blocklist: %s\n", subkeyPath)
-			values, err := registry.ReadKeyValues(keyPath, subkeyPath)
-			if err == nil {
-				for valueName, valueData := range values {
-					extensionID := detection.ExtractExtensionIDFromValue(valueData)
-					if extensionID != "" {
-						telemetry.Printf(ctx, "    ├─ %s: %s\n", valueName, extensionID)
-						blockedIDs[extensionID] = true
-					}
-				}
-			} else {
-				telemetry.Printf(ctx, "    ⚠️  Could not read values: %v\n", err)
-			}
-		}
-	}
-
-	for valuePath, value := range state.Values {
-		if detection.IsFirefoxExtensionSettings(valuePath) &&
-			pathutils.Contains(valuePath, "installation_mode") &&
-			value.Data == "blocked" {
-			extensionID := detection.ExtractFirefoxExtensionID(valuePath)
-			if extensionID != "" {
-				telemetry.Printf(ctx, "  🦊 Firefox blocked: %s\n", extensionID)
-				blockedIDs[extensionID] = true
-			}
-		}
+	blocked := BuildInventory(ctx, state).BlockedIDs()
+	blockedIDs := make(map[string]bool, len(blocked))
+	for _, extensionID := range slices.Sorted(maps.Keys(blocked)) {
+		telemetry.Printf(ctx, "  🔍 Blocked: %s (%s)\n", extensionID, strings.Join(blocked[extensionID], ", "))
+		blockedIDs[extensionID] = true
 	}
-
 	return blockedIDs
 }
 
-// CleanupExtensionSettings removes extension settings for all blocked extensions
-func CleanupExtensionSettings(ctx context.Context, keyPath string, state *registry.RegState, canWrite bool, extensionIndex *registry.ExtensionPathIndex) {
-	ctx, span := telemetry.StartSpan(ctx, "monitor.CleanupExtensionSettings",
-		attribute.String("key-path", keyPath),
-		attribute.Bool("can-write", canWrite),
-	)
+// CleanupExtensionSettings plans removing the 3rdparty extension settings of
+// every blocked extension. It only runs when the Cleanup action mode
+// removes.
+func (e *Engine) CleanupExtensionSettings(ctx context.Context, state *registry.RegState) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.CleanupExtensionSettings")
 	defer span.End()
 
-	if !canWrite {
-		telemetry.Println(ctx, "(DRY-RUN MODE - showing planned operations)")
-	} else if !admin.IsAdmin() {
-		telemetry.Println(ctx, "\n⚠️  Not running as Administrator - skipping extension settings cleanup")
-		return
+	mode := e.resolveMode(ctx, DetectorCleanup)
+	if !mode.removes() {
+		telemetry.Printf(ctx, "\n⏭️  Skipping extension settings cleanup (%s mode: %s)\n", DetectorCleanup, mode)
+		return nil
 	}
 
 	telemetry.Println(ctx, "\n========================================")
@@ -785,81 +654,36 @@ func CleanupExtensionSettings(ctx context.Context, keyPath string, state *regist
 	telemetry.Println(ctx, "Note: This removes settings for ALL extensions in blocklists,")
 	telemetry.Println(ctx, "      regardless of whether they were added via forcelist or manually.")
 
-	blockedIDs := GetBlockedExtensionIDs(ctx, keyPath, state)
+	blockedIDs := GetBlockedExtensionIDs(ctx, state)
 
 	if len(blockedIDs) == 0 {
 		telemetry.Println(ctx, "✓ No blocked extensions found")
 		telemetry.Println(ctx, "========================================")
-		fmt.Println()
-		return
+		telemetry.Println(ctx)
+		return nil
 	}
 
 	telemetry.Printf(ctx, "\nFound %d blocked extension ID(s):\n", len(blockedIDs))
-	for id := range blockedIDs {
+	ids := slices.Sorted(maps.Keys(blockedIDs))
+	for _, id := range ids {
 		telemetry.Printf(ctx, "  - %s\n", id)
 	}
 
-	for extensionID := range blockedIDs {
-		telemetry.Printf(ctx, "\n[CHECKING SETTINGS FOR BLOCKED EXTENSION]\n")
-		telemetry.Printf(ctx, "Extension ID: %s\n", extensionID)
-		registry.RemoveExtensionSettingsForID(keyPath, extensionID, !canWrite, state, extensionIndex)
-	}
-
-	telemetry.Println(ctx, "========================================")
-	fmt.Println()
-}
-
-// WatchRegistryChanges monitors registry changes and processes them
-func WatchRegistryChanges(ctx context.Context, hKey windows.Handle, keyPath string, previousState *registry.RegState, canWrite bool, extensionIndex *registry.ExtensionPathIndex) {
-	ctx, span := telemetry.StartSpan(ctx, "monitor.WatchRegistryChanges",
-		attribute.String("key-path", keyPath),
-		attribute.Bool("can-write", canWrite),
-	)
-	defer span.End()
-
-	event, err := windows.CreateEvent(nil, 0, 0, nil)
-	if err != nil {
-		telemetry.Println(ctx, "Error creating event:", err)
-		telemetry.RecordError(ctx, err)
-		return
-	}
-	defer func() { _ = windows.CloseHandle(event) }()
-
-	err = windows.RegNotifyChangeKeyValue(hKey, true, windows.REG_NOTIFY_CHANGE_NAME|windows.REG_NOTIFY_CHANGE_LAST_SET, event, true)
-	if err != nil {
-		telemetry.Println(ctx, "Error setting up registry notification:", err)
-		telemetry.RecordError(ctx, err)
-		return
-	}
-
-	telemetry.Println(ctx, "Monitoring registry changes...")
-	telemetry.AddEvent(ctx, "monitoring-started")
-
-	for {
-		status, err := windows.WaitForSingleObject(event, windows.INFINITE)
-		if err != nil {
-			telemetry.Println(ctx, "Error waiting for event:", err)
-			telemetry.RecordError(ctx, err)
-			return
-		}
-
-		if status == windows.WAIT_OBJECT_0 {
-			telemetry.AddEvent(ctx, "registry-change-detected")
-
-			newState, err := CaptureRegistryState(ctx, hKey, keyPath)
-			if err != nil {
-				telemetry.Println(ctx, "Error capturing new state:", err)
-			} else {
-				PrintDiff(ctx, previousState, newState, keyPath, canWrite, extensionIndex)
-				previousState = newState
-			}
-
-			err = windows.RegNotifyChangeKeyValue(hKey, true, windows.REG_NOTIFY_CHANGE_NAME|windows.REG_NOTIFY_CHANGE_LAST_SET, event, true)
-			if err != nil {
-				telemetry.Println(ctx, "Error re-arming registry notification:", err)
-				telemetry.RecordError(ctx, err)
-				return
+	var actions []plan.Action
+	index := newExtensionIndex(state)
+	c := cause{detector: DetectorCleanup, reason: "settings of a blocked extension", quarantine: mode == Quarantine}
+	for _, extensionID := range ids {
+		if found := settingsRemovals(index, extensionID, c); len(found) > 0 {
+			telemetry.Printf(ctx, "\n[REMOVING SETTINGS OF BLOCKED EXTENSION]\n")
+			telemetry.Printf(ctx, "Extension ID: %s\n", extensionID)
+			for _, a := range found {
+				telemetry.Printf(ctx, "  🗑️  Deleting extension settings: %s\n", a.Path)
 			}
+			actions = append(actions, found...)
 		}
 	}
+
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+	return actions
 }
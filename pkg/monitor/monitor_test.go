@@ -0,0 +1,672 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/regfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+const policiesKeyPath = `SOFTWARE\Policies`
+
+// Extension IDs used by the fixtures.
+const (
+	idA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	idB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	idC = "cccccccccccccccccccccccccccccccc"
+	idD = "dddddddddddddddddddddddddddddddd"
+)
+
+// Policy keys used by the fixtures, relative to policiesKeyPath.
+const (
+	chromeForcelist = `Google\Chrome\ExtensionInstallForcelist`
+	chromeBlocklist = `Google\Chrome\ExtensionInstallBlocklist`
+	chromeAllowlist = `Google\Chrome\ExtensionInstallAllowlist`
+	edgeForcelist   = `Microsoft\Edge\ExtensionInstallForcelist`
+	edgeBlocklist   = `Microsoft\Edge\ExtensionInstallBlocklist`
+	edgeAllowlist   = `Microsoft\Edge\ExtensionInstallAllowlist`
+)
+
+// regHeader starts the .reg fixtures.
+const regHeader = "Windows Registry Editor Version 5.00\n"
+
+// newTree returns an in-memory registry holding the keys of the .reg file
+// text reg.
+func newTree(t *testing.T, reg string) *registry.MemoryBackend {
+	t.Helper()
+	f, err := regfile.Parse(strings.NewReader(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := registry.NewMemoryBackend()
+	if _, err := f.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// captureState captures the policies of b.
+func captureState(t *testing.T, b registry.Backend) *registry.RegState {
+	t.Helper()
+	state, err := registry.CaptureState(b, policiesKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// TestMain discards the progress messages of the passes.
+func TestMain(m *testing.M) {
+	telemetry.SetSuppressStdout(true)
+	os.Exit(m.Run())
+}
+
+// testContext returns the context the passes run with.
+func testContext() context.Context {
+	return context.Background()
+}
+
+// configure returns an engine with the action modes and exemptions.
+func configure(t *testing.T, modes ActionModes, rules []exemptions.Rule) *Engine {
+	t.Helper()
+	set, err := exemptions.New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine()
+	e.Modes = modes
+	e.Exemptions = set
+	return e
+}
+
+// enforce plans passes on the policies of b and, unless dryRun, applies the
+// plan to b. The state the plan simulates must match b afterwards. It
+// returns the state of b afterwards as the plan simulates it.
+func enforce(t *testing.T, e *Engine, b registry.Backend, dryRun bool, passes ...Pass) *registry.RegState {
+	t.Helper()
+	sim, err := e.BuildPlan(testContext(), b, policiesKeyPath, passes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryRun {
+		return sim.Before
+	}
+	for _, r := range e.ApplyPlan(testContext(), b, policiesKeyPath, sim.Plan) {
+		if r.Status == plan.Failed {
+			t.Errorf("%s failed: %s", r.Action, r.Error)
+		}
+	}
+	if changes := registry.Diff(sim.After, captureState(t, b)); len(changes) > 0 {
+		t.Errorf("applied plan differs from its simulation: %v", changes)
+	}
+	return sim.After
+}
+
+// errUnreadable is returned for the key of an unreadableBackend.
+var errUnreadable = errors.New("access denied")
+
+// unreadableBackend fails to list the values of one key.
+type unreadableBackend struct {
+	*registry.MemoryBackend
+	path string
+}
+
+func (b unreadableBackend) EnumValues(path string) ([]registry.RawValue, error) {
+	if strings.EqualFold(path, b.path) {
+		return nil, errUnreadable
+	}
+	return b.MemoryBackend.EnumValues(path)
+}
+
+// listed is the list keys a test expects: the extension IDs each key
+// lists, with nil for a key that must not exist.
+type listed map[string][]string
+
+// check compares the keys of want with b and state.
+func (want listed) check(t *testing.T, b registry.Backend, state *registry.RegState) {
+	t.Helper()
+	for _, relPath := range slices.Sorted(maps.Keys(want)) {
+		ids, ok := listedIDs(t, b, relPath)
+		switch {
+		case want[relPath] == nil && ok:
+			t.Errorf("%s exists listing %q, want it deleted", relPath, ids)
+		case want[relPath] == nil && state.Subkeys[relPath]:
+			t.Errorf("%s deleted but still in state", relPath)
+		case want[relPath] != nil && !ok:
+			t.Errorf("%s does not exist, want it listing %q", relPath, want[relPath])
+		case want[relPath] != nil && !slices.Equal(ids, want[relPath]):
+			t.Errorf("%s lists %q, want %q", relPath, ids, want[relPath])
+		}
+	}
+}
+
+// listedIDs returns the sorted extension IDs listed by the values of the
+// key at relPath, and whether the key exists.
+func listedIDs(t *testing.T, b registry.Backend, relPath string) ([]string, bool) {
+	t.Helper()
+	values, err := registry.ReadKeyValues(b, policiesKeyPath, relPath)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, value := range values {
+		ids = append(ids, registry.ExtensionIDs(value)...)
+	}
+	slices.Sort(ids)
+	return ids, true
+}
+
+func TestProcessExistingPolicies(t *testing.T) {
+	chromeFixture := regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist]
+"1"="` + idA + `;https://evil.example.com/update.xml"
+"2"="` + idB + `"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+"2"="` + idD + `"
+`
+	tests := []struct {
+		name       string
+		reg        string
+		modes      ActionModes
+		exemptions []exemptions.Rule
+		// unreadable is a key whose values cannot be read.
+		unreadable string
+		dryRun     bool
+		want       listed
+	}{
+		{
+			name: "forcelist blocked and deleted",
+			reg:  chromeFixture,
+			want: listed{
+				chromeForcelist: nil,
+				chromeBlocklist: {idA, idB},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name:       "exempted entry kept",
+			reg:        chromeFixture,
+			exemptions: []exemptions.Rule{{ID: idB}},
+			want: listed{
+				chromeForcelist: {idB},
+				chromeBlocklist: {idA},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name:  "block mode leaves forcelist",
+			reg:   chromeFixture,
+			modes: ActionModes{DetectorForcelist: Block},
+			want: listed{
+				chromeForcelist: {idA, idB},
+				chromeBlocklist: {idA, idB},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name:  "remove mode does not block",
+			reg:   chromeFixture,
+			modes: ActionModes{DetectorForcelist: Remove},
+			want: listed{
+				chromeForcelist: nil,
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name:  "observe mode changes nothing",
+			reg:   chromeFixture,
+			modes: ActionModes{DetectorDefault: Observe},
+			want: listed{
+				chromeForcelist: {idA, idB},
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name:   "dry run changes nothing",
+			reg:    chromeFixture,
+			dryRun: true,
+			want: listed{
+				chromeForcelist: {idA, idB},
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name:       "unreadable forcelist left in place",
+			reg:        chromeFixture,
+			unreadable: chromeForcelist,
+			want: listed{
+				chromeForcelist: {idA, idB},
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name: "browsers blocked separately",
+			reg: chromeFixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge\ExtensionInstallForcelist]
+"1"="` + idC + `"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+`,
+			want: listed{
+				chromeForcelist: nil,
+				chromeBlocklist: {idA, idB},
+				chromeAllowlist: {idD},
+				edgeForcelist:   nil,
+				edgeBlocklist:   {idC},
+				edgeAllowlist:   {idA},
+			},
+		},
+		{
+			name: "existing blocklist extended",
+			reg: chromeFixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+"2"="` + idC + `"
+`,
+			want: listed{
+				chromeForcelist: nil,
+				chromeBlocklist: {idA, idB, idC},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name: "Firefox install policy removed",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Mozilla\Firefox\Extensions\Install]
+"1"="https://evil.example/x.xpi"
+`,
+			want: listed{
+				`Mozilla\Firefox\Extensions\Install`: nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := configure(t, tt.modes, tt.exemptions)
+			tree := newTree(t, tt.reg)
+			if tt.unreadable != "" {
+				// A partial capture must never be planned from.
+				b := unreadableBackend{tree, policiesKeyPath + `\` + tt.unreadable}
+				if _, err := e.BuildPlan(testContext(), b, policiesKeyPath, e.ProcessExistingPolicies); !errors.Is(err, errUnreadable) {
+					t.Fatalf("BuildPlan error = %v, want %v", err, errUnreadable)
+				}
+				tt.want.check(t, tree, captureState(t, tree))
+				return
+			}
+
+			tt.want.check(t, tree, enforce(t, e, tree, tt.dryRun, e.ProcessExistingPolicies))
+		})
+	}
+}
+
+func TestRemediateChanges(t *testing.T) {
+	before := regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+"2"="` + idD + `"
+`
+	tests := []struct {
+		name string
+		// change is applied to the before tree after its state is captured.
+		change     string
+		modes      ActionModes
+		exemptions []exemptions.Rule
+		unreadable string
+		want       listed
+	}{
+		{
+			name: "forcelist added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist]
+"1"="` + idA + `"
+"2"="` + idB + `"
+`,
+			want: listed{
+				chromeForcelist: nil,
+				chromeBlocklist: {idA, idB},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name: "exempted forcelist entry added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist]
+"1"="` + idA + `"
+"2"="` + idD + `"
+`,
+			exemptions: []exemptions.Rule{{ID: idD, Browser: "chrome"}},
+			want: listed{
+				chromeForcelist: {idD},
+				chromeBlocklist: {idA},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name: "unreadable forcelist added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist]
+"1"="` + idA + `"
+`,
+			unreadable: chromeForcelist,
+			want: listed{
+				chromeForcelist: {idA},
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name: "observed forcelist added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist]
+"1"="` + idA + `"
+`,
+			modes: ActionModes{DetectorForcelist: Observe},
+			want: listed{
+				chromeForcelist: {idA},
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name: "blocklist entry added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idB + `"
+`,
+			want: listed{
+				chromeBlocklist: {idB},
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name: "ExtensionSettings install entry added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome]
+"ExtensionSettings"="{\"` + idB + `\":{\"installation_mode\":\"force_installed\",\"update_url\":\"https://evil.example/u.xml\"}}"
+`,
+			want: listed{
+				chromeBlocklist: {idB},
+				chromeAllowlist: {idA, idD},
+			},
+		},
+		{
+			name: "ExtensionSettings entry shared with an exempted ID added",
+			change: `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome]
+"ExtensionSettings"="{\"` + idB + `,` + idD + `\":{\"installation_mode\":\"force_installed\",\"update_url\":\"https://evil.example/u.xml\"}}"
+`,
+			exemptions: []exemptions.Rule{{ID: idD, Browser: "chrome"}},
+			want: listed{
+				chromeBlocklist: {idB},
+				chromeAllowlist: {idA, idD},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := configure(t, tt.modes, tt.exemptions)
+			tree := newTree(t, before)
+			oldState := captureState(t, tree)
+			f, err := regfile.Parse(strings.NewReader(regHeader + tt.change))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Apply(tree); err != nil {
+				t.Fatal(err)
+			}
+			remediate := func(ctx context.Context, state *registry.RegState) []plan.Action {
+				return e.RemediateChanges(ctx, registry.Diff(oldState, state), state)
+			}
+			if tt.unreadable != "" {
+				// A partial capture must never be planned from.
+				b := unreadableBackend{tree, policiesKeyPath + `\` + tt.unreadable}
+				if _, err := e.BuildPlan(testContext(), b, policiesKeyPath, remediate); !errors.Is(err, errUnreadable) {
+					t.Fatalf("BuildPlan error = %v, want %v", err, errUnreadable)
+				}
+				tt.want.check(t, tree, captureState(t, tree))
+				return
+			}
+
+			tt.want.check(t, tree, enforce(t, e, tree, false, remediate))
+		})
+	}
+}
+
+func TestEnforceBlockAllowlistConsistency(t *testing.T) {
+	// blockA is a pass planning to blocklist idA before the check runs.
+	blockA := func(ctx context.Context, state *registry.RegState) []plan.Action {
+		return []plan.Action{{Kind: plan.AddBlocklistEntry, Path: chromeBlocklist, ExtensionID: idA}}
+	}
+	tests := []struct {
+		name string
+		reg  string
+		// planned runs before the check.
+		planned bool
+		dryRun  bool
+		want    listed
+	}{
+		{
+			name: "conflicting entry removed",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+"2"="` + idD + `"
+`,
+			want: listed{
+				chromeBlocklist: {idA},
+				chromeAllowlist: {idD},
+			},
+		},
+		{
+			name: "emptied allowlist deleted",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+"2"="` + idB + `"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+"2"="` + idB + `"
+`,
+			want: listed{
+				chromeBlocklist: {idA, idB},
+				chromeAllowlist: nil,
+			},
+		},
+		{
+			name: "other browser's blocklist ignored",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+`,
+			want: listed{
+				edgeBlocklist:   {idA},
+				chromeAllowlist: {idA},
+			},
+		},
+		{
+			name: "no blocklist",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+`,
+			want: listed{
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA},
+			},
+		},
+		{
+			name: "entries planned by earlier passes count",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+`,
+			planned: true,
+			want: listed{
+				chromeBlocklist: {idA},
+				chromeAllowlist: nil,
+			},
+		},
+		{
+			name: "dry run changes nothing",
+			reg: regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="` + idA + `"
+`,
+			planned: true,
+			dryRun:  true,
+			want: listed{
+				chromeBlocklist: nil,
+				chromeAllowlist: {idA},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := configure(t, nil, nil)
+			b := newTree(t, tt.reg)
+			passes := []Pass{e.EnforceBlockAllowlistConsistency}
+			if tt.planned {
+				passes = append([]Pass{blockA}, passes...)
+			}
+
+			sim, err := e.BuildPlan(testContext(), b, policiesKeyPath, passes...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.dryRun {
+				e.ApplyPlan(testContext(), b, policiesKeyPath, sim.Plan)
+			}
+
+			tt.want.check(t, b, captureState(t, b))
+		})
+	}
+}
+
+func TestCleanupExtensionSettings(t *testing.T) {
+	const (
+		settingsA = `Google\Chrome\3rdparty\extensions\` + idA
+		settingsB = `Google\Chrome\3rdparty\extensions\` + idB
+		settingsC = `Microsoft\Edge\3rdparty\extensions\` + idC
+	)
+	fixture := regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\3rdparty\extensions\` + idA + `\policy]
+"foo"="bar"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\3rdparty\extensions\` + idB + `\policy]
+"foo"="bar"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge\3rdparty\extensions\` + idC + `\policy]
+"foo"="bar"
+`
+	tests := []struct {
+		name   string
+		reg    string
+		modes  ActionModes
+		dryRun bool
+		// kept and removed are the 3rdparty keys expected afterwards.
+		kept, removed []string
+	}{
+		{
+			name: "settings of blocklisted extension removed",
+			reg: fixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+`,
+			kept:    []string{settingsB, settingsC},
+			removed: []string{settingsA},
+		},
+		{
+			name: "blocked in any browser",
+			reg: fixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge\ExtensionInstallBlocklist]
+"1"="` + idC + `"
+`,
+			kept:    []string{settingsA, settingsB},
+			removed: []string{settingsC},
+		},
+		{
+			name: "blocked by ExtensionSettings",
+			reg: fixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome]
+"ExtensionSettings"="{\"` + idB + `\":{\"installation_mode\":\"blocked\"}}"
+`,
+			kept:    []string{settingsA, settingsC},
+			removed: []string{settingsB},
+		},
+		{
+			name: "nothing blocked",
+			reg:  fixture,
+			kept: []string{settingsA, settingsB, settingsC},
+		},
+		{
+			name: "cleanup observed",
+			reg: fixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+`,
+			modes: ActionModes{DetectorCleanup: Observe},
+			kept:  []string{settingsA, settingsB, settingsC},
+		},
+		{
+			name: "dry run",
+			reg: fixture + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="` + idA + `"
+`,
+			dryRun: true,
+			kept:   []string{settingsA, settingsB, settingsC},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := configure(t, tt.modes, nil)
+			b := newTree(t, tt.reg)
+
+			state := enforce(t, e, b, tt.dryRun, e.CleanupExtensionSettings)
+
+			for _, relPath := range tt.kept {
+				if !keyExists(b, policiesKeyPath, relPath) {
+					t.Errorf("%s was removed", relPath)
+				}
+			}
+			for _, relPath := range tt.removed {
+				if keyExists(b, policiesKeyPath, relPath) {
+					t.Errorf("%s was not removed", relPath)
+				}
+				if state.Subkeys[relPath] {
+					t.Errorf("%s removed but still in state", relPath)
+				}
+			}
+		})
+	}
+}
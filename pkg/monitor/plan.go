@@ -0,0 +1,304 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// ============================================================================
+// PLAN - Enforcement passes planning remediation actions
+// ============================================================================
+
+// Pass is one enforcement pass: it finds the policies in state it acts on
+// and returns the actions remediating them. Passes never write; the actions
+// are applied by ApplyPlan.
+type Pass func(ctx context.Context, state *registry.RegState) []plan.Action
+
+// EnforcementPasses returns the full enforcement sequence of e, in order.
+// Startup, scan, plan, apply and Reconcile all plan with it, so that they
+// act on the same policies.
+func (e *Engine) EnforcementPasses() []Pass {
+	return []Pass{
+		e.VerifyOwnedEntries,
+		e.EnforceIntelBlocklist,
+		e.ProcessExistingPolicies,
+		// Run the targeted consistency pass first so startup behavior matches
+		// the live path, then follow with the broader allowlist cleanup.
+		e.EnforceBlockAllowlistConsistency,
+		e.CleanupAllowlists,
+		e.CleanupExtensionSettings,
+		e.ProcessHijackPolicies,
+		func(ctx context.Context, _ *registry.RegState) []plan.Action {
+			return e.ProcessPolicyFiles(ctx, e.PolicyFiles)
+		},
+	}
+}
+
+// cause is why a remediation action is taken.
+type cause struct {
+	// detector is the detector, or the hijack policy, taking the action.
+	detector string
+	// source is the policy that made the action necessary; the action's
+	// own path if empty.
+	source string
+	reason string
+	// quarantine backs up the target before it is changed.
+	quarantine bool
+}
+
+// action returns the action of kind on path taken for c.
+func (c cause) action(kind plan.Kind, path, extensionID string) plan.Action {
+	source := c.source
+	if source == "" {
+		source = path
+	}
+	return plan.Action{
+		Kind:        kind,
+		Browser:     detection.GetBrowserIDFromPath(path),
+		ExtensionID: extensionID,
+		Detector:    c.detector,
+		Path:        path,
+		Source:      source,
+		Reason:      c.reason,
+		Quarantine:  c.quarantine,
+	}
+}
+
+// valueAction returns the action of kind writing value to valuePath.
+func (c cause) valueAction(kind plan.Kind, valuePath string, value registry.RegValue) plan.Action {
+	a := c.action(kind, valuePath, "")
+	value.Name = valuePath
+	a.Value = &value
+	return a
+}
+
+// Simulation is a plan with the policy state before and after it is
+// applied.
+type Simulation struct {
+	Plan   *plan.Plan
+	Before *registry.RegState
+	After  *registry.RegState
+}
+
+// Plan runs passes in order against a copy of state and returns the plan
+// they build. The actions of each pass are made on the copy before the next
+// pass runs, so that e.g. the blocklist/allowlist consistency check sees
+// the blocklist entries planned before it. Actions of detectors in Observe
+// mode are reported and left out of the plan.
+func (e *Engine) Plan(ctx context.Context, state *registry.RegState, passes ...Pass) *Simulation {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.Plan",
+		attribute.Int("passes", len(passes)),
+	)
+	defer span.End()
+
+	p := &plan.Plan{}
+	working := state.Clone()
+	observed := 0
+	for _, pass := range passes {
+		for _, a := range pass(ctx, working) {
+			if e.observes(a.Detector) {
+				observed++
+				telemetry.Printf(ctx, "  👁️  %s mode, not applied: %s\n", Observe, a)
+				continue
+			}
+			if p.Add(a) {
+				simulate(working, a)
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("actions", p.Len()), attribute.Int("observed", observed))
+	return &Simulation{Plan: p, Before: state, After: working}
+}
+
+// BuildPlan captures the policies below keyPath from b and plans their
+// remediation with passes. Nothing is written to b.
+func (e *Engine) BuildPlan(ctx context.Context, b registry.Backend, keyPath string, passes ...Pass) (*Simulation, error) {
+	state, err := CaptureRegistryState(ctx, b, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("capturing policy tree: %w", err)
+	}
+	return e.Plan(ctx, state, passes...), nil
+}
+
+// simulate makes the change of a in state, as ApplyPlan makes it in the
+// policies.
+func simulate(state *registry.RegState, a plan.Action) {
+	switch a.Kind {
+	case plan.DeleteKey:
+		removeKey(state, a.Path)
+	case plan.DeleteValue:
+		removeValue(state, a.Path)
+	case plan.SetValue:
+		if a.Value != nil {
+			setValue(state, a.Path, *a.Value)
+		}
+	case plan.AddBlocklistEntry:
+		values := keyValues(state, a.Path)
+		if listsID(values, a.ExtensionID) {
+			return
+		}
+		name := registry.NextListValueName(values)
+		setValue(state, pathutils.BuildPath(a.Path, name),
+			registry.NewRegValue(name, detection.RegSZ, detection.EncodeUTF16String(a.ExtensionID)))
+	case plan.SetFirefoxBlocked:
+		setValue(state, pathutils.BuildPath(a.Path, "installation_mode"),
+			registry.NewRegValue("installation_mode", detection.RegSZ, detection.EncodeUTF16String("blocked")))
+	case plan.RemoveAllowlistValue:
+		for name, value := range keyValues(state, a.Path) {
+			if slices.Contains(registry.ExtensionIDs(value), a.ExtensionID) {
+				removeValue(state, pathutils.BuildPath(a.Path, name))
+			}
+		}
+	case plan.RemoveExtensionSettings:
+		switch {
+		case a.Value != nil:
+			setValue(state, a.Path, *a.Value)
+		case detection.IsExtensionSettingsValue(a.Path):
+			removeValue(state, a.Path)
+		default:
+			removeKey(state, a.Path)
+		}
+	}
+}
+
+// keyValues returns the values of the key at keyPath in state by name.
+func keyValues(state *registry.RegState, keyPath string) map[string]registry.RegValue {
+	values := make(map[string]registry.RegValue)
+	for valuePath, value := range state.Values {
+		if parent, ok := pathutils.GetParentPath(valuePath); ok && strings.EqualFold(parent, keyPath) {
+			values[pathutils.GetKeyName(valuePath)] = value
+		}
+	}
+	return values
+}
+
+// listsID reports whether a value of a list policy key lists extensionID.
+func listsID(values map[string]registry.RegValue, extensionID string) bool {
+	for _, value := range values {
+		if slices.Contains(registry.ExtensionIDs(value), extensionID) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyExistsIn reports whether state has the key at keyPath, ignoring case.
+func keyExistsIn(state *registry.RegState, keyPath string) bool {
+	return existingKey(state, keyPath) != ""
+}
+
+// existingKey returns the spelling of the key at keyPath in state, or ""
+// if state has no such key.
+func existingKey(state *registry.RegState, keyPath string) string {
+	if state.Subkeys[keyPath] {
+		return keyPath
+	}
+	for subkeyPath := range state.Subkeys {
+		if strings.EqualFold(subkeyPath, keyPath) {
+			return subkeyPath
+		}
+	}
+	return ""
+}
+
+// addKey adds the key at keyPath and its ancestors to state, keeping the
+// spelling of the ones it already has.
+func addKey(state *registry.RegState, keyPath string) string {
+	if existing := existingKey(state, keyPath); existing != "" {
+		return existing
+	}
+	if parent, ok := pathutils.GetParentPath(keyPath); ok {
+		keyPath = pathutils.BuildPath(addKey(state, parent), pathutils.GetKeyName(keyPath))
+	}
+	state.Subkeys[keyPath] = true
+	return keyPath
+}
+
+// setValue stores value at valuePath in state, replacing a value of the
+// same name in any spelling.
+func setValue(state *registry.RegState, valuePath string, value registry.RegValue) {
+	removeValue(state, valuePath)
+	if parent, ok := pathutils.GetParentPath(valuePath); ok {
+		valuePath = pathutils.BuildPath(addKey(state, parent), pathutils.GetKeyName(valuePath))
+	}
+	value.Name = valuePath
+	state.Values[valuePath] = value
+}
+
+// removeValue deletes the value at valuePath from state, ignoring case.
+func removeValue(state *registry.RegState, valuePath string) {
+	for path := range state.Values {
+		if strings.EqualFold(path, valuePath) {
+			delete(state.Values, path)
+		}
+	}
+}
+
+// removeKey deletes the key at keyPath and everything below it from state,
+// ignoring case.
+func removeKey(state *registry.RegState, keyPath string) {
+	below := func(path string) bool {
+		_, ok := pathutils.TrimPathPrefix(path, keyPath)
+		return ok
+	}
+	maps.DeleteFunc(state.Subkeys, func(path string, _ bool) bool { return below(path) })
+	maps.DeleteFunc(state.Values, func(path string, _ registry.RegValue) bool { return below(path) })
+}
+
+// PrintPlan prints the actions of p in order, with their reasons and
+// sources.
+func PrintPlan(ctx context.Context, p *plan.Plan) {
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Printf(ctx, "📋 Remediation plan: %d action(s)\n", p.Len())
+	telemetry.Println(ctx, "========================================")
+	if p.Len() == 0 {
+		telemetry.Println(ctx, "✓ Nothing to remediate")
+	}
+	for i, a := range p.Actions {
+		quarantine := ""
+		if a.Quarantine {
+			quarantine = " (quarantined first)"
+		}
+		telemetry.Printf(ctx, "%3d. [%s] %s%s\n", i+1, a.Kind, a, quarantine)
+		telemetry.Printf(ctx, "     Reason: %s\n", a.Reason)
+		if a.Source != a.Path {
+			telemetry.Printf(ctx, "     Source: %s\n", a.Source)
+		}
+	}
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+}
+
+// PrintResults prints the outcome of every applied action and a summary.
+func PrintResults(ctx context.Context, results []plan.Result) {
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Println(ctx, "Plan results")
+	telemetry.Println(ctx, "========================================")
+	for i, r := range results {
+		switch r.Status {
+		case plan.Applied:
+			telemetry.Printf(ctx, "%3d. ✓ %s\n", i+1, r.Action)
+		case plan.Skipped:
+			telemetry.Printf(ctx, "%3d. ⏭️  %s (already done)\n", i+1, r.Action)
+		case plan.Failed:
+			telemetry.Printf(ctx, "%3d. ❌ %s: %s\n", i+1, r.Action, r.Error)
+		default:
+			telemetry.Printf(ctx, "%3d. %s (%s)\n", i+1, r.Action, r.Status)
+		}
+	}
+	telemetry.Printf(ctx, "Applied %d, skipped %d, failed %d of %d action(s)\n",
+		plan.Count(results, plan.Applied), plan.Count(results, plan.Skipped), plan.Count(results, plan.Failed), len(results))
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+}
@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+const planFixture = `Windows Registry Editor Version 5.00
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist]
+"1"="aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa;https://evil.example.com/update.xml"
+"2"="bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallAllowlist]
+"1"="aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+"2"="dddddddddddddddddddddddddddddddd"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\3rdparty\extensions\aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\policy]
+"foo"="bar"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Microsoft\Edge\ExtensionInstallForcelist]
+"1"="cccccccccccccccccccccccccccccccc"
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\BraveSoftware\Brave\ExtensionInstallForcelist]
+"1"="eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+`
+
+func planPasses(e *Engine) []Pass {
+	return []Pass{e.ProcessExistingPolicies, e.EnforceBlockAllowlistConsistency, e.CleanupExtensionSettings}
+}
+
+func TestBuildPlanDeterministic(t *testing.T) {
+	e := NewEngine()
+	b := newTree(t, planFixture)
+	before := captureState(t, b)
+
+	var want []plan.Action
+	for i := range 10 {
+		sim, err := e.BuildPlan(testContext(), b, policiesKeyPath, planPasses(e)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			want = sim.Plan.Actions
+			if len(want) == 0 {
+				t.Fatal("plan has no actions")
+			}
+			continue
+		}
+		if !reflect.DeepEqual(sim.Plan.Actions, want) {
+			t.Fatalf("run %d planned\n%v\nwant\n%v", i, sim.Plan.Actions, want)
+		}
+	}
+
+	if after := captureState(t, b); !reflect.DeepEqual(after, before) {
+		t.Error("BuildPlan changed the source tree")
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	e := NewEngine()
+	b := newTree(t, planFixture)
+	sim, err := e.BuildPlan(testContext(), b, policiesKeyPath, planPasses(e)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ApplyPlan makes the changes BuildPlan simulated.
+	e.ApplyPlan(testContext(), b, policiesKeyPath, sim.Plan)
+	if b.OpenKey(policiesKeyPath+`\Google\Chrome\ExtensionInstallForcelist`) == nil {
+		t.Error("forcelist was not deleted by ApplyPlan")
+	}
+	if changes := registry.Diff(sim.After, captureState(t, b)); len(changes) > 0 {
+		t.Errorf("applied plan differs from its simulation: %v", changes)
+	}
+
+	// Applying it again changes nothing.
+	for _, r := range e.ApplyPlan(testContext(), b, policiesKeyPath, sim.Plan) {
+		if r.Status != plan.Skipped {
+			t.Errorf("second apply of %s: %s, want %s", r.Action, r.Status, plan.Skipped)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/policyfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// matchPolicyFileExemption returns the rule exempting a policies.json finding.
+func (e *Engine) matchPolicyFileExemption(finding policyfile.Finding) (exemptions.Rule, bool) {
+	return e.Exemptions.Match(exemptions.Candidate{
+		ID:        finding.ExtensionID,
+		Browser:   "firefox",
+		UpdateURL: finding.URL,
+		Source:    exemptions.SourcePolicyFile,
+	})
+}
+
+// ProcessPolicyFiles scans the Firefox policies.json files at paths, which
+// Firefox reads in addition to the registry. Install policies are reported
+// and planned for remediation like their registry counterparts: one
+// RewritePolicyFile action per file with findings, applied with the
+// policies.json action mode. Missing files are skipped.
+func (e *Engine) ProcessPolicyFiles(ctx context.Context, paths []string) []plan.Action {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.ProcessPolicyFiles",
+		attribute.Int("paths", len(paths)),
+	)
+	defer span.End()
+
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Println(ctx, "Checking Firefox policies.json files...")
+	mode := e.resolveMode(ctx, DetectorPolicyFile)
+	action := e.settingsAction(mode)
+	telemetry.Println(ctx, "========================================")
+
+	var actions []plan.Action
+	total := 0
+	for _, path := range paths {
+		findings, err := e.policyFileFindings(ctx, path)
+		if err != nil {
+			telemetry.Printf(ctx, "⚠️  Could not read %s: %v\n", path, err)
+			telemetry.RecordError(ctx, err)
+			continue
+		}
+		telemetry.Printf(ctx, "📄 %s: %d finding(s)\n", path, len(findings))
+		if len(findings) == 0 {
+			continue
+		}
+		total += len(findings)
+
+		telemetry.Printf(ctx, "\n[FIREFOX POLICIES.JSON DETECTED]\n")
+		for _, finding := range findings {
+			telemetry.Printf(ctx, "  ⚠️  %s: %s\n", finding.Policy, finding.Detail)
+			if finding.ExtensionID != "" {
+				telemetry.Printf(ctx, "  🔍 Extension ID: %s\n", finding.ExtensionID)
+				e.recordExtensionDetected(ctx, "firefox", finding.ExtensionID)
+			}
+		}
+		telemetry.Printf(ctx, "  📝 Rewriting %s: %s install entries, remove Extensions.Install/Locked\n", path, action)
+		c := cause{detector: DetectorPolicyFile, reason: "policies.json installs extensions", quarantine: mode == Quarantine}
+		a := c.action(plan.RewritePolicyFile, path, "")
+		a.Browser = "firefox"
+		actions = append(actions, a)
+	}
+	span.SetAttributes(attribute.Int("findings", total))
+
+	if total == 0 {
+		telemetry.Println(ctx, "✓ No extension install policies in policies.json files")
+	}
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+	return actions
+}
+
+// policyFileFindings returns the findings of the policies.json file at path
+// that no exemption covers, logging each exemption. A missing file has
+// none.
+func (e *Engine) policyFileFindings(ctx context.Context, path string) ([]policyfile.Finding, error) {
+	f, err := policyfile.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	findings, err := f.Findings()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(findings, func(finding policyfile.Finding) bool {
+		rule, ok := e.matchPolicyFileExemption(finding)
+		if ok {
+			logExemption(ctx, finding.Detail, rule)
+		}
+		return ok
+	}), nil
+}
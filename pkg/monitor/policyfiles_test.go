@@ -0,0 +1,103 @@
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/policyfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// policyFileFixture force-installs two Firefox extensions and locks a
+// third.
+const policyFileFixture = `{"policies": {
+  "DisableTelemetry": true,
+  "ExtensionSettings": {
+    "evil@example.com": {"installation_mode": "force_installed", "install_url": "https://example.com/evil.xpi"},
+    "good@example.com": {"installation_mode": "force_installed", "install_url": "https://addons.mozilla.org/good.xpi"}
+  },
+  "Extensions": {"Locked": ["locked@example.com"]}
+}}`
+
+// firefoxPolicies is a registry without extension policies.
+const firefoxPolicies = regHeader + `
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Mozilla\Firefox]
+`
+
+func TestProcessPolicyFiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		modes      ActionModes
+		exemptions []exemptions.Rule
+		dryRun     bool
+		// want lists the extension IDs still installed or locked afterwards.
+		want []string
+	}{
+		{
+			name: "enforced",
+			want: nil,
+		},
+		{
+			name:       "exempted",
+			exemptions: []exemptions.Rule{{ID: "good@example.com", Browser: "firefox"}},
+			want:       []string{"good@example.com"},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			want:   []string{"evil@example.com", "good@example.com", "locked@example.com"},
+		},
+		{
+			name:  "observed",
+			modes: ActionModes{DetectorPolicyFile: Observe},
+			want:  []string{"evil@example.com", "good@example.com", "locked@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "policies.json")
+			if err := os.WriteFile(path, []byte(policyFileFixture), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			paths := []string{filepath.Join(dir, "missing", "policies.json"), path}
+
+			e := configure(t, tt.modes, tt.exemptions)
+			var planned []plan.Action
+			pass := func(ctx context.Context, state *registry.RegState) []plan.Action {
+				planned = e.ProcessPolicyFiles(ctx, paths)
+				return planned
+			}
+			enforce(t, e, newTree(t, firefoxPolicies), tt.dryRun, pass)
+
+			if len(planned) != 1 || planned[0].Kind != plan.RewritePolicyFile || planned[0].Path != path {
+				t.Fatalf("ProcessPolicyFiles() planned %v, want one rewrite of %s", planned, path)
+			}
+
+			f, err := policyfile.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			findings, err := f.Findings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, finding := range findings {
+				got = append(got, finding.ExtensionID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("findings afterwards = %q, want %q", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("finding %d afterwards = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
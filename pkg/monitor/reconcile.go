@@ -0,0 +1,199 @@
+package monitor
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// ============================================================================
+// RECONCILIATION - Periodic re-enforcement and the polling fallback
+// ============================================================================
+
+// DefaultReconcileInterval is how often the watchers of the engines
+// NewEngine returns reconcile.
+const DefaultReconcileInterval = 10 * time.Minute
+
+// DefaultPollInterval is how often the watchers of the engines NewEngine
+// returns capture state when change notifications are unavailable.
+const DefaultPollInterval = 30 * time.Second
+
+// tick names the periodic task whose timer ended a wait of the watch loops.
+type tick int
+
+const (
+	noTick tick = iota
+	reconcileTick
+	intelTick
+)
+
+// timers deliver the ticks of the periodic tasks of the watch loops. A nil
+// channel never fires.
+type timers struct {
+	reconcile <-chan time.Time // every reconcile interval, if enabled
+	intel     <-chan time.Time // every intel reload interval, with a feed
+}
+
+// startTimers starts the timers of the periodic tasks and returns them with
+// a function stopping them. The tasks run on the watch loop's goroutine, so
+// their writes never race the remediation of change notifications.
+func (e *Engine) startTimers() (timers, func()) {
+	var t timers
+	var tickers []*time.Ticker
+	if e.ReconcileInterval > 0 {
+		ticker := time.NewTicker(e.ReconcileInterval)
+		tickers = append(tickers, ticker)
+		t.reconcile = ticker.C
+	}
+	if e.Intel != nil {
+		ticker := time.NewTicker(intelReloadInterval)
+		tickers = append(tickers, ticker)
+		t.intel = ticker.C
+	}
+	return t, func() {
+		for _, ticker := range tickers {
+			ticker.Stop()
+		}
+	}
+}
+
+// runTick runs the periodic task t and returns the state to diff the next
+// burst against.
+func (e *Engine) runTick(ctx context.Context, t tick, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) *registry.RegState {
+	switch t {
+	case reconcileTick:
+		return e.Reconcile(ctx, b, keyPath, previousState, canWrite, onChange)
+	case intelTick:
+		return e.reloadIntelFeed(ctx, b, keyPath, previousState, canWrite, onChange)
+	}
+	return previousState
+}
+
+// reload makes b re-read its sources if it caches them, so that a capture
+// sees changes made without a notification.
+func reload(b registry.Backend) error {
+	if managed, ok := b.(*registry.ManagedBackend); ok {
+		return managed.Reload()
+	}
+	return nil
+}
+
+// Reconcile re-captures the state below keyPath and plans the
+// EnforcementPasses against it, correcting drift that change notifications
+// missed. The plan is enforced like any other. The changes
+// since previousState are passed to onChange, if not nil, with the state
+// after enforcement. It returns that state, or previousState if the capture
+// failed.
+func (e *Engine) Reconcile(ctx context.Context, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) *registry.RegState {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.Reconcile",
+		attribute.String("key-path", keyPath),
+		attribute.Bool("can-write", canWrite),
+	)
+	defer span.End()
+
+	telemetry.Println(ctx, "\n========================================")
+	telemetry.Println(ctx, "🔄 Reconciling policies...")
+	telemetry.Println(ctx, "Time:", time.Now().Format(time.RFC3339))
+	telemetry.Println(ctx, "========================================")
+
+	if err := reload(b); err != nil {
+		telemetry.Println(ctx, "Error reloading policies:", err)
+		telemetry.RecordError(ctx, err)
+		return previousState
+	}
+	captured, err := CaptureRegistryState(ctx, b, keyPath)
+	if err != nil {
+		telemetry.Println(ctx, "Error capturing state:", err)
+		return previousState
+	}
+	missed := registry.Diff(previousState, captured)
+	for _, change := range missed {
+		PrintChange(ctx, change)
+	}
+
+	sim := e.Plan(ctx, captured, e.EnforcementPasses()...)
+	state := e.Enforce(ctx, b, keyPath, sim, canWrite)
+	corrected := registry.Diff(captured, state)
+
+	span.SetAttributes(attribute.Int("drift.missed", len(missed)), attribute.Int("drift.corrected", len(corrected)))
+	span.SetAttributes(changeCounts("corrected", corrected)...)
+	if len(missed) == 0 && len(corrected) == 0 {
+		telemetry.Println(ctx, "✓ No drift found")
+	} else {
+		telemetry.Printf(ctx, "✓ Reconciled: %d change(s) missed by notifications, %d corrected\n", len(missed), len(corrected))
+	}
+	telemetry.Println(ctx, "========================================")
+	telemetry.Println(ctx)
+
+	if onChange != nil && (len(missed) > 0 || len(corrected) > 0) {
+		onChange(ctx, state, append(missed, corrected...))
+	}
+	return state
+}
+
+// changeCounts returns one attribute per change kind in changes, e.g.
+// "corrected.subkey-removed", holding how many changes have that kind.
+func changeCounts(prefix string, changes []registry.Change) []attribute.KeyValue {
+	counts := make(map[registry.ChangeKind]int)
+	for _, change := range changes {
+		counts[change.Kind]++
+	}
+	var attrs []attribute.KeyValue
+	for _, kind := range []registry.ChangeKind{registry.SubkeyAdded, registry.SubkeyRemoved, registry.ValueAdded, registry.ValueRemoved, registry.ValueChanged} {
+		if counts[kind] > 0 {
+			name := strings.ToLower(strings.ReplaceAll(kind.String(), " ", "-"))
+			attrs = append(attrs, attribute.Int(prefix+"."+name, counts[kind]))
+		}
+	}
+	return attrs
+}
+
+// pollChanges is the fallback of the watchers when change notifications
+// fail: state is captured every poll interval and processed like a burst of
+// notifications when it changed. Reconciliation and intel feed reloads keep
+// running. It returns when ctx is done.
+func (e *Engine) pollChanges(ctx context.Context, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) {
+	telemetry.Printf(ctx, "⚠️  Change notifications unavailable - polling every %s\n", e.PollInterval)
+	telemetry.AddEvent(ctx, "polling-started", attribute.String("interval", e.PollInterval.String()))
+
+	poll := time.NewTicker(e.PollInterval)
+	defer poll.Stop()
+	ticks, stop := e.startTimers()
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks.reconcile:
+			previousState = e.runTick(ctx, reconcileTick, b, keyPath, previousState, canWrite, onChange)
+		case <-ticks.intel:
+			previousState = e.runTick(ctx, intelTick, b, keyPath, previousState, canWrite, onChange)
+		case <-poll.C:
+			if err := reload(b); err != nil {
+				telemetry.Println(ctx, "Error reloading policies:", err)
+				telemetry.RecordError(ctx, err)
+				continue
+			}
+			newState, err := CaptureRegistryState(ctx, b, keyPath)
+			telemetry.RecordCapturePerformed(ctx, "poll")
+			if err != nil {
+				telemetry.Println(ctx, "Error capturing new state:", err)
+				continue
+			}
+			if len(registry.Diff(previousState, newState)) == 0 {
+				continue
+			}
+			state, changes := e.handleChanges(ctx, b, keyPath, previousState, newState, canWrite)
+			previousState = state
+			if onChange != nil {
+				onChange(ctx, state, changes)
+			}
+		}
+	}
+}
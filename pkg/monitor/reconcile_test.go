@@ -0,0 +1,57 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/hijack"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// TestReconcileHijackPolicy sets a hijack policy between two reconciliations
+// without a change notification: the second one must remove it like startup
+// does.
+func TestReconcileHijackPolicy(t *testing.T) {
+	const chromeKey = policiesKeyPath + `\Google\Chrome`
+	const homepage = `Google\Chrome\HomepageLocation`
+
+	e := NewEngine()
+	e.HijackActions = hijack.Actions{"HomepageLocation": hijack.Remove}
+	b := newTree(t, regHeader+`
+[HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallBlocklist]
+"1"="`+idA+`"
+`)
+
+	var reported []registry.Change
+	onChange := func(_ context.Context, _ *registry.RegState, changes []registry.Change) {
+		reported = append(reported, changes...)
+	}
+
+	state := e.Reconcile(testContext(), b, policiesKeyPath, captureState(t, b), true, onChange)
+	if len(reported) > 0 {
+		t.Fatalf("first reconciliation reported %v, want no drift", reported)
+	}
+
+	if err := b.SetValue(chromeKey, "HomepageLocation", detection.RegSZ, detection.EncodeUTF16String("https://evil.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	state = e.Reconcile(testContext(), b, policiesKeyPath, state, true, onChange)
+
+	if _, ok := state.Values[homepage]; ok {
+		t.Errorf("%s still in the reconciled state", homepage)
+	}
+	if _, ok := captureState(t, b).Values[homepage]; ok {
+		t.Errorf("%s not removed by reconciliation", homepage)
+	}
+	var added, removed bool
+	for _, change := range reported {
+		if change.Path == homepage {
+			added = added || change.Kind == registry.ValueAdded
+			removed = removed || change.Kind == registry.ValueRemoved
+		}
+	}
+	if !added || !removed {
+		t.Errorf("reported changes %v, want %s added and removed", reported, homepage)
+	}
+}
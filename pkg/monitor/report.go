@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/policyfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/report"
+)
+
+// ============================================================================
+// DETECTIONS - The policies a scan reports
+// ============================================================================
+
+// FindDetections returns the policies in state that the enforcement passes
+// detect: Chromium forcelist entries, install entries of the JSON
+// ExtensionSettings policy, force- or normal-installed Firefox
+// ExtensionSettings entries, Firefox Extensions\Install and
+// Extensions\Locked values and hijack policies. Entries kept by an
+// exemption or update URL rule are included with AllowedBy set. The result
+// is sorted by source path and extension ID.
+func (e *Engine) FindDetections(state *registry.RegState) []report.Detection {
+	var detections []report.Detection
+	for valuePath, value := range state.Values {
+		browser := detection.GetBrowserIDFromPath(valuePath)
+
+		switch {
+		case detection.IsChromeExtensionForcelist(valuePath):
+			forcelistKeyPath, ok := pathutils.GetParentPath(valuePath)
+			if !ok {
+				continue
+			}
+			for _, entry := range value.Strings() {
+				v := e.decideForcelistEntry(forcelistKeyPath, entry)
+				if v.entry.ID == "" {
+					continue
+				}
+				d := report.Detection{
+					Browser:     browser,
+					ExtensionID: v.entry.ID,
+					UpdateURL:   v.entry.UpdateURL,
+					Source:      valuePath,
+					Policy:      browsers.ExtensionInstallForcelist,
+				}
+				switch {
+				case v.trusted && v.keep:
+					d.AllowedBy = "update URL rule " + v.trustRule.String()
+				case !v.trusted && v.exempt:
+					d.AllowedBy = v.exemption.String()
+				}
+				detections = append(detections, d)
+			}
+
+		case detection.IsExtensionSettingsValue(valuePath):
+			settings, ok := parseExtensionSettingsValue(value)
+			if !ok {
+				continue
+			}
+			for _, entry := range settings.Installed() {
+				updateURL := entry.UpdateURL
+				if updateURL == "" {
+					updateURL = entry.InstallURL
+				}
+				for _, extensionID := range entry.IDs() {
+					rule, exempt := e.matchExemption(valuePath, browsers.ExtensionSettings, extensionID, updateURL)
+					detections = append(detections, report.Detection{
+						Browser:     browser,
+						ExtensionID: extensionID,
+						UpdateURL:   updateURL,
+						Source:      valuePath,
+						Policy:      browsers.ExtensionSettings,
+						AllowedBy:   allowedBy(rule, exempt),
+					})
+				}
+			}
+
+		case isFirefoxInstallMode(valuePath, value):
+			extensionID := detection.ExtractFirefoxExtensionID(valuePath)
+			if extensionID == "" {
+				continue
+			}
+			var installURL string
+			if entryPath, ok := pathutils.GetParentPath(valuePath); ok {
+				installURL = state.Values[pathutils.BuildPath(entryPath, "install_url")].Unexpanded()
+			}
+			rule, exempt := e.matchFirefoxExemption(state, valuePath, extensionID)
+			detections = append(detections, report.Detection{
+				Browser:     browser,
+				ExtensionID: extensionID,
+				UpdateURL:   installURL,
+				Source:      valuePath,
+				Policy:      browsers.ExtensionSettings,
+				AllowedBy:   allowedBy(rule, exempt),
+			})
+
+		case detection.IsFirefoxExtensionsInstall(valuePath):
+			detections = append(detections, report.Detection{
+				Browser:   browser,
+				UpdateURL: value.Unexpanded(),
+				Source:    valuePath,
+				Policy:    browsers.ExtensionsInstall,
+			})
+
+		case detection.IsFirefoxExtensionsLocked(valuePath):
+			detections = append(detections, report.Detection{
+				Browser:     browser,
+				ExtensionID: detection.SanitizeExtensionID(value.Unexpanded()),
+				Source:      valuePath,
+				Policy:      browsers.ExtensionsLocked,
+			})
+		}
+	}
+
+	for _, hit := range FindHijackPolicies(state) {
+		detections = append(detections, report.Detection{
+			Browser: hit.Browser.ID,
+			Source:  hit.Target,
+			Policy:  hit.Detector.Policy,
+		})
+	}
+
+	report.SortDetections(detections)
+	return detections
+}
+
+// FindPolicyFileDetections returns the extension install policies of the
+// Firefox policies.json files at paths, like ProcessPolicyFiles reports
+// them. Missing and unreadable files are skipped.
+func (e *Engine) FindPolicyFileDetections(paths []string) []report.Detection {
+	var detections []report.Detection
+	for _, path := range paths {
+		f, err := policyfile.Load(path)
+		if err != nil {
+			continue
+		}
+		findings, err := f.Findings()
+		if err != nil {
+			continue
+		}
+		for _, finding := range findings {
+			rule, exempt := e.matchPolicyFileExemption(finding)
+			detections = append(detections, report.Detection{
+				Browser:     "firefox",
+				ExtensionID: finding.ExtensionID,
+				UpdateURL:   finding.URL,
+				Source:      path,
+				Policy:      finding.Policy,
+				AllowedBy:   allowedBy(rule, exempt),
+			})
+		}
+	}
+	report.SortDetections(detections)
+	return detections
+}
+
+// allowedBy returns the AllowedBy of a detection matched by rule.
+func allowedBy(rule exemptions.Rule, exempt bool) string {
+	if !exempt {
+		return ""
+	}
+	return rule.String()
+}
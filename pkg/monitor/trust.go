@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/exemptions"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+	"github.com/kad/WindowsBrowserGuard/pkg/trust"
+)
+
+// parseForcelistEntry parses a forcelist entry of the forcelist key at
+// forcelistKeyPath. Entries without an update URL install from the browser's
+// store and are parsed as if they named its update URL.
+func parseForcelistEntry(forcelistKeyPath, value string) detection.ForcelistEntry {
+	entry := detection.ParseForcelistEntry(value)
+	if entry.UpdateURL != "" {
+		return entry
+	}
+	if d, _, ok := browsers.ForPath(forcelistKeyPath); ok && d.UpdateURL != "" {
+		return detection.ParseForcelistEntry(entry.ID + ";" + d.UpdateURL)
+	}
+	return entry
+}
+
+// forcelistVerdict is the decision taken for one forcelist entry.
+type forcelistVerdict struct {
+	entry     detection.ForcelistEntry
+	keep      bool
+	trustRule trust.Rule // set if trusted
+	trusted   bool
+	exemption exemptions.Rule // set if exempt
+	exempt    bool
+}
+
+// decideForcelistEntry decides whether a forcelist entry is kept: the first
+// matching update URL rule wins, so a block rule overrides exemptions;
+// otherwise the entry is kept only if it is exempted.
+func (e *Engine) decideForcelistEntry(forcelistKeyPath, value string) forcelistVerdict {
+	v := forcelistVerdict{entry: parseForcelistEntry(forcelistKeyPath, value)}
+	if v.trustRule, v.trusted = e.UpdateURLRules.Decide(v.entry); v.trusted {
+		v.keep = v.trustRule.Action == trust.Allow
+		return v
+	}
+	v.exemption, v.exempt = e.matchExemption(forcelistKeyPath, browsers.ExtensionInstallForcelist, v.entry.ID, v.entry.UpdateURL)
+	v.keep = v.exempt
+	return v
+}
+
+// log reports the verdict: the matching update URL rule or exemption.
+func (v forcelistVerdict) log(ctx context.Context) {
+	if v.exempt {
+		logExemption(ctx, v.entry.ID, v.exemption)
+	}
+	if !v.trusted {
+		return
+	}
+	icon := "✅"
+	if !v.keep {
+		icon = "⛔"
+	}
+	telemetry.Printf(ctx, "  %s %s update URL %s: %s (rule: %s)\n", icon, v.entry.ID, v.entry.UpdateURL, v.trustRule.Action, v.trustRule)
+	telemetry.AddEvent(ctx, "update-url-decision",
+		attribute.String("extension.id", v.entry.ID),
+		attribute.String("update-url", v.entry.UpdateURL),
+		attribute.String("scheme", v.entry.Scheme),
+		attribute.String("host", v.entry.Host),
+		attribute.String("action", string(v.trustRule.Action)),
+		attribute.String("rule", v.trustRule.String()),
+	)
+}
@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/fswatch"
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// WatchFileChanges monitors the JSON policy files of b and processes their
+// changes through the same capture, diff and remediation steps as
+// WatchRegistryChanges: once a burst of changes ends (see Engine.Debounce),
+// b is reloaded, state is re-captured and what was added is remediated. The
+// Firefox policies.json files at e.PolicyFiles are watched as well and
+// re-processed with ProcessPolicyFiles when they change. onChange, if not
+// nil, is called after every processed change of b and reconciliation (see
+// Engine.ReconcileInterval). If notifications fail, the files are polled
+// instead (see Engine.PollInterval). It returns once ctx is cancelled, after
+// finishing the burst or reconciliation in progress.
+func (e *Engine) WatchFileChanges(ctx context.Context, b *registry.ManagedBackend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.WatchFileChanges",
+		attribute.String("key-path", keyPath),
+		attribute.Int("policy-files", len(e.PolicyFiles)),
+		attribute.Bool("can-write", canWrite),
+	)
+	defer span.End()
+
+	previousState, err := e.watchFiles(ctx, b, keyPath, previousState, canWrite, onChange)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	telemetry.Println(ctx, "Error:", err)
+	telemetry.RecordError(ctx, err)
+	e.pollChanges(ctx, b, keyPath, previousState, canWrite, onChange)
+}
+
+// watchFiles runs the notification loop of WatchFileChanges until ctx is
+// done or notifications fail. It returns the last processed state.
+func (e *Engine) watchFiles(ctx context.Context, b *registry.ManagedBackend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) (*registry.RegState, error) {
+	w, err := fswatch.New()
+	if err != nil {
+		return previousState, fmt.Errorf("setting up policy file notification: %w", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	for _, src := range b.Sources() {
+		if src.Browser.Family == browsers.Gecko {
+			err = w.AddFile(src.Path)
+		} else {
+			err = w.AddDir(src.Path, "*.json")
+		}
+		if err != nil {
+			return previousState, fmt.Errorf("watching policy files: %w", err)
+		}
+		telemetry.Printf(ctx, "👀 Watching %s policies: %s\n", src.Browser.DisplayName, src.Path)
+	}
+	for _, path := range e.PolicyFiles {
+		if err := w.AddFile(path); err != nil {
+			return previousState, fmt.Errorf("watching policy files: %w", err)
+		}
+		telemetry.Printf(ctx, "👀 Watching Firefox policies: %s\n", path)
+	}
+
+	telemetry.Println(ctx, "Monitoring policy file changes...")
+	telemetry.AddEvent(ctx, "monitoring-started")
+
+	ticks, stop := e.startTimers()
+	defer stop()
+	for {
+		changed := make(map[string]bool)
+		n, t, err := coalesce(ctx, e.Debounce, "files", w.Changes(), w.Errors(), ticks, func(paths []string) {
+			for _, path := range paths {
+				changed[path] = true
+			}
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return previousState, nil
+			}
+			return previousState, fmt.Errorf("waiting for policy file changes: %w", err)
+		}
+		if n == 0 {
+			previousState = e.runTick(ctx, t, b, keyPath, previousState, canWrite, onChange)
+			continue
+		}
+		paths := slices.Sorted(maps.Keys(changed))
+		telemetry.AddEvent(ctx, "policy-file-change-detected",
+			attribute.StringSlice("paths", paths),
+			attribute.Int("notifications", n),
+		)
+
+		var files []string
+		managed := false
+		for _, path := range paths {
+			if slices.Contains(e.PolicyFiles, path) {
+				files = append(files, path)
+			} else {
+				managed = true
+			}
+		}
+
+		// Changed policies.json files are planned along with the changes of
+		// b, so that one plan covers the burst.
+		var extra []Pass
+		if len(files) > 0 {
+			extra = append(extra, func(ctx context.Context, _ *registry.RegState) []plan.Action {
+				return e.ProcessPolicyFiles(ctx, files)
+			})
+		}
+		if managed {
+			if err := b.Reload(); err != nil {
+				telemetry.Println(ctx, "Error reloading policy files:", err)
+				telemetry.RecordError(ctx, err)
+				if len(extra) == 0 {
+					continue
+				}
+			}
+		}
+		previousState = e.processChanges(ctx, b, keyPath, "files", n, previousState, canWrite, onChange, extra...)
+	}
+}
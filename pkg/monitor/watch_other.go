@@ -0,0 +1,29 @@
+//go:build !windows
+
+package monitor
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// WatchRegistryChanges monitors registry changes and processes them.
+// Registry change notifications are only available on Windows, so b is
+// polled instead (see Engine.PollInterval) until ctx is cancelled.
+func (e *Engine) WatchRegistryChanges(ctx context.Context, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.WatchRegistryChanges",
+		attribute.String("key-path", keyPath),
+		attribute.Bool("can-write", canWrite),
+	)
+	defer span.End()
+
+	err := errors.New("registry change notifications are only available on Windows")
+	telemetry.Println(ctx, "Error setting up registry notification:", err)
+	telemetry.RecordError(ctx, err)
+	e.pollChanges(ctx, b, keyPath, previousState, canWrite, onChange)
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sys/windows"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+	"github.com/kad/WindowsBrowserGuard/pkg/telemetry"
+)
+
+// WatchRegistryChanges monitors registry changes and processes them.
+// keyPath is opened under HKEY_LOCAL_MACHINE for change notifications; state
+// is re-captured and remediated through b. onChange, if not nil, is called
+// after every processed burst of notifications (see Engine.Debounce) and
+// reconciliation (see Engine.ReconcileInterval). If notifications fail, the
+// registry is polled instead (see Engine.PollInterval). It returns once ctx
+// is cancelled, after finishing the burst or reconciliation in progress.
+func (e *Engine) WatchRegistryChanges(ctx context.Context, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) {
+	ctx, span := telemetry.StartSpan(ctx, "monitor.WatchRegistryChanges",
+		attribute.String("key-path", keyPath),
+		attribute.Bool("can-write", canWrite),
+	)
+	defer span.End()
+
+	previousState, err := e.watchRegistry(ctx, b, keyPath, previousState, canWrite, onChange)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	telemetry.Println(ctx, "Error:", err)
+	telemetry.RecordError(ctx, err)
+	e.pollChanges(ctx, b, keyPath, previousState, canWrite, onChange)
+}
+
+// watchRegistry runs the notification loop of WatchRegistryChanges until ctx
+// is done or notifications fail. It returns the last processed state.
+func (e *Engine) watchRegistry(ctx context.Context, b registry.Backend, keyPath string, previousState *registry.RegState, canWrite bool, onChange ChangeHandler) (*registry.RegState, error) {
+	key, err := syscall.UTF16PtrFromString(keyPath)
+	if err != nil {
+		return previousState, fmt.Errorf("converting key path: %w", err)
+	}
+
+	var hKey windows.Handle
+	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, key, 0, windows.KEY_NOTIFY|windows.KEY_READ, &hKey)
+	if err != nil {
+		return previousState, fmt.Errorf("opening registry key for notifications: %w", err)
+	}
+	defer func() { _ = windows.RegCloseKey(hKey) }()
+
+	event, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		return previousState, fmt.Errorf("creating event: %w", err)
+	}
+	defer func() { _ = windows.CloseHandle(event) }()
+
+	err = windows.RegNotifyChangeKeyValue(hKey, true, windows.REG_NOTIFY_CHANGE_NAME|windows.REG_NOTIFY_CHANGE_LAST_SET, event, true)
+	if err != nil {
+		return previousState, fmt.Errorf("setting up registry notification: %w", err)
+	}
+
+	// quit ends the wait of the notification goroutine when the loop returns.
+	quit, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return previousState, fmt.Errorf("creating event: %w", err)
+	}
+	defer func() { _ = windows.CloseHandle(quit) }()
+
+	// Notifications are received and re-armed in the background so that a
+	// burst keeps being counted while the previous one is processed.
+	notify := make(chan struct{})
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer func() { _ = windows.SetEvent(quit) }()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handles := []windows.Handle{event, quit}
+		for {
+			status, err := windows.WaitForMultipleObjects(handles, false, windows.INFINITE)
+			if err != nil {
+				errs <- fmt.Errorf("waiting for event: %w", err)
+				return
+			}
+			if status != windows.WAIT_OBJECT_0 {
+				return
+			}
+			err = windows.RegNotifyChangeKeyValue(hKey, true, windows.REG_NOTIFY_CHANGE_NAME|windows.REG_NOTIFY_CHANGE_LAST_SET, event, true)
+			if err != nil {
+				errs <- fmt.Errorf("re-arming registry notification: %w", err)
+				return
+			}
+			select {
+			case notify <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	telemetry.Println(ctx, "Monitoring registry changes...")
+	telemetry.AddEvent(ctx, "monitoring-started")
+
+	ticks, stop := e.startTimers()
+	defer stop()
+	for {
+		n, t, err := coalesce(ctx, e.Debounce, "registry", notify, errs, ticks, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return previousState, nil
+			}
+			return previousState, err
+		}
+		if n == 0 {
+			previousState = e.runTick(ctx, t, b, keyPath, previousState, canWrite, onChange)
+			continue
+		}
+		telemetry.AddEvent(ctx, "registry-change-detected", attribute.Int("notifications", n))
+		previousState = e.processChanges(ctx, b, keyPath, "registry", n, previousState, canWrite, onChange)
+	}
+}
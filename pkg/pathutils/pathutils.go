@@ -72,6 +72,22 @@ func HasPathComponentSequence(path string, components ...string) bool {
 	return false
 }
 
+// TrimPathPrefix removes prefix from path when prefix names path itself or
+// one of its ancestor keys (case-insensitive, whole components only).
+// Example: TrimPathPrefix("Google\\Chrome\\ExtensionInstallForcelist", "Google\\Chrome") == ("ExtensionInstallForcelist", true)
+func TrimPathPrefix(path, prefix string) (string, bool) {
+	if len(path) < len(prefix) || !strings.EqualFold(path[:len(prefix)], prefix) {
+		return "", false
+	}
+	if len(path) == len(prefix) {
+		return "", true
+	}
+	if path[len(prefix)] != '\\' {
+		return "", false
+	}
+	return path[len(prefix)+1:], true
+}
+
 // HasPathComponent checks if path contains a specific component
 // More efficient than case-insensitive contains for path matching
 func HasPathComponent(path, component string) bool {
@@ -0,0 +1,178 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// ============================================================================
+// REMEDIATION PLAN - Typed remediation actions and their results
+// ============================================================================
+
+// Kind is the type of a remediation action.
+type Kind string
+
+const (
+	// DeleteKey deletes the key at Path and everything below it.
+	DeleteKey Kind = "delete-key"
+	// DeleteValue deletes the value at Path, e.g. a forcelist value whose
+	// entries are all removed or a hijack policy value.
+	DeleteValue Kind = "delete-value"
+	// SetValue writes Value at Path, e.g. a forcelist value rewritten with
+	// its kept entries or a hijack policy reset to its safe value.
+	SetValue Kind = "set-value"
+	// AddBlocklistEntry adds ExtensionID to the Chromium
+	// ExtensionInstallBlocklist key at Path.
+	AddBlocklistEntry Kind = "add-blocklist-entry"
+	// SetFirefoxBlocked sets installation_mode=blocked in the Gecko
+	// ExtensionSettings\{id} key at Path.
+	SetFirefoxBlocked Kind = "set-firefox-blocked"
+	// RemoveAllowlistValue removes the values listing ExtensionID from the
+	// Chromium ExtensionInstallAllowlist key at Path.
+	RemoveAllowlistValue Kind = "remove-allowlist-value"
+	// RemoveExtensionSettings removes extension settings at Path: a
+	// 3rdparty extension policy key is deleted; the JSON ExtensionSettings
+	// value is rewritten with Value, or deleted if Value is nil.
+	RemoveExtensionSettings Kind = "remove-extension-settings"
+	// ReleaseEntry drops the guard-written entry of ExtensionID in the key
+	// at Path from the ownership ledger because current policy allows the
+	// extension. The policies are left as they are.
+	ReleaseEntry Kind = "release-entry"
+	// RewritePolicyFile rewrites the Firefox policies.json file at Path,
+	// blocking or removing the extension install policies it holds. Path is
+	// a file path.
+	RewritePolicyFile Kind = "rewrite-policy-file"
+)
+
+// Action is one remediation step. Paths are relative to
+// HKLM\SOFTWARE\Policies, except those of RewritePolicyFile.
+type Action struct {
+	Kind        Kind   `json:"kind"`
+	Browser     string `json:"browser,omitempty"`
+	ExtensionID string `json:"extensionId,omitempty"`
+	// Detector is the detector, or the hijack policy, that planned the
+	// action.
+	Detector string `json:"detector,omitempty"`
+	// Path is the key or value the action changes.
+	Path string `json:"path"`
+	// Source is the policy that made the action necessary.
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+	// Quarantine backs up Path before it is deleted or rewritten.
+	Quarantine bool `json:"quarantine,omitempty"`
+	// Value is the value written by SetValue and RemoveExtensionSettings.
+	Value *registry.RegValue `json:"-"`
+}
+
+// key identifies the change a makes; a plan holds each change once.
+func (a Action) key() string {
+	return string(a.Kind) + "|" + strings.ToLower(a.Path) + "|" + strings.ToLower(a.ExtensionID)
+}
+
+// String describes a in one line.
+func (a Action) String() string {
+	switch a.Kind {
+	case DeleteKey:
+		return "Delete key " + a.Path
+	case DeleteValue:
+		return "Delete value " + a.Path
+	case SetValue:
+		return fmt.Sprintf("Set %s = %s", a.Path, a.data())
+	case AddBlocklistEntry:
+		return fmt.Sprintf("Add %s to blocklist %s", a.ExtensionID, a.Path)
+	case SetFirefoxBlocked:
+		return fmt.Sprintf("Block %s in %s", a.ExtensionID, a.Path)
+	case RemoveAllowlistValue:
+		return fmt.Sprintf("Remove %s from allowlist %s", a.ExtensionID, a.Path)
+	case RemoveExtensionSettings:
+		if a.Value != nil {
+			return fmt.Sprintf("Rewrite extension settings %s = %s", a.Path, a.data())
+		}
+		return "Remove extension settings " + a.Path
+	case ReleaseEntry:
+		return fmt.Sprintf("Release guard-written entry for %s in %s", a.ExtensionID, a.Path)
+	case RewritePolicyFile:
+		return "Rewrite policy file " + a.Path
+	}
+	return string(a.Kind) + " " + a.Path
+}
+
+// data returns the printable data of Value.
+func (a Action) data() string {
+	if a.Value == nil {
+		return ""
+	}
+	return a.Value.Data
+}
+
+// Plan is an ordered list of remediation actions. Actions are applied in
+// order: e.g. allowlist values are removed before the emptied key is
+// deleted.
+type Plan struct {
+	Actions []Action `json:"actions"`
+}
+
+// Add appends a unless the plan already makes the same change. It reports
+// whether a was added.
+func (p *Plan) Add(a Action) bool {
+	for _, planned := range p.Actions {
+		if planned.key() == a.key() {
+			return false
+		}
+	}
+	p.Actions = append(p.Actions, a)
+	return true
+}
+
+// Len returns the number of actions in p.
+func (p *Plan) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.Actions)
+}
+
+// Status is the outcome of an action.
+type Status string
+
+const (
+	// Planned actions were not applied (yet).
+	Planned Status = "planned"
+	// Applied actions changed the policies.
+	Applied Status = "applied"
+	// Skipped actions found their change already made.
+	Skipped Status = "skipped"
+	// Failed actions could not be applied; Error says why.
+	Failed Status = "failed"
+)
+
+// Result is the outcome of applying an action.
+type Result struct {
+	Action
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Results returns every action of p with the Planned status.
+func (p *Plan) Results() []Result {
+	results := make([]Result, 0, p.Len())
+	if p != nil {
+		for _, a := range p.Actions {
+			results = append(results, Result{Action: a, Status: Planned})
+		}
+	}
+	return results
+}
+
+// Count returns the number of results with status s.
+func Count(results []Result, s Status) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == s {
+			n++
+		}
+	}
+	return n
+}
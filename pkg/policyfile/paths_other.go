@@ -0,0 +1,11 @@
+//go:build !windows
+
+package policyfile
+
+// DefaultPaths returns the policies.json locations of the standard Firefox
+// installs. None are scanned by default on this platform, where the live
+// backend already reads Firefox's policies.json (see
+// registry.ManagedBackend); configure additional paths explicitly.
+func DefaultPaths() []string {
+	return nil
+}
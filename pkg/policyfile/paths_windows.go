@@ -0,0 +1,18 @@
+package policyfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultPaths returns the policies.json locations of the standard Firefox
+// and Firefox ESR installs (both use "Mozilla Firefox").
+func DefaultPaths() []string {
+	var paths []string
+	for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)"} {
+		if dir := os.Getenv(env); dir != "" {
+			paths = append(paths, filepath.Join(dir, "Mozilla Firefox", "distribution", "policies.json"))
+		}
+	}
+	return paths
+}
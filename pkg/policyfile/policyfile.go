@@ -0,0 +1,265 @@
+package policyfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/atomicfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+)
+
+// ============================================================================
+// POLICIES.JSON - Firefox enterprise policy files outside the registry
+// ============================================================================
+
+// Policy names reported in findings.
+const (
+	PolicyExtensionSettings = "ExtensionSettings"
+	PolicyExtensionsInstall = "Extensions.Install"
+	PolicyExtensionsLocked  = "Extensions.Locked"
+)
+
+// Keys of the policies.json document.
+const (
+	policiesKey            = "policies"
+	extensionsKey          = "Extensions"
+	extensionsInstallField = "Install"
+	extensionsLockedField  = "Locked"
+)
+
+// Finding is one extension install policy found in a policies.json file.
+type Finding struct {
+	Policy      string // PolicyExtensionSettings, PolicyExtensionsInstall or PolicyExtensionsLocked
+	ExtensionID string // empty for Extensions.Install, which lists URLs or paths
+	URL         string // install_url or the Extensions.Install entry
+	Detail      string // installation mode, install URL or locked ID
+}
+
+// File is a parsed policies.json. Unknown policies and fields are preserved
+// when the file is rewritten.
+type File struct {
+	Path     string
+	mode     os.FileMode
+	doc      map[string]json.RawMessage
+	policies map[string]json.RawMessage
+}
+
+// Load reads and parses the policies.json at path. A missing file is
+// reported with an error satisfying errors.Is(err, os.ErrNotExist).
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Path: path, mode: 0o644}
+	if info, err := os.Stat(path); err == nil {
+		f.mode = info.Mode().Perm()
+	}
+	if err := json.Unmarshal(data, &f.doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if f.doc == nil {
+		f.doc = make(map[string]json.RawMessage)
+	}
+	if raw, ok := f.doc[policiesKey]; ok {
+		if err := json.Unmarshal(raw, &f.policies); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q: %w", path, policiesKey, err)
+		}
+	}
+	if f.policies == nil {
+		f.policies = make(map[string]json.RawMessage)
+	}
+	return f, nil
+}
+
+// ExtensionSettings returns the ExtensionSettings policy, or nil if the file
+// has none.
+func (f *File) ExtensionSettings() (extsettings.Settings, error) {
+	raw, ok := f.policies[PolicyExtensionSettings]
+	if !ok {
+		return nil, nil
+	}
+	settings, err := extsettings.Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return settings, nil
+}
+
+// extensions returns the fields of the Extensions policy.
+func (f *File) extensions() map[string]json.RawMessage {
+	var ext map[string]json.RawMessage
+	if raw, ok := f.policies[extensionsKey]; ok {
+		_ = json.Unmarshal(raw, &ext)
+	}
+	return ext
+}
+
+// extensionsList returns the string list Extensions.<field>.
+func (f *File) extensionsList(field string) []string {
+	var list []string
+	if raw, ok := f.extensions()[field]; ok {
+		_ = json.Unmarshal(raw, &list)
+	}
+	return list
+}
+
+// ExtensionsInstall returns the URLs and paths listed in Extensions.Install.
+func (f *File) ExtensionsInstall() []string {
+	return f.extensionsList(extensionsInstallField)
+}
+
+// ExtensionsLocked returns the extension IDs listed in Extensions.Locked.
+func (f *File) ExtensionsLocked() []string {
+	return f.extensionsList(extensionsLockedField)
+}
+
+// Findings returns every extension install policy in f: force- or
+// normal-installed ExtensionSettings entries, Extensions.Install and
+// Extensions.Locked.
+func (f *File) Findings() ([]Finding, error) {
+	var findings []Finding
+
+	settings, err := f.ExtensionSettings()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range settings.Installed() {
+		for _, id := range entry.IDs() {
+			findings = append(findings, Finding{Policy: PolicyExtensionSettings, ExtensionID: id, URL: entry.InstallURL, Detail: entry.Mode})
+		}
+	}
+	for _, url := range f.ExtensionsInstall() {
+		findings = append(findings, Finding{Policy: PolicyExtensionsInstall, URL: url, Detail: url})
+	}
+	for _, id := range f.ExtensionsLocked() {
+		findings = append(findings, Finding{
+			Policy:      PolicyExtensionsLocked,
+			ExtensionID: detection.SanitizeExtensionID(id),
+			Detail:      id,
+		})
+	}
+	return findings, nil
+}
+
+// Remediate applies the registry semantics of BlockGeckoExtension to f:
+// ExtensionSettings install entries get action, IDs in Extensions.Locked are
+// blocked through ExtensionSettings, and the Extensions.Install and
+// Extensions.Locked entries are removed. Findings for which exempt returns
+// true are left in place; exempt may be nil. It reports whether f changed.
+func (f *File) Remediate(action extsettings.Action, exempt func(Finding) bool) (bool, error) {
+	if exempt == nil {
+		exempt = func(Finding) bool { return false }
+	}
+	settings, err := f.ExtensionSettings()
+	if err != nil {
+		return false, err
+	}
+	if settings == nil {
+		settings = make(extsettings.Settings)
+	}
+	changed := false
+
+	for _, entry := range settings.Installed() {
+		var ids []string
+		for _, id := range entry.IDs() {
+			if !exempt(Finding{Policy: PolicyExtensionSettings, ExtensionID: id, URL: entry.InstallURL, Detail: entry.Mode}) {
+				ids = append(ids, id)
+			}
+		}
+		changed = settings.ApplyIDs(entry.Key, ids, action) || changed
+	}
+
+	var locked []string
+	for _, raw := range f.ExtensionsLocked() {
+		id := detection.SanitizeExtensionID(raw)
+		if exempt(Finding{Policy: PolicyExtensionsLocked, ExtensionID: id, Detail: raw}) {
+			locked = append(locked, raw)
+			continue
+		}
+		changed = true
+		if id == "" {
+			continue
+		}
+		blocked, _ := json.Marshal(extsettings.ModeBlocked)
+		if settings[id] == nil {
+			settings[id] = make(map[string]json.RawMessage)
+		}
+		settings[id]["installation_mode"] = blocked
+	}
+
+	var install []string
+	for _, url := range f.ExtensionsInstall() {
+		if exempt(Finding{Policy: PolicyExtensionsInstall, URL: url, Detail: url}) {
+			install = append(install, url)
+		} else {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	if len(settings) > 0 {
+		data, err := settings.Marshal()
+		if err != nil {
+			return false, err
+		}
+		f.policies[PolicyExtensionSettings] = json.RawMessage(data)
+	} else {
+		delete(f.policies, PolicyExtensionSettings)
+	}
+
+	if ext := f.extensions(); ext != nil {
+		for field, kept := range map[string][]string{extensionsInstallField: install, extensionsLockedField: locked} {
+			if len(kept) == 0 {
+				delete(ext, field)
+				continue
+			}
+			data, err := json.Marshal(kept)
+			if err != nil {
+				return false, fmt.Errorf("encoding %s.%s: %w", extensionsKey, field, err)
+			}
+			ext[field] = data
+		}
+		if len(ext) > 0 {
+			data, err := json.Marshal(ext)
+			if err != nil {
+				return false, fmt.Errorf("encoding %s policy: %w", extensionsKey, err)
+			}
+			f.policies[extensionsKey] = data
+		} else {
+			delete(f.policies, extensionsKey)
+		}
+	}
+	return true, nil
+}
+
+// Marshal returns the indented JSON form of f.
+func (f *File) Marshal() ([]byte, error) {
+	policies, err := json.Marshal(f.policies)
+	if err != nil {
+		return nil, fmt.Errorf("encoding policies: %w", err)
+	}
+	f.doc[policiesKey] = policies
+	data, err := json.MarshalIndent(f.doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", f.Path, err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Save writes f back to f.Path atomically, keeping the file permissions.
+func Save(f *File) error {
+	data, err := f.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.WriteFile(f.Path, data, f.mode); err != nil {
+		return fmt.Errorf("saving policy file: %w", err)
+	}
+	return nil
+}
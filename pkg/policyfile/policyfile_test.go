@@ -0,0 +1,206 @@
+package policyfile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
+)
+
+// fixture installs two extensions through ExtensionSettings, one through
+// Extensions.Install and locks a third, next to settings unrelated to
+// extensions.
+const fixture = `{
+  "comment": "managed by IT",
+  "policies": {
+    "DisableTelemetry": true,
+    "ExtensionSettings": {
+      "*": {"installation_mode": "allowed"},
+      "evil@example.com": {
+        "installation_mode": "force_installed",
+        "install_url": "https://example.com/evil.xpi",
+        "default_area": "navbar"
+      },
+      "good@example.com": {
+        "installation_mode": "normal_installed",
+        "install_url": "https://addons.mozilla.org/good.xpi"
+      },
+      "old@example.com": {"installation_mode": "blocked"}
+    },
+    "Extensions": {
+      "Install": ["https://example.com/other.xpi"],
+      "Locked": ["locked@example.com"],
+      "Uninstall": ["gone@example.com"]
+    }
+  }
+}`
+
+// writeFixture writes data to a policies.json in a temporary directory and
+// returns its path.
+func writeFixture(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policies.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load loads the policies.json at path.
+func load(t *testing.T, path string) *File {
+	t.Helper()
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// decode parses data into generic values, so that two encodings of the same
+// document compare equal.
+func decode(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return v
+}
+
+func TestFindings(t *testing.T) {
+	findings, err := load(t, writeFixture(t, fixture)).Findings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Finding{
+		{Policy: PolicyExtensionSettings, ExtensionID: "evil@example.com", URL: "https://example.com/evil.xpi", Detail: extsettings.ModeForceInstalled},
+		{Policy: PolicyExtensionSettings, ExtensionID: "good@example.com", URL: "https://addons.mozilla.org/good.xpi", Detail: extsettings.ModeNormalInstalled},
+		{Policy: PolicyExtensionsInstall, URL: "https://example.com/other.xpi", Detail: "https://example.com/other.xpi"},
+		{Policy: PolicyExtensionsLocked, ExtensionID: "locked@example.com", Detail: "locked@example.com"},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("Findings() = %+v\nwant %+v", findings, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "policies.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() of a missing file error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not JSON", `{`, "parsing"},
+		{"policies not an object", `{"policies": []}`, `"policies"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFixture(t, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	f := load(t, writeFixture(t, `{"policies": {"ExtensionSettings": []}}`))
+	if _, err := f.Findings(); err == nil {
+		t.Error("Findings() of a malformed ExtensionSettings succeeded, want an error")
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	for _, data := range []string{`{}`, `{"policies": {}}`, `null`} {
+		f := load(t, writeFixture(t, data))
+		findings, err := f.Findings()
+		if err != nil || len(findings) != 0 {
+			t.Errorf("Findings() of %s = %v, %v, want none", data, findings, err)
+		}
+		if changed, err := f.Remediate(extsettings.ActionBlock, nil); changed || err != nil {
+			t.Errorf("Remediate() of %s = %v, %v, want false, nil", data, changed, err)
+		}
+	}
+}
+
+func TestRemediate(t *testing.T) {
+	// remediated is fixture after remediation with action, good@example.com
+	// exempted.
+	remediated := func(action extsettings.Action) map[string]any {
+		want := decode(t, []byte(fixture))
+		policies := want["policies"].(map[string]any)
+		settings := policies["ExtensionSettings"].(map[string]any)
+		switch action {
+		case extsettings.ActionBlock:
+			settings["evil@example.com"] = map[string]any{"installation_mode": "blocked", "default_area": "navbar"}
+		case extsettings.ActionRemove:
+			delete(settings, "evil@example.com")
+		}
+		settings["locked@example.com"] = map[string]any{"installation_mode": "blocked"}
+		policies["Extensions"] = map[string]any{"Uninstall": []any{"gone@example.com"}}
+		return want
+	}
+	exempt := func(finding Finding) bool { return finding.ExtensionID == "good@example.com" }
+
+	for _, action := range []extsettings.Action{extsettings.ActionBlock, extsettings.ActionRemove} {
+		t.Run(string(action), func(t *testing.T) {
+			path := writeFixture(t, fixture)
+			f := load(t, path)
+			if changed, err := f.Remediate(action, exempt); !changed || err != nil {
+				t.Fatalf("Remediate() = %v, %v, want true, nil", changed, err)
+			}
+			if err := Save(f); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := decode(t, data), remediated(action); !reflect.DeepEqual(got, want) {
+				t.Errorf("saved %s\nwant %v", data, want)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("saved file mode = %v, want %v", info.Mode().Perm(), os.FileMode(0o600))
+			}
+
+			// Only the exempted finding is left.
+			findings, err := load(t, path).Findings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(findings) != 1 || !exempt(findings[0]) {
+				t.Errorf("findings after Remediate() = %+v, want only the exempted one", findings)
+			}
+		})
+	}
+}
+
+func TestRemediateSharedEntry(t *testing.T) {
+	path := writeFixture(t, `{"policies": {"ExtensionSettings": {
+  "a@example.com,b@example.com": {"installation_mode": "force_installed", "install_url": "https://example.com/x.xpi"}
+}}}`)
+	f := load(t, path)
+	exempt := func(finding Finding) bool { return finding.ExtensionID == "b@example.com" }
+	if changed, err := f.Remediate(extsettings.ActionRemove, exempt); !changed || err != nil {
+		t.Fatalf("Remediate() = %v, %v, want true, nil", changed, err)
+	}
+	settings, err := f.ExtensionSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []extsettings.Entry{{Key: "b@example.com", Mode: extsettings.ModeForceInstalled, InstallURL: "https://example.com/x.xpi"}}
+	if got := settings.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries after Remediate() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package quarantine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/regfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// ============================================================================
+// QUARANTINE - Backups of policies taken before they are deleted
+// ============================================================================
+
+// Store writes backups into Dir. Registry keys and values are saved as .reg
+// files that restore them when imported; files are copied as they are.
+type Store struct {
+	Dir string
+}
+
+// fileName returns a unique-looking, filesystem-safe backup name for what.
+func fileName(what string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, what)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + safe + "-*"
+}
+
+// create opens a new backup file for what with the given extension.
+func (s Store) create(what, ext string) (*os.File, error) {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating quarantine directory: %w", err)
+	}
+	f, err := os.CreateTemp(s.Dir, fileName(what)+ext)
+	if err != nil {
+		return nil, fmt.Errorf("creating quarantine file: %w", err)
+	}
+	return f, nil
+}
+
+// writeReg writes rf as a new backup file for what.
+func (s Store) writeReg(what string, rf *regfile.File) (string, error) {
+	f, err := s.create(what, ".reg")
+	if err != nil {
+		return "", err
+	}
+	if _, err := rf.WriteTo(f); err != nil {
+		_ = f.Close()
+		return "", fmt.Errorf("writing %s: %w", f.Name(), err)
+	}
+	return f.Name(), f.Close()
+}
+
+// SaveKey backs up the key at relPath below keyPath (relative to HKLM),
+// with all its values and subkeys, and returns the backup file path.
+func (s Store) SaveKey(b registry.Backend, keyPath, relPath string) (string, error) {
+	state := registry.NewRegState()
+	state.Subkeys[relPath] = true
+	if err := registry.CaptureKeyRecursive(b, keyPath, relPath, state, 0); err != nil {
+		return "", fmt.Errorf("reading %s: %w", relPath, err)
+	}
+	return s.writeReg(relPath, regfile.FromState(state, keyPath, relPath))
+}
+
+// SaveValue backs up a single value, stored at valuePath below keyPath, and
+// returns the backup file path.
+func (s Store) SaveValue(keyPath, valuePath string, value registry.RegValue) (string, error) {
+	parent, _ := pathutils.GetParentPath(valuePath)
+	rf := &regfile.File{Unicode: true, Keys: []regfile.Key{{
+		Path:   pathutils.BuildPath(regfile.HiveLocalMachine, keyPath, parent),
+		Values: []regfile.Value{{Name: pathutils.GetKeyName(valuePath), Type: value.Type, Data: value.Raw}},
+	}}}
+	return s.writeReg(valuePath, rf)
+}
+
+// SaveFile copies the file at path and returns the backup file path.
+func (s Store) SaveFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = src.Close() }()
+
+	dst, err := s.create(filepath.Base(path), filepath.Ext(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return "", fmt.Errorf("copying %s: %w", path, err)
+	}
+	return dst.Name(), dst.Close()
+}
@@ -0,0 +1,418 @@
+package regfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// ============================================================================
+// .REG FILES - Parser for regedit text exports (REGEDIT4 and version 5.00)
+// ============================================================================
+
+const (
+	// HeaderV4 is the first line of an ANSI REGEDIT4 export.
+	HeaderV4 = "REGEDIT4"
+	// HeaderV5 is the first line of a Unicode regedit export.
+	HeaderV5 = "Windows Registry Editor Version 5.00"
+
+	// HiveLocalMachine is the root name .reg files use for HKLM.
+	HiveLocalMachine = "HKEY_LOCAL_MACHINE"
+)
+
+// Value is a single value line of a .reg file. Data always holds the
+// native registry encoding (UTF-16LE for string types), regardless of the
+// file version it was read from.
+type Value struct {
+	Name   string // "" for the default value (@)
+	Type   uint32
+	Data   []byte
+	Delete bool // "name"=-
+}
+
+// Key is a [key] section of a .reg file. Path includes the hive name, e.g.
+// HKEY_LOCAL_MACHINE\SOFTWARE\Policies.
+type Key struct {
+	Path   string
+	Delete bool // [-key]
+	Values []Value
+}
+
+// File is a parsed .reg file.
+type File struct {
+	Unicode bool // version 5.00 (true) or REGEDIT4 (false)
+	Keys    []Key
+}
+
+// Parse reads a .reg file from r. UTF-16LE (with BOM), UTF-8 and ANSI
+// encoded files are accepted.
+func Parse(r io.Reader) (*File, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading .reg data: %w", err)
+	}
+
+	lines := joinContinuations(strings.Split(decodeText(raw), "\n"))
+
+	f := &File{}
+	headerSeen := false
+	var current *Key
+
+	for _, line := range lines {
+		text := strings.TrimSpace(line.text)
+		if text == "" || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if !headerSeen {
+			switch text {
+			case HeaderV5:
+				f.Unicode = true
+			case HeaderV4:
+				f.Unicode = false
+			default:
+				return nil, fmt.Errorf("line %d: not a .reg file (unexpected header %q)", line.number, text)
+			}
+			headerSeen = true
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated key header", line.number)
+			}
+			keyPath := text[1 : len(text)-1]
+			del := strings.HasPrefix(keyPath, "-")
+			if del {
+				keyPath = keyPath[1:]
+			}
+			keyPath = strings.Trim(strings.TrimSpace(keyPath), "\\")
+			if keyPath == "" {
+				return nil, fmt.Errorf("line %d: empty key path", line.number)
+			}
+			f.Keys = append(f.Keys, Key{Path: keyPath, Delete: del})
+			current = &f.Keys[len(f.Keys)-1]
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("line %d: value outside of a key section", line.number)
+		}
+		if current.Delete {
+			return nil, fmt.Errorf("line %d: value inside a key deletion section", line.number)
+		}
+
+		value, err := parseValue(text, f.Unicode)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		current.Values = append(current.Values, value)
+	}
+
+	if !headerSeen {
+		return nil, errors.New("not a .reg file (empty input)")
+	}
+	return f, nil
+}
+
+// ParseFile parses the .reg file at path.
+func ParseFile(path string) (*File, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = fh.Close() }()
+
+	f, err := Parse(fh)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Apply writes the keys and values of f into b, honouring key and value
+// deletions. Only HKEY_LOCAL_MACHINE sections are applied, because every
+// Backend is rooted at HKLM; the number of skipped sections is returned.
+func (f *File) Apply(b registry.Backend) (skipped int, err error) {
+	for _, key := range f.Keys {
+		keyPath, ok := LocalMachinePath(key.Path)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		if key.Delete {
+			parent, name := splitLast(keyPath)
+			err := registry.DeleteRegistryKeyRecursive(b, parent, name)
+			if err != nil && !errors.Is(err, registry.ErrNotFound) {
+				return skipped, fmt.Errorf("deleting %s: %w", key.Path, err)
+			}
+			continue
+		}
+
+		if err := b.CreateKey(keyPath); err != nil {
+			return skipped, fmt.Errorf("creating %s: %w", key.Path, err)
+		}
+		for _, value := range key.Values {
+			if value.Delete {
+				err := b.DeleteValue(keyPath, value.Name)
+				if err != nil && !errors.Is(err, registry.ErrNotFound) {
+					return skipped, fmt.Errorf("deleting %s\\%s: %w", key.Path, value.Name, err)
+				}
+				continue
+			}
+			if err := b.SetValue(keyPath, value.Name, value.Type, value.Data); err != nil {
+				return skipped, fmt.Errorf("setting %s\\%s: %w", key.Path, value.Name, err)
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// LoadFile parses the .reg file at path into a new in-memory registry tree.
+func LoadFile(path string) (*registry.MemoryBackend, error) {
+	f, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := registry.NewMemoryBackend()
+	if _, err := f.Apply(b); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// LoadState parses the .reg file at path and captures keyPath (relative to
+// HKLM, e.g. SOFTWARE\Policies) from it. The returned backend holds the full
+// imported tree so that registry passes can run against it in dry-run mode.
+func LoadState(path, keyPath string) (*registry.RegState, *registry.MemoryBackend, error) {
+	b, err := LoadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := b.OpenKey(keyPath); err != nil {
+		return nil, nil, fmt.Errorf("%s does not contain HKLM\\%s: %w", path, keyPath, err)
+	}
+	state, err := registry.CaptureState(b, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("capturing HKLM\\%s from %s: %w", keyPath, path, err)
+	}
+	return state, b, nil
+}
+
+// LocalMachinePath strips the HKEY_LOCAL_MACHINE (or HKLM) prefix from a
+// .reg key path. ok is false for keys in any other hive.
+func LocalMachinePath(keyPath string) (string, bool) {
+	hive, rest, _ := strings.Cut(keyPath, "\\")
+	if !strings.EqualFold(hive, HiveLocalMachine) && !strings.EqualFold(hive, "HKLM") {
+		return "", false
+	}
+	return rest, true
+}
+
+func splitLast(path string) (parent, name string) {
+	idx := strings.LastIndex(path, "\\")
+	if idx == -1 {
+		return "", path
+	}
+	return path[:idx], path[idx+1:]
+}
+
+// decodeText converts raw file bytes to a string, honouring UTF-16LE and
+// UTF-8 byte order marks. Non-UTF-8 input without a BOM is treated as
+// Latin-1, the closest portable match for ANSI REGEDIT4 exports.
+func decodeText(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xFF, 0xFE}):
+		raw = raw[2:]
+		u16 := make([]uint16, len(raw)/2)
+		for i := range u16 {
+			u16[i] = uint16(raw[i*2]) | uint16(raw[i*2+1])<<8
+		}
+		return normalizeNewlines(string(utf16.Decode(u16)))
+	case bytes.HasPrefix(raw, []byte{0xEF, 0xBB, 0xBF}):
+		return normalizeNewlines(string(raw[3:]))
+	case utf8.Valid(raw):
+		return normalizeNewlines(string(raw))
+	default:
+		return normalizeNewlines(latin1ToString(raw))
+	}
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+func latin1ToString(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+type logicalLine struct {
+	number int
+	text   string
+}
+
+// joinContinuations merges hex data lines that end in a backslash with the
+// lines that follow them, as written by regedit for long binary values.
+func joinContinuations(raw []string) []logicalLine {
+	var lines []logicalLine
+	for i := 0; i < len(raw); i++ {
+		line := logicalLine{number: i + 1, text: strings.TrimRight(raw[i], " \t\r")}
+		for strings.HasSuffix(line.text, "\\") && !strings.HasPrefix(strings.TrimSpace(line.text), "[") && i+1 < len(raw) {
+			i++
+			line.text = line.text[:len(line.text)-1] + strings.TrimSpace(raw[i])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseValue parses a `"name"=data` or `@=data` line.
+func parseValue(text string, unicode bool) (Value, error) {
+	var v Value
+	var rest string
+
+	if strings.HasPrefix(text, "@") {
+		rest = strings.TrimSpace(text[1:])
+	} else if strings.HasPrefix(text, "\"") {
+		name, n, err := parseQuoted(text)
+		if err != nil {
+			return v, fmt.Errorf("value name: %w", err)
+		}
+		v.Name = name
+		rest = strings.TrimSpace(text[n:])
+	} else {
+		return v, fmt.Errorf("unexpected line %q", text)
+	}
+
+	if !strings.HasPrefix(rest, "=") {
+		return v, fmt.Errorf("missing '=' after value name %q", v.Name)
+	}
+	data := strings.TrimSpace(rest[1:])
+
+	switch {
+	case data == "-":
+		v.Delete = true
+	case strings.HasPrefix(data, "\""):
+		s, n, err := parseQuoted(data)
+		if err != nil {
+			return v, fmt.Errorf("value %q: %w", v.Name, err)
+		}
+		if strings.TrimSpace(data[n:]) != "" {
+			return v, fmt.Errorf("value %q: trailing data after string", v.Name)
+		}
+		v.Type = detection.RegSZ
+		v.Data = detection.EncodeUTF16String(s)
+	case hasPrefixFold(data, "dword:"):
+		n, err := strconv.ParseUint(strings.TrimSpace(data[len("dword:"):]), 16, 32)
+		if err != nil {
+			return v, fmt.Errorf("value %q: invalid dword: %w", v.Name, err)
+		}
+		v.Type = detection.RegDword
+		v.Data = []byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}
+	case hasPrefixFold(data, "hex"):
+		valueType, payload, err := parseHexValue(data)
+		if err != nil {
+			return v, fmt.Errorf("value %q: %w", v.Name, err)
+		}
+		if !unicode && (valueType == detection.RegExpandSZ || valueType == detection.RegMultiSZ) {
+			// REGEDIT4 stores string types as single-byte ANSI text;
+			// convert to the UTF-16LE form the registry uses.
+			payload = ansiToUTF16(payload)
+		}
+		v.Type = valueType
+		v.Data = payload
+	default:
+		return v, fmt.Errorf("value %q: unsupported data %q", v.Name, data)
+	}
+	return v, nil
+}
+
+// parseQuoted parses a double-quoted .reg string starting at s[0], handling
+// the \\ and \" escapes. It returns the unescaped string and the number of
+// bytes consumed including both quotes.
+func parseQuoted(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				sb.WriteByte(s[i])
+				continue
+			}
+			return "", 0, errors.New("dangling escape in quoted string")
+		case '"':
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated quoted string")
+}
+
+// parseHexValue parses `hex:..` (REG_BINARY) and `hex(N):..` data.
+func parseHexValue(data string) (uint32, []byte, error) {
+	valueType := detection.RegBinary
+	rest := data[len("hex"):]
+	if strings.HasPrefix(rest, "(") {
+		end := strings.Index(rest, ")")
+		if end == -1 {
+			return 0, nil, errors.New("unterminated hex type")
+		}
+		n, err := strconv.ParseUint(rest[1:end], 16, 32)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid hex type %q: %w", rest[1:end], err)
+		}
+		valueType = uint32(n)
+		rest = rest[end+1:]
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return 0, nil, errors.New("missing ':' after hex type")
+	}
+	rest = strings.TrimSpace(rest[1:])
+	if rest == "" {
+		return valueType, []byte{}, nil
+	}
+
+	var payload []byte
+	for _, part := range strings.Split(rest, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		b, err := hex.DecodeString(part)
+		if err != nil || len(b) != 1 {
+			return 0, nil, fmt.Errorf("invalid hex byte %q", part)
+		}
+		payload = append(payload, b[0])
+	}
+	return valueType, payload, nil
+}
+
+// ansiToUTF16 widens single-byte string data to UTF-16LE.
+func ansiToUTF16(data []byte) []byte {
+	out := make([]byte, 0, len(data)*2)
+	for _, c := range data {
+		out = append(out, c, 0)
+	}
+	return out
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,234 @@
+package regfile
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+const (
+	forcelistKey = `HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome\ExtensionInstallForcelist`
+	chromeKey    = `HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Google\Chrome`
+)
+
+// fixtureKeys are the keys both fixtures hold, whatever the file version.
+var fixtureKeys = []Key{
+	{Path: forcelistKey, Values: []Value{
+		{Name: "1", Type: detection.RegSZ, Data: detection.EncodeUTF16String("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa;https://clients2.google.com/service/update2/crx")},
+		{Name: `Quoted "name"`, Type: detection.RegSZ, Data: detection.EncodeUTF16String(`C:\Path\with "quotes"`)},
+		{Name: "Expand", Type: detection.RegExpandSZ, Data: detection.EncodeUTF16String(`%SystemRoot%\x`)},
+		{Name: "Multi", Type: detection.RegMultiSZ, Data: detection.EncodeUTF16MultiString([]string{"a", "bc"})},
+		{Name: "Qword", Type: detection.RegQword, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Name: "Dword", Type: detection.RegDword, Data: []byte{0x2a, 0, 0, 0}},
+		{Name: "Binary", Type: detection.RegBinary, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{Name: "", Type: detection.RegSZ, Data: detection.EncodeUTF16String("default")},
+	}},
+	{Path: `HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Old`, Delete: true},
+	{Path: chromeKey, Values: []Value{
+		{Name: "Gone", Delete: true},
+	}},
+}
+
+func TestParseFile(t *testing.T) {
+	v5Keys := append([]Key(nil), fixtureKeys...)
+	v5Keys[2] = Key{Path: chromeKey, Values: []Value{
+		{Name: "Gone", Delete: true},
+		{Name: "Unicode", Type: detection.RegSZ, Data: detection.EncodeUTF16String("Grüße 拡張")},
+	}}
+
+	for _, tt := range []struct {
+		file string
+		want File
+	}{
+		{"regedit4.reg", File{Unicode: false, Keys: fixtureKeys}},
+		{"regedit5.reg", File{Unicode: true, Keys: v5Keys}},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := ParseFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Unicode != tt.want.Unicode {
+				t.Errorf("Unicode = %v, want %v", got.Unicode, tt.want.Unicode)
+			}
+			if len(got.Keys) != len(tt.want.Keys) {
+				t.Fatalf("got %d keys, want %d", len(got.Keys), len(tt.want.Keys))
+			}
+			for i, key := range got.Keys {
+				want := tt.want.Keys[i]
+				if key.Path != want.Path || key.Delete != want.Delete || len(key.Values) != len(want.Values) {
+					t.Errorf("key %d = %s (delete %v, %d values), want %s (delete %v, %d values)",
+						i, key.Path, key.Delete, len(key.Values), want.Path, want.Delete, len(want.Values))
+					continue
+				}
+				for j, value := range key.Values {
+					if !reflect.DeepEqual(value, want.Values[j]) {
+						t.Errorf("%s: value %d = %+v, want %+v", key.Path, j, value, want.Values[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseEncodings(t *testing.T) {
+	text := HeaderV5 + "\r\n\r\n[" + chromeKey + "]\r\n\"Name\"=\"Grüße\"\r\n"
+	want := detection.EncodeUTF16String("Grüße")
+
+	utf16le := []byte{0xFF, 0xFE}
+	for _, c := range text {
+		utf16le = append(utf16le, byte(c), byte(c>>8))
+	}
+	for _, tt := range []struct {
+		name string
+		raw  []byte
+	}{
+		{"UTF-16LE", utf16le},
+		{"UTF-8 with BOM", append([]byte{0xEF, 0xBB, 0xBF}, text...)},
+		{"UTF-8", []byte(text)},
+		{"Latin-1", []byte(strings.NewReplacer("ü", "\xfc", "ß", "\xdf").Replace(text))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(bytes.NewReader(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Keys) != 1 || len(f.Keys[0].Values) != 1 {
+				t.Fatalf("parsed %+v", f.Keys)
+			}
+			if got := f.Keys[0].Values[0].Data; !bytes.Equal(got, want) {
+				t.Errorf("data = % x, want % x", got, want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, text, want string
+	}{
+		{"empty", "", "empty input"},
+		{"no header", "[" + chromeKey + "]\n", "unexpected header"},
+		{"unterminated key", HeaderV5 + "\n[" + chromeKey + "\n", "unterminated key header"},
+		{"empty key", HeaderV5 + "\n[]\n", "empty key path"},
+		{"value outside key", HeaderV5 + "\n\"a\"=\"b\"\n", "outside of a key section"},
+		{"value in deletion", HeaderV5 + "\n[-" + chromeKey + "]\n\"a\"=\"b\"\n", "key deletion section"},
+		{"unterminated name", HeaderV5 + "\n[" + chromeKey + "]\n\"a=b\n", "unterminated quoted string"},
+		{"missing equals", HeaderV5 + "\n[" + chromeKey + "]\n\"a\" \"b\"\n", "missing '='"},
+		{"trailing data", HeaderV5 + "\n[" + chromeKey + "]\n\"a\"=\"b\" c\n", "trailing data"},
+		{"bad dword", HeaderV5 + "\n[" + chromeKey + "]\n\"a\"=dword:xyz\n", "invalid dword"},
+		{"bad hex byte", HeaderV5 + "\n[" + chromeKey + "]\n\"a\"=hex:0g\n", "invalid hex byte"},
+		{"bad hex type", HeaderV5 + "\n[" + chromeKey + "]\n\"a\"=hex(z):00\n", "invalid hex type"},
+		{"unsupported data", HeaderV5 + "\n[" + chromeKey + "]\n\"a\"=qword:1\n", "unsupported data"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteRoundTrip writes the state of each fixture and loads it back.
+func TestWriteRoundTrip(t *testing.T) {
+	const keyPath = `SOFTWARE\Policies`
+	for _, file := range []string{"regedit4.reg", "regedit5.reg"} {
+		t.Run(file, func(t *testing.T) {
+			want, _, err := LoadState(filepath.Join("testdata", file), keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "state.reg")
+			if err := WriteFile(path, FromState(want, keyPath, "")); err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := LoadState(path, keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("state after round trip:\n%+v\nwant:\n%+v", got, want)
+			}
+		})
+	}
+}
+
+// TestRemediationRoundTrip checks that importing a remediation file turns
+// the before tree into the after tree.
+func TestRemediationRoundTrip(t *testing.T) {
+	const keyPath = `SOFTWARE\Policies`
+	_, b, err := LoadState(filepath.Join("testdata", "regedit5.reg"), keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := registry.CaptureState(b, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := keyPath + `\Google\Chrome`
+	if err := registry.DeleteRegistryKeyRecursive(b, base, "ExtensionInstallForcelist"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateKey(base + `\ExtensionInstallBlocklist`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetValue(base+`\ExtensionInstallBlocklist`, "1", detection.RegSZ, detection.EncodeUTF16String("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetValue(base, "Unicode", detection.RegSZ, detection.EncodeUTF16String("changed")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := registry.CaptureState(b, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := Remediation(before, after, keyPath).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, target, err := LoadState(filepath.Join("testdata", "regedit5.reg"), keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Apply(target); err != nil {
+		t.Fatal(err)
+	}
+	got, err := registry.CaptureState(target, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, after) {
+		t.Errorf("state after importing the remediation:\n%+v\nwant:\n%+v", got, after)
+	}
+}
+
+func TestFormatValueWraps(t *testing.T) {
+	data := bytes.Repeat([]byte{0xab}, 100)
+	line := formatValue(Value{Name: "Binary", Type: detection.RegBinary, Data: data})
+	for _, part := range strings.Split(line, "\r\n") {
+		if len(part) > maxLineWidth {
+			t.Errorf("line of %d characters: %q", len(part), part)
+		}
+	}
+
+	f, err := Parse(strings.NewReader(HeaderV5 + "\r\n[" + chromeKey + "]\r\n" + line + "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Keys[0].Values[0].Data; !bytes.Equal(got, data) {
+		t.Errorf("wrapped data parsed as % x", got)
+	}
+}
@@ -0,0 +1,266 @@
+package regfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// ============================================================================
+// .REG WRITER - Exports RegState snapshots and remediation plans
+// ============================================================================
+
+// maxLineWidth matches the wrapping regedit uses for hex data.
+const maxLineWidth = 80
+
+// WriteTo writes f in "Windows Registry Editor Version 5.00" format: UTF-16LE
+// with a byte order mark and CRLF line endings, as regedit itself exports.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	sb.WriteString(HeaderV5 + "\r\n")
+
+	for _, key := range f.Keys {
+		sb.WriteString("\r\n")
+		if key.Delete {
+			sb.WriteString("[-" + key.Path + "]\r\n")
+			continue
+		}
+		sb.WriteString("[" + key.Path + "]\r\n")
+		for _, value := range key.Values {
+			sb.WriteString(formatValue(value))
+			sb.WriteString("\r\n")
+		}
+	}
+	sb.WriteString("\r\n")
+
+	u16 := utf16.Encode([]rune(sb.String()))
+	var buf bytes.Buffer
+	buf.Grow(2 + len(u16)*2)
+	buf.Write([]byte{0xFF, 0xFE})
+	for _, c := range u16 {
+		buf.WriteByte(byte(c))
+		buf.WriteByte(byte(c >> 8))
+	}
+	return buf.WriteTo(w)
+}
+
+// WriteFile writes f to path, replacing any existing file.
+func WriteFile(path string, f *File) error {
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteTo(fh); err != nil {
+		_ = fh.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return fh.Close()
+}
+
+// formatValue renders a single value line, wrapping hex data like regedit.
+func formatValue(v Value) string {
+	name := "@"
+	if v.Name != "" {
+		name = quote(v.Name)
+	}
+	if v.Delete {
+		return name + "=-"
+	}
+
+	switch v.Type {
+	case detection.RegSZ:
+		if s, ok := plainString(v.Data); ok {
+			return name + "=" + quote(s)
+		}
+	case detection.RegDword:
+		if len(v.Data) == 4 {
+			n := uint32(v.Data[0]) | uint32(v.Data[1])<<8 | uint32(v.Data[2])<<16 | uint32(v.Data[3])<<24
+			return fmt.Sprintf("%s=dword:%08x", name, n)
+		}
+	}
+
+	prefix := name + "=hex:"
+	if v.Type != detection.RegBinary {
+		prefix = fmt.Sprintf("%s=hex(%x):", name, v.Type)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	lineLen := len(prefix)
+	for i, c := range v.Data {
+		item := fmt.Sprintf("%02x", c)
+		if i < len(v.Data)-1 {
+			item += ","
+		}
+		if lineLen+len(item) > maxLineWidth-1 && i > 0 {
+			sb.WriteString("\\\r\n  ")
+			lineLen = 2
+		}
+		sb.WriteString(item)
+		lineLen += len(item)
+	}
+	return sb.String()
+}
+
+// plainString reports whether REG_SZ data can be written as a quoted string
+// without losing information (single NUL terminator, no line breaks).
+func plainString(data []byte) (string, bool) {
+	s := detection.DecodeUTF16String(data)
+	if strings.ContainsAny(s, "\r\n") {
+		return "", false
+	}
+	return s, bytes.Equal(detection.EncodeUTF16String(s), data)
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// localMachineKey returns the .reg section name for a path relative to
+// keyPath (both relative to HKLM).
+func localMachineKey(keyPath, relativePath string) string {
+	return pathutils.BuildPath(HiveLocalMachine, keyPath, relativePath)
+}
+
+// valueKeyAndName splits a RegState value path into its key and value name.
+// A default (@) value is captured under the path of its own key, so a value
+// path that is also a subkey path names that key's default value.
+func valueKeyAndName(state *registry.RegState, valuePath string) (string, string) {
+	if state.Subkeys[valuePath] {
+		return valuePath, ""
+	}
+	if parent, ok := pathutils.GetParentPath(valuePath); ok {
+		return parent, pathutils.GetKeyName(valuePath)
+	}
+	return "", valuePath
+}
+
+func inSubtree(path, subtree string) bool {
+	if subtree == "" {
+		return true
+	}
+	return strings.EqualFold(path, subtree) ||
+		(len(path) > len(subtree) && strings.EqualFold(path[:len(subtree)], subtree) && path[len(subtree)] == '\\')
+}
+
+func sortPathsFold(paths []string) {
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.ToLower(paths[i]) < strings.ToLower(paths[j])
+	})
+}
+
+// FromState converts the part of state below subtree ("" for everything)
+// into a .reg file. State paths are relative to keyPath, which is relative
+// to HKLM.
+func FromState(state *registry.RegState, keyPath, subtree string) *File {
+	valuesByKey := make(map[string][]registry.RegValue)
+	keys := []string{}
+	if subtree == "" {
+		keys = append(keys, "")
+	}
+	for subkeyPath := range state.Subkeys {
+		if inSubtree(subkeyPath, subtree) {
+			keys = append(keys, subkeyPath)
+		}
+	}
+	for valuePath, value := range state.Values {
+		keyRel, _ := valueKeyAndName(state, valuePath)
+		if inSubtree(keyRel, subtree) {
+			valuesByKey[strings.ToLower(keyRel)] = append(valuesByKey[strings.ToLower(keyRel)], value)
+		}
+	}
+	sortPathsFold(keys)
+
+	f := &File{Unicode: true}
+	for _, keyRel := range keys {
+		key := Key{Path: localMachineKey(keyPath, keyRel)}
+		values := valuesByKey[strings.ToLower(keyRel)]
+		sort.Slice(values, func(i, j int) bool {
+			return strings.ToLower(values[i].Name) < strings.ToLower(values[j].Name)
+		})
+		for _, value := range values {
+			_, name := valueKeyAndName(state, value.Name)
+			key.Values = append(key.Values, Value{Name: name, Type: value.Type, Data: value.Raw})
+		}
+		f.Keys = append(f.Keys, key)
+	}
+	return f
+}
+
+// Remediation builds a .reg file that turns the registry described by before
+// into the one described by after: removed keys become [-HKEY_...] sections,
+// removed values become "name"=- lines and added or changed values are set.
+// Both states are relative to keyPath.
+func Remediation(before, after *registry.RegState, keyPath string) *File {
+	changes := registry.Diff(before, after)
+
+	removedKeys := make(map[string]bool)
+	for _, change := range changes {
+		if change.Kind == registry.SubkeyRemoved {
+			removedKeys[strings.ToLower(change.Path)] = true
+		}
+	}
+	underRemovedKey := func(path string) bool {
+		for parent, ok := pathutils.GetParentPath(path); ok; parent, ok = pathutils.GetParentPath(parent) {
+			if removedKeys[strings.ToLower(parent)] {
+				return true
+			}
+		}
+		return false
+	}
+
+	f := &File{Unicode: true}
+	valueChanges := make(map[string][]Value)
+	var touched []string
+
+	touch := func(keyRel string) {
+		if _, seen := valueChanges[keyRel]; !seen {
+			valueChanges[keyRel] = nil
+			touched = append(touched, keyRel)
+		}
+	}
+
+	// Diff orders changes by path, so the topmost removed key of a subtree
+	// is always seen before its descendants.
+	for _, change := range changes {
+		switch change.Kind {
+		case registry.SubkeyRemoved:
+			if !underRemovedKey(change.Path) {
+				f.Keys = append(f.Keys, Key{Path: localMachineKey(keyPath, change.Path), Delete: true})
+			}
+		case registry.SubkeyAdded:
+			touch(change.Path)
+		case registry.ValueRemoved:
+			keyRel, name := valueKeyAndName(before, change.Path)
+			if removedKeys[strings.ToLower(keyRel)] || underRemovedKey(keyRel) {
+				continue
+			}
+			touch(keyRel)
+			valueChanges[keyRel] = append(valueChanges[keyRel], Value{Name: name, Delete: true})
+		case registry.ValueAdded, registry.ValueChanged:
+			keyRel, name := valueKeyAndName(after, change.Path)
+			touch(keyRel)
+			valueChanges[keyRel] = append(valueChanges[keyRel], Value{Name: name, Type: change.New.Type, Data: change.New.Raw})
+		}
+	}
+
+	sortPathsFold(touched)
+	for _, keyRel := range touched {
+		values := valueChanges[keyRel]
+		sort.Slice(values, func(i, j int) bool {
+			return strings.ToLower(values[i].Name) < strings.ToLower(values[j].Name)
+		})
+		f.Keys = append(f.Keys, Key{Path: localMachineKey(keyPath, keyRel), Values: values})
+	}
+	return f
+}
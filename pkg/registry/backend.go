@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned (possibly wrapped) by a Backend when the requested
+// key or value does not exist.
+var ErrNotFound = errors.New("registry key or value not found")
+
+// RawValue is a single registry value as stored by a Backend: its name
+// (relative to the containing key), REG_* type and undecoded data bytes.
+type RawValue struct {
+	Name string
+	Type uint32
+	Data []byte
+}
+
+// Backend abstracts the registry operations used by the guard so that the
+// enforcement logic can run against the live Windows registry or against an
+// in-memory tree. All paths are relative to the backend root (HKLM for the
+// live registry), use backslash separators and are matched case-insensitively.
+type Backend interface {
+	// OpenKey checks that the key at path exists and can be opened for
+	// reading. It returns an error wrapping ErrNotFound if it does not exist.
+	OpenKey(path string) error
+
+	// EnumSubkeys returns the names of the direct subkeys of path.
+	EnumSubkeys(path string) ([]string, error)
+
+	// EnumValues returns all values stored directly under path.
+	EnumValues(path string) ([]RawValue, error)
+
+	// CreateKey creates the key at path, including any missing parents.
+	// Creating an existing key is not an error.
+	CreateKey(path string) error
+
+	// SetValue creates or replaces the named value under an existing key.
+	SetValue(path, name string, valueType uint32, data []byte) error
+
+	// DeleteValue removes the named value from path.
+	DeleteValue(path, name string) error
+
+	// DeleteKey removes the key at path. The key must not have subkeys.
+	DeleteKey(path string) error
+
+	// HasWriteAccess reports whether the current process may modify the
+	// backend (on Windows: whether it runs with Administrator privileges).
+	HasWriteAccess() bool
+}
+
+// joinKeyPath joins a base key path and a path relative to it.
+func joinKeyPath(baseKeyPath, relativePath string) string {
+	if baseKeyPath == "" {
+		return relativePath
+	}
+	if relativePath == "" {
+		return baseKeyPath
+	}
+	return baseKeyPath + "\\" + relativePath
+}
+
+// splitKeyPath splits path into its parent key path and final key name.
+// The parent is empty for top-level keys.
+func splitKeyPath(path string) (parent, name string) {
+	lastSlash := strings.LastIndex(path, "\\")
+	if lastSlash == -1 {
+		return "", path
+	}
+	return path[:lastSlash], path[lastSlash+1:]
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package registry
+
+// NewLiveBackend returns the Backend for the live system policy store: the
+// managed JSON policy files of every browser in the default table, mounted
+// at ManagedMountPoint (HKLM\SOFTWARE\Policies on Windows).
+func NewLiveBackend() (Backend, error) {
+	return OpenManagedBackend(DefaultManagedSources())
+}
@@ -0,0 +1,330 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/admin"
+	"github.com/kad/WindowsBrowserGuard/pkg/buffers"
+)
+
+var (
+	advapi32        = syscall.NewLazyDLL("advapi32.dll")
+	regEnumValueW   = advapi32.NewProc("RegEnumValueW")
+	regDeleteKeyW   = advapi32.NewProc("RegDeleteKeyW")
+	regSetValueExW  = advapi32.NewProc("RegSetValueExW")
+	regCreateKeyExW = advapi32.NewProc("RegCreateKeyExW")
+	regDeleteValueW = advapi32.NewProc("RegDeleteValueW")
+)
+
+// WindowsBackend implements Backend on top of the live Windows registry.
+// All paths are relative to HKEY_LOCAL_MACHINE.
+type WindowsBackend struct {
+	root windows.Handle
+}
+
+// NewWindowsBackend returns a Backend operating on HKEY_LOCAL_MACHINE.
+func NewWindowsBackend() *WindowsBackend {
+	return &WindowsBackend{root: windows.HKEY_LOCAL_MACHINE}
+}
+
+// NewLiveBackend returns the Backend for the live system policy store.
+func NewLiveBackend() (Backend, error) {
+	return NewWindowsBackend(), nil
+}
+
+// wrapRegError maps "not found" errors from advapi32 onto ErrNotFound while
+// keeping the original Windows error in the chain.
+func wrapRegError(err error) error {
+	if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+// regCallError converts a raw advapi32 return code into an error.
+func regCallError(op string, ret uintptr) error {
+	if ret == uintptr(windows.ERROR_FILE_NOT_FOUND) {
+		return fmt.Errorf("%s: %w", op, ErrNotFound)
+	}
+	return fmt.Errorf("%s: error code %d", op, ret)
+}
+
+func (b *WindowsBackend) open(path string, access uint32) (windows.Handle, error) {
+	if path == "" {
+		return b.root, nil
+	}
+	keyPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, fmt.Errorf("error converting key path: %w", err)
+	}
+	var hKey windows.Handle
+	if err := windows.RegOpenKeyEx(b.root, keyPtr, 0, access, &hKey); err != nil {
+		return 0, wrapRegError(err)
+	}
+	return hKey, nil
+}
+
+func (b *WindowsBackend) close(hKey windows.Handle) {
+	if hKey != b.root {
+		_ = windows.RegCloseKey(hKey)
+	}
+}
+
+func (b *WindowsBackend) OpenKey(path string) error {
+	hKey, err := b.open(path, windows.KEY_READ)
+	if err != nil {
+		return fmt.Errorf("error opening key: %w", err)
+	}
+	b.close(hKey)
+	return nil
+}
+
+func (b *WindowsBackend) EnumSubkeys(path string) ([]string, error) {
+	hKey, err := b.open(path, windows.KEY_READ)
+	if err != nil {
+		return nil, fmt.Errorf("error opening key: %w", err)
+	}
+	defer b.close(hKey)
+
+	var names []string
+	for index := uint32(0); ; index++ {
+		nameBuf := buffers.GetNameBuffer()
+		nameLen := uint32(len(*nameBuf))
+		err := windows.RegEnumKeyEx(hKey, index, &(*nameBuf)[0], &nameLen, nil, nil, nil, nil)
+		if err == windows.ERROR_NO_MORE_ITEMS {
+			buffers.PutNameBuffer(nameBuf)
+			break
+		}
+		if err != nil {
+			buffers.PutNameBuffer(nameBuf)
+			return nil, fmt.Errorf("error enumerating subkeys: %v", err)
+		}
+		names = append(names, syscall.UTF16ToString((*nameBuf)[:nameLen]))
+		buffers.PutNameBuffer(nameBuf)
+	}
+	return names, nil
+}
+
+func (b *WindowsBackend) EnumValues(path string) ([]RawValue, error) {
+	hKey, err := b.open(path, windows.KEY_READ)
+	if err != nil {
+		return nil, fmt.Errorf("error opening key: %w", err)
+	}
+	defer b.close(hKey)
+
+	var values []RawValue
+	for index := uint32(0); ; index++ {
+		value, done, err := enumRegValue(hKey, index)
+		if done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading value at index %d under %q: %w", index, path, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// enumRegValue reads a single indexed value from hKey via RegEnumValueW,
+// returning its name, type, and raw data. If the value data does not fit in
+// the standard 16KB buffer (ERROR_MORE_DATA), it retries with a 64KB buffer
+// and then with one of the exact reported size instead of aborting the
+// caller's entire enumeration/capture.
+// done reports ERROR_NO_MORE_ITEMS (enumeration exhausted).
+func enumRegValue(hKey windows.Handle, index uint32) (value RawValue, done bool, err error) {
+	nameBuf := buffers.GetLargeNameBuffer()
+	defer buffers.PutLargeNameBuffer(nameBuf)
+
+	dataBuf := buffers.GetDataBuffer()
+	defer buffers.PutDataBuffer(dataBuf)
+
+	nameLen := uint32(len(*nameBuf))
+	dataLen := uint32(len(*dataBuf))
+
+	ret, _, _ := regEnumValueW.Call(
+		uintptr(hKey),
+		uintptr(index),
+		uintptr(unsafe.Pointer(&(*nameBuf)[0])),
+		uintptr(unsafe.Pointer(&nameLen)),
+		0,
+		uintptr(unsafe.Pointer(&value.Type)),
+		uintptr(unsafe.Pointer(&(*dataBuf)[0])),
+		uintptr(unsafe.Pointer(&dataLen)),
+	)
+
+	switch {
+	case ret == uintptr(windows.ERROR_NO_MORE_ITEMS):
+		done = true
+		return
+	case ret == uintptr(windows.ERROR_MORE_DATA):
+		largeDataBuf := buffers.GetLargeDataBuffer()
+		defer buffers.PutLargeDataBuffer(largeDataBuf)
+
+		nameLen = uint32(len(*nameBuf))
+		largeDataLen := uint32(len(*largeDataBuf))
+
+		ret, _, _ = regEnumValueW.Call(
+			uintptr(hKey),
+			uintptr(index),
+			uintptr(unsafe.Pointer(&(*nameBuf)[0])),
+			uintptr(unsafe.Pointer(&nameLen)),
+			0,
+			uintptr(unsafe.Pointer(&value.Type)),
+			uintptr(unsafe.Pointer(&(*largeDataBuf)[0])),
+			uintptr(unsafe.Pointer(&largeDataLen)),
+		)
+		if ret == uintptr(windows.ERROR_MORE_DATA) {
+			// Larger still (e.g. a JSON policy in a big REG_SZ): largeDataLen
+			// now holds the exact size, so read into a dedicated buffer.
+			data := make([]byte, largeDataLen)
+			nameLen = uint32(len(*nameBuf))
+			ret, _, _ = regEnumValueW.Call(
+				uintptr(hKey),
+				uintptr(index),
+				uintptr(unsafe.Pointer(&(*nameBuf)[0])),
+				uintptr(unsafe.Pointer(&nameLen)),
+				0,
+				uintptr(unsafe.Pointer(&value.Type)),
+				uintptr(unsafe.Pointer(&data[0])),
+				uintptr(unsafe.Pointer(&largeDataLen)),
+			)
+			if ret != 0 {
+				err = fmt.Errorf("error enumerating values (retry with %d byte buffer): error code %d", len(data), ret)
+				return
+			}
+			value.Name = syscall.UTF16ToString((*nameBuf)[:nameLen])
+			value.Data = data[:largeDataLen]
+			return
+		}
+		if ret != 0 {
+			err = fmt.Errorf("error enumerating values (retry with 64KB buffer): error code %d", ret)
+			return
+		}
+		value.Name = syscall.UTF16ToString((*nameBuf)[:nameLen])
+		value.Data = append([]byte(nil), (*largeDataBuf)[:largeDataLen]...)
+		return
+	case ret != 0:
+		err = fmt.Errorf("error enumerating values: error code %d", ret)
+		return
+	}
+
+	value.Name = syscall.UTF16ToString((*nameBuf)[:nameLen])
+	value.Data = append([]byte(nil), (*dataBuf)[:dataLen]...)
+	return
+}
+
+func (b *WindowsBackend) CreateKey(path string) error {
+	keyPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return fmt.Errorf("error converting key path: %v", err)
+	}
+
+	var hKey windows.Handle
+	var disposition uint32
+
+	ret, _, _ := regCreateKeyExW.Call(
+		uintptr(b.root),
+		uintptr(unsafe.Pointer(keyPtr)),
+		0,
+		0,
+		0,
+		uintptr(windows.KEY_READ|windows.KEY_WRITE),
+		0,
+		uintptr(unsafe.Pointer(&hKey)),
+		uintptr(unsafe.Pointer(&disposition)),
+	)
+	if ret != 0 {
+		return regCallError("error creating/opening key", ret)
+	}
+	_ = windows.RegCloseKey(hKey)
+	return nil
+}
+
+func (b *WindowsBackend) SetValue(path, name string, valueType uint32, data []byte) error {
+	hKey, err := b.open(path, windows.KEY_READ|windows.KEY_WRITE)
+	if err != nil {
+		return fmt.Errorf("error opening key: %w", err)
+	}
+	defer b.close(hKey)
+
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("error converting value name %s: %w", name, err)
+	}
+
+	var dataPtr uintptr
+	if len(data) > 0 {
+		dataPtr = uintptr(unsafe.Pointer(&data[0]))
+	}
+
+	ret, _, _ := regSetValueExW.Call(
+		uintptr(hKey),
+		uintptr(unsafe.Pointer(namePtr)),
+		0,
+		uintptr(valueType),
+		dataPtr,
+		uintptr(len(data)),
+	)
+	if ret != 0 {
+		return regCallError("error setting value "+name, ret)
+	}
+	return nil
+}
+
+func (b *WindowsBackend) DeleteValue(path, name string) error {
+	hKey, err := b.open(path, windows.KEY_READ|windows.KEY_WRITE)
+	if err != nil {
+		return fmt.Errorf("error opening key: %w", err)
+	}
+	defer b.close(hKey)
+
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("error converting value name %s: %w", name, err)
+	}
+
+	ret, _, _ := regDeleteValueW.Call(
+		uintptr(hKey),
+		uintptr(unsafe.Pointer(namePtr)),
+	)
+	if ret != 0 {
+		return regCallError("error deleting value "+name, ret)
+	}
+	return nil
+}
+
+func (b *WindowsBackend) DeleteKey(path string) error {
+	parentPath, keyName := splitKeyPath(path)
+	if keyName == "" {
+		return fmt.Errorf("cannot delete the root key")
+	}
+
+	hParentKey, err := b.open(parentPath, windows.DELETE)
+	if err != nil {
+		return fmt.Errorf("error opening parent key: %w", err)
+	}
+	defer b.close(hParentKey)
+
+	keyPtr, err := syscall.UTF16PtrFromString(keyName)
+	if err != nil {
+		return fmt.Errorf("error converting key path: %v", err)
+	}
+
+	ret, _, _ := regDeleteKeyW.Call(uintptr(hParentKey), uintptr(unsafe.Pointer(keyPtr)))
+	if ret != 0 {
+		return regCallError("error deleting key", ret)
+	}
+	return nil
+}
+
+// HasWriteAccess reports whether the process runs with Administrator
+// privileges, which HKLM\SOFTWARE\Policies requires for any modification.
+func (b *WindowsBackend) HasWriteAccess() bool {
+	return admin.IsAdmin()
+}
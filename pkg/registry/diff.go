@@ -0,0 +1,97 @@
+package registry
+
+import (
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// STATE DIFF - Structured comparison of two RegState captures
+// ============================================================================
+
+// ChangeKind identifies what a Change did to the registry.
+type ChangeKind int
+
+const (
+	SubkeyAdded ChangeKind = iota
+	SubkeyRemoved
+	ValueAdded
+	ValueRemoved
+	ValueChanged
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case SubkeyAdded:
+		return "SUBKEY ADDED"
+	case SubkeyRemoved:
+		return "SUBKEY REMOVED"
+	case ValueAdded:
+		return "VALUE ADDED"
+	case ValueRemoved:
+		return "VALUE REMOVED"
+	case ValueChanged:
+		return "VALUE CHANGED"
+	}
+	return "UNKNOWN"
+}
+
+// Change is a single difference between two states. Path is relative to the
+// captured key. Old is set for ValueRemoved and ValueChanged, New for
+// ValueAdded and ValueChanged.
+type Change struct {
+	Kind ChangeKind
+	Path string
+	Old  RegValue
+	New  RegValue
+}
+
+// IsSubkey reports whether c describes a key rather than a value.
+func (c Change) IsSubkey() bool {
+	return c.Kind == SubkeyAdded || c.Kind == SubkeyRemoved
+}
+
+// Diff returns the changes that turn oldState into newState, ordered by path
+// (case-insensitively) and then by kind, so equal inputs always produce the
+// same list. Values are compared with RegValue.Equal.
+func Diff(oldState, newState *RegState) []Change {
+	var changes []Change
+
+	for path := range newState.Subkeys {
+		if !oldState.Subkeys[path] {
+			changes = append(changes, Change{Kind: SubkeyAdded, Path: path})
+		}
+	}
+	for path := range oldState.Subkeys {
+		if !newState.Subkeys[path] {
+			changes = append(changes, Change{Kind: SubkeyRemoved, Path: path})
+		}
+	}
+
+	for path, newVal := range newState.Values {
+		oldVal, exists := oldState.Values[path]
+		switch {
+		case !exists:
+			changes = append(changes, Change{Kind: ValueAdded, Path: path, New: newVal})
+		case !oldVal.Equal(newVal):
+			changes = append(changes, Change{Kind: ValueChanged, Path: path, Old: oldVal, New: newVal})
+		}
+	}
+	for path, oldVal := range oldState.Values {
+		if _, exists := newState.Values[path]; !exists {
+			changes = append(changes, Change{Kind: ValueRemoved, Path: path, Old: oldVal})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if la, lb := strings.ToLower(a.Path), strings.ToLower(b.Path); la != lb {
+			return la < lb
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Path < b.Path
+	})
+	return changes
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+)
+
+// sz returns a REG_SZ value holding s.
+func sz(name, s string) RegValue {
+	return NewRegValue(name, detection.RegSZ, detection.EncodeUTF16String(s))
+}
+
+// stateOf returns a state holding keys and values, keyed by their paths.
+func stateOf(keys []string, values map[string]RegValue) *RegState {
+	state := NewRegState()
+	for _, key := range keys {
+		state.Subkeys[key] = true
+	}
+	for path, value := range values {
+		state.Values[path] = value
+	}
+	return state
+}
+
+func TestDiff(t *testing.T) {
+	const forcelist = `Google\Chrome\ExtensionInstallForcelist`
+	const entry = forcelist + `\1`
+	old := sz("1", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	changed := sz("1", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	// The same string with an extra trailing NUL, as some tools write it.
+	padded := NewRegValue("1", detection.RegSZ, append(detection.EncodeUTF16String("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), 0, 0))
+	dword := NewRegValue("1", detection.RegDword, []byte{1, 0, 0, 0})
+
+	tests := []struct {
+		name               string
+		oldState, newState *RegState
+		want               []Change
+	}{
+		{
+			name:     "unchanged",
+			oldState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+			newState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+		},
+		{
+			name:     "added",
+			oldState: stateOf(nil, nil),
+			newState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+			want: []Change{
+				{Kind: SubkeyAdded, Path: forcelist},
+				{Kind: ValueAdded, Path: entry, New: old},
+			},
+		},
+		{
+			name:     "removed",
+			oldState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+			newState: stateOf(nil, nil),
+			want: []Change{
+				{Kind: SubkeyRemoved, Path: forcelist},
+				{Kind: ValueRemoved, Path: entry, Old: old},
+			},
+		},
+		{
+			name:     "modified",
+			oldState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+			newState: stateOf([]string{forcelist}, map[string]RegValue{entry: changed}),
+			want:     []Change{{Kind: ValueChanged, Path: entry, Old: old, New: changed}},
+		},
+		{
+			name:     "type changed",
+			oldState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+			newState: stateOf([]string{forcelist}, map[string]RegValue{entry: dword}),
+			want:     []Change{{Kind: ValueChanged, Path: entry, Old: old, New: dword}},
+		},
+		{
+			name:     "NUL padding only",
+			oldState: stateOf([]string{forcelist}, map[string]RegValue{entry: old}),
+			newState: stateOf([]string{forcelist}, map[string]RegValue{entry: padded}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.oldState, tt.newState); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffOrder(t *testing.T) {
+	oldState := stateOf([]string{`b`, `A\c`}, map[string]RegValue{
+		`A\c\1`: sz("1", "x"),
+		`B\2`:   sz("2", "x"),
+	})
+	newState := stateOf([]string{`a`, `B\d`}, map[string]RegValue{
+		`a\1`:   sz("1", "x"),
+		`b\2`:   sz("2", "y"),
+		`A\c\1`: sz("1", "y"),
+	})
+
+	changes := Diff(oldState, newState)
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.Kind.String()+" "+change.Path)
+	}
+	// Paths sort case-insensitively, then by kind; paths differing only in
+	// case sort by their bytes.
+	want := []string{
+		`SUBKEY ADDED a`,
+		`VALUE ADDED a\1`,
+		`SUBKEY REMOVED A\c`,
+		`VALUE CHANGED A\c\1`,
+		`SUBKEY REMOVED b`,
+		`VALUE ADDED b\2`,
+		`VALUE REMOVED B\2`,
+		`SUBKEY ADDED B\d`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() order\n%q\nwant\n%q", got, want)
+	}
+
+	// Map iteration order must not leak into the result.
+	for i := range 10 {
+		if again := Diff(oldState, newState); !reflect.DeepEqual(again, changes) {
+			t.Fatalf("run %d: Diff() is not deterministic", i)
+		}
+	}
+}
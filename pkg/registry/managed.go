@@ -0,0 +1,726 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/atomicfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+)
+
+// ============================================================================
+// MANAGED POLICY FILES - Linux JSON browser policies as a registry tree
+// ============================================================================
+
+// ManagedMountPoint is where a ManagedBackend mounts the browser policy
+// roots, matching HKLM\SOFTWARE\Policies on Windows.
+const ManagedMountPoint = `SOFTWARE\Policies`
+
+// GuardPolicyFile is the name of the managed policy file the guard writes
+// new Chromium policies and its blocklist additions to. Chromium lets the
+// lexicographically last file win when files set the same policy, so the
+// name sorts after ordinary policy files.
+const GuardPolicyFile = "zz-browserguard.json"
+
+// geckoPoliciesKey wraps the policies in a Gecko policies.json.
+const geckoPoliciesKey = "policies"
+
+// ManagedSource is where one browser reads its JSON policies.
+type ManagedSource struct {
+	Browser browsers.Descriptor
+	// Path is the managed policy directory of a Chromium browser, whose
+	// *.json files are merged, or the policies.json file of a Gecko browser.
+	Path string
+}
+
+// DefaultManagedSources returns the sources of every browser in the default
+// table that has a ManagedPolicyPath.
+func DefaultManagedSources() []ManagedSource {
+	var sources []ManagedSource
+	for _, d := range browsers.All() {
+		if d.ManagedPolicyPath != "" {
+			sources = append(sources, ManagedSource{Browser: d, Path: d.ManagedPolicyPath})
+		}
+	}
+	return sources
+}
+
+// gecko reports whether s holds a Gecko policies.json.
+func (s ManagedSource) gecko() bool {
+	return s.Browser.Family == browsers.Gecko
+}
+
+// root returns the tree path of the browser's policy root.
+func (s ManagedSource) root() string {
+	return joinKeyPath(ManagedMountPoint, s.Browser.PolicyRoot)
+}
+
+// files returns the policy files of s in the order the browser reads them.
+func (s ManagedSource) files() ([]string, error) {
+	if s.gecko() {
+		return []string{s.Path}, nil
+	}
+	return filepath.Glob(filepath.Join(s.Path, "*.json"))
+}
+
+// ownFile returns the file the guard writes policies of s to when no other
+// file defines them.
+func (s ManagedSource) ownFile() string {
+	if s.gecko() {
+		return s.Path
+	}
+	return filepath.Join(s.Path, GuardPolicyFile)
+}
+
+// jsonKind records the JSON type a tree key or value was loaded from, so it
+// is written back as the same type.
+type jsonKind uint8
+
+const (
+	kindDefault jsonKind = iota
+	kindBool             // REG_DWORD holding true or false
+	kindJSON             // REG_SZ holding raw JSON (objects, non-string lists)
+	kindArray            // key whose values or subkeys are named "1".."n"
+	kindObject           // key whose values or subkeys are the members
+)
+
+// managedFile is one JSON policy file. Policies are kept as raw JSON so the
+// ones the guard never touches are written back unchanged.
+type managedFile struct {
+	path     string
+	mode     os.FileMode
+	gecko    bool
+	doc      map[string]json.RawMessage
+	policies map[string]json.RawMessage
+}
+
+// loadManagedFile reads the policy file at path. A missing file is reported
+// with an error satisfying errors.Is(err, os.ErrNotExist).
+func loadManagedFile(path string, gecko bool) (*managedFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := newManagedFile(path, gecko)
+	if info, err := os.Stat(path); err == nil {
+		f.mode = info.Mode().Perm()
+	}
+	if err := json.Unmarshal(data, &f.doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if f.doc == nil {
+		f.doc = make(map[string]json.RawMessage)
+	}
+	if !gecko {
+		f.policies = f.doc
+		return f, nil
+	}
+	if raw, ok := f.doc[geckoPoliciesKey]; ok {
+		if err := json.Unmarshal(raw, &f.policies); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q: %w", path, geckoPoliciesKey, err)
+		}
+	}
+	if f.policies == nil {
+		f.policies = make(map[string]json.RawMessage)
+	}
+	return f, nil
+}
+
+// newManagedFile returns an empty policy file to be created at path.
+func newManagedFile(path string, gecko bool) *managedFile {
+	f := &managedFile{path: path, mode: 0o644, gecko: gecko, doc: make(map[string]json.RawMessage)}
+	f.policies = f.doc
+	if gecko {
+		f.policies = make(map[string]json.RawMessage)
+	}
+	return f
+}
+
+// set stores policy name, keeping the spelling of an existing entry.
+func (f *managedFile) set(name string, raw json.RawMessage) {
+	for existing := range f.policies {
+		if strings.EqualFold(existing, name) {
+			name = existing
+			break
+		}
+	}
+	f.policies[name] = raw
+}
+
+// remove deletes policy name in any spelling.
+func (f *managedFile) remove(name string) {
+	for existing := range f.policies {
+		if strings.EqualFold(existing, name) {
+			delete(f.policies, existing)
+		}
+	}
+}
+
+// save writes f atomically, like policyfile.Save, creating its directory
+// when the guard writes the first policy file of a browser.
+func (f *managedFile) save() error {
+	if f.gecko {
+		policies, err := json.Marshal(f.policies)
+		if err != nil {
+			return fmt.Errorf("encoding policies: %w", err)
+		}
+		f.doc[geckoPoliciesKey] = policies
+	}
+	data, err := json.MarshalIndent(f.doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", f.path, err)
+	}
+	data = append(data, '\n')
+
+	if err := atomicfile.WriteFile(f.path, data, f.mode); err != nil {
+		return fmt.Errorf("saving policy file: %w", err)
+	}
+	return nil
+}
+
+// ManagedBackend implements Backend over the JSON policy files browsers read
+// on Linux, so the detectors and remediation passes run on them unchanged.
+// Each browser's policies are mounted below ManagedMountPoint at its policy
+// root, using the layout of the Windows registry:
+//
+//   - strings, integers and booleans become REG_SZ and REG_DWORD values;
+//   - string lists become keys with values named "1".."n", like
+//     ExtensionInstallForcelist;
+//   - other Chromium objects and lists become REG_SZ values holding JSON,
+//     like the ExtensionSettings policy;
+//   - Gecko objects and lists become nested keys, as Firefox reads them from
+//     the registry.
+//
+// Every write is applied to the tree and then to the file defining the
+// policy, converting the policy back to JSON. A policy removed from the tree
+// is removed from every file that sets it. Chromium blocklists and policies
+// no file sets yet are written to GuardPolicyFile; Gecko policies always go
+// to the browser's policies.json.
+type ManagedBackend struct {
+	sources []ManagedSource
+
+	mu   sync.Mutex
+	tree *MemoryBackend
+	// files holds the loaded files by path.
+	files map[string]*managedFile
+	// defs lists the files setting each policy, by lower-case tree path, in
+	// the order they were read; the last one is in effect.
+	defs map[string][]*managedFile
+	// kinds holds the JSON types loaded, by lower-case tree path.
+	kinds map[string]jsonKind
+	// warnings holds the problems found by the last load.
+	warnings []string
+}
+
+// OpenManagedBackend reads the policy files of sources. Missing files and
+// directories are empty; files that cannot be parsed are skipped, as
+// browsers ignore them too, and reported by Warnings.
+func OpenManagedBackend(sources []ManagedSource) (*ManagedBackend, error) {
+	b := &ManagedBackend{sources: sources}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Sources returns the policy locations b reads.
+func (b *ManagedBackend) Sources() []ManagedSource {
+	return slices.Clone(b.sources)
+}
+
+// Warnings returns the files and policies the last load skipped, and
+// clears them so that each problem is reported once per load.
+func (b *ManagedBackend) Warnings() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	warnings := b.warnings
+	b.warnings = nil
+	return warnings
+}
+
+// warn records a problem found while loading.
+func (b *ManagedBackend) warn(format string, args ...any) {
+	b.warnings = append(b.warnings, fmt.Sprintf(format, args...))
+}
+
+// Reload re-reads every policy file, replacing the tree, after the files
+// were changed by something other than b.
+func (b *ManagedBackend) Reload() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.load()
+}
+
+// load rebuilds the tree from the policy files. Callers must hold b.mu or
+// own b exclusively.
+func (b *ManagedBackend) load() error {
+	b.tree = NewMemoryBackend()
+	b.files = make(map[string]*managedFile)
+	b.defs = make(map[string][]*managedFile)
+	b.kinds = make(map[string]jsonKind)
+	b.warnings = nil
+	if err := b.tree.CreateKey(ManagedMountPoint); err != nil {
+		return err
+	}
+
+	for _, src := range b.sources {
+		paths, err := src.files()
+		if err != nil {
+			return fmt.Errorf("listing %s: %w", src.Path, err)
+		}
+		for _, path := range paths {
+			f, err := loadManagedFile(path, src.gecko())
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				b.warn("skipping policy file %s: %v", path, err)
+				continue
+			}
+			b.files[path] = f
+			if err := b.mount(src, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mount adds the policies of f to the tree. A policy already set by an
+// earlier file of the same browser is replaced.
+func (b *ManagedBackend) mount(src ManagedSource, f *managedFile) error {
+	root := src.root()
+	if err := b.tree.CreateKey(root); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(f.policies))
+	for name := range f.policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "" || strings.Contains(name, `\`) {
+			b.warn("skipping policy %q in %s: invalid name", name, f.path)
+			continue
+		}
+		key := strings.ToLower(joinKeyPath(root, name))
+		if len(b.defs[key]) > 0 {
+			if err := b.unmount(root, name); err != nil {
+				return err
+			}
+		}
+		v, err := decodeJSON(f.policies[name])
+		if err != nil {
+			b.warn("skipping policy %s in %s: %v", name, f.path, err)
+			continue
+		}
+		if err := b.put(root, name, v, src.gecko()); err != nil {
+			return fmt.Errorf("%s: policy %s: %w", f.path, name, err)
+		}
+		b.defs[key] = append(b.defs[key], f)
+	}
+	return nil
+}
+
+// unmount removes policy name from the tree.
+func (b *ManagedBackend) unmount(root, name string) error {
+	policyPath := joinKeyPath(root, name)
+	if b.tree.OpenKey(policyPath) == nil {
+		if err := DeleteRegistryKeyRecursive(b.tree, root, name); err != nil {
+			return err
+		}
+	} else if err := b.tree.DeleteValue(root, name); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	b.forgetKinds(policyPath)
+	return nil
+}
+
+// forgetKinds drops the JSON types recorded at or below path.
+func (b *ManagedBackend) forgetKinds(path string) {
+	for key := range b.kinds {
+		if _, ok := pathutils.TrimPathPrefix(key, path); ok {
+			delete(b.kinds, key)
+		}
+	}
+}
+
+// decodeJSON decodes raw keeping numbers as json.Number.
+func decodeJSON(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// dword encodes n as REG_DWORD data.
+func dword(n uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, n)
+}
+
+// put stores the JSON value v as name below the key at parent.
+func (b *ManagedBackend) put(parent, name string, v any, gecko bool) error {
+	path := joinKeyPath(parent, name)
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		b.kinds[strings.ToLower(path)] = kindBool
+		n := uint32(0)
+		if v {
+			n = 1
+		}
+		return b.tree.SetValue(parent, name, detection.RegDword, dword(n))
+	case string:
+		return b.tree.SetValue(parent, name, detection.RegSZ, detection.EncodeUTF16String(v))
+	case json.Number:
+		if n, err := strconv.ParseUint(v.String(), 10, 32); err == nil {
+			return b.tree.SetValue(parent, name, detection.RegDword, dword(uint32(n)))
+		}
+		b.kinds[strings.ToLower(path)] = kindJSON
+		return b.tree.SetValue(parent, name, detection.RegSZ, detection.EncodeUTF16String(v.String()))
+	case []any:
+		if !gecko && slices.ContainsFunc(v, func(elem any) bool { _, ok := elem.(string); return !ok }) {
+			return b.putJSON(parent, name, v)
+		}
+		if err := b.tree.CreateKey(path); err != nil {
+			return err
+		}
+		b.kinds[strings.ToLower(path)] = kindArray
+		for i, elem := range v {
+			if err := b.put(path, strconv.Itoa(i+1), elem, gecko); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		if !gecko {
+			return b.putJSON(parent, name, v)
+		}
+		if err := b.tree.CreateKey(path); err != nil {
+			return err
+		}
+		b.kinds[strings.ToLower(path)] = kindObject
+		members := make([]string, 0, len(v))
+		for member := range v {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		for _, member := range members {
+			if member == "" || strings.Contains(member, `\`) {
+				return fmt.Errorf("invalid member name %q", member)
+			}
+			if err := b.put(path, member, v[member], gecko); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported JSON value %T", v)
+}
+
+// putJSON stores v as a REG_SZ value holding its JSON encoding.
+func (b *ManagedBackend) putJSON(parent, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b.kinds[strings.ToLower(joinKeyPath(parent, name))] = kindJSON
+	return b.tree.SetValue(parent, name, detection.RegSZ, detection.EncodeUTF16String(string(data)))
+}
+
+// encodeValue returns the JSON form of the tree value at path.
+func (b *ManagedBackend) encodeValue(path string, raw RawValue) (any, bool) {
+	v := NewRegValue(raw.Name, raw.Type, raw.Data)
+	kind := b.kinds[strings.ToLower(path)]
+	switch {
+	case v.IsString():
+		s := v.Unexpanded()
+		if kind == kindJSON && json.Valid([]byte(s)) {
+			return json.RawMessage(s), true
+		}
+		return s, true
+	case v.Type == detection.RegMultiSZ:
+		return v.Strings(), true
+	}
+	if n, ok := v.Uint64(); ok {
+		if kind == kindBool {
+			return n != 0, true
+		}
+		return n, true
+	}
+	return nil, false
+}
+
+// encodeKey returns the JSON form of the tree key at path: a list if it was
+// loaded from one or all its entries are numbered, otherwise an object. An
+// empty key is kept as an empty list or, for Gecko, an empty object, so the
+// tree reloads unchanged.
+func (b *ManagedBackend) encodeKey(path string, gecko bool) (any, error) {
+	values, err := b.tree.EnumValues(path)
+	if err != nil {
+		return nil, err
+	}
+	subkeys, err := b.tree.EnumSubkeys(path)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]any, len(values)+len(subkeys))
+	for _, raw := range values {
+		if v, ok := b.encodeValue(joinKeyPath(path, raw.Name), raw); ok {
+			members[raw.Name] = v
+		}
+	}
+	for _, name := range subkeys {
+		v, err := b.encodeKey(joinKeyPath(path, name), gecko)
+		if err != nil {
+			return nil, err
+		}
+		members[name] = v
+	}
+
+	kind := b.kinds[strings.ToLower(path)]
+	if len(members) == 0 {
+		if kind == kindObject || (kind == kindDefault && gecko) {
+			return members, nil
+		}
+		return []any{}, nil
+	}
+	indexes := make(map[string]int, len(members))
+	for name := range members {
+		if n, err := strconv.Atoi(name); err == nil && n > 0 {
+			indexes[name] = n
+		}
+	}
+	if kind == kindObject || (kind != kindArray && len(indexes) != len(members)) {
+		return members, nil
+	}
+	// A list keeps the order of its indexes; entries that are not numbered
+	// follow in name order.
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ni, iok := indexes[names[i]]
+		nj, jok := indexes[names[j]]
+		if iok != jok {
+			return iok
+		}
+		if iok && ni != nj {
+			return ni < nj
+		}
+		return names[i] < names[j]
+	})
+	list := make([]any, len(names))
+	for i, name := range names {
+		list[i] = members[name]
+	}
+	return list, nil
+}
+
+// locate returns the source and the policy name affected by a change to the
+// key at path or, when name is not empty, to its value name. policy is
+// empty for the mount point, a policy root and their ancestors, which exist
+// in the tree only. Paths outside every source cannot be written.
+func (b *ManagedBackend) locate(path, name string) (src ManagedSource, policy string, err error) {
+	for _, s := range b.sources {
+		rest, ok := pathutils.TrimPathPrefix(path, s.root())
+		if !ok {
+			continue
+		}
+		if rest == "" {
+			// The values of a policy root are policies themselves.
+			return s, name, nil
+		}
+		return s, pathutils.SplitPath(rest)[0], nil
+	}
+	for _, s := range b.sources {
+		if _, ok := pathutils.TrimPathPrefix(s.root(), path); ok {
+			return ManagedSource{}, "", nil
+		}
+	}
+	return ManagedSource{}, "", fmt.Errorf("%s: no managed policy file for this path", path)
+}
+
+// flush writes policy name of src from the tree to the policy files.
+func (b *ManagedBackend) flush(src ManagedSource, name string) error {
+	root := src.root()
+	policyPath := joinKeyPath(root, name)
+	var (
+		value   any
+		present bool
+	)
+	if b.tree.OpenKey(policyPath) == nil {
+		var err error
+		if value, err = b.encodeKey(policyPath, src.gecko()); err != nil {
+			return err
+		}
+		present = true
+	} else if values, err := b.tree.EnumValues(root); err == nil {
+		for _, raw := range values {
+			if strings.EqualFold(raw.Name, name) {
+				value, present = b.encodeValue(policyPath, raw)
+				break
+			}
+		}
+	}
+
+	key := strings.ToLower(policyPath)
+	defs := b.defs[key]
+	if !present {
+		var errs []error
+		for _, f := range defs {
+			f.remove(name)
+			if err := f.save(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(b.defs, key)
+		b.forgetKinds(policyPath)
+		return errors.Join(errs...)
+	}
+
+	target := b.files[src.ownFile()]
+	if n := len(defs); n > 0 && (src.gecko() || !strings.EqualFold(name, browsers.ExtensionInstallBlocklist)) {
+		target = defs[n-1]
+	}
+	if target == nil {
+		target = newManagedFile(src.ownFile(), src.gecko())
+		b.files[target.path] = target
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding policy %s: %w", name, err)
+	}
+	target.set(name, data)
+	if err := target.save(); err != nil {
+		return err
+	}
+	if !slices.Contains(defs, target) {
+		b.defs[key] = append(defs, target)
+	}
+	return nil
+}
+
+// flushAll writes every policy of the sources whose root lies at or below
+// path, after a key above the policies was deleted.
+func (b *ManagedBackend) flushAll(path string) error {
+	var errs []error
+	for _, src := range b.sources {
+		if _, ok := pathutils.TrimPathPrefix(src.root(), path); !ok {
+			continue
+		}
+		prefix := strings.ToLower(src.root()) + `\`
+		for key := range b.defs {
+			if name, ok := strings.CutPrefix(key, prefix); ok {
+				errs = append(errs, b.flush(src, name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *ManagedBackend) OpenKey(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tree.OpenKey(path)
+}
+
+func (b *ManagedBackend) EnumSubkeys(path string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tree.EnumSubkeys(path)
+}
+
+func (b *ManagedBackend) EnumValues(path string) ([]RawValue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tree.EnumValues(path)
+}
+
+func (b *ManagedBackend) CreateKey(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src, policy, err := b.locate(path, "")
+	if err != nil {
+		return err
+	}
+	if err := b.tree.CreateKey(path); err != nil {
+		return err
+	}
+	if policy == "" {
+		return nil
+	}
+	return b.flush(src, policy)
+}
+
+func (b *ManagedBackend) SetValue(path, name string, valueType uint32, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src, policy, err := b.locate(path, name)
+	if err != nil {
+		return err
+	}
+	if policy == "" {
+		return fmt.Errorf("%s: values above the policy roots cannot be stored in a policy file", path)
+	}
+	if err := b.tree.SetValue(path, name, valueType, data); err != nil {
+		return err
+	}
+	return b.flush(src, policy)
+}
+
+func (b *ManagedBackend) DeleteValue(path, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src, policy, err := b.locate(path, name)
+	if err != nil {
+		return err
+	}
+	if err := b.tree.DeleteValue(path, name); err != nil {
+		return err
+	}
+	if policy == "" {
+		return nil
+	}
+	return b.flush(src, policy)
+}
+
+func (b *ManagedBackend) DeleteKey(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src, policy, err := b.locate(path, "")
+	if err != nil {
+		return err
+	}
+	if err := b.tree.DeleteKey(path); err != nil {
+		return err
+	}
+	if policy == "" {
+		return b.flushAll(path)
+	}
+	return b.flush(src, policy)
+}
+
+// HasWriteAccess reports whether the process runs as root, which owns the
+// managed policy locations.
+func (b *ManagedBackend) HasWriteAccess() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/browsers"
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+)
+
+const (
+	chromeRoot  = ManagedMountPoint + `\Google\Chrome`
+	firefoxRoot = ManagedMountPoint + `\Mozilla\Firefox`
+)
+
+// managedFixture holds the policy files of a Chrome managed directory and a
+// Firefox policies.json. Both Chrome files set HomepageLocation; the later
+// one wins.
+var managedFixture = map[string]string{
+	"chrome/a.json": `{
+  "ExtensionInstallForcelist": ["aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa;https://example.com/update.xml", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"],
+  "ExtensionSettings": {"cccccccccccccccccccccccccccccccc": {"installation_mode": "blocked"}},
+  "HomepageLocation": "https://a.example",
+  "BrowserSignin": 0,
+  "MetricsReportingEnabled": false,
+  "Large": 5000000000,
+  "Mixed": [1, "a"]
+}
+`,
+	"chrome/b.json": `{"HomepageLocation": "https://b.example"}
+`,
+	"firefox/policies.json": `{
+  "policies": {
+    "DisableTelemetry": true,
+    "Extensions": {"Install": ["https://example.com/x.xpi"], "Locked": ["x@example.com"]},
+    "ExtensionSettings": {"x@example.com": {"installation_mode": "force_installed", "install_url": "https://example.com/x.xpi"}}
+  },
+  "comment": "kept as is"
+}
+`,
+}
+
+// openManaged writes managedFixture below a temporary directory and opens a
+// ManagedBackend on it.
+func openManaged(t *testing.T) (*ManagedBackend, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range managedFixture {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chrome, _ := browsers.Get("chrome")
+	firefox, _ := browsers.Get("firefox")
+	b, err := OpenManagedBackend([]ManagedSource{
+		{Browser: chrome, Path: filepath.Join(dir, "chrome")},
+		{Browser: firefox, Path: filepath.Join(dir, "firefox", "policies.json")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, dir
+}
+
+// readPolicyFile decodes the JSON file at path.
+func readPolicyFile(t *testing.T, path string) any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := decodeJSON(data)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return v
+}
+
+func TestManagedLoad(t *testing.T) {
+	b, _ := openManaged(t)
+	state, err := CaptureState(b, ManagedMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]RawValue{
+		`Google\Chrome\ExtensionInstallForcelist\1`:                         {Type: detection.RegSZ, Data: detection.EncodeUTF16String("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa;https://example.com/update.xml")},
+		`Google\Chrome\ExtensionInstallForcelist\2`:                         {Type: detection.RegSZ, Data: detection.EncodeUTF16String("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")},
+		`Google\Chrome\ExtensionSettings`:                                   {Type: detection.RegSZ, Data: detection.EncodeUTF16String(`{"cccccccccccccccccccccccccccccccc":{"installation_mode":"blocked"}}`)},
+		`Google\Chrome\HomepageLocation`:                                    {Type: detection.RegSZ, Data: detection.EncodeUTF16String("https://b.example")},
+		`Google\Chrome\BrowserSignin`:                                       {Type: detection.RegDword, Data: dword(0)},
+		`Google\Chrome\MetricsReportingEnabled`:                             {Type: detection.RegDword, Data: dword(0)},
+		`Google\Chrome\Large`:                                               {Type: detection.RegSZ, Data: detection.EncodeUTF16String("5000000000")},
+		`Google\Chrome\Mixed`:                                               {Type: detection.RegSZ, Data: detection.EncodeUTF16String(`[1,"a"]`)},
+		`Mozilla\Firefox\DisableTelemetry`:                                  {Type: detection.RegDword, Data: dword(1)},
+		`Mozilla\Firefox\Extensions\Install\1`:                              {Type: detection.RegSZ, Data: detection.EncodeUTF16String("https://example.com/x.xpi")},
+		`Mozilla\Firefox\Extensions\Locked\1`:                               {Type: detection.RegSZ, Data: detection.EncodeUTF16String("x@example.com")},
+		`Mozilla\Firefox\ExtensionSettings\x@example.com\installation_mode`: {Type: detection.RegSZ, Data: detection.EncodeUTF16String("force_installed")},
+		`Mozilla\Firefox\ExtensionSettings\x@example.com\install_url`:       {Type: detection.RegSZ, Data: detection.EncodeUTF16String("https://example.com/x.xpi")},
+	} {
+		got, ok := state.Values[path]
+		if !ok {
+			t.Errorf("value %s missing", path)
+			continue
+		}
+		if got.Type != want.Type || !reflect.DeepEqual(got.Raw, want.Data) {
+			t.Errorf("value %s = type %d %q, want type %d %q", path, got.Type, got.Raw, want.Type, want.Data)
+		}
+	}
+	if len(state.Values) != 13 {
+		t.Errorf("got %d values: %v", len(state.Values), slices.Sorted(maps.Keys(state.Values)))
+	}
+}
+
+// TestManagedWarnings checks that a broken policy file is skipped and
+// reported once per load instead of being printed.
+func TestManagedWarnings(t *testing.T) {
+	b, dir := openManaged(t)
+	if w := b.Warnings(); len(w) != 0 {
+		t.Fatalf("warnings of the fixture = %q", w)
+	}
+
+	broken := filepath.Join(dir, "chrome", "c.json")
+	if err := os.WriteFile(broken, []byte(`{"HomepageLocation": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	w := b.Warnings()
+	if len(w) != 1 || !strings.Contains(w[0], broken) {
+		t.Errorf("warnings = %q, want one for %s", w, broken)
+	}
+	if w := b.Warnings(); len(w) != 0 {
+		t.Errorf("warnings reported twice: %q", w)
+	}
+	state, err := CaptureState(b, ManagedMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Values[`Google\Chrome\HomepageLocation`]; !ok {
+		t.Error("policies of the other files were not loaded")
+	}
+}
+
+// TestManagedRoundTrip writes every policy back unchanged and checks that
+// the files hold the same JSON and reload into the same tree.
+func TestManagedRoundTrip(t *testing.T) {
+	b, dir := openManaged(t)
+	before, err := CaptureState(b, ManagedMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, root := range []string{chromeRoot, firefoxRoot} {
+		values, err := b.EnumValues(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, raw := range values {
+			if err := b.SetValue(root, raw.Name, raw.Type, raw.Data); err != nil {
+				t.Fatalf("rewriting %s\\%s: %v", root, raw.Name, err)
+			}
+		}
+		subkeys, err := b.EnumSubkeys(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range subkeys {
+			if err := b.CreateKey(root + `\` + name); err != nil {
+				t.Fatalf("rewriting %s\\%s: %v", root, name, err)
+			}
+		}
+	}
+
+	for name, content := range managedFixture {
+		want, err := decodeJSON([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readPolicyFile(t, filepath.Join(dir, filepath.FromSlash(name))); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s after writing it back:\n%v\nwant:\n%v", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chrome", GuardPolicyFile)); !os.IsNotExist(err) {
+		t.Errorf("unchanged policies were written to %s", GuardPolicyFile)
+	}
+
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := CaptureState(b, ManagedMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("tree after reload:\n%+v\nwant:\n%+v", after, before)
+	}
+}
+
+func TestManagedWrites(t *testing.T) {
+	b, dir := openManaged(t)
+	aPath := filepath.Join(dir, "chrome", "a.json")
+	if err := os.Chmod(aPath, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteRegistryKeyRecursive(b, chromeRoot, "ExtensionInstallForcelist"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateKey(chromeRoot + `\ExtensionInstallBlocklist`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetValue(chromeRoot+`\ExtensionInstallBlocklist`, "1", detection.RegSZ, detection.EncodeUTF16String("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteValue(firefoxRoot+`\Extensions\Install`, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	a := readPolicyFile(t, aPath).(map[string]any)
+	if _, ok := a["ExtensionInstallForcelist"]; ok {
+		t.Error("forcelist still set in a.json")
+	}
+	if _, ok := a["HomepageLocation"]; !ok {
+		t.Error("untouched policy dropped from a.json")
+	}
+	if info, err := os.Stat(aPath); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("a.json mode = %v, %v; want 0640", info.Mode().Perm(), err)
+	}
+
+	guard := readPolicyFile(t, filepath.Join(dir, "chrome", GuardPolicyFile)).(map[string]any)
+	if got, want := guard["ExtensionInstallBlocklist"], []any{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("%s blocklist = %v, want %v", GuardPolicyFile, got, want)
+	}
+
+	firefox := readPolicyFile(t, filepath.Join(dir, "firefox", "policies.json")).(map[string]any)
+	if firefox["comment"] != "kept as is" {
+		t.Errorf("policies.json lost its other members: %v", firefox)
+	}
+	extensions := firefox["policies"].(map[string]any)["Extensions"]
+	if want := map[string]any{"Install": []any{}, "Locked": []any{"x@example.com"}}; !reflect.DeepEqual(extensions, want) {
+		t.Errorf("Extensions = %v, want %v", extensions, want)
+	}
+
+	for _, sub := range []string{"chrome", "firefox"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".tmp") {
+				t.Errorf("temporary file %s left behind", e.Name())
+			}
+		}
+	}
+}
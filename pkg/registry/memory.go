@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
+)
+
+// memKey is a single key in a MemoryBackend tree. Subkeys and values are
+// indexed by lower-case name so lookups are case-insensitive while the
+// original spelling is preserved for enumeration.
+type memKey struct {
+	name       string
+	subkeys    map[string]*memKey
+	values     map[string]RawValue
+	valueOrder []string
+}
+
+func newMemKey(name string) *memKey {
+	return &memKey{
+		name:    name,
+		subkeys: make(map[string]*memKey),
+		values:  make(map[string]RawValue),
+	}
+}
+
+// MemoryBackend is a pure-Go, in-memory registry tree implementing Backend.
+// It is used to run enforcement scenarios without a Windows registry and to
+// evaluate offline registry exports. Subkeys are enumerated in sorted order
+// and values in insertion order, matching what RegEnumKeyEx and RegEnumValue
+// return on a live system.
+type MemoryBackend struct {
+	mu   sync.RWMutex
+	root *memKey
+}
+
+// NewMemoryBackend returns an empty in-memory registry tree.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{root: newMemKey("")}
+}
+
+// lookup walks to the key at path. Callers must hold m.mu.
+func (m *MemoryBackend) lookup(path string) (*memKey, error) {
+	key := m.root
+	for _, part := range pathutils.SplitPath(path) {
+		if part == "" {
+			continue
+		}
+		next, ok := key.subkeys[strings.ToLower(part)]
+		if !ok {
+			return nil, fmt.Errorf("key %s: %w", path, ErrNotFound)
+		}
+		key = next
+	}
+	return key, nil
+}
+
+func (m *MemoryBackend) OpenKey(path string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, err := m.lookup(path)
+	return err
+}
+
+func (m *MemoryBackend) EnumSubkeys(path string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, err := m.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(key.subkeys))
+	for _, sub := range key.subkeys {
+		names = append(names, sub.name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	return names, nil
+}
+
+func (m *MemoryBackend) EnumValues(path string) ([]RawValue, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, err := m.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]RawValue, 0, len(key.valueOrder))
+	for _, lower := range key.valueOrder {
+		v := key.values[lower]
+		v.Data = append([]byte(nil), v.Data...)
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (m *MemoryBackend) CreateKey(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.root
+	for _, part := range pathutils.SplitPath(path) {
+		if part == "" {
+			continue
+		}
+		lower := strings.ToLower(part)
+		next, ok := key.subkeys[lower]
+		if !ok {
+			next = newMemKey(part)
+			key.subkeys[lower] = next
+		}
+		key = next
+	}
+	return nil
+}
+
+func (m *MemoryBackend) SetValue(path, name string, valueType uint32, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.lookup(path)
+	if err != nil {
+		return err
+	}
+	lower := strings.ToLower(name)
+	if _, exists := key.values[lower]; !exists {
+		key.valueOrder = append(key.valueOrder, lower)
+	} else {
+		// Keep the spelling of the existing value, as the registry does.
+		name = key.values[lower].Name
+	}
+	key.values[lower] = RawValue{
+		Name: name,
+		Type: valueType,
+		Data: append([]byte(nil), data...),
+	}
+	return nil
+}
+
+func (m *MemoryBackend) DeleteValue(path, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.lookup(path)
+	if err != nil {
+		return err
+	}
+	lower := strings.ToLower(name)
+	if _, exists := key.values[lower]; !exists {
+		return fmt.Errorf("value %s\\%s: %w", path, name, ErrNotFound)
+	}
+	delete(key.values, lower)
+	for i, n := range key.valueOrder {
+		if n == lower {
+			key.valueOrder = append(key.valueOrder[:i], key.valueOrder[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) DeleteKey(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parentPath, name := splitKeyPath(path)
+	if name == "" {
+		return fmt.Errorf("cannot delete the root key")
+	}
+	parent, err := m.lookup(parentPath)
+	if err != nil {
+		return err
+	}
+	lower := strings.ToLower(name)
+	key, ok := parent.subkeys[lower]
+	if !ok {
+		return fmt.Errorf("key %s: %w", path, ErrNotFound)
+	}
+	if len(key.subkeys) > 0 {
+		return fmt.Errorf("key %s has subkeys", path)
+	}
+	delete(parent.subkeys, lower)
+	return nil
+}
+
+// HasWriteAccess always reports true: an in-memory tree is always writable.
+func (m *MemoryBackend) HasWriteAccess() bool {
+	return true
+}
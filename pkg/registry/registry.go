@@ -1,40 +1,51 @@
 package registry
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
 
-	"golang.org/x/sys/windows"
-
-	"github.com/kad/WindowsBrowserGuard/pkg/buffers"
 	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/extsettings"
 	"github.com/kad/WindowsBrowserGuard/pkg/pathutils"
 )
 
-var (
-	advapi32        = syscall.NewLazyDLL("advapi32.dll")
-	regEnumValueW   = advapi32.NewProc("RegEnumValueW")
-	regDeleteKeyW   = advapi32.NewProc("RegDeleteKeyW")
-	regSetValueExW  = advapi32.NewProc("RegSetValueExW")
-	regCreateKeyExW = advapi32.NewProc("RegCreateKeyExW")
-	regDeleteValueW = advapi32.NewProc("RegDeleteValueW")
-)
-
-type RegValue struct {
-	Name string
-	Type uint32
-	Data string
-}
-
 type RegState struct {
 	Subkeys map[string]bool
 	Values  map[string]RegValue
 }
 
+// NewRegState returns an empty RegState ready to be filled by a capture.
+func NewRegState() *RegState {
+	return &RegState{
+		Subkeys: make(map[string]bool),
+		Values:  make(map[string]RegValue),
+	}
+}
+
+// Clone returns a copy of s whose keys and values can be changed without
+// affecting s.
+func (s *RegState) Clone() *RegState {
+	return &RegState{
+		Subkeys: maps.Clone(s.Subkeys),
+		Values:  maps.Clone(s.Values),
+	}
+}
+
+// CaptureState captures keyPath and all of its subkeys from b.
+func CaptureState(b Backend, keyPath string) (*RegState, error) {
+	state := NewRegState()
+	if err := CaptureKeyRecursive(b, keyPath, "", state, 0); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
 type PerfMetrics struct {
 	StartupTime      time.Duration
 	IndexBuildTime   time.Duration
@@ -44,70 +55,6 @@ type PerfMetrics struct {
 
 const MaxRegistryDepth = 8
 
-// enumRegValue reads a single indexed value from hKey via RegEnumValueW,
-// returning its name, type, and formatted data. If the value data does not
-// fit in the standard 16KB buffer (ERROR_MORE_DATA), it retries once with a
-// 64KB buffer instead of aborting the caller's entire enumeration/capture.
-// done reports ERROR_NO_MORE_ITEMS (enumeration exhausted).
-func enumRegValue(hKey windows.Handle, index uint32) (name string, valueType uint32, data string, done bool, err error) {
-	nameBuf := buffers.GetLargeNameBuffer()
-	defer buffers.PutLargeNameBuffer(nameBuf)
-
-	dataBuf := buffers.GetDataBuffer()
-	defer buffers.PutDataBuffer(dataBuf)
-
-	nameLen := uint32(len(*nameBuf))
-	dataLen := uint32(len(*dataBuf))
-
-	ret, _, _ := regEnumValueW.Call(
-		uintptr(hKey),
-		uintptr(index),
-		uintptr(unsafe.Pointer(&(*nameBuf)[0])),
-		uintptr(unsafe.Pointer(&nameLen)),
-		0,
-		uintptr(unsafe.Pointer(&valueType)),
-		uintptr(unsafe.Pointer(&(*dataBuf)[0])),
-		uintptr(unsafe.Pointer(&dataLen)),
-	)
-
-	switch {
-	case ret == uintptr(windows.ERROR_NO_MORE_ITEMS):
-		done = true
-		return
-	case ret == uintptr(windows.ERROR_MORE_DATA):
-		largeDataBuf := buffers.GetLargeDataBuffer()
-		defer buffers.PutLargeDataBuffer(largeDataBuf)
-
-		nameLen = uint32(len(*nameBuf))
-		largeDataLen := uint32(len(*largeDataBuf))
-
-		ret, _, _ = regEnumValueW.Call(
-			uintptr(hKey),
-			uintptr(index),
-			uintptr(unsafe.Pointer(&(*nameBuf)[0])),
-			uintptr(unsafe.Pointer(&nameLen)),
-			0,
-			uintptr(unsafe.Pointer(&valueType)),
-			uintptr(unsafe.Pointer(&(*largeDataBuf)[0])),
-			uintptr(unsafe.Pointer(&largeDataLen)),
-		)
-		if ret != 0 {
-			err = fmt.Errorf("error enumerating values (retry with 64KB buffer): error code %d", ret)
-			return
-		}
-		name = syscall.UTF16ToString((*nameBuf)[:nameLen])
-		data = detection.FormatRegValue(valueType, (*largeDataBuf)[:largeDataLen])
-		return
-	case ret != 0:
-		err = fmt.Errorf("error enumerating values: error code %d", ret)
-		return
-	}
-
-	name = syscall.UTF16ToString((*nameBuf)[:nameLen])
-	data = detection.FormatRegValue(valueType, (*dataBuf)[:dataLen])
-	return
-}
-
 type ExtensionPathIndex struct {
 	pathsByExtID map[string][]string
 	mu           sync.RWMutex
@@ -170,6 +117,14 @@ func (idx *ExtensionPathIndex) GetPaths(extensionID string) []string {
 	return result
 }
 
+// IDs returns the indexed extension IDs, sorted.
+func (idx *ExtensionPathIndex) IDs() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(idx.pathsByExtID))
+}
+
 func (idx *ExtensionPathIndex) Remove(extensionID string) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
@@ -182,75 +137,41 @@ func (idx *ExtensionPathIndex) GetCount() int {
 	return len(idx.pathsByExtID)
 }
 
-func CaptureKeyRecursive(hKey windows.Handle, relativePath string, state *RegState, depth int) error {
+func CaptureKeyRecursive(b Backend, baseKeyPath, relativePath string, state *RegState, depth int) error {
 	if depth > MaxRegistryDepth {
 		return nil
 	}
 
-	var index uint32
-	subkeyNames := []string{}
-	for {
-		nameBuf := buffers.GetNameBuffer()
-		nameLen := uint32(len(*nameBuf))
-		err := windows.RegEnumKeyEx(hKey, index, &(*nameBuf)[0], &nameLen, nil, nil, nil, nil)
-		if err == windows.ERROR_NO_MORE_ITEMS {
-			buffers.PutNameBuffer(nameBuf)
-			break
-		}
-		if err != nil {
-			buffers.PutNameBuffer(nameBuf)
-			return fmt.Errorf("error enumerating subkeys: %v", err)
-		}
-		subkeyName := syscall.UTF16ToString((*nameBuf)[:nameLen])
-		buffers.PutNameBuffer(nameBuf)
+	keyPath := joinKeyPath(baseKeyPath, relativePath)
 
-		fullPath := pathutils.BuildPath(relativePath, subkeyName)
-
-		state.Subkeys[fullPath] = true
-		subkeyNames = append(subkeyNames, subkeyName)
-
-		index++
+	subkeyNames, err := b.EnumSubkeys(keyPath)
+	if err != nil {
+		return fmt.Errorf("error enumerating subkeys: %w", err)
+	}
+	for _, subkeyName := range subkeyNames {
+		state.Subkeys[pathutils.BuildPath(relativePath, subkeyName)] = true
 	}
 
-	index = 0
-	for {
-		valueName, valueType, valueData, done, err := enumRegValue(hKey, index)
-		if done {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading value at index %d under %q: %w", index, relativePath, err)
-		}
-
-		fullPath := pathutils.BuildPath(relativePath, valueName)
-
-		state.Values[fullPath] = RegValue{
-			Name: fullPath,
-			Type: valueType,
-			Data: valueData,
-		}
+	values, err := b.EnumValues(keyPath)
+	if err != nil {
+		return fmt.Errorf("error reading values under %q: %w", relativePath, err)
+	}
+	for _, value := range values {
+		fullPath := pathutils.BuildPath(relativePath, value.Name)
 
-		index++
+		state.Values[fullPath] = NewRegValue(fullPath, value.Type, value.Data)
 	}
 
 	for _, subkeyName := range subkeyNames {
-		subkeyPtr, err := syscall.UTF16PtrFromString(subkeyName)
-		if err != nil {
-			continue
-		}
+		fullPath := pathutils.BuildPath(relativePath, subkeyName)
 
-		var hSubKey windows.Handle
-		err = windows.RegOpenKeyEx(hKey, subkeyPtr, 0, windows.KEY_READ, &hSubKey)
-		if err != nil {
+		// Subkeys that vanish or cannot be opened between enumeration and
+		// descent are skipped, matching RegOpenKeyEx failures on a live system.
+		if err := b.OpenKey(joinKeyPath(baseKeyPath, fullPath)); err != nil {
 			continue
 		}
 
-		fullPath := pathutils.BuildPath(relativePath, subkeyName)
-
-		err = CaptureKeyRecursive(hSubKey, fullPath, state, depth+1)
-		_ = windows.RegCloseKey(hSubKey)
-
-		if err != nil {
+		if err := CaptureKeyRecursive(b, baseKeyPath, fullPath, state, depth+1); err != nil {
 			return err
 		}
 	}
@@ -258,116 +179,36 @@ func CaptureKeyRecursive(hKey windows.Handle, relativePath string, state *RegSta
 	return nil
 }
 
-func ReadKeyValues(baseKeyPath, relativePath string) (map[string]string, error) {
-	fullPath := baseKeyPath
-	if relativePath != "" {
-		fullPath = baseKeyPath + "\\" + relativePath
-	}
+// ReadKeyValues reads all values of a single key, keyed by value name.
+func ReadKeyValues(b Backend, baseKeyPath, relativePath string) (map[string]RegValue, error) {
+	fullPath := joinKeyPath(baseKeyPath, relativePath)
 
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
+	rawValues, err := b.EnumValues(fullPath)
 	if err != nil {
-		return nil, fmt.Errorf("error converting key path: %w", err)
+		return nil, fmt.Errorf("error reading values under %q: %w", fullPath, err)
 	}
 
-	var hKey windows.Handle
-	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, keyPtr, 0, windows.KEY_READ, &hKey)
-	if err != nil {
-		return nil, fmt.Errorf("error opening key: %w", err)
-	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
-
-	values := make(map[string]string)
-	var index uint32
-	for {
-		valueName, _, valueData, done, err := enumRegValue(hKey, index)
-		if done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading value at index %d under %q: %w", index, fullPath, err)
-		}
-		values[valueName] = valueData
-		index++
+	values := make(map[string]RegValue, len(rawValues))
+	for _, value := range rawValues {
+		values[value.Name] = NewRegValue(value.Name, value.Type, value.Data)
 	}
 
 	return values, nil
 }
 
-func DeleteRegistryKey(baseKeyPath, relativePath string, dryRun bool) error {
-	fullPath := baseKeyPath
-	if relativePath != "" {
-		fullPath = baseKeyPath + "\\" + relativePath
-	}
-
-	if dryRun {
-		fmt.Printf("  [DRY-RUN] Would delete registry key: HKLM\\%s\n", fullPath)
-		return nil
-	}
-
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
-	if err != nil {
-		return fmt.Errorf("error converting key path: %v", err)
-	}
-
-	var hKey windows.Handle
-	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, keyPtr, 0, windows.DELETE, &hKey)
-	if err != nil {
-		return fmt.Errorf("error opening key for deletion: %v", err)
-	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
-
-	ret, _, _ := regDeleteKeyW.Call(uintptr(hKey), uintptr(0))
-	if ret != 0 {
-		return fmt.Errorf("error deleting key: error code %d", ret)
-	}
-
-	return nil
-}
-
-func DeleteRegistryKeyRecursive(baseKeyPath, relativePath string, dryRun bool) error {
-	fullPath := baseKeyPath
-	if relativePath != "" {
-		fullPath = baseKeyPath + "\\" + relativePath
-	}
-
-	if dryRun {
-		fmt.Printf("  [DRY-RUN] Would recursively delete registry key: HKLM\\%s\n", fullPath)
-		return nil
-	}
-
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
-	if err != nil {
-		return fmt.Errorf("error converting key path: %v", err)
-	}
-
-	var hKey windows.Handle
-	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, keyPtr, 0, windows.KEY_READ|windows.DELETE, &hKey)
-	if err != nil {
-		return fmt.Errorf("error opening key: %v", err)
-	}
+// DeleteRegistryKeyRecursive deletes the key at relativePath below
+// baseKeyPath with everything below it.
+func DeleteRegistryKeyRecursive(b Backend, baseKeyPath, relativePath string) error {
+	fullPath := joinKeyPath(baseKeyPath, relativePath)
 
 	// Enumerate all subkey names up front instead of always re-reading index 0
 	// as subkeys are deleted. Relying on live index shifting means a subkey
 	// whose deletion fails (permission denied, key open elsewhere, etc.) would
 	// be re-enumerated at the same index forever, hanging the caller.
-	var subkeyNames []string
-	for index := uint32(0); ; index++ {
-		nameBuf := buffers.GetNameBuffer()
-		nameLen := uint32(len(*nameBuf))
-		enumErr := windows.RegEnumKeyEx(hKey, index, &(*nameBuf)[0], &nameLen, nil, nil, nil, nil)
-		if enumErr == windows.ERROR_NO_MORE_ITEMS {
-			buffers.PutNameBuffer(nameBuf)
-			break
-		}
-		if enumErr != nil {
-			buffers.PutNameBuffer(nameBuf)
-			_ = windows.RegCloseKey(hKey)
-			return fmt.Errorf("error enumerating subkeys: %v", enumErr)
-		}
-		subkeyNames = append(subkeyNames, syscall.UTF16ToString((*nameBuf)[:nameLen]))
-		buffers.PutNameBuffer(nameBuf)
+	subkeyNames, err := b.EnumSubkeys(fullPath)
+	if err != nil {
+		return fmt.Errorf("error enumerating subkeys: %w", err)
 	}
-	_ = windows.RegCloseKey(hKey)
 
 	for _, subkeyName := range subkeyNames {
 		subkeyPath := relativePath
@@ -376,359 +217,160 @@ func DeleteRegistryKeyRecursive(baseKeyPath, relativePath string, dryRun bool) e
 		}
 		subkeyPath += subkeyName
 
-		if delErr := DeleteRegistryKeyRecursive(baseKeyPath, subkeyPath, dryRun); delErr != nil {
+		if delErr := DeleteRegistryKeyRecursive(b, baseKeyPath, subkeyPath); delErr != nil {
 			return fmt.Errorf("failed to delete subkey %s: %w", subkeyPath, delErr)
 		}
 	}
 
-	parentPath := baseKeyPath
 	if relativePath != "" {
-		lastSlash := -1
-		for i := len(relativePath) - 1; i >= 0; i-- {
-			if relativePath[i] == '\\' {
-				lastSlash = i
-				break
-			}
-		}
-
-		if lastSlash >= 0 {
-			parentPath = baseKeyPath + "\\" + relativePath[:lastSlash]
-			keyName := relativePath[lastSlash+1:]
-			keyPtr, _ = syscall.UTF16PtrFromString(keyName)
-		} else {
-			keyPtr, _ = syscall.UTF16PtrFromString(relativePath)
-		}
-
-		parentKeyPtr, _ := syscall.UTF16PtrFromString(parentPath)
-		var hParentKey windows.Handle
-		err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, parentKeyPtr, 0, windows.DELETE, &hParentKey)
-		if err != nil {
-			return fmt.Errorf("error opening parent key: %v", err)
-		}
-		defer func() { _ = windows.RegCloseKey(hParentKey) }()
-
-		ret, _, _ := regDeleteKeyW.Call(uintptr(hParentKey), uintptr(unsafe.Pointer(keyPtr)))
-		if ret != 0 {
-			return fmt.Errorf("error deleting key: error code %d", ret)
+		if err := b.DeleteKey(fullPath); err != nil {
+			return fmt.Errorf("error deleting key: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func AddToBlocklist(baseKeyPath, blocklistPath, extensionID string, dryRun bool) error {
-	fullPath := baseKeyPath
-	if blocklistPath != "" {
-		fullPath = baseKeyPath + "\\" + blocklistPath
-	}
+// AddToBlocklist adds extensionID to the Chromium blocklist key at
+// blocklistPath under the first free numeric value name, unless it is
+// listed already.
+func AddToBlocklist(b Backend, baseKeyPath, blocklistPath, extensionID string) error {
+	fullPath := joinKeyPath(baseKeyPath, blocklistPath)
 
-	if dryRun {
-		fmt.Printf("  [DRY-RUN] Would add to blocklist: HKLM\\%s\n", fullPath)
-		fmt.Printf("  [DRY-RUN]   Extension ID: %s\n", extensionID)
-		return nil
+	if err := b.CreateKey(fullPath); err != nil {
+		return fmt.Errorf("error creating/opening blocklist key: %w", err)
 	}
 
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
-	if err != nil {
-		return fmt.Errorf("error converting key path: %v", err)
-	}
-
-	var hKey windows.Handle
-	var disposition uint32
-
-	ret, _, _ := regCreateKeyExW.Call(
-		uintptr(windows.HKEY_LOCAL_MACHINE),
-		uintptr(unsafe.Pointer(keyPtr)),
-		0,
-		0,
-		0,
-		uintptr(windows.KEY_READ|windows.KEY_WRITE),
-		0,
-		uintptr(unsafe.Pointer(&hKey)),
-		uintptr(unsafe.Pointer(&disposition)),
-	)
-
-	if ret != 0 {
-		return fmt.Errorf("error creating/opening blocklist key: error code %d", ret)
-	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
-
-	existingValues, err := ReadKeyValues(baseKeyPath, blocklistPath)
+	existingValues, err := ReadKeyValues(b, baseKeyPath, blocklistPath)
 	if err != nil {
 		return fmt.Errorf("error reading existing blocklist values: %w", err)
 	}
 
 	for _, value := range existingValues {
-		if value == extensionID {
-			fmt.Printf("  ℹ️  Extension ID %s already in blocklist\n", extensionID)
+		if slices.Contains(value.Strings(), extensionID) {
 			return nil
 		}
 	}
 
-	nextIndex := 1
-	for {
-		indexStr := fmt.Sprintf("%d", nextIndex)
-		if _, exists := existingValues[indexStr]; !exists {
-			break
-		}
-		nextIndex++
-	}
-
-	indexName := fmt.Sprintf("%d", nextIndex)
-	indexNamePtr, _ := syscall.UTF16PtrFromString(indexName)
-
-	extensionIDUTF16, _ := syscall.UTF16FromString(extensionID)
-	dataSize := uint32(len(extensionIDUTF16) * 2)
-
-	ret, _, _ = regSetValueExW.Call(
-		uintptr(hKey),
-		uintptr(unsafe.Pointer(indexNamePtr)),
-		0,
-		uintptr(windows.REG_SZ),
-		uintptr(unsafe.Pointer(&extensionIDUTF16[0])),
-		uintptr(dataSize),
-	)
-
-	if ret != 0 {
-		return fmt.Errorf("error setting blocklist value: error code %d", ret)
+	indexName := NextListValueName(existingValues)
+	if err := b.SetValue(fullPath, indexName, detection.RegSZ, detection.EncodeUTF16String(extensionID)); err != nil {
+		return fmt.Errorf("error setting blocklist value: %w", err)
 	}
-
-	fmt.Printf("  ✓ Added extension ID %s to blocklist at index %s\n", extensionID, indexName)
 	return nil
 }
 
-func BlockFirefoxExtension(baseKeyPath, extensionID string, dryRun bool) error {
-	if dryRun {
-		fmt.Printf("  [DRY-RUN] Would block Firefox extension: %s\n", extensionID)
-		return nil
+// NextListValueName returns the first numeric value name, counting from 1,
+// that values of a list policy key does not use yet.
+func NextListValueName(values map[string]RegValue) string {
+	for n := 1; ; n++ {
+		name := strconv.Itoa(n)
+		if _, exists := values[name]; !exists {
+			return name
+		}
 	}
+}
 
-	blocklistPath := detection.GetFirefoxBlocklistPath(extensionID)
-	fullPath := baseKeyPath
-	if blocklistPath != "" {
-		fullPath = baseKeyPath + "\\" + blocklistPath
-	}
+// BlockGeckoExtension sets installation_mode=blocked for extensionID in the
+// ExtensionSettings policy below policyRoot (e.g. Mozilla\Firefox).
+func BlockGeckoExtension(b Backend, baseKeyPath, policyRoot, extensionID string) error {
+	fullPath := joinKeyPath(baseKeyPath, detection.GetGeckoBlocklistPath(policyRoot, extensionID))
 
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
-	if err != nil {
-		return fmt.Errorf("error converting key path: %v", err)
+	if err := b.CreateKey(fullPath); err != nil {
+		return fmt.Errorf("error creating blocklist key: %w", err)
 	}
 
-	var hKey windows.Handle
-	var disposition uint32
-
-	ret, _, _ := regCreateKeyExW.Call(
-		uintptr(windows.HKEY_LOCAL_MACHINE),
-		uintptr(unsafe.Pointer(keyPtr)),
-		0,
-		0,
-		0,
-		uintptr(windows.KEY_READ|windows.KEY_WRITE),
-		0,
-		uintptr(unsafe.Pointer(&hKey)),
-		uintptr(unsafe.Pointer(&disposition)),
-	)
-
-	if ret != 0 {
-		return fmt.Errorf("error creating blocklist key: error code %d", ret)
+	if err := b.SetValue(fullPath, "installation_mode", detection.RegSZ, detection.EncodeUTF16String("blocked")); err != nil {
+		return fmt.Errorf("error setting installation_mode: %w", err)
 	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
-
-	valueNamePtr, _ := syscall.UTF16PtrFromString("installation_mode")
-	valueDataUTF16, _ := syscall.UTF16FromString("blocked")
-	dataSize := uint32(len(valueDataUTF16) * 2)
-
-	ret, _, _ = regSetValueExW.Call(
-		uintptr(hKey),
-		uintptr(unsafe.Pointer(valueNamePtr)),
-		0,
-		uintptr(windows.REG_SZ),
-		uintptr(unsafe.Pointer(&valueDataUTF16[0])),
-		uintptr(dataSize),
-	)
-
-	if ret != 0 {
-		return fmt.Errorf("error setting installation_mode: error code %d", ret)
-	}
-
-	fmt.Printf("  ✓ Blocked Firefox extension: %s\n", extensionID)
 	return nil
 }
 
-func RemoveFromAllowlist(baseKeyPath, allowlistPath, extensionID string, dryRun bool) error {
-	if dryRun {
-		fmt.Printf("  [DRY-RUN] Would remove from allowlist: %s\n", extensionID)
-		return nil
-	}
-
-	fullPath := baseKeyPath
-	if allowlistPath != "" {
-		fullPath = baseKeyPath + "\\" + allowlistPath
-	}
-
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
-	if err != nil {
-		return fmt.Errorf("error converting key path: %v", err)
-	}
-
-	var hKey windows.Handle
-	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, keyPtr, 0, windows.KEY_READ|windows.KEY_WRITE, &hKey)
+// EncodeExtensionSettings returns the JSON ExtensionSettings value old
+// rewritten with settings, keeping the original value type.
+func EncodeExtensionSettings(old RegValue, settings extsettings.Settings) (RegValue, error) {
+	data, err := settings.Marshal()
 	if err != nil {
-		if err == windows.ERROR_FILE_NOT_FOUND {
-			return nil
-		}
-		return fmt.Errorf("error opening allowlist key: %v", err)
-	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
+		return RegValue{}, err
+	}
+	valueType := old.Type
+	var raw []byte
+	switch valueType {
+	case detection.RegMultiSZ:
+		raw = detection.EncodeUTF16MultiString([]string{data})
+	case detection.RegExpandSZ:
+		raw = detection.EncodeUTF16String(data)
+	default:
+		valueType = detection.RegSZ
+		raw = detection.EncodeUTF16String(data)
+	}
+	return NewRegValue(old.Name, valueType, raw), nil
+}
 
-	existingValues, err := ReadKeyValues(baseKeyPath, allowlistPath)
-	if err != nil {
-		return fmt.Errorf("error reading allowlist values: %v", err)
-	}
-
-	found := false
-	for valueName, valueData := range existingValues {
-		checkID := detection.ExtractExtensionIDFromValue(valueData)
-		if checkID == extensionID {
-			found = true
-			fmt.Printf("  🔍 Found in allowlist at index %s\n", valueName)
-
-			valueNamePtr, _ := syscall.UTF16PtrFromString(valueName)
-			ret, _, _ := regDeleteValueW.Call(
-				uintptr(hKey),
-				uintptr(unsafe.Pointer(valueNamePtr)),
-			)
-			if ret != 0 {
-				return fmt.Errorf("error deleting allowlist value: error code %d", ret)
-			}
-			fmt.Printf("  ✓ Removed %s from allowlist\n", extensionID)
-		}
-	}
+// DeletePolicyValue deletes the value at valuePath, whose last component
+// is the value name.
+func DeletePolicyValue(b Backend, baseKeyPath, valuePath string) error {
+	keyPath, name := splitKeyPath(valuePath)
+	fullPath := joinKeyPath(baseKeyPath, keyPath)
 
-	if !found {
-		fmt.Printf("  ℹ️  Extension ID %s not found in allowlist\n", extensionID)
+	if err := b.DeleteValue(fullPath, name); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("error deleting value %s: %w", name, err)
 	}
-
 	return nil
 }
 
-func RemoveAllowlistValueNames(baseKeyPath, allowlistPath string, valueNames []string, dryRun bool) ([]string, error) {
-	if len(valueNames) == 0 {
-		return nil, nil
-	}
+// SetPolicyValue writes the value at valuePath, whose last component is the
+// value name. It returns the value as written.
+func SetPolicyValue(b Backend, baseKeyPath, valuePath string, valueType uint32, raw []byte) (RegValue, error) {
+	keyPath, name := splitKeyPath(valuePath)
+	fullPath := joinKeyPath(baseKeyPath, keyPath)
 
-	if dryRun {
-		return append([]string(nil), valueNames...), nil
-	}
-
-	fullPath := baseKeyPath
-	if allowlistPath != "" {
-		fullPath = baseKeyPath + "\\" + allowlistPath
-	}
-
-	keyPtr, err := syscall.UTF16PtrFromString(fullPath)
-	if err != nil {
-		return nil, fmt.Errorf("error converting key path: %w", err)
+	if err := b.SetValue(fullPath, name, valueType, raw); err != nil {
+		return RegValue{}, fmt.Errorf("error setting value %s: %w", name, err)
 	}
+	return NewRegValue(valuePath, valueType, raw), nil
+}
 
-	var hKey windows.Handle
-	err = windows.RegOpenKeyEx(windows.HKEY_LOCAL_MACHINE, keyPtr, 0, windows.KEY_READ|windows.KEY_WRITE, &hKey)
+// RemoveFromAllowlist deletes the values of the Chromium allowlist key at
+// allowlistPath that list extensionID. A missing key lists nothing.
+func RemoveFromAllowlist(b Backend, baseKeyPath, allowlistPath, extensionID string) error {
+	existingValues, err := ReadKeyValues(b, baseKeyPath, allowlistPath)
 	if err != nil {
-		if err == windows.ERROR_FILE_NOT_FOUND {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("error opening allowlist key: %w", err)
-	}
-	defer func() { _ = windows.RegCloseKey(hKey) }()
-
-	deleted := make([]string, 0, len(valueNames))
-	for _, valueName := range valueNames {
-		valueNamePtr, convErr := syscall.UTF16PtrFromString(valueName)
-		if convErr != nil {
-			return deleted, fmt.Errorf("error converting value name %s: %w", valueName, convErr)
-		}
-
-		ret, _, _ := regDeleteValueW.Call(
-			uintptr(hKey),
-			uintptr(unsafe.Pointer(valueNamePtr)),
-		)
-		if ret == uintptr(windows.ERROR_FILE_NOT_FOUND) {
-			continue
-		}
-		if ret != 0 {
-			return deleted, fmt.Errorf("error deleting allowlist value %s: error code %d", valueName, ret)
+		if errors.Is(err, ErrNotFound) {
+			return nil
 		}
-		deleted = append(deleted, valueName)
+		return fmt.Errorf("error reading allowlist values: %w", err)
 	}
 
-	return deleted, nil
-}
-
-func RemoveExtensionSettingsForID(baseKeyPath, extensionID string, dryRun bool, state *RegState, extensionIndex *ExtensionPathIndex) {
-	fmt.Printf("  🔍 Checking for extension settings: %s\n", extensionID)
-	fmt.Printf("  📊 Scanning %d subkeys and %d values...\n", len(state.Subkeys), len(state.Values))
-
-	var settingsToRemove map[string]bool
-
-	if extensionIndex != nil {
-		paths := extensionIndex.GetPaths(extensionID)
-		settingsToRemove = make(map[string]bool, len(paths))
-		for _, p := range paths {
-			fmt.Printf("  🎯 Found (indexed): %s\n", p)
-			settingsToRemove[p] = true
-		}
-	} else {
-		settingsToRemove = make(map[string]bool)
-
-		for subkeyPath := range state.Subkeys {
-			if pathutils.ContainsIgnoreCase(subkeyPath, "3rdparty") &&
-				pathutils.ContainsIgnoreCase(subkeyPath, "extensions") &&
-				pathutils.ContainsIgnoreCase(subkeyPath, extensionID) {
-				fmt.Printf("  🎯 Found matching subkey: %s\n", subkeyPath)
-				settingsToRemove[subkeyPath] = true
-			}
-		}
-
-		for valuePath := range state.Values {
-			if pathutils.ContainsIgnoreCase(valuePath, "3rdparty") &&
-				pathutils.ContainsIgnoreCase(valuePath, "extensions") &&
-				pathutils.ContainsIgnoreCase(valuePath, extensionID) {
-				fmt.Printf("  🎯 Found matching value: %s\n", valuePath)
-
-				parts := pathutils.SplitPath(valuePath)
-				for i := 0; i < len(parts); i++ {
-					if parts[i] == extensionID {
-						settingsPath := strings.Join(parts[:i+1], "\\")
-						fmt.Printf("  📍 Extracted settings path: %s\n", settingsPath)
-						settingsToRemove[settingsPath] = true
-						break
-					}
-				}
+	fullPath := joinKeyPath(baseKeyPath, allowlistPath)
+	for _, valueName := range slices.Sorted(maps.Keys(existingValues)) {
+		if slices.Contains(ExtensionIDs(existingValues[valueName]), extensionID) {
+			if err := b.DeleteValue(fullPath, valueName); err != nil {
+				return fmt.Errorf("error deleting allowlist value: %w", err)
 			}
 		}
 	}
+	return nil
+}
 
-	if len(settingsToRemove) == 0 {
-		fmt.Printf("  ℹ️  No extension settings found for %s\n", extensionID)
-		return
-	}
-
-	fmt.Printf("  🗑️  Found %d setting path(s) to remove\n", len(settingsToRemove))
-
-	for settingsPath := range settingsToRemove {
-		fmt.Printf("  🗑️  Deleting extension settings: %s\n", settingsPath)
-		err := DeleteRegistryKeyRecursive(baseKeyPath, settingsPath, dryRun)
-		if err != nil {
-			fmt.Printf("  ⚠️  Failed to delete settings: %v\n", err)
-		} else {
-			fmt.Printf("  ✓ Successfully removed settings for %s\n", extensionID)
-			delete(state.Subkeys, settingsPath)
-			RemoveSubtreeFromState(state, settingsPath)
-			if extensionIndex != nil {
-				extensionIndex.Remove(extensionID)
-			}
-		}
-	}
+// PruneForcelistValues decides which values of a forcelist key change when
+// the entries for which remove returns true are removed. values holds the
+// key's values by name; a value left without entries is deleted, and a
+// REG_MULTI_SZ value that lists kept and removed entries is rewritten with
+// the kept ones. It returns the names of the deleted values and the
+// rewritten values, in name order.
+func PruneForcelistValues(values map[string]RegValue, remove func(entry string) bool) (deleted []string, rewritten []RegValue) {
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		entries := values[name].Strings()
+		kept := slices.DeleteFunc(slices.Clone(entries), remove)
+		switch {
+		case len(kept) == len(entries):
+		case len(kept) == 0:
+			deleted = append(deleted, name)
+		default:
+			rewritten = append(rewritten, NewRegValue(name, detection.RegMultiSZ, detection.EncodeUTF16MultiString(kept)))
+		}
+	}
+	return deleted, rewritten
 }
 
 func RemoveSubtreeFromState(state *RegState, prefix string) {
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"slices"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+)
+
+// RegValue is a captured registry value. Raw holds the data exactly as read
+// from the registry; Data is its display form (see detection.FormatRegValue).
+// Use the typed accessors rather than Data when inspecting or comparing values.
+type RegValue struct {
+	Name string
+	Type uint32
+	Data string
+	Raw  []byte
+}
+
+// NewRegValue builds a RegValue from raw registry data.
+func NewRegValue(name string, valueType uint32, raw []byte) RegValue {
+	return RegValue{
+		Name: name,
+		Type: valueType,
+		Data: detection.FormatRegValue(valueType, raw),
+		Raw:  raw,
+	}
+}
+
+// IsString reports whether v is a REG_SZ or REG_EXPAND_SZ value.
+func (v RegValue) IsString() bool {
+	return v.Type == detection.RegSZ || v.Type == detection.RegExpandSZ
+}
+
+// Unexpanded returns the string held by a REG_SZ or REG_EXPAND_SZ value
+// without expanding environment variable references. It returns "" for
+// other types.
+func (v RegValue) Unexpanded() string {
+	if !v.IsString() {
+		return ""
+	}
+	if v.Raw == nil {
+		return v.Data
+	}
+	return detection.DecodeUTF16String(v.Raw)
+}
+
+// Expanded returns the string held by v with %NAME% references in a
+// REG_EXPAND_SZ value resolved against the current process environment.
+func (v RegValue) Expanded() string {
+	s := v.Unexpanded()
+	if v.Type != detection.RegExpandSZ {
+		return s
+	}
+	return detection.ExpandEnvironmentStrings(s, os.LookupEnv)
+}
+
+// Strings returns the strings held by v: every entry of a REG_MULTI_SZ value,
+// or the single unexpanded string of a REG_SZ/REG_EXPAND_SZ value. It returns
+// nil for non-string types.
+func (v RegValue) Strings() []string {
+	switch {
+	case v.Type == detection.RegMultiSZ:
+		return detection.DecodeUTF16MultiString(v.Raw)
+	case v.IsString():
+		return []string{v.Unexpanded()}
+	}
+	return nil
+}
+
+// Uint32 returns the number held by a REG_DWORD or REG_DWORD_BIG_ENDIAN value.
+func (v RegValue) Uint32() (uint32, bool) {
+	return detection.DecodeUint32(v.Type, v.Raw)
+}
+
+// Uint64 returns the number held by a REG_QWORD value, or a DWORD widened
+// to 64 bits.
+func (v RegValue) Uint64() (uint64, bool) {
+	if n, ok := v.Uint32(); ok {
+		return uint64(n), true
+	}
+	if v.Type != detection.RegQword {
+		return 0, false
+	}
+	return detection.DecodeUint64(v.Raw)
+}
+
+// Equal reports whether v and o have the same type and typed contents.
+// Strings compare by their text, so differences in trailing NUL padding
+// are not reported as changes.
+func (v RegValue) Equal(o RegValue) bool {
+	if v.Type != o.Type {
+		return false
+	}
+	switch {
+	case v.IsString():
+		return v.Unexpanded() == o.Unexpanded()
+	case v.Type == detection.RegMultiSZ:
+		return slices.Equal(v.Strings(), o.Strings())
+	case v.Raw == nil && o.Raw == nil:
+		return v.Data == o.Data
+	}
+	if n, ok := v.Uint64(); ok {
+		m, ok := o.Uint64()
+		return ok && n == m
+	}
+	return bytes.Equal(v.Raw, o.Raw)
+}
+
+// ExtensionIDs returns the extension IDs listed by a Chromium policy list
+// value ("id" or "id;update-url"). A REG_MULTI_SZ value may list several.
+func ExtensionIDs(v RegValue) []string {
+	var ids []string
+	for _, entry := range v.Strings() {
+		if id := detection.ExtractExtensionIDFromValue(entry); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+)
+
+// Extension IDs used by the value fixtures.
+const (
+	valueIDA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	valueIDB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// utf16LE encodes s as little-endian UTF-16 without a NUL terminator.
+func utf16LE(s string) []byte {
+	data := detection.EncodeUTF16String(s)
+	return data[:len(data)-2]
+}
+
+// raw returns the registry value of valueType holding data.
+func raw(valueType uint32, data ...[]byte) RegValue {
+	return NewRegValue("v", valueType, slices.Concat(data...))
+}
+
+// nul is a UTF-16 NUL.
+var nul = []byte{0, 0}
+
+func TestRegValueExpanded(t *testing.T) {
+	t.Setenv("WBG_TEST_DIR", `C:\Guard`)
+
+	tests := []struct {
+		name  string
+		value RegValue
+		want  string
+	}{
+		{"REG_SZ", raw(detection.RegSZ, utf16LE("plain"), nul), "plain"},
+		{"REG_SZ is not expanded", raw(detection.RegSZ, utf16LE(`%WBG_TEST_DIR%\x`), nul), `%WBG_TEST_DIR%\x`},
+		{"REG_EXPAND_SZ", raw(detection.RegExpandSZ, utf16LE(`%WBG_TEST_DIR%\x`), nul), `C:\Guard\x`},
+		{"undefined variable", raw(detection.RegExpandSZ, utf16LE(`%WBG_TEST_UNSET%\x`), nul), `%WBG_TEST_UNSET%\x`},
+		{"without terminator", raw(detection.RegSZ, utf16LE("plain")), "plain"},
+		{"stops at the first NUL", raw(detection.RegSZ, utf16LE("plain"), nul, utf16LE("junk"), nul), "plain"},
+		{"odd length", raw(detection.RegSZ, utf16LE("plain"), []byte{'x'}), "plain"},
+		{"single byte", raw(detection.RegSZ, []byte{'x'}), ""},
+		{"not a string", raw(detection.RegDword, []byte{1, 0, 0, 0}), ""},
+		{"display form only", RegValue{Type: detection.RegSZ, Data: "imported"}, "imported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.value.Expanded(); got != tt.want {
+				t.Errorf("Expanded() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegValueStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		value RegValue
+		want  []string
+	}{
+		{"REG_MULTI_SZ", raw(detection.RegMultiSZ, utf16LE("a"), nul, utf16LE("b"), nul, nul), []string{"a", "b"}},
+		{"missing final terminator", raw(detection.RegMultiSZ, utf16LE("a"), nul, utf16LE("b"), nul), []string{"a", "b"}},
+		{"missing both terminators", raw(detection.RegMultiSZ, utf16LE("a"), nul, utf16LE("b")), []string{"a", "b"}},
+		{"odd length", raw(detection.RegMultiSZ, utf16LE("a"), nul, utf16LE("b"), nul, nul, []byte{'x'}), []string{"a", "b"}},
+		{"ends at an empty string", raw(detection.RegMultiSZ, utf16LE("a"), nul, nul, utf16LE("b"), nul, nul), []string{"a"}},
+		{"empty", raw(detection.RegMultiSZ, nul), []string{}},
+		{"REG_SZ", raw(detection.RegSZ, utf16LE("a"), nul), []string{"a"}},
+		{"REG_EXPAND_SZ is not expanded", raw(detection.RegExpandSZ, utf16LE("%WBG_TEST_DIR%"), nul), []string{"%WBG_TEST_DIR%"}},
+		{"not a string", raw(detection.RegDword, []byte{1, 0, 0, 0}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.value.Strings()
+			if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("Strings() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegValueNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  RegValue
+		want32 uint32
+		ok32   bool
+		want64 uint64
+		ok64   bool
+	}{
+		{"REG_DWORD", raw(detection.RegDword, []byte{0x78, 0x56, 0x34, 0x12}), 0x12345678, true, 0x12345678, true},
+		{"REG_DWORD_BIG_ENDIAN", raw(detection.RegDwordBigEndian, []byte{0x12, 0x34, 0x56, 0x78}), 0x12345678, true, 0x12345678, true},
+		{"short REG_DWORD", raw(detection.RegDword, []byte{1, 0, 0}), 0, false, 0, false},
+		{"REG_QWORD", raw(detection.RegQword, []byte{1, 0, 0, 0, 2, 0, 0, 0}), 0, false, 0x200000001, true},
+		{"short REG_QWORD", raw(detection.RegQword, []byte{1, 0, 0, 0}), 0, false, 0, false},
+		{"REG_SZ", raw(detection.RegSZ, utf16LE("1"), nul), 0, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := tt.value.Uint32(); got != tt.want32 || ok != tt.ok32 {
+				t.Errorf("Uint32() = %#x, %v, want %#x, %v", got, ok, tt.want32, tt.ok32)
+			}
+			if got, ok := tt.value.Uint64(); got != tt.want64 || ok != tt.ok64 {
+				t.Errorf("Uint64() = %#x, %v, want %#x, %v", got, ok, tt.want64, tt.ok64)
+			}
+		})
+	}
+}
+
+func TestRegValueEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b RegValue
+		want bool
+	}{
+		{"same REG_SZ", raw(detection.RegSZ, utf16LE("a"), nul), raw(detection.RegSZ, utf16LE("a"), nul), true},
+		{"REG_SZ padding", raw(detection.RegSZ, utf16LE("a"), nul), raw(detection.RegSZ, utf16LE("a"), nul, nul, nul), true},
+		{"REG_SZ without terminator", raw(detection.RegSZ, utf16LE("a"), nul), raw(detection.RegSZ, utf16LE("a")), true},
+		{"REG_SZ odd length", raw(detection.RegSZ, utf16LE("a"), nul), raw(detection.RegSZ, utf16LE("a"), []byte{'x'}), true},
+		{"different REG_SZ", raw(detection.RegSZ, utf16LE("a"), nul), raw(detection.RegSZ, utf16LE("b"), nul), false},
+		{"REG_SZ and REG_EXPAND_SZ", raw(detection.RegSZ, utf16LE("a"), nul), raw(detection.RegExpandSZ, utf16LE("a"), nul), false},
+		{"REG_EXPAND_SZ compares unexpanded", raw(detection.RegExpandSZ, utf16LE("%WBG_A%"), nul), raw(detection.RegExpandSZ, utf16LE("%WBG_B%"), nul), false},
+		{"REG_MULTI_SZ terminators", raw(detection.RegMultiSZ, utf16LE("a"), nul, nul), raw(detection.RegMultiSZ, utf16LE("a"), nul), true},
+		{"REG_MULTI_SZ order", raw(detection.RegMultiSZ, utf16LE("a"), nul, utf16LE("b"), nul, nul), raw(detection.RegMultiSZ, utf16LE("b"), nul, utf16LE("a"), nul, nul), false},
+		{"REG_DWORD", raw(detection.RegDword, []byte{1, 0, 0, 0}), raw(detection.RegDword, []byte{1, 0, 0, 0, 0}), true},
+		{"different REG_DWORD", raw(detection.RegDword, []byte{1, 0, 0, 0}), raw(detection.RegDword, []byte{2, 0, 0, 0}), false},
+		{"REG_DWORD and REG_QWORD", raw(detection.RegDword, []byte{1, 0, 0, 0}), raw(detection.RegQword, []byte{1, 0, 0, 0, 0, 0, 0, 0}), false},
+		{"REG_BINARY", raw(detection.RegBinary, []byte{1, 2}), raw(detection.RegBinary, []byte{1, 2}), true},
+		{"different REG_BINARY", raw(detection.RegBinary, []byte{1, 2}), raw(detection.RegBinary, []byte{1, 2, 0}), false},
+		{"display form only", RegValue{Type: detection.RegBinary, Data: "01"}, RegValue{Type: detection.RegBinary, Data: "01"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Equal(tt.b); got != tt.want {
+				t.Errorf("Equal() = %v, want %v", got, tt.want)
+			}
+			if got := tt.b.Equal(tt.a); got != tt.want {
+				t.Errorf("Equal() reversed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtensionIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		value RegValue
+		want  []string
+	}{
+		{"ID", raw(detection.RegSZ, utf16LE(valueIDA), nul), []string{valueIDA}},
+		{"ID with update URL", raw(detection.RegSZ, utf16LE(valueIDA+";https://example.com/update.xml"), nul), []string{valueIDA}},
+		{"NUL-terminated junk", raw(detection.RegSZ, utf16LE(valueIDA), nul, utf16LE(valueIDB), nul), []string{valueIDA}},
+		{"odd length", raw(detection.RegSZ, utf16LE(valueIDA), []byte{'x'}), []string{valueIDA}},
+		{"REG_MULTI_SZ", raw(detection.RegMultiSZ, utf16LE(valueIDA), nul, utf16LE(valueIDB+";https://example.com/u.xml"), nul, nul), []string{valueIDA, valueIDB}},
+		{"REG_MULTI_SZ odd length", raw(detection.RegMultiSZ, utf16LE(valueIDA), nul, utf16LE(valueIDB), []byte{'x'}), []string{valueIDA, valueIDB}},
+		{"empty", raw(detection.RegSZ, nul), nil},
+		{"update URL only", raw(detection.RegSZ, utf16LE(" ;https://example.com/update.xml"), nul), nil},
+		{"not a string", raw(detection.RegDword, []byte{1, 0, 0, 0}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtensionIDs(tt.value); !slices.Equal(got, tt.want) {
+				t.Errorf("ExtensionIDs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package report
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+)
+
+// ============================================================================
+// SCAN REPORT - Machine-readable scan results (JSON, JSONL, CSV, SARIF)
+// ============================================================================
+
+// Detection is a policy found by a scan: an extension install policy entry
+// or a hijack policy.
+type Detection struct {
+	Browser     string `json:"browser"`
+	ExtensionID string `json:"extensionId,omitempty"`
+	UpdateURL   string `json:"updateUrl,omitempty"`
+	// Source is the registry path of the policy, relative to
+	// HKLM\SOFTWARE\Policies, or the path of a policies.json file.
+	Source string `json:"source"`
+	// Policy is the policy type, e.g. ExtensionInstallForcelist or
+	// Extensions\Locked.
+	Policy string `json:"policy"`
+	// AllowedBy is the exemption or update URL rule that keeps the entry in
+	// place, empty if the guard acts on it.
+	AllowedBy string `json:"allowedBy,omitempty"`
+}
+
+// Report is the result of a one-shot scan.
+type Report struct {
+	// Source is what was scanned: the live registry, a .reg export or a
+	// hive file.
+	Source     string        `json:"source"`
+	Detections []Detection   `json:"detections"`
+	Actions    []plan.Result `json:"actions"`
+}
+
+// Format is a machine-readable output format.
+type Format string
+
+const (
+	// JSON writes the report as one JSON document.
+	JSON Format = "json"
+	// JSONL writes one JSON object per detection and action, each with a
+	// "type" field of "detection" or "action".
+	JSONL Format = "jsonl"
+	// CSV writes one row per detection and action below a header row.
+	CSV Format = "csv"
+	// SARIF writes a SARIF 2.1.0 log with one result per detection and
+	// action.
+	SARIF Format = "sarif"
+)
+
+// Formats lists every supported format.
+var Formats = []Format{JSON, JSONL, CSV, SARIF}
+
+// ParseFormat parses a format name (case-insensitive).
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q (want json, jsonl, csv or sarif)", s)
+}
+
+// Write writes r to w in format f. Detections and actions are written in
+// the order of SortDetections and SortResults, so that scans of the same
+// policies give byte-identical output.
+func (r *Report) Write(w io.Writer, f Format) error {
+	sorted := *r
+	sorted.Detections = slices.Clone(r.Detections)
+	sorted.Actions = slices.Clone(r.Actions)
+	SortDetections(sorted.Detections)
+	SortResults(sorted.Actions)
+	r = &sorted
+
+	switch f {
+	case JSON:
+		return r.writeJSON(w)
+	case JSONL:
+		return r.writeJSONL(w)
+	case CSV:
+		return r.writeCSV(w)
+	case SARIF:
+		return r.writeSARIF(w)
+	}
+	return fmt.Errorf("unknown output format %q", f)
+}
+
+// SortDetections sorts detections by source path, extension ID, update URL
+// and policy, and then by their remaining fields.
+func SortDetections(detections []Detection) {
+	slices.SortFunc(detections, func(a, b Detection) int {
+		return cmp.Or(
+			strings.Compare(a.Source, b.Source),
+			strings.Compare(a.ExtensionID, b.ExtensionID),
+			strings.Compare(a.UpdateURL, b.UpdateURL),
+			strings.Compare(a.Policy, b.Policy),
+			strings.Compare(a.Browser, b.Browser),
+			strings.Compare(a.AllowedBy, b.AllowedBy),
+		)
+	})
+}
+
+// SortResults sorts action results by path, extension ID and kind, and then
+// by their remaining fields. The order is for reporting; plans are applied
+// in the order of their actions.
+func SortResults(results []plan.Result) {
+	slices.SortFunc(results, func(a, b plan.Result) int {
+		return cmp.Or(
+			strings.Compare(a.Path, b.Path),
+			strings.Compare(a.ExtensionID, b.ExtensionID),
+			strings.Compare(string(a.Kind), string(b.Kind)),
+			strings.Compare(a.Source, b.Source),
+			strings.Compare(a.Browser, b.Browser),
+			strings.Compare(a.Reason, b.Reason),
+			strings.Compare(string(a.Status), string(b.Status)),
+			strings.Compare(a.Error, b.Error),
+		)
+	})
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	out := *r
+	// Empty lists are written as [] rather than null.
+	if out.Detections == nil {
+		out.Detections = []Detection{}
+	}
+	if out.Actions == nil {
+		out.Actions = []plan.Result{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func (r *Report) writeJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, d := range r.Detections {
+		line := struct {
+			Type string `json:"type"`
+			Detection
+		}{"detection", d}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	for _, a := range r.Actions {
+		line := struct {
+			Type string `json:"type"`
+			plan.Result
+		}{"action", a}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader names the CSV columns. Detections fill the first seven, actions
+// the browser, extension, source and the last six.
+var csvHeader = []string{
+	"type", "browser", "extensionId", "updateUrl", "source", "policy", "allowedBy",
+	"kind", "path", "reason", "quarantine", "status", "error",
+}
+
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, d := range r.Detections {
+		if err := cw.Write([]string{
+			"detection", d.Browser, d.ExtensionID, d.UpdateURL, d.Source, d.Policy, d.AllowedBy,
+			"", "", "", "", "", "",
+		}); err != nil {
+			return err
+		}
+	}
+	for _, a := range r.Actions {
+		if err := cw.Write([]string{
+			"action", a.Browser, a.ExtensionID, "", a.Source, "", "",
+			string(a.Kind), a.Path, a.Reason, strconv.FormatBool(a.Quarantine), string(a.Status), a.Error,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,115 @@
+package report
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/plan"
+)
+
+func testReport() *Report {
+	return &Report{
+		Source: "test.reg",
+		Detections: []Detection{
+			{Browser: "chrome", ExtensionID: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Source: `Google\Chrome\ExtensionInstallForcelist\1`, Policy: "ExtensionInstallForcelist"},
+			{Browser: "chrome", ExtensionID: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Source: `Google\Chrome\ExtensionInstallForcelist\1`, Policy: "ExtensionInstallForcelist"},
+			{Browser: "chrome", ExtensionID: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", UpdateURL: "https://a.example/u.xml", Source: `Google\Chrome\ExtensionSettings`, Policy: "ExtensionSettings"},
+			{Browser: "chrome", ExtensionID: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", UpdateURL: "https://b.example/u.xml", Source: `Google\Chrome\ExtensionSettings`, Policy: "ExtensionSettings", AllowedBy: "exemption"},
+			{Browser: "edge", ExtensionID: "cccccccccccccccccccccccccccccccc", Source: `Microsoft\Edge\ExtensionInstallForcelist\1`, Policy: "ExtensionInstallForcelist"},
+			{Browser: "firefox", UpdateURL: "https://x.example/x.xpi", Source: `Mozilla\Firefox\Extensions\Install\1`, Policy: `Extensions\Install`},
+		},
+		Actions: []plan.Result{
+			{Action: plan.Action{Kind: plan.AddBlocklistEntry, Browser: "chrome", ExtensionID: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Path: `Google\Chrome\ExtensionInstallBlocklist`, Source: `Google\Chrome\ExtensionInstallForcelist\1`}, Status: plan.Planned},
+			{Action: plan.Action{Kind: plan.AddBlocklistEntry, Browser: "chrome", ExtensionID: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Path: `Google\Chrome\ExtensionInstallBlocklist`, Source: `Google\Chrome\ExtensionInstallForcelist\1`}, Status: plan.Planned},
+			{Action: plan.Action{Kind: plan.RemoveAllowlistValue, Browser: "chrome", ExtensionID: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Path: `Google\Chrome\ExtensionInstallAllowlist`}, Status: plan.Planned},
+			{Action: plan.Action{Kind: plan.DeleteKey, Browser: "chrome", Path: `Google\Chrome\ExtensionInstallForcelist`, Quarantine: true}, Status: plan.Failed, Error: "access denied"},
+			{Action: plan.Action{Kind: plan.DeleteValue, Browser: "firefox", Path: `Mozilla\Firefox\Extensions\Install\1`}, Status: plan.Applied},
+		},
+	}
+}
+
+// TestWriteStable checks that the output does not depend on the order of
+// detections and actions.
+func TestWriteStable(t *testing.T) {
+	r := testReport()
+	for _, f := range Formats {
+		t.Run(string(f), func(t *testing.T) {
+			var want bytes.Buffer
+			if err := r.Write(&want, f); err != nil {
+				t.Fatal(err)
+			}
+			rng := rand.New(rand.NewPCG(1, 2))
+			for range 20 {
+				shuffled := *r
+				shuffled.Detections = slices.Clone(r.Detections)
+				shuffled.Actions = slices.Clone(r.Actions)
+				rng.Shuffle(len(shuffled.Detections), func(i, j int) {
+					shuffled.Detections[i], shuffled.Detections[j] = shuffled.Detections[j], shuffled.Detections[i]
+				})
+				rng.Shuffle(len(shuffled.Actions), func(i, j int) {
+					shuffled.Actions[i], shuffled.Actions[j] = shuffled.Actions[j], shuffled.Actions[i]
+				})
+
+				var got bytes.Buffer
+				if err := shuffled.Write(&got, f); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Bytes(), want.Bytes()) {
+					t.Fatalf("output of shuffled report differs:\n%s\nwant:\n%s", got.Bytes(), want.Bytes())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteLeavesReport(t *testing.T) {
+	r := testReport()
+	r.Detections[0], r.Detections[4] = r.Detections[4], r.Detections[0]
+	want := slices.Clone(r.Detections)
+	if err := r.Write(&bytes.Buffer{}, JSON); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(r.Detections, want) {
+		t.Error("Write reordered the detections of the report")
+	}
+}
+
+func TestSortResults(t *testing.T) {
+	results := testReport().Actions
+	SortResults(results)
+	var got []string
+	for _, r := range results {
+		got = append(got, string(r.Kind)+" "+r.Path+" "+r.ExtensionID)
+	}
+	want := []string{
+		`remove-allowlist-value Google\Chrome\ExtensionInstallAllowlist aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa`,
+		`add-blocklist-entry Google\Chrome\ExtensionInstallBlocklist aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa`,
+		`add-blocklist-entry Google\Chrome\ExtensionInstallBlocklist bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb`,
+		`delete-key Google\Chrome\ExtensionInstallForcelist `,
+		`delete-value Mozilla\Firefox\Extensions\Install\1 `,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("sorted results:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{"json", JSON, false},
+		{"JSONL", JSONL, false},
+		{"Csv", CSV, false},
+		{"sarif", SARIF, false},
+		{"xml", "", true},
+	} {
+		got, err := ParseFormat(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// SARIF 2.1.0 log, limited to the properties the guard fills in.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	toolName     = "WindowsBrowserGuard"
+	toolURI      = "https://github.com/kad/WindowsBrowserGuard"
+	// policiesRoot prefixes registry sources to name their full key.
+	policiesRoot = `HKLM\SOFTWARE\Policies\`
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+	// Properties names the scanned source.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string          `json:"ruleId"`
+	RuleIndex  int             `json:"ruleIndex"`
+	Kind       string          `json:"kind"`
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations"`
+	Properties map[string]any  `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// sarifRules collects the rules referenced by results, in order of first
+// use.
+type sarifRules struct {
+	rules []sarifRule
+	index map[string]int
+}
+
+// ref returns the index of rule id, adding it with description if new.
+func (r *sarifRules) ref(id, description string) int {
+	if i, ok := r.index[id]; ok {
+		return i
+	}
+	if r.index == nil {
+		r.index = make(map[string]int)
+	}
+	r.index[id] = len(r.rules)
+	r.rules = append(r.rules, sarifRule{ID: id, ShortDescription: sarifMessage{Text: description}})
+	return r.index[id]
+}
+
+// writeSARIF writes a SARIF log: detections the guard acts on are failing
+// results with level warning, exempted ones passing results, and actions
+// informational results carrying their status.
+func (r *Report) writeSARIF(w io.Writer) error {
+	var rules sarifRules
+	results := make([]sarifResult, 0, len(r.Detections)+len(r.Actions))
+
+	for _, d := range r.Detections {
+		id := "policy/" + strings.ReplaceAll(d.Policy, `\`, ".")
+		result := sarifResult{
+			RuleID:    id,
+			RuleIndex: rules.ref(id, d.Policy+" policy detected"),
+			Kind:      "fail",
+			Level:     "warning",
+			Message:   sarifMessage{Text: d.describe()},
+			Locations: []sarifLocation{sourceLocation(d.Source)},
+			Properties: properties(map[string]any{
+				"browser":     d.Browser,
+				"extensionId": d.ExtensionID,
+				"updateUrl":   d.UpdateURL,
+				"allowedBy":   d.AllowedBy,
+			}),
+		}
+		if d.AllowedBy != "" {
+			result.Kind, result.Level = "pass", "none"
+		}
+		results = append(results, result)
+	}
+
+	for _, a := range r.Actions {
+		id := "action/" + string(a.Kind)
+		results = append(results, sarifResult{
+			RuleID:    id,
+			RuleIndex: rules.ref(id, "Remediation action "+string(a.Kind)),
+			Kind:      "informational",
+			Level:     "none",
+			Message:   sarifMessage{Text: fmt.Sprintf("%s (%s): %s", a.Action, a.Status, a.Reason)},
+			Locations: []sarifLocation{sourceLocation(a.Path)},
+			Properties: properties(map[string]any{
+				"browser":     a.Browser,
+				"extensionId": a.ExtensionID,
+				"source":      a.Source,
+				"status":      string(a.Status),
+				"quarantine":  a.Quarantine,
+				"error":       a.Error,
+			}),
+		})
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           toolName,
+				InformationURI: toolURI,
+				Rules:          rules.rules,
+			}},
+			Results:    results,
+			Properties: map[string]string{"source": r.Source},
+		}},
+	}
+	if log.Runs[0].Tool.Driver.Rules == nil {
+		log.Runs[0].Tool.Driver.Rules = []sarifRule{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// describe returns the SARIF message of d.
+func (d Detection) describe() string {
+	var sb strings.Builder
+	sb.WriteString(d.Browser + " " + d.Policy)
+	if d.ExtensionID != "" {
+		sb.WriteString(" installs " + d.ExtensionID)
+	}
+	if d.UpdateURL != "" {
+		sb.WriteString(" from " + d.UpdateURL)
+	}
+	if d.AllowedBy != "" {
+		sb.WriteString(" (allowed by " + d.AllowedBy + ")")
+	}
+	return sb.String()
+}
+
+// sourceLocation returns the location of a registry path relative to
+// HKLM\SOFTWARE\Policies, or of a policies.json file.
+func sourceLocation(source string) sarifLocation {
+	if strings.HasSuffix(strings.ToLower(source), ".json") {
+		return sarifLocation{PhysicalLocation: &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: fileURI(source)},
+		}}
+	}
+	return sarifLocation{LogicalLocations: []sarifLogicalLocation{{
+		FullyQualifiedName: policiesRoot + source,
+		Kind:               "resource",
+	}}}
+}
+
+// fileURI returns the file: URI of path, e.g. file:///C:/Program%20Files/...
+func fileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// properties drops the empty strings and false values of m.
+func properties(m map[string]any) map[string]any {
+	for k, v := range m {
+		if v == "" || v == false {
+			delete(m, k)
+		}
+	}
+	return m
+}
@@ -0,0 +1,124 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/atomicfile"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+// ============================================================================
+// SNAPSHOTS - Last known policy state and action journal persisted to disk
+// ============================================================================
+
+// FormatVersion is written to every snapshot file and checked on load.
+const FormatVersion = 1
+
+// MaxJournalEntries bounds the journal; the oldest entries are dropped first.
+const MaxJournalEntries = 500
+
+// Journal event names.
+const (
+	EventStartup = "startup" // enforcement passes ran on startup
+	EventDrift   = "drift"   // changes found between the saved and live state
+	EventChange  = "change"  // a change notification was processed
+	EventManual  = "manual"  // saved by "snapshot save"
+)
+
+// ChangeRecord is the journal form of a registry.Change.
+type ChangeRecord struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// JournalEntry records one processed batch of changes.
+type JournalEntry struct {
+	Time    time.Time      `json:"time"`
+	Event   string         `json:"event"`
+	DryRun  bool           `json:"dryRun,omitempty"`
+	Changes []ChangeRecord `json:"changes,omitempty"`
+}
+
+// Snapshot is the on-disk format: the captured state of KeyPath (relative to
+// HKLM) and the journal of what the guard processed.
+type Snapshot struct {
+	Version int                `json:"version"`
+	KeyPath string             `json:"keyPath"`
+	Taken   time.Time          `json:"taken"`
+	State   *registry.RegState `json:"state"`
+	Journal []JournalEntry     `json:"journal,omitempty"`
+}
+
+// New returns a snapshot of state taken now.
+func New(keyPath string, state *registry.RegState) *Snapshot {
+	return &Snapshot{
+		Version: FormatVersion,
+		KeyPath: keyPath,
+		Taken:   time.Now().UTC(),
+		State:   state,
+	}
+}
+
+// Record replaces the saved state with state and appends a journal entry for
+// changes, trimming the journal to MaxJournalEntries.
+func (s *Snapshot) Record(event string, state *registry.RegState, changes []registry.Change, dryRun bool) {
+	entry := JournalEntry{
+		Time:   time.Now().UTC(),
+		Event:  event,
+		DryRun: dryRun,
+	}
+	for _, change := range changes {
+		entry.Changes = append(entry.Changes, ChangeRecord{Kind: change.Kind.String(), Path: change.Path})
+	}
+
+	s.State = state
+	s.Taken = entry.Time
+	s.Journal = append(s.Journal, entry)
+	if len(s.Journal) > MaxJournalEntries {
+		s.Journal = append([]JournalEntry(nil), s.Journal[len(s.Journal)-MaxJournalEntries:]...)
+	}
+}
+
+// Load reads a snapshot file. A missing file is reported with an error
+// satisfying errors.Is(err, os.ErrNotExist).
+func Load(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+	if s.Version != FormatVersion {
+		return nil, fmt.Errorf("snapshot %s: unsupported version %d", path, s.Version)
+	}
+	if s.State == nil {
+		s.State = registry.NewRegState()
+	}
+	if s.State.Subkeys == nil {
+		s.State.Subkeys = make(map[string]bool)
+	}
+	if s.State.Values == nil {
+		s.State.Values = make(map[string]registry.RegValue)
+	}
+	return &s, nil
+}
+
+// Save writes s to path atomically: the data goes to a temporary file in the
+// same directory, is flushed to disk and then renamed over path, so a crash
+// never leaves a truncated snapshot behind.
+func Save(path string, s *Snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("saving snapshot: %w", err)
+	}
+	return nil
+}
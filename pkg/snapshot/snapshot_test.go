@@ -0,0 +1,135 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+	"github.com/kad/WindowsBrowserGuard/pkg/registry"
+)
+
+const (
+	keyPath   = `SOFTWARE\Policies`
+	forcelist = `Google\Chrome\ExtensionInstallForcelist`
+	entry     = forcelist + `\1`
+)
+
+// forcelistState returns a state force-installing extensionID.
+func forcelistState(extensionID string) *registry.RegState {
+	state := registry.NewRegState()
+	state.Subkeys[forcelist] = true
+	state.Values[entry] = registry.NewRegValue("1", detection.RegSZ, detection.EncodeUTF16String(extensionID))
+	return state
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	s := New(keyPath, registry.NewRegState())
+	state := forcelistState("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	changes := registry.Diff(s.State, state)
+	s.Record(EventChange, state, changes, true)
+
+	if err := Save(path, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Version != FormatVersion || got.KeyPath != keyPath || !got.Taken.Equal(s.Taken) {
+		t.Errorf("Load() = version %d, key %q, taken %v, want %d, %q, %v",
+			got.Version, got.KeyPath, got.Taken, FormatVersion, keyPath, s.Taken)
+	}
+	if diff := registry.Diff(state, got.State); len(diff) > 0 {
+		t.Errorf("loaded state differs from the saved one: %v", diff)
+	}
+	if !reflect.DeepEqual(got.State.Values[entry].Raw, state.Values[entry].Raw) {
+		t.Errorf("loaded value = %v, want %v", got.State.Values[entry].Raw, state.Values[entry].Raw)
+	}
+	want := []JournalEntry{{
+		Time:   s.Journal[0].Time,
+		Event:  EventChange,
+		DryRun: true,
+		Changes: []ChangeRecord{
+			{Kind: "SUBKEY ADDED", Path: forcelist},
+			{Kind: "VALUE ADDED", Path: entry},
+		},
+	}}
+	if len(got.Journal) != 1 || !got.Journal[0].Time.Equal(want[0].Time) {
+		t.Fatalf("loaded journal = %+v, want %+v", got.Journal, want)
+	}
+	got.Journal[0].Time = want[0].Time
+	if !reflect.DeepEqual(got.Journal, want) {
+		t.Errorf("loaded journal = %+v, want %+v", got.Journal, want)
+	}
+}
+
+func TestRecordTrimsJournal(t *testing.T) {
+	s := New(keyPath, registry.NewRegState())
+	for i := range MaxJournalEntries + 10 {
+		event := EventChange
+		if i == 10 {
+			event = EventDrift
+		}
+		s.Record(event, s.State, nil, false)
+	}
+	if len(s.Journal) != MaxJournalEntries {
+		t.Fatalf("journal holds %d entries, want %d", len(s.Journal), MaxJournalEntries)
+	}
+	// The first ten entries were dropped, so the drift entry comes first.
+	if s.Journal[0].Event != EventDrift {
+		t.Errorf("oldest journal entry = %q, want %q", s.Journal[0].Event, EventDrift)
+	}
+	if last := s.Journal[len(s.Journal)-1]; !last.Time.Equal(s.Taken) {
+		t.Errorf("newest journal entry at %v, want the snapshot time %v", last.Time, s.Taken)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() of a missing file error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not JSON", `{`, "parsing snapshot"},
+		{"unsupported version", `{"version": 2}`, "unsupported version 2"},
+		{"no version", `{}`, "unsupported version 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmptyState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, []byte(`{"version": 1, "state": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The loaded state must be usable without nil checks.
+	if s.State.Subkeys == nil || s.State.Values == nil {
+		t.Errorf("Load() state = %+v, want empty maps", s.State)
+	}
+}
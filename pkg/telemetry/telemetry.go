@@ -499,8 +499,9 @@ func Println(ctx context.Context, args ...interface{}) {
 
 // Metrics functions
 
-// RecordExtensionDetected increments the counter for detected extensions
-func RecordExtensionDetected(ctx context.Context, browser string, extensionID string) {
+// RecordExtensionDetected increments the counter for detected extensions.
+// attrs are added to the browser and extension_id labels.
+func RecordExtensionDetected(ctx context.Context, browser string, extensionID string, attrs ...attribute.KeyValue) {
 	if meter == nil {
 		return
 	}
@@ -508,10 +509,10 @@ func RecordExtensionDetected(ctx context.Context, browser string, extensionID st
 		metric.WithDescription("Number of forced extensions detected"),
 		metric.WithUnit("{extension}"))
 	counter.Add(ctx, 1,
-		metric.WithAttributes(
+		metric.WithAttributes(append([]attribute.KeyValue{
 			attribute.String("browser", browser),
 			attribute.String("extension_id", extensionID),
-		))
+		}, attrs...)...))
 }
 
 // RecordExtensionBlocked increments the counter for blocked extensions
@@ -529,6 +530,83 @@ func RecordExtensionBlocked(ctx context.Context, browser string, extensionID str
 		))
 }
 
+// RecordPolicyDetected increments the counter for detected hijack policies
+func RecordPolicyDetected(ctx context.Context, browser string, policy string) {
+	if meter == nil {
+		return
+	}
+	counter, _ := meter.Int64Counter("browser_guard.policies.detected",
+		metric.WithDescription("Number of browser hijack policies detected"),
+		metric.WithUnit("{policy}"))
+	counter.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("browser", browser),
+			attribute.String("policy", policy),
+		))
+}
+
+// RecordPolicyRemediated increments the counter for removed or reset hijack policies
+func RecordPolicyRemediated(ctx context.Context, browser string, policy string, action string) {
+	if meter == nil {
+		return
+	}
+	counter, _ := meter.Int64Counter("browser_guard.policies.remediated",
+		metric.WithDescription("Number of browser hijack policies removed or reset"),
+		metric.WithUnit("{policy}"))
+	counter.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("browser", browser),
+			attribute.String("policy", policy),
+			attribute.String("action", action),
+		))
+}
+
+// RecordTamperRestored increments the counter for guard-written entries
+// restored after they were removed or modified
+func RecordTamperRestored(ctx context.Context, browser string, kind string) {
+	if meter == nil {
+		return
+	}
+	counter, _ := meter.Int64Counter("browser_guard.tamper.restored",
+		metric.WithDescription("Number of guard-written blocklist entries restored after tampering"),
+		metric.WithUnit("{entry}"))
+	counter.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("browser", browser),
+			attribute.String("kind", kind),
+		))
+}
+
+// RecordNotificationReceived increments the counter for change
+// notifications received by a watcher
+func RecordNotificationReceived(ctx context.Context, source string) {
+	if meter == nil {
+		return
+	}
+	counter, _ := meter.Int64Counter("browser_guard.notifications.received",
+		metric.WithDescription("Number of change notifications received"),
+		metric.WithUnit("{notification}"))
+	counter.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("source", source),
+		))
+}
+
+// RecordCapturePerformed increments the counter for state captures
+// performed after a burst of change notifications
+func RecordCapturePerformed(ctx context.Context, source string) {
+	if meter == nil {
+		return
+	}
+	counter, _ := meter.Int64Counter("browser_guard.captures.performed",
+		metric.WithDescription("Number of state captures performed for change notifications"),
+		metric.WithUnit("{capture}"))
+	counter.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("source", source),
+		))
+}
+
 // RecordRegistryOperation records a registry operation
 func RecordRegistryOperation(ctx context.Context, operation string, success bool) {
 	if meter == nil {
@@ -0,0 +1,131 @@
+package trust
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+)
+
+// ============================================================================
+// UPDATE-URL TRUST - Allow or block forcelist entries by their update URL
+// ============================================================================
+
+// Action is the verdict of a rule.
+type Action string
+
+const (
+	// Allow keeps a forcelist entry in place, like an exemption.
+	Allow Action = "allow"
+	// Block removes and blocklists a forcelist entry even when an exemption
+	// matches it.
+	Block Action = "block"
+)
+
+// Rule matches forcelist entries by the parts of their update URL. Empty
+// fields match anything, so a rule with only Name and Action is a catch-all.
+type Rule struct {
+	Name   string `json:"Name,omitempty"`
+	Action Action `json:"Action"`
+	// Scheme is compared case-insensitively, e.g. "https" or "file".
+	Scheme string `json:"Scheme,omitempty"`
+	// Host is an exact host name, or "*.example.com" for any subdomain.
+	Host string `json:"Host,omitempty"`
+	// Path is a prefix of the URL path, e.g. "/service/update2/crx".
+	Path string `json:"Path,omitempty"`
+}
+
+// String describes r for log output.
+func (r Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	parts := []string{string(r.Action)}
+	if r.Scheme != "" {
+		parts = append(parts, "scheme="+r.Scheme)
+	}
+	if r.Host != "" {
+		parts = append(parts, "host="+r.Host)
+	}
+	if r.Path != "" {
+		parts = append(parts, "path="+r.Path)
+	}
+	return strings.Join(parts, " ")
+}
+
+// matches reports whether entry satisfies every field of r.
+func (r Rule) matches(entry detection.ForcelistEntry) bool {
+	if r.Scheme != "" && !strings.EqualFold(r.Scheme, entry.Scheme) {
+		return false
+	}
+	if r.Host != "" && !matchHost(r.Host, entry.Host) {
+		return false
+	}
+	if r.Path != "" && !strings.HasPrefix(strings.ToLower(entry.Path), strings.ToLower(r.Path)) {
+		return false
+	}
+	return true
+}
+
+// matchHost matches host against an exact name or a "*.domain" pattern,
+// which covers the subdomains of domain but not domain itself.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// Defaults returns the rules used when the configuration sets none: entries
+// updating from local files are blocked, entries from the Chrome Web Store
+// and Edge Add-ons are allowed, and every other (self-hosted) update URL is
+// blocked.
+func Defaults() []Rule {
+	return []Rule{
+		{Name: "local-file", Action: Block, Scheme: "file"},
+		{Name: "chrome-web-store", Action: Allow, Scheme: "https", Host: "clients2.google.com", Path: "/service/update2/crx"},
+		{Name: "edge-addons", Action: Allow, Scheme: "https", Host: "edge.microsoft.com", Path: "/extensionwebstorebase"},
+		{Name: "self-hosted", Action: Block},
+	}
+}
+
+// Rules is an ordered rule list; the first matching rule decides. The zero
+// value and nil decide nothing.
+type Rules struct {
+	rules []Rule
+}
+
+// New validates rules and returns them as Rules.
+func New(rules []Rule) (*Rules, error) {
+	r := &Rules{}
+	for i, rule := range rules {
+		rule.Action = Action(strings.ToLower(strings.TrimSpace(string(rule.Action))))
+		if rule.Action != Allow && rule.Action != Block {
+			return nil, fmt.Errorf("update URL rule %d (%s): unknown action %q (want %q or %q)", i+1, rule, rule.Action, Allow, Block)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Len returns the number of rules in r.
+func (r *Rules) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.rules)
+}
+
+// Decide returns the first rule matching entry.
+func (r *Rules) Decide(entry detection.ForcelistEntry) (Rule, bool) {
+	if r == nil {
+		return Rule{}, false
+	}
+	for _, rule := range r.rules {
+		if rule.matches(entry) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
@@ -0,0 +1,53 @@
+package trust
+
+import (
+	"testing"
+
+	"github.com/kad/WindowsBrowserGuard/pkg/detection"
+)
+
+func TestDefaults(t *testing.T) {
+	rules, err := New(Defaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	for _, tt := range []struct {
+		updateURL string
+		action    Action
+		rule      string
+	}{
+		{"https://clients2.google.com/service/update2/crx", Allow, "chrome-web-store"},
+		{"https://CLIENTS2.google.com/service/update2/crx?x=1", Allow, "chrome-web-store"},
+		{"https://edge.microsoft.com/extensionwebstorebase/v1/crx", Allow, "edge-addons"},
+		{"file:///C:/ext/update.xml", Block, "local-file"},
+		{`C:\ext\update.xml`, Block, "local-file"},
+		{`\\server\share\update.xml`, Block, "local-file"},
+		{"http://clients2.google.com/service/update2/crx", Block, "self-hosted"},
+		{"https://clients2.google.com/other", Block, "self-hosted"},
+		{"https://updates.example.com/ext.xml", Block, "self-hosted"},
+	} {
+		rule, ok := rules.Decide(detection.ParseForcelistEntry(id + ";" + tt.updateURL))
+		if !ok || rule.Action != tt.action || rule.Name != tt.rule {
+			t.Errorf("%s: decided by %q (%s, %v), want %q (%s)", tt.updateURL, rule.Name, rule.Action, ok, tt.rule, tt.action)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	rules, err := New([]Rule{{Action: " ALLOW ", Host: "a.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule, ok := rules.Decide(detection.ParseForcelistEntry("id;https://a.example/u.xml")); !ok || rule.Action != Allow {
+		t.Errorf("Decide = %v, %v; want the allow rule", rule, ok)
+	}
+	if _, err := New([]Rule{{Action: "trust"}}); err == nil {
+		t.Error("New accepted an unknown action")
+	}
+
+	var none *Rules
+	if _, ok := none.Decide(detection.ParseForcelistEntry("id;https://a.example/u.xml")); ok || none.Len() != 0 {
+		t.Error("nil Rules decided an entry")
+	}
+}